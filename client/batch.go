@@ -0,0 +1,80 @@
+// batch.go
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+// A Batcher collects records and sends them with InsertBatch, once Size records are waiting or Interval has passed.
+// It is safe to Add from several goroutines
+type Batcher struct {
+	client   *Client
+	size     int
+	lock     sync.Mutex
+	pending  []tagbrowser.InsertArgs
+	stop     chan bool
+	finished sync.WaitGroup
+	OnError  func(error) // Called when a batch cannot be sent.  Default: ignore
+}
+
+func (c *Client) NewBatcher(size int, interval time.Duration) *Batcher {
+	if size < 1 {
+		size = 100
+	}
+	b := &Batcher{client: c, size: size, stop: make(chan bool)}
+	if interval > 0 {
+		b.finished.Add(1)
+		go b.flushWorker(interval)
+	}
+	return b
+}
+
+func (b *Batcher) flushWorker(interval time.Duration) {
+	defer b.finished.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Flush(context.Background())
+		}
+	}
+}
+
+// Queue a record, sending the batch if it is full
+func (b *Batcher) Add(ctx context.Context, rec tagbrowser.InsertArgs) error {
+	b.lock.Lock()
+	b.pending = append(b.pending, rec)
+	full := len(b.pending) >= b.size
+	b.lock.Unlock()
+	if full {
+		return b.Flush(ctx)
+	}
+	return nil
+}
+
+// Send every queued record
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.lock.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.lock.Unlock()
+	_, err := b.client.InsertBatch(ctx, batch)
+	if err != nil && b.OnError != nil {
+		b.OnError(err)
+	}
+	return err
+}
+
+// Send the remaining records and stop the flush timer
+func (b *Batcher) Close(ctx context.Context) error {
+	close(b.stop)
+	b.finished.Wait()
+	return b.Flush(ctx)
+}
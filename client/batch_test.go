@@ -0,0 +1,76 @@
+// batch_test.go
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// The number of calls f has had, and the records it has stored
+func fakeCounts(f *fakeResponder) (int, int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	stored := 0
+	for _, n := range f.stored {
+		stored = stored + n
+	}
+	return f.calls, stored
+}
+
+func TestBatcherSendsFullBatches(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: -1}
+	c := New(Options{Address: serveFake(t, f)})
+	defer c.Close()
+	b := c.NewBatcher(3, 0)
+	for i := 0; i < 7; i++ {
+		if err := b.Add(context.Background(), records(fmt.Sprintf("file%v", i))[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls, stored := fakeCounts(f); calls != 2 || stored != 6 {
+		t.Errorf("before Close: %v calls storing %v records, want 2 calls storing 6", calls, stored)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls, stored := fakeCounts(f); calls != 3 || stored != 7 {
+		t.Errorf("after Close: %v calls storing %v records, want 3 calls storing 7", calls, stored)
+	}
+}
+
+func TestBatcherSendsAfterTheInterval(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: -1}
+	c := New(Options{Address: serveFake(t, f)})
+	defer c.Close()
+	b := c.NewBatcher(100, 10*time.Millisecond)
+	defer b.Close(context.Background())
+	if err := b.Add(context.Background(), records("a")[0]); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, stored := fakeCounts(f); stored == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the record was not sent after the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBatcherReportsFailedBatches(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: 0}
+	c := New(Options{Address: serveFake(t, f), MaxRetries: -1})
+	defer c.Close()
+	b := c.NewBatcher(2, 0)
+	var reported error
+	b.OnError = func(err error) { reported = err }
+	b.Add(context.Background(), records("a")[0])
+	err := b.Add(context.Background(), records("b")[0])
+	if err == nil || reported != err {
+		t.Errorf("Add returned %v and OnError got %v, want the same error", err, reported)
+	}
+}
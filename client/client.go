@@ -0,0 +1,437 @@
+// client.go
+
+// Package client is a Go client for tagserver's JSON-RPC port.
+//
+// A Client keeps a small pool of connections, redials broken connections with exponential backoff,
+// and retries calls that fail because the connection went away.  Every call takes a context, which
+// bounds the whole call including retries.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+// Returned when the server refuses a record, e.g. because it is shutting down
+var ErrRejected = errors.New("record rejected by server")
+
+// Returned when the connection failed after records were sent, so the server may or may not have stored them.  Inserts
+// are not idempotent, so they are not sent again
+var ErrUncertain = errors.New("connection failed after the records were sent, they may have been stored")
+
+type Options struct {
+	Address     string        // Server IP and port.  Default: tagbrowser.ServerAddress
+	PoolSize    int           // Number of connections to keep open.  Default: 2
+	MaxRetries  int           // Attempts after the first failure, before giving up.  Default: 5, -1 for none, RetryForever to never give up
+	BaseBackoff time.Duration // Wait before the first retry, doubled after each failure.  Default: 100ms
+	MaxBackoff  time.Duration // Longest wait between retries.  Default: 5s
+	DialTimeout time.Duration // Default: 5s
+	Token       string        // API token, sent with every call.  Needed when the server has tokens configured
+	TLS         *tls.Config   // Connect with TLS.  See TLSConfig.  Default: plain text
+	Index       string        // Index to search and insert into.  "a,b" or "*" search several indexes.  Default: the server's default index
+}
+
+// A MaxRetries that keeps retrying until the call succeeds or its context ends
+const RetryForever = math.MaxInt32
+
+type Client struct {
+	opts  Options
+	lock  sync.Mutex
+	conns []*rpc.Client
+	next  int
+}
+
+func (o *Options) setDefaults() {
+	if o.Address == "" {
+		o.Address = tagbrowser.ServerAddress
+	}
+	if o.PoolSize < 1 {
+		o.PoolSize = 2
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	} else if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.BaseBackoff == 0 {
+		o.BaseBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = 5 * time.Second
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+}
+
+// Create a client.  Connections are opened when they are first needed
+func New(opts Options) *Client {
+	opts.setDefaults()
+	return &Client{opts: opts, conns: make([]*rpc.Client, opts.PoolSize)}
+}
+
+// Create a client for address, and check that the server can be reached
+func Dial(address string) (*Client, error) {
+	return Connect(Options{Address: address})
+}
+
+// Create a client, and check that the server can be reached
+func Connect(opts Options) (*Client, error) {
+	c := New(opts)
+	if _, _, err := c.conn(context.Background()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) Address() string {
+	return c.opts.Address
+}
+
+// Close every open connection
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, conn := range c.conns {
+		if conn != nil {
+			conn.Close()
+			c.conns[i] = nil
+		}
+	}
+	return nil
+}
+
+// Pick the next connection from the pool, dialing it if needed
+func (c *Client) conn(ctx context.Context) (*rpc.Client, int, error) {
+	c.lock.Lock()
+	slot := c.next
+	c.next = (c.next + 1) % len(c.conns)
+	conn := c.conns[slot]
+	c.lock.Unlock()
+	if conn != nil {
+		return conn, slot, nil
+	}
+
+	var netConn net.Conn
+	var err error
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	if c.opts.TLS != nil {
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: c.opts.TLS}
+		netConn, err = tlsDialer.DialContext(ctx, "tcp", c.opts.Address)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", c.opts.Address)
+	}
+	if err != nil {
+		return nil, slot, err
+	}
+	conn = jsonrpc.NewClient(netConn)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conns[slot] != nil {
+		//Another call dialed this slot first
+		conn.Close()
+		return c.conns[slot], slot, nil
+	}
+	c.conns[slot] = conn
+	return conn, slot, nil
+}
+
+// Throw away a broken connection, so the next call redials it
+func (c *Client) drop(slot int, conn *rpc.Client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conns[slot] == conn {
+		c.conns[slot] = nil
+	}
+	conn.Close()
+}
+
+func (c *Client) backoff(ctx context.Context, attempt int) error {
+	wait := c.opts.MaxBackoff
+	if attempt < 32 {
+		wait = c.opts.BaseBackoff << uint(attempt)
+	}
+	if wait > c.opts.MaxBackoff || wait <= 0 {
+		wait = c.opts.MaxBackoff
+	}
+	//Jitter, so a crowd of clients doesn't reconnect in step
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// Errors from the server itself are returned as rpc.ServerError, and are not worth retrying
+func retryable(err error) bool {
+	var serverErr rpc.ServerError
+	return !errors.As(err, &serverErr)
+}
+
+// Call a TagResponder method, retrying on connection failures
+func (c *Client) Call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	return c.call(ctx, method, args, reply, true)
+}
+
+// Call a method.  Unless resend is set, a call is only retried if it was never sent, so writes are not made twice
+func (c *Client) call(ctx context.Context, method string, args interface{}, reply interface{}, resend bool) error {
+	var err error
+	for attempt := 0; attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if berr := c.backoff(ctx, attempt-1); berr != nil {
+				return fmt.Errorf("%v: %v (last error: %v)", method, berr, err)
+			}
+		}
+		var conn *rpc.Client
+		var slot int
+		conn, slot, err = c.conn(ctx)
+		if err != nil {
+			continue
+		}
+		call := conn.Go("TagResponder."+method, args, reply, make(chan *rpc.Call, 1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-call.Done:
+			err = call.Error
+		}
+		if err == nil || !retryable(err) {
+			return err
+		}
+		c.drop(slot, conn)
+		if !resend && err != rpc.ErrShutdown {
+			return fmt.Errorf("%w: %v", ErrUncertain, err)
+		}
+	}
+	return fmt.Errorf("%v failed after %v attempts: %v", method, c.opts.MaxRetries+1, err)
+}
+
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]tagbrowser.ResultRecordTransmittable, error) {
+	reply := &tagbrowser.Reply{}
+	err := c.Call(ctx, "SearchString", &tagbrowser.Args{A: query, Limit: limit, Index: c.opts.Index, Token: c.opts.Token}, reply)
+	return reply.C, err
+}
+
+// Search, returning the best maxGroups files, each with up to lines of its best lines and its number of matching lines
+func (c *Client) SearchGroups(ctx context.Context, query string, maxGroups int, lines int) ([]tagbrowser.ResultGroup, error) {
+	reply := &tagbrowser.Reply{}
+	err := c.Call(ctx, "SearchString", &tagbrowser.Args{A: query, Limit: maxGroups, Index: c.opts.Index, GroupBy: tagbrowser.GroupByFile, GroupLines: lines, Token: c.opts.Token}, reply)
+	return reply.Groups, err
+}
+
+// Find the records most like the record for name at line, best first.  The record itself is left out
+func (c *Client) Similar(ctx context.Context, name string, line int, limit int) ([]tagbrowser.ResultRecordTransmittable, error) {
+	reply := &tagbrowser.Reply{}
+	err := c.Call(ctx, "SimilarRecords", &tagbrowser.SimilarArgs{Name: name, Position: line, Limit: limit, Index: c.opts.Index, Token: c.opts.Token}, reply)
+	return reply.C, err
+}
+
+// Search with any of the options in args.  Index and Token default to the client's
+func (c *Client) Query(ctx context.Context, args tagbrowser.Args) (*tagbrowser.Reply, error) {
+	if args.Index == "" {
+		args.Index = c.opts.Index
+	}
+	if args.Token == "" {
+		args.Token = c.opts.Token
+	}
+	reply := &tagbrowser.Reply{}
+	err := c.Call(ctx, "SearchString", &args, reply)
+	return reply, err
+}
+
+// Search with a Mode of tagbrowser.SearchModeVector or SearchModeHybrid.  vector may be nil, to have the server embed query
+func (c *Client) SearchVector(ctx context.Context, query string, mode string, vector []float32, limit int) ([]tagbrowser.ResultRecordTransmittable, error) {
+	reply := &tagbrowser.Reply{}
+	err := c.Call(ctx, "SearchString", &tagbrowser.Args{A: query, Limit: limit, Index: c.opts.Index, Mode: mode, Vector: vector, Token: c.opts.Token}, reply)
+	return reply.C, err
+}
+
+func (c *Client) Predict(ctx context.Context, prefix string, limit int) ([]string, error) {
+	reply := &tagbrowser.StringListReply{}
+	err := c.Call(ctx, "PredictString", &tagbrowser.Args{A: prefix, Limit: limit, Index: c.opts.Index, Token: c.opts.Token}, reply)
+	return reply.C, err
+}
+
+// Insert one record.  A record refused by the server is retried like a connection failure, and reported as ErrRejected.
+// If the connection fails after the record was sent, it is not sent again, and ErrUncertain is returned
+func (c *Client) Insert(ctx context.Context, rec tagbrowser.InsertArgs) error {
+	token := rec.Token
+	if token == "" {
+		token = c.opts.Token
+	}
+	_, err := c.insertBatch(ctx, []tagbrowser.InsertArgs{rec}, token)
+	return err
+}
+
+// Insert several records in one call.  The server stores records in order and stops at the first it refuses, so only
+// the records after the stored ones are sent again.  Returns the number of records stored, which are the first ones
+func (c *Client) InsertBatch(ctx context.Context, recs []tagbrowser.InsertArgs) (int, error) {
+	return c.insertBatch(ctx, recs, c.opts.Token)
+}
+
+func (c *Client) insertBatch(ctx context.Context, recs []tagbrowser.InsertArgs, token string) (int, error) {
+	if c.opts.Index != "" {
+		for i := range recs {
+			if recs[i].Index == "" {
+				recs[i].Index = c.opts.Index
+			}
+		}
+	}
+	stored := 0
+	var reason string
+	for attempt := 0; stored < len(recs) && attempt <= c.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if err := c.backoff(ctx, attempt-1); err != nil {
+				return stored, err
+			}
+		}
+		reply := &tagbrowser.SuccessReply{}
+		if err := c.call(ctx, "InsertRecords", &tagbrowser.BatchInsertArgs{Records: recs[stored:], Token: token}, reply, false); err != nil {
+			return stored, err
+		}
+		stored = stored + reply.Inserted
+		if reply.Success {
+			return len(recs), nil
+		}
+		reason = reply.Reason
+	}
+	if stored == len(recs) {
+		return stored, nil
+	}
+	return stored, fmt.Errorf("%w: %v", ErrRejected, reason)
+}
+
+func (c *Client) Delete(ctx context.Context, name string, line int, allLines bool) (int, error) {
+	reply := &tagbrowser.DeleteReply{}
+	err := c.Call(ctx, "DeleteRecord", &tagbrowser.DeleteArgs{Name: name, Position: line, AllLines: allLines, Index: c.opts.Index, Token: c.opts.Token}, reply)
+	return reply.Deleted, err
+}
+
+// Report that the user opened a search result, so the server can rank it higher next time
+func (c *Client) Click(ctx context.Context, name string, line int) error {
+	return c.Call(ctx, "RecordClick", &tagbrowser.ClickArgs{Name: name, Position: line, Token: c.opts.Token}, &tagbrowser.SuccessReply{})
+}
+
+func (c *Client) Status(ctx context.Context) (map[string]string, error) {
+	reply := &tagbrowser.StatusReply{}
+	err := c.Call(ctx, "Status", &tagbrowser.Args{Token: c.opts.Token}, reply)
+	return reply.Answer, err
+}
+
+func (c *Client) HistoStatus(ctx context.Context) (map[string]int, error) {
+	reply := &tagbrowser.HistoReply{}
+	err := c.Call(ctx, "HistoStatus", &tagbrowser.Args{Token: c.opts.Token}, reply)
+	return reply.TagsToFilesHisto, err
+}
+
+func (c *Client) TopTagsStatus(ctx context.Context) (map[string]int, error) {
+	reply := &tagbrowser.TopTagsReply{}
+	err := c.Call(ctx, "TopTagsStatus", &tagbrowser.Args{Token: c.opts.Token}, reply)
+	return reply.TopTags, err
+}
+
+// Create an index on the server.  Needs an admin token
+func (c *Client) CreateIndex(ctx context.Context, args tagbrowser.CreateIndexArgs) error {
+	if args.Token == "" {
+		args.Token = c.opts.Token
+	}
+	reply := &tagbrowser.SuccessReply{}
+	if err := c.Call(ctx, "CreateIndex", &args, reply); err != nil {
+		return err
+	}
+	if !reply.Success {
+		return errors.New(reply.Reason)
+	}
+	return nil
+}
+
+// Add silos to a disk farm of the server.  The records are moved in the background.  Needs an admin token
+func (c *Client) AddSilos(ctx context.Context, args tagbrowser.SiloArgs) error {
+	return c.siloCall(ctx, "AddSilos", args)
+}
+
+// Move the records out of a silo of a disk farm in the background, then retire it.  Needs an admin token
+func (c *Client) DrainSilo(ctx context.Context, args tagbrowser.SiloArgs) error {
+	return c.siloCall(ctx, "DrainSilo", args)
+}
+
+// Move each record of a farm that held records before routing to the silo its file routes to, in the background.  Needs
+// an admin token
+func (c *Client) RouteRecords(ctx context.Context, args tagbrowser.SiloArgs) error {
+	return c.siloCall(ctx, "RouteRecords", args)
+}
+
+// Compact the silos of a disk farm of the server in the background.  Needs an admin token
+func (c *Client) Compact(ctx context.Context, args tagbrowser.SiloArgs) error {
+	return c.siloCall(ctx, "Compact", args)
+}
+
+func (c *Client) siloCall(ctx context.Context, method string, args tagbrowser.SiloArgs) error {
+	if args.Index == "" {
+		args.Index = c.opts.Index
+	}
+	if args.Token == "" {
+		args.Token = c.opts.Token
+	}
+	reply := &tagbrowser.SuccessReply{}
+	if err := c.Call(ctx, method, &args, reply); err != nil {
+		return err
+	}
+	if !reply.Success {
+		return errors.New(reply.Reason)
+	}
+	return nil
+}
+
+// Write a snapshot of every farm to dir, a new or empty directory on the server.  Needs an admin token
+func (c *Client) Backup(ctx context.Context, dir string) (*tagbrowser.BackupManifest, error) {
+	return c.backupCall(ctx, "Backup", dir)
+}
+
+// Check the backup in dir, a directory on the server, and replace the server's silos with it.  Needs an admin token
+func (c *Client) Restore(ctx context.Context, dir string) (*tagbrowser.BackupManifest, error) {
+	return c.backupCall(ctx, "Restore", dir)
+}
+
+func (c *Client) backupCall(ctx context.Context, method string, dir string) (*tagbrowser.BackupManifest, error) {
+	reply := &tagbrowser.BackupReply{}
+	if err := c.Call(ctx, method, &tagbrowser.BackupArgs{Dir: dir, Token: c.opts.Token}, reply); err != nil {
+		return nil, err
+	}
+	if !reply.Success {
+		return nil, errors.New(reply.Reason)
+	}
+	return reply.Manifest, nil
+}
+
+// Make a replication follower the leader, so it takes writes.  Needs an admin token
+func (c *Client) Promote(ctx context.Context) error {
+	reply := &tagbrowser.SuccessReply{}
+	if err := c.Call(ctx, "Promote", &tagbrowser.Args{Token: c.opts.Token}, reply); err != nil {
+		return err
+	}
+	if !reply.Success {
+		return errors.New(reply.Reason)
+	}
+	return nil
+}
+
+// Order the server to quit
+func (c *Client) Shutdown(ctx context.Context) error {
+	reply := &tagbrowser.SuccessReply{}
+	return c.Call(ctx, "Shutdown", &tagbrowser.Args{Token: c.opts.Token}, reply)
+}
@@ -0,0 +1,137 @@
+// client_test.go
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+// Stands in for tagserver's TagResponder.  It refuses the batch at refuseAt once, after storing the records before it
+type fakeResponder struct {
+	lock     sync.Mutex
+	stored   map[string]int
+	calls    int
+	refuseAt int
+	hangUp   bool //Close the connection instead of replying
+}
+
+func (f *fakeResponder) InsertRecords(args *tagbrowser.BatchInsertArgs, reply *tagbrowser.SuccessReply) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	for i, r := range args.Records {
+		if f.refuseAt >= 0 && i == f.refuseAt {
+			f.refuseAt = -1
+			reply.Success = false
+			reply.Reason = "Server not ready"
+			return nil
+		}
+		f.stored[r.Name]++
+		reply.Inserted++
+	}
+	reply.Success = true
+	return nil
+}
+
+// Serve f on a local port.  Connections are closed without a reply while f.hangUp is set
+func serveFake(t *testing.T, f *fakeResponder) string {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName("TagResponder", f); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.lock.Lock()
+			hangUp := f.hangUp
+			f.lock.Unlock()
+			if hangUp {
+				go func() {
+					buf := make([]byte, 4096)
+					conn.Read(buf)
+					f.lock.Lock()
+					f.calls++
+					f.lock.Unlock()
+					conn.Close()
+				}()
+				continue
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return l.Addr().String()
+}
+
+func records(names ...string) []tagbrowser.InsertArgs {
+	out := []tagbrowser.InsertArgs{}
+	for _, n := range names {
+		out = append(out, tagbrowser.InsertArgs{Name: n, Tags: []string{"tag"}})
+	}
+	return out
+}
+
+func TestInsertBatchResendsOnlyRefusedRecords(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: 2}
+	c := New(Options{Address: serveFake(t, f), BaseBackoff: time.Millisecond})
+	defer c.Close()
+	stored, err := c.InsertBatch(context.Background(), records("a", "b", "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 4 {
+		t.Errorf("InsertBatch stored %v, want 4", stored)
+	}
+	for _, n := range []string{"a", "b", "c", "d"} {
+		if f.stored[n] != 1 {
+			t.Errorf("%v was stored %v times", n, f.stored[n])
+		}
+	}
+	if f.calls != 2 {
+		t.Errorf("%v calls, want 2", f.calls)
+	}
+}
+
+func TestInsertBatchReportsRefusal(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: 1}
+	c := New(Options{Address: serveFake(t, f), MaxRetries: -1})
+	defer c.Close()
+	stored, err := c.InsertBatch(context.Background(), records("a", "b"))
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("got %v, want ErrRejected", err)
+	}
+	if stored != 1 {
+		t.Errorf("InsertBatch stored %v, want 1", stored)
+	}
+}
+
+func TestInsertIsNotResentAfterTheConnectionFails(t *testing.T) {
+	f := &fakeResponder{stored: map[string]int{}, refuseAt: -1, hangUp: true}
+	c := New(Options{Address: serveFake(t, f), BaseBackoff: time.Millisecond})
+	defer c.Close()
+	err := c.Insert(context.Background(), records("a")[0])
+	if !errors.Is(err, ErrUncertain) {
+		t.Fatalf("got %v, want ErrUncertain", err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.calls != 1 {
+		t.Errorf("the record was sent %v times", f.calls)
+	}
+}
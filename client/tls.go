@@ -0,0 +1,42 @@
+// tls.go
+package client
+
+import (
+	"crypto/tls"
+	"flag"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+// The -tls, -ca, -cert and -key flags shared by the command line tools
+type TLSFlags struct {
+	Enable bool
+	CA     string
+	Cert   string
+	Key    string
+}
+
+// Register the TLS flags in fs.  The tools pass flag.CommandLine
+func AddTLSFlags(fs *flag.FlagSet) *TLSFlags {
+	t := &TLSFlags{}
+	fs.BoolVar(&t.Enable, "tls", false, "Connect to the server with TLS")
+	fs.StringVar(&t.CA, "ca", "", "CA certificate that signed the server's certificate.  Implies -tls.  Default: the system roots")
+	fs.StringVar(&t.Cert, "cert", "", "Client certificate, for servers that verify clients.  Implies -tls")
+	fs.StringVar(&t.Key, "key", "", "Private key for -cert")
+	return t
+}
+
+// The TLS settings the flags ask for, or nil if they do not ask for TLS
+func (t *TLSFlags) Config() (*tls.Config, error) {
+	if !t.Enable && t.CA == "" && t.Cert == "" {
+		return nil, nil
+	}
+	return TLSConfig(t.CA, t.Cert, t.Key)
+}
+
+// Build the TLS settings for Options.TLS.
+// caFile is the CA that signed the server's certificate, or empty to use the system roots.
+// certFile and keyFile are the client's own certificate, for servers that verify clients.  Leave them empty otherwise
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	return tagbrowser.ClientTLSConfig(caFile, certFile, keyFile)
+}
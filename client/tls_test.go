@@ -0,0 +1,53 @@
+// tls_test.go
+package client
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func parseTLSFlags(t *testing.T, args ...string) *TLSFlags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := AddTLSFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestTLSFlagsWithoutTLS(t *testing.T) {
+	config, err := parseTLSFlags(t).Config()
+	if err != nil || config != nil {
+		t.Fatalf("Config() = %v, %v, want nil, nil", config, err)
+	}
+}
+
+func TestTLSFlagsUseSystemRoots(t *testing.T) {
+	config, err := parseTLSFlags(t, "-tls").Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config == nil || config.RootCAs != nil || len(config.Certificates) != 0 {
+		t.Fatalf("-tls gave %+v, want a config with the system roots and no client certificate", config)
+	}
+}
+
+func TestTLSFlagsReportBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-ca", filepath.Join(dir, "missing.pem")},
+		{"-ca", notPEM},
+		{"-cert", notPEM, "-key", notPEM},
+	} {
+		if _, err := parseTLSFlags(t, args...).Config(); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
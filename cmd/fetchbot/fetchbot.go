@@ -2,13 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"net/url"
 	"os"
 	"regexp"
@@ -17,6 +17,7 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/donomii/tagdb/client"
 	"github.com/donomii/tagdb/tagbrowser"
 
 	"golang.org/x/net/html"
@@ -50,7 +51,11 @@ func dispatchURLs(urlCh chan string, matchString string) {
 }
 
 var urlCh chan string
-var rpcClient *rpc.Client
+var dbClient *client.Client
+var apiToken = os.Getenv("TAGDB_TOKEN")
+var tlsFlags = client.AddTLSFlags(flag.CommandLine)
+var tlsConfig *tls.Config
+var indexName string
 var debug = false
 
 func hasSymbol(str string) bool {
@@ -67,13 +72,19 @@ func main() {
 	flag.StringVar(&tagbrowser.ServerAddress, "server", tagbrowser.ServerAddress, fmt.Sprintf("Server IP and Port.  Default: %s", tagbrowser.ServerAddress))
 	flag.BoolVar(&debug, "debug", false, "Print extra debugging information")
 	flag.StringVar(&matchString, "match", matchString, "Only follow URLs that match this regular expression")
+	flag.StringVar(&apiToken, "token", apiToken, "API token for servers that have tokens configured.  Default: $TAGDB_TOKEN")
+	flag.StringVar(&indexName, "index", "", "Index to store pages in.  Default: the server's default index")
 	flag.Parse()
+	var err error
+	tlsConfig, err = tlsFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
 	urls := flag.Args()
 	if debug {
 		log.Println("Debugging active")
 	}
-	var err error
-	rpcClient, err = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+	dbClient, err = client.Connect(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName})
 	if err != nil {
 		log.Printf("Failed to connect to tagserver on %s, exiting\n", tagbrowser.ServerAddress)
 		os.Exit(1)
@@ -129,17 +140,11 @@ func handler(ctx *fetchbot.Context, res *http.Response, err error) {
 			}
 		}
 
-		args := &tagbrowser.InsertArgs{fmt.Sprintf("%s", ctx.Cmd.URL()), -1, filtered}
-		reply := &tagbrowser.SuccessReply{}
-		rpcClient, err = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
-		if err != nil {
-			log.Printf("Failed to connect to %s, exiting\n", tagbrowser.ServerAddress)
-			return
-		} else {
-
-			log.Printf("Connected to tagserver on %s\n", tagbrowser.ServerAddress)
+		args := tagbrowser.InsertArgs{Name: fmt.Sprintf("%s", ctx.Cmd.URL()), Position: -1, Tags: filtered}
+		args.Metadata = map[string]string{"host": ctx.Cmd.URL().Hostname(), "fetched": time.Now().UTC().Format(time.RFC3339), "size": fmt.Sprintf("%v", len(body))}
+		if err := dbClient.Insert(context.Background(), args); err != nil {
+			log.Printf("Failed to store %s: %v\n", ctx.Cmd.URL(), err)
 		}
-		rpcClient.Call("TagResponder.InsertRecord", args, reply)
 
 		var (
 			anchorTag = []byte{'a'}
@@ -21,6 +21,7 @@ import (
 
 var noContents = false
 var rpcClient *rpc.Client
+var apiToken = os.Getenv("TAGDB_TOKEN")
 var profile = false
 var debug = false
 var verbose = false
@@ -156,7 +157,7 @@ func processFile(aPath string, fileNameFingerprint []string) {
 
 func makeArgs(aPath string, number int, f []string) *tagbrowser.InsertArgs {
 	url := slashes_regexp.ReplaceAllLiteralString(aPath, "/")
-	args := &tagbrowser.InsertArgs{fmt.Sprintf("%s", url), number, f}
+	args := &tagbrowser.InsertArgs{Name: fmt.Sprintf("%s", url), Position: number, Tags: f, Token: apiToken}
 	return args
 }
 
@@ -227,6 +228,7 @@ func main() {
 	flag.BoolVar(&debug, "debug", false, "Display additional debug information")
 	flag.IntVar(&numworkers, "parallel", 1, "Maximum number of simultaneous inserts to attempt")
 	flag.StringVar(&tagbrowser.ServerAddress, "server", tagbrowser.ServerAddress, fmt.Sprintf("Server IP and Port.  Default: %s", tagbrowser.ServerAddress))
+	flag.StringVar(&apiToken, "token", apiToken, "API token for servers that have tokens configured.  Default: $TAGDB_TOKEN")
 	flag.StringVar(&filePattern, "accept", `.`, "Regexp filter for files.  e.g. 'txt$|doc$'")
 	flag.Parse()
 	dirs := flag.Args()
@@ -0,0 +1,111 @@
+// tagfsck.go
+
+//Checks silo files for inconsistencies, and optionally repairs them.  Stop the tagserver first, tagfsck opens the files
+//directly.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+// The silo files named on the command line.  Directories are searched for silo files
+func siloFiles(args []string) []string {
+	files := []string{}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if info.IsDir() {
+			found := tagbrowser.FindSiloFiles(arg)
+			if len(found) == 0 {
+				log.Printf("No silo files in %v", arg)
+			}
+			files = append(files, found...)
+		} else {
+			files = append(files, arg)
+		}
+	}
+	return files
+}
+
+func printReport(report *tagbrowser.FsckReport, verbose bool) {
+	status := "ok"
+	if !report.OK() {
+		status = "PROBLEMS"
+	}
+	fmt.Printf("%v: %v silo, version %v: %v\n", report.File, report.Kind, report.Version, status)
+	if verbose {
+		tables := []string{}
+		for k := range report.Counts {
+			tables = append(tables, k)
+		}
+		sort.Strings(tables)
+		for _, k := range tables {
+			fmt.Printf("    %v: %v\n", k, report.Counts[k])
+		}
+	}
+	if !report.OK() {
+		fmt.Println("    " + strings.Replace(report.String(), "\n", "\n    ", -1))
+	}
+	for _, u := range report.Unrepairable {
+		fmt.Printf("    cannot repair: %v\n", u)
+	}
+	if report.Backup != "" {
+		fmt.Printf("    the file before repair is in %v\n", report.Backup)
+	}
+}
+
+func main() {
+	repair := flag.Bool("repair", false, "Repair the silos that have problems, keeping a copy of each file first")
+	verbose := flag.Bool("v", false, "Show the number of rows in each table")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-repair] [-v] silo files or directories...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, file := range siloFiles(flag.Args()) {
+		report, err := tagbrowser.CheckSilo(file)
+		if err != nil {
+			fmt.Printf("%v: %v\n", file, err)
+			failed = true
+			continue
+		}
+		printReport(report, *verbose)
+		if report.OK() {
+			continue
+		}
+		if !*repair {
+			failed = true
+			continue
+		}
+		report, err = tagbrowser.RepairSilo(file)
+		if err != nil {
+			fmt.Printf("%v: repair failed: %v\n", file, err)
+			failed = true
+			continue
+		}
+		fmt.Print("After repair, ")
+		printReport(report, *verbose)
+		if !report.OK() {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+
+// fileowner.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"syscall"
+)
+
+var ownerNames = map[string]string{}
+var ownerNamesLock sync.Mutex
+
+// Look up a user or group name, remembering the answer.  Falls back to the number if there is no name
+func ownerName(kind string, id uint32) string {
+	key := fmt.Sprintf("%v:%v", kind, id)
+	ownerNamesLock.Lock()
+	defer ownerNamesLock.Unlock()
+	if name, ok := ownerNames[key]; ok {
+		return name
+	}
+	name := fmt.Sprintf("%v", id)
+	if kind == "user" {
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+	} else {
+		if g, err := user.LookupGroupId(name); err == nil {
+			name = g.Name
+		}
+	}
+	ownerNames[key] = name
+	return name
+}
+
+// The principals that may read a file: its owner, and its group if the group can read it.
+// Returns nil for files that everyone can read, so they are indexed as public
+func filePrincipals(aPath string) []string {
+	info, err := os.Stat(aPath)
+	if err != nil {
+		return nil
+	}
+	perm := info.Mode().Perm()
+	if perm&0004 != 0 {
+		return nil
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	principals := []string{"user:" + ownerName("user", stat.Uid)}
+	if perm&0040 != 0 {
+		principals = append(principals, "group:"+ownerName("group", stat.Gid))
+	}
+	return principals
+}
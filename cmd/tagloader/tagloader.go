@@ -2,11 +2,11 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
 	"path"
 	"regexp"
@@ -16,12 +16,19 @@ import (
 	"sync"
 	"time"
 
+	"github.com/donomii/tagdb/client"
 	"github.com/donomii/tagdb/tagbrowser"
 	"github.com/ungerik/go-dry"
 )
 
 var noContents = false
-var rpcClient *rpc.Client
+var dbClient *client.Client
+var batcher *client.Batcher
+var batchSize = 100
+var apiToken = os.Getenv("TAGDB_TOKEN")
+var tlsFlags = client.AddTLSFlags(flag.CommandLine)
+var tlsConfig *tls.Config
+var indexName string
 var profile = false
 var debug = false
 var verbose = false
@@ -101,21 +108,8 @@ func insertRec(aPath string, number int, f []string) {
 	wg.Add(1)
 	defer wg.Done()
 	args := makeArgs(aPath, number, f)
-	reply := &tagbrowser.SuccessReply{}
-	if rpcClient != nil {
-		rpcClient.Call("TagResponder.InsertRecord", args, reply)
-	}
-	if reply.Success == false {
-		log.Printf("Insert record failed: %v", reply.Reason)
-		time.Sleep(1.0 * time.Second)
-		var serr error
-		rpcClient, serr = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
-		if serr != nil {
-			log.Println("Could not connect to server: ", serr)
-		}
-		insertRec(aPath, number, f)
-	}
-
+	//Failures are reported by batcher.OnError
+	batcher.Add(context.Background(), *args)
 }
 
 func processFile(aPath string, fileNameFingerprint []string) {
@@ -164,10 +158,39 @@ func processFile(aPath string, fileNameFingerprint []string) {
 
 func makeArgs(aPath string, number int, f []string) *tagbrowser.InsertArgs {
 	url := slashes_regexp.ReplaceAllLiteralString(aPath, "/")
-	args := &tagbrowser.InsertArgs{fmt.Sprintf("%s", url), number, f}
+	args := &tagbrowser.InsertArgs{Name: fmt.Sprintf("%s", url), Position: number, Tags: f}
+	if ownerACL {
+		args.Principals = filePrincipals(aPath)
+	}
+	args.Metadata = fileMetadata(aPath)
 	return args
 }
 
+var metaLock sync.Mutex
+var lastMetaPath string
+var lastMeta map[string]string
+
+// The modification time, size and extension of a file.  Every line of a file gets the same fields, so the last answer is kept
+func fileMetadata(aPath string) map[string]string {
+	metaLock.Lock()
+	defer metaLock.Unlock()
+	if aPath == lastMetaPath {
+		return lastMeta
+	}
+	meta := map[string]string{}
+	if info, err := os.Stat(aPath); err == nil {
+		meta["mtime"] = info.ModTime().UTC().Format(time.RFC3339)
+		if !info.IsDir() {
+			meta["size"] = strconv.FormatInt(info.Size(), 10)
+		}
+	}
+	if ext := strings.TrimPrefix(strings.ToLower(path.Ext(aPath)), "."); ext != "" {
+		meta["ext"] = ext
+	}
+	lastMetaPath, lastMeta = aPath, meta
+	return meta
+}
+
 func actuallyProcessFile(fullPath string) {
 	p := fullPath
 	var i int
@@ -219,6 +242,7 @@ func scanDir(aPath string, aCh chan []string) {
 
 var numworkers = 1
 var everyLine bool
+var ownerACL bool
 var filePattern string
 var fileMatch *regexp.Regexp
 
@@ -231,11 +255,20 @@ func main() {
 	flag.BoolVar(&wantHelp, "help", false, "Display help")
 	flag.BoolVar(&verbose, "verbose", false, "Show files as they are loaded")
 	flag.BoolVar(&everyLine, "everyLine", false, "Register every line as a record, rather than treat the entire file as one line")
+	flag.BoolVar(&ownerACL, "acl", false, "Only let the file's owner and group find files that are not world readable")
 	flag.BoolVar(&debug, "debug", false, "Display additional debug information")
 	flag.IntVar(&numworkers, "parallel", 1, "Maximum number of simultaneous inserts to attempt")
+	flag.IntVar(&batchSize, "batch", batchSize, "Number of records to send to the server in each insert")
 	flag.StringVar(&tagbrowser.ServerAddress, "server", tagbrowser.ServerAddress, fmt.Sprintf("Server IP and Port.  Default: %s", tagbrowser.ServerAddress))
 	flag.StringVar(&filePattern, "accept", `.`, "Regexp filter for files.  e.g. 'txt$|doc$'")
+	flag.StringVar(&apiToken, "token", apiToken, "API token for servers that have tokens configured.  Default: $TAGDB_TOKEN")
+	flag.StringVar(&indexName, "index", "", "Index to store records in.  Default: the server's default index")
 	flag.Parse()
+	var err error
+	tlsConfig, err = tlsFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
 	dirs := flag.Args()
 	if len(dirs) < 1 || wantHelp {
 		fmt.Println("Use: loader.exe  <--noContents>  directory")
@@ -268,29 +301,37 @@ func main() {
 		log.Println("Ignoring file contents, only loading file names")
 	}
 	fileMatch = regexp.MustCompile(filePattern)
-	var err error
 	if loadFromArgs {
 		index, _ := strconv.ParseInt(dirs[1], 0, 0)
-		args := &tagbrowser.InsertArgs{dirs[0], int(index), dirs[2:]}
-		reply := &tagbrowser.SuccessReply{false, ""}
+		args := tagbrowser.InsertArgs{Name: dirs[0], Position: int(index), Tags: dirs[2:]}
 		if debug {
 			log.Println("Connecting to server on ", tagbrowser.ServerAddress)
 		}
-		rpcClient, err = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+		dbClient, err = client.Connect(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName})
 		if err != nil {
 			log.Println("Could not connect to server: ", err)
 			os.Exit(1)
 		}
-		rpcClient.Call("TagResponder.InsertRecord", args, reply)
+		defer dbClient.Close()
+		if err := dbClient.Insert(context.Background(), args); err != nil {
+			log.Println("Insert record failed: ", err)
+			os.Exit(1)
+		}
 	} else {
 		if debug {
 			log.Println("Connecting to server on ", tagbrowser.ServerAddress)
 		}
-		rpcClient, err = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+		//Keep sending refused records until the server stores them, e.g. while it starts up
+		dbClient, err = client.Connect(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName, MaxRetries: client.RetryForever, MaxBackoff: time.Second})
 		if err != nil {
 			log.Println("Could not connect to server: ", err)
 			os.Exit(1)
 		}
+		defer dbClient.Close()
+		batcher = dbClient.NewBatcher(batchSize, time.Second)
+		batcher.OnError = func(err error) {
+			log.Printf("Insert record failed: %v", err)
+		}
 		if debug {
 			log.Println("Server connected established, loading...")
 		}
@@ -312,6 +353,7 @@ func main() {
 			}
 		}
 		wg.Wait()
+		batcher.Close(context.Background())
 		//for true {
 		//	time.Sleep(1 * time.Second)
 		//}
@@ -2,113 +2,279 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
-	"net/rpc/jsonrpc"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/donomii/tagdb/client"
 	"github.com/donomii/tagdb/tagbrowser"
 )
 
-func search(terms []string, displayFingerprint bool) {
+func search(c *client.Client, terms []string, match, mode string, displayFingerprint, displayMetadata bool) {
 
 	log.Println("Searching for", terms)
-	client, err := jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
-
-	if err != nil {
-		log.Fatal("dialing:", err)
-	}
 
 	searchTerm := strings.Join(terms, " ")
-	args := &tagbrowser.Args{searchTerm, 10}
-	preply := &tagbrowser.Reply{}
-	err = client.Call("TagResponder.SearchString", args, preply)
+	reply, err := c.Query(context.Background(), tagbrowser.Args{A: searchTerm, Limit: 10, Match: match, Mode: mode})
 	if err != nil {
 		log.Println("RPC error:", err)
 	}
-	for _, v := range preply.C {
+	for _, v := range reply.C {
 		if displayFingerprint {
 			fmt.Printf("%v: %v(%v) %v\n", v.Score, v.Filename, v.Line, v.Fingerprint)
 		} else {
 			fmt.Printf("%v: %v(%v)\n", v.Score, v.Filename, v.Line)
 		}
+		if displayMetadata && len(v.Metadata) > 0 {
+			fmt.Printf("    %v\n", formatMetadata(v.Metadata))
+		}
 	}
 	log.Println("Search complete")
 }
 
-func status() {
-	log.Println("Checking tag database status")
-	client, err := jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+// Search, showing the best files with up to lines lines each
+func searchGroups(c *client.Client, terms []string, lines int) {
+	log.Println("Searching for", terms)
+	groups, err := c.SearchGroups(context.Background(), strings.Join(terms, " "), 10, lines)
+	if err != nil {
+		log.Println("RPC error:", err)
+	}
+	for _, g := range groups {
+		fmt.Printf("%v: %v (%v matching lines)\n", g.Score, g.Filename, g.Hits)
+		for _, v := range g.Lines {
+			fmt.Printf("    %v: line %v\n", v.Score, v.Line)
+		}
+	}
+	log.Println("Search complete")
+}
 
+// Show the records most like the record at "name:line"
+func searchSimilar(c *client.Client, source string) {
+	i := strings.LastIndex(source, ":")
+	if i < 0 {
+		log.Fatal("Use -similar name:line")
+	}
+	line, err := strconv.Atoi(source[i+1:])
 	if err != nil {
-		log.Fatal("dialing:", err)
+		log.Fatal("Use -similar name:line, line must be a number")
 	}
-	args := &tagbrowser.Args{"", 0}
-	sreply := &tagbrowser.StatusReply{}
+	log.Println("Searching for records like", source)
+	results, err := c.Similar(context.Background(), source[:i], line, 10)
+	if err != nil {
+		log.Println("RPC error:", err)
+	}
+	for _, v := range results {
+		fmt.Printf("%v%%: %v(%v)\n", v.Score, v.Filename, v.Line)
+	}
+	log.Println("Search complete")
+}
+
+// Fields as "key=value" pairs, in name order
+func formatMetadata(meta map[string]string) string {
+	fields := []string{}
+	for k, v := range meta {
+		fields = append(fields, k+"="+v)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+func status(c *client.Client) {
+	log.Println("Checking tag database status")
+	ctx := context.Background()
 	log.Println("Fetching status")
-	err = client.Call("TagResponder.Status", args, sreply)
+	answer, err := c.Status(ctx)
 	log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
 	log.Println("General statistics and settings")
-	log.Println("Status: ", *sreply)
+	log.Println("Status: ", answer)
 
-	hreply := &tagbrowser.HistoReply{}
 	log.Println("Fetching Histo Stats")
-	err = client.Call("TagResponder.HistoStatus", args, hreply)
+	histo, err := c.HistoStatus(ctx)
 	log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
-	log.Println("Number of files the tag occurred in : Number of tags with this occurrence", hreply)
+	log.Println("Number of files the tag occurred in : Number of tags with this occurrence", histo)
 	for i := 0; i < 50; i = i + 1 {
-		fmt.Printf("%d: %v\n", i, hreply.TagsToFilesHisto[fmt.Sprintf("%d", i)])
+		fmt.Printf("%d: %v\n", i, histo[fmt.Sprintf("%d", i)])
 	}
-	treply := &tagbrowser.TopTagsReply{}
 	log.Println("Fetching status")
-	err = client.Call("TagResponder.TopTagsStatus", args, treply)
+	topTags, err := c.TopTagsStatus(ctx)
 	log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
 	fmt.Println("Top tags, by the number of files they occur in:")
-	for k, v := range treply.TopTags {
+	for k, v := range topTags {
 		log.Println(k, ":", v)
 	}
 	log.Println("Check complete")
 }
 
+// The number of silos in a backup
+func backupSilos(manifest *tagbrowser.BackupManifest) int {
+	n := 0
+	for _, f := range manifest.Farms {
+		n = n + len(f.Silos)
+	}
+	return n
+}
+
 var completeMatch = false
+var apiToken = os.Getenv("TAGDB_TOKEN")
+var tlsFlags = client.AddTLSFlags(flag.CommandLine)
+var tlsConfig *tls.Config
+var indexName string
 
 func main() {
 	var shutdown bool
 	fetchStatus := false
 	displayFingerprint := false
+	displayMetadata := false
+	sortBy := ""
+	groupLines := 0
+	similar := ""
+	mode := ""
+	match := ""
+	var createIndex string
+	addSilos := 0
+	drainSilo := ""
+	compact := false
+	routeRecords := false
+	farm := ""
+	promote := false
+	backup := ""
+	restore := ""
 	flag.StringVar(&tagbrowser.ServerAddress, "server", tagbrowser.ServerAddress, fmt.Sprintf("Server IP and Port.  Default: %s", tagbrowser.ServerAddress))
-	flag.BoolVar(&completeMatch, "completeMatch", false, "Do not return partial matches")
+	flag.BoolVar(&completeMatch, "completeMatch", false, "Do not return partial matches.  The same as -match all")
+	flag.StringVar(&match, "match", "", "\"any\" to find records with any of the words, \"all\" for records with every word, or \"minimum_should_match=N\" for records with at least N.  Default: any")
 	flag.BoolVar(&fetchStatus, "status", false, "Report status")
 	flag.BoolVar(&shutdown, "shutdown", false, "Shutdown the server")
+	flag.StringVar(&createIndex, "createIndex", "", "Create an index with this name, in database/<name> on the server.  Needs an admin token")
+	flag.IntVar(&addSilos, "addSilos", 0, "Add this many silos to a disk farm of -index, and move records into them.  Needs an admin token")
+	flag.StringVar(&drainSilo, "drainSilo", "", "Move the records out of the silo with this id, in a disk farm of -index, then retire it.  Needs an admin token")
+	flag.BoolVar(&compact, "compact", false, "Compact the silos of a disk farm of -index in the background, removing deleted data and unused strings.  Needs an admin token")
+	flag.BoolVar(&routeRecords, "routeRecords", false, "Move each record of a farm of -index that held records before routing to the silo its file routes to, so deletes only look in that silo.  Needs an admin token")
+	flag.StringVar(&farm, "farm", "", "Location of the farm for -addSilos, -drainSilo, -compact and -routeRecords.  Only needed if the index has several farms")
+	flag.StringVar(&backup, "backup", "", "Write a snapshot of every farm to this new or empty directory, on the server.  Needs an admin token")
+	flag.StringVar(&restore, "restore", "", "Check the backup in this directory, on the server, and replace the server's silos with it.  Needs an admin token")
+	flag.BoolVar(&promote, "promote", false, "Make a replication follower the leader, so it takes writes.  Needs an admin token")
 	flag.BoolVar(&displayFingerprint, "fingerprint", false, "Display the tag fingerprint for each result")
+	flag.BoolVar(&displayMetadata, "metadata", false, "Display the metadata fields for each result")
+	flag.IntVar(&groupLines, "group", 0, "Show one result per file, with this many of its best lines.  Default: one result per line")
+	flag.StringVar(&mode, "mode", "", "\"vector\" to find the records with the nearest vectors, or \"hybrid\" to mix them with the tag results.  Needs an embedder on the server.  Default: tags")
+	flag.StringVar(&similar, "similar", "", "Show the records most like the record at name:line, instead of searching")
+	flag.StringVar(&sortBy, "sort", "", "Sort by this metadata field, or -field for largest first.  Default: best score first")
+	flag.StringVar(&apiToken, "token", apiToken, "API token for servers that have tokens configured.  Default: $TAGDB_TOKEN")
+	flag.StringVar(&indexName, "index", "", "Index to search.  \"a,b\" searches several indexes, \"*\" searches all of them.  Default: the server's default index")
 	flag.Parse()
+	var err error
+	tlsConfig, err = tlsFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := client.New(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName})
+	defer c.Close()
 	if shutdown {
-		client, _ := jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
-		args := &tagbrowser.Args{"", 0}
-		sreply := &tagbrowser.StatusReply{}
-		client.Call("TagResponder.Shutdown", args, sreply)
+		if err := c.Shutdown(context.Background()); err != nil {
+			log.Println("RPC error:", err)
+		}
+		os.Exit(0)
+	}
+	if createIndex != "" {
+		if err := c.CreateIndex(context.Background(), tagbrowser.CreateIndexArgs{Name: createIndex}); err != nil {
+			log.Println("Could not create index:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Created index", createIndex)
+		os.Exit(0)
+	}
+	if addSilos > 0 {
+		if err := c.AddSilos(context.Background(), tagbrowser.SiloArgs{Farm: farm, Silos: addSilos}); err != nil {
+			log.Println("Could not add silos:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Added", addSilos, "silos, records are being moved.  See -status for progress")
+		os.Exit(0)
+	}
+	if drainSilo != "" {
+		if err := c.DrainSilo(context.Background(), tagbrowser.SiloArgs{Farm: farm, Silo: drainSilo}); err != nil {
+			log.Println("Could not drain silo:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Draining silo", drainSilo, "  See -status for progress")
+		os.Exit(0)
+	}
+	if compact {
+		if err := c.Compact(context.Background(), tagbrowser.SiloArgs{Farm: farm}); err != nil {
+			log.Println("Could not compact:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Compacting, see -status for progress")
 		os.Exit(0)
 	}
+	if routeRecords {
+		if err := c.RouteRecords(context.Background(), tagbrowser.SiloArgs{Farm: farm}); err != nil {
+			log.Println("Could not route records:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Moving records, see -status for progress")
+		os.Exit(0)
+	}
+	if backup != "" {
+		manifest, err := c.Backup(context.Background(), backup)
+		if err != nil {
+			log.Println("Could not back up:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Backed up", backupSilos(manifest), "silos to", backup)
+		os.Exit(0)
+	}
+	if restore != "" {
+		manifest, err := c.Restore(context.Background(), restore)
+		if err != nil {
+			log.Println("Could not restore:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Restored", backupSilos(manifest), "silos from the backup taken", manifest.Created)
+		os.Exit(0)
+	}
+	if promote {
+		if err := c.Promote(context.Background()); err != nil {
+			log.Println("Could not promote:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Promoted to leader")
+		os.Exit(0)
+	}
+	if completeMatch {
+		match = tagbrowser.MatchAll
+	}
 	terms := flag.Args()
+	if sortBy != "" {
+		terms = append(terms, "sort:"+sortBy)
+	}
 	if len(terms) < 1 {
 		fmt.Println("Use: query.exe  < --completeMatch >  search terms")
 
 	}
 
 	if fetchStatus {
-		status()
+		status(c)
+	} else if similar != "" {
+		searchSimilar(c, similar)
+	} else if groupLines > 0 {
+		searchGroups(c, terms, groupLines)
 	} else {
-		search(terms, displayFingerprint)
+		search(c, terms, match, mode, displayFingerprint, displayMetadata)
 	}
 }
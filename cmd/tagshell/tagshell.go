@@ -2,22 +2,25 @@
 package main
 
 import (
+	"github.com/donomii/tagdb/client"
 	"github.com/donomii/tagdb/tagbrowser"
+	"github.com/donomii/tagdb/tagdbpb"
 	//"strings"
 	"bufio"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/rpc/jsonrpc"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"time"
-	//"sort"
-	"net/rpc"
 
 	"github.com/nsf/termbox-go"
+	"google.golang.org/grpc"
 
 	"sync"
 )
@@ -39,7 +42,14 @@ var focus = "input"
 var inputPos = 0
 var searchStr string
 var debugStr = ""
-var client *rpc.Client
+var dbClient *client.Client
+var grpcAddress = ""
+var apiToken = os.Getenv("TAGDB_TOKEN")
+var tlsFlags = client.AddTLSFlags(flag.CommandLine)
+var tlsConfig *tls.Config
+var indexName string
+var groupLines int
+var grpcClient tagdbpb.TagDBClient
 
 var predictResults []string
 
@@ -71,16 +81,98 @@ func search(searchTerm string, numResults int) []tagbrowser.ResultRecordTransmit
 	statuses["Status"] = "Searching"
 	//log.Println("Searching for: ", searchTerm)
 
-	args := &tagbrowser.Args{searchTerm, numResults}
-	preply := &tagbrowser.Reply{}
-	err := client.Call("TagResponder.SearchString", args, preply)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := dbClient.Search(ctx, searchTerm, numResults)
 	if err != nil {
 		//log.Fatal("RPC error:", err)
 		statuses["Status"] = fmt.Sprintf("RPC error: %v", err)
 	} else {
 		statuses["Status"] = "Search complete"
 	}
-	return preply.C
+	return res
+}
+
+//Convert gRPC results to the same format as the JSON-RPC results
+func fromGrpcResults(in []*tagdbpb.Result) []tagbrowser.ResultRecordTransmittable {
+	out := []tagbrowser.ResultRecordTransmittable{}
+	for _, r := range in {
+		out = append(out, tagbrowser.ResultRecordTransmittable{Filename: r.Filename, Line: fmt.Sprintf("%v", r.Line), Fingerprint: r.Fingerprint, Sample: r.Sample, Score: fmt.Sprintf("%v", r.Score), Metadata: r.Metadata})
+	}
+	return out
+}
+
+//Add new results to a result list, keeping the best numResults
+func mergeResults(current, extra []tagbrowser.ResultRecordTransmittable, numResults int) []tagbrowser.ResultRecordTransmittable {
+	merged := append(append([]tagbrowser.ResultRecordTransmittable{}, current...), extra...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, _ := strconv.Atoi(merged[i].Score)
+		b, _ := strconv.Atoi(merged[j].Score)
+		return a > b
+	})
+	if len(merged) > numResults {
+		merged = merged[0:numResults]
+	}
+	return merged
+}
+
+//Contact server with search string, and display the results from each farm as soon as they arrive
+func streamSearch(searchTerm string, numResults int) []tagbrowser.ResultRecordTransmittable {
+	statuses["Status"] = "Searching"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stream, err := grpcClient.SearchStream(ctx, &tagdbpb.SearchRequest{Query: searchTerm, Limit: int32(numResults), Index: indexName})
+	if err != nil {
+		statuses["Status"] = fmt.Sprintf("RPC error: %v", err)
+		return results
+	}
+	partial := []tagbrowser.ResultRecordTransmittable{}
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			statuses["Status"] = fmt.Sprintf("RPC error: %v", err)
+			return partial
+		}
+		if ev.Done {
+			statuses["Status"] = fmt.Sprintf("Search complete (%v farms, %vms)", ev.Farms, ev.Milliseconds)
+			return fromGrpcResults(ev.Results)
+		}
+		partial = mergeResults(partial, fromGrpcResults(ev.Results), numResults)
+		results = partial
+		refreshTerm()
+	}
+}
+
+//Search for the best files, listing each file's best lines together
+func groupSearch(searchTerm string, numResults int) []tagbrowser.ResultRecordTransmittable {
+	statuses["Status"] = "Searching"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	groups, err := dbClient.SearchGroups(ctx, searchTerm, numResults, groupLines)
+	if err != nil {
+		statuses["Status"] = fmt.Sprintf("RPC error: %v", err)
+		return nil
+	}
+	res := []tagbrowser.ResultRecordTransmittable{}
+	for _, g := range groups {
+		res = append(res, g.Lines...)
+	}
+	if len(res) > numResults {
+		res = res[:numResults]
+	}
+	statuses["Status"] = fmt.Sprintf("Search complete (%v files)", len(groups))
+	return res
+}
+
+//Search with streaming if the gRPC port is available, otherwise with JSON-RPC
+func runSearch(searchTerm string, numResults int) []tagbrowser.ResultRecordTransmittable {
+	if groupLines > 0 {
+		return groupSearch(searchTerm, numResults)
+	}
+	if grpcClient != nil {
+		return streamSearch(searchTerm, numResults)
+	}
+	return search(searchTerm, numResults)
 }
 
 //Contact server request predictions
@@ -88,53 +180,51 @@ func predictString(searchTerm string) []string {
 	statuses["Status"] = "Predicting"
 	//log.Println("Predicting: ", searchTerm)
 
-	args := &tagbrowser.Args{searchTerm, 10}
-	preply := &tagbrowser.StringListReply{}
-	err := client.Call("TagResponder.PredictString", args, preply)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	res, err := dbClient.Predict(ctx, searchTerm, 10)
 	if err != nil {
 		//log.Println("RPC error:", err)
 		statuses["Status"] = fmt.Sprintf("RPC error: %v", err)
 	} else {
 		statuses["Status"] = "Predict complete"
 	}
-	return preply.C
+	return res
 }
 
 func status() {
 	log.Println("Checking tag database status")
-	client, err := jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+	c, err := client.Connect(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName})
 
 	if err != nil {
 		log.Fatal("dialing:", err)
 	}
-	args := &tagbrowser.Args{"", 0}
-	sreply := &tagbrowser.StatusReply{}
+	defer c.Close()
+	ctx := context.Background()
 	//log.Println("Fetching status")
-	err = client.Call("TagResponder.Status", args, sreply)
+	answer, err := c.Status(ctx)
 	//log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
-	fmt.Println("Status: ", *sreply)
+	fmt.Println("Status: ", answer)
 
-	hreply := &tagbrowser.HistoReply{}
 	log.Println("Fetching status")
-	err = client.Call("TagResponder.HistoStatus", args, hreply)
+	histo, err := c.HistoStatus(ctx)
 	log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
-	fmt.Println("Status: ", hreply)
-	fmt.Printf("0: %v\n1: %v\n2: %v\n3: %v\n4: %v\n5: %v\n6: %v\n7: %v\n8: %v\n", hreply.TagsToFilesHisto["0"], hreply.TagsToFilesHisto["1"], hreply.TagsToFilesHisto["2"], hreply.TagsToFilesHisto["3"], hreply.TagsToFilesHisto["4"], hreply.TagsToFilesHisto["5"], hreply.TagsToFilesHisto["6"], hreply.TagsToFilesHisto["7"], hreply.TagsToFilesHisto["8"])
+	fmt.Println("Status: ", histo)
+	fmt.Printf("0: %v\n1: %v\n2: %v\n3: %v\n4: %v\n5: %v\n6: %v\n7: %v\n8: %v\n", histo["0"], histo["1"], histo["2"], histo["3"], histo["4"], histo["5"], histo["6"], histo["7"], histo["8"])
 
-	treply := &tagbrowser.TopTagsReply{}
 	log.Println("Fetching status")
-	err = client.Call("TagResponder.TopTagsStatus", args, treply)
+	topTags, err := c.TopTagsStatus(ctx)
 	log.Println("Received status")
 	if err != nil {
 		log.Fatal("RPC error:", err)
 	}
-	fmt.Println("Status: ", *treply)
+	fmt.Println("Status: ", topTags)
 
 	log.Println("Check complete")
 }
@@ -245,6 +335,16 @@ func extractWord(aLine string, pos int) string {
 	start := searchLeft(aLine, pos)
 	return aLine[start:pos]
 }
+// Tell the server which result was opened, so it ranks higher in later searches
+func reportLaunch(filename, line string) {
+	pos, _ := strconv.Atoi(line)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := dbClient.Click(ctx, filename, pos); err != nil {
+		statuses["Status"] = fmt.Sprintf("Could not report open: %v", err)
+	}
+}
+
 func doInput() {
 	if !serverActive {
 		return
@@ -273,6 +373,7 @@ func doInput() {
 					if line < 0 {
 						line = 0
 					}
+					go reportLaunch(results[selection].Filename, results[selection].Line)
 					if isLinux() || isDarwin() {
 						termbox.Close()
 						tagbrowser.Launch(results[selection].Filename, fmt.Sprintf("%v", line))
@@ -306,7 +407,7 @@ func doInput() {
 					refreshTerm()
 				case termbox.KeyEnter:
 
-					results = search(searchStr, 10)
+					results = runSearch(searchStr, 10)
 
 					//sort.Sort(results) FIXME
 					focus = "selection"
@@ -319,7 +420,7 @@ func doInput() {
 				default:
 					//statuses["Input"] = ev.Key
 					searchStr = fmt.Sprintf("%s%c", searchStr, ev.Ch)
-					results = search(searchStr, 10)
+					results = runSearch(searchStr, 10)
 					//sort.Sort(results) FIXME
 					predictResults = predictString(extractWord(searchStr, inputPos+1))
 					inputPos += 1
@@ -393,7 +494,16 @@ func shutdown() {
 func main() {
 	LineCache = map[string]string{}
 	flag.StringVar(&tagbrowser.ServerAddress, "server", tagbrowser.ServerAddress, fmt.Sprintf("Server IP and Port.  Default: %s", tagbrowser.ServerAddress))
+	flag.StringVar(&grpcAddress, "grpc", grpcAddress, "Server gRPC IP and Port, e.g. 127.0.0.1:6782.  If set, results are displayed as each farm returns them")
+	flag.StringVar(&apiToken, "token", apiToken, "API token for servers that have tokens configured.  Default: $TAGDB_TOKEN")
+	flag.IntVar(&groupLines, "group", 0, "List results by file, with up to this many lines from each file.  Default: one result per line")
+	flag.StringVar(&indexName, "index", "", "Index to search.  \"a,b\" searches several indexes, \"*\" searches all of them.  Default: the server's default index")
 	flag.Parse()
+	var err error
+	tlsConfig, err = tlsFlags.Config()
+	if err != nil {
+		log.Fatal(err)
+	}
 	//terms := flag.Args()
 	//if len(terms) < 1 {
 	//	fmt.Println("Use: query.exe  < --completeMatch >  search terms")
@@ -416,13 +526,24 @@ func main() {
 	go automaticdoInput()
 
 	statuses["Server"] = "Connecting"
-	var err error
-	client, err = jsonrpc.Dial("tcp", tagbrowser.ServerAddress)
+	dbClient, err = client.Connect(client.Options{Address: tagbrowser.ServerAddress, Token: apiToken, TLS: tlsConfig, Index: indexName})
 
 	if err != nil {
 		log.Fatal("dialing:", err)
 	}
-	defer client.Close()
+	defer dbClient.Close()
+	if grpcAddress != "" {
+		opts := []grpc.DialOption{tagdbpb.WithToken(apiToken)}
+		if tlsConfig != nil {
+			opts = append(opts, tagdbpb.WithTLS(tlsConfig))
+		}
+		c, conn, err := tagdbpb.Dial(grpcAddress, opts...)
+		if err != nil {
+			log.Fatal("dialing:", err)
+		}
+		defer conn.Close()
+		grpcClient = c
+	}
 	statuses["Server"] = "Connected"
 	for {
 		time.Sleep(1 * time.Second)
@@ -2,3 +2,4 @@
     go build -o release/tagquery.exe cmd/tagquery/tagquery.go
     go build -o release/tagserver.exe cmd/tagserver/tagserver.go
     go build -o release/tagloader.exe cmd/tagloader/tagloader.go
+    go build -o release/tagfsck.exe cmd/tagfsck/tagfsck.go
@@ -0,0 +1,174 @@
+// acl.go
+
+//Per-record access control.  A record may carry a list of principals (users or groups, e.g. "user:alice", "group:hr").
+//Only callers whose token lists one of those principals can find the record.  Records without principals can be found by everyone.
+//
+//The principals are stored as extra tags on the record, so they are saved by every kind of silo without changing the
+//storage formats.  The tags start with a control character, which the search string splitters never produce, so they
+//cannot be searched for and are removed from results.
+
+package tagbrowser
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const aclTagPrefix = "\x1facl:"
+
+// Every record with principals also carries this tag, so a silo can tell restricted records from public ones
+const aclRestrictedTag = aclTagPrefix
+
+func aclTag(principal string) string {
+	return aclTagPrefix + principal
+}
+
+func isACLTag(tag string) bool {
+	return strings.HasPrefix(tag, aclTagPrefix)
+}
+
+// Add the ACL tags for principals to a record's tags
+func withACLTags(tags []string, principals []string) []string {
+	if len(principals) == 0 {
+		return tags
+	}
+	out := make([]string, 0, len(tags)+len(principals)+1)
+	for _, t := range tags {
+		if !isACLTag(t) {
+			out = append(out, t)
+		}
+	}
+	out = append(out, aclRestrictedTag)
+	for _, p := range principals {
+		if p != "" {
+			out = append(out, aclTag(p))
+		}
+	}
+	return out
+}
+
+// The principals a searcher holds.  A nil viewer can see every record
+type viewer struct {
+	principals []string
+}
+
+// Work out what the holder of token may see.  Admin tokens, and servers without tokens, see everything
+func viewerFor(token string) *viewer {
+	if !authEnabled() {
+		return nil
+	}
+	t := lookupToken(token)
+	if t == nil {
+		return &viewer{}
+	}
+	if t.scope >= scopeAdmin {
+		return nil
+	}
+	return &viewer{principals: t.principals}
+}
+
+// The viewer for a search.  Admin tokens may search as a caller holding other principals, with restricted and viewAs
+func searchViewer(token string, restricted bool, viewAs []string) *viewer {
+	v := viewerFor(token)
+	if v == nil && restricted {
+		return &viewer{principals: cleanPrincipals(viewAs)}
+	}
+	return v
+}
+
+// A viewer's principals, as symbols in one silo
+type aclFilter struct {
+	restricted int          //Symbol of aclRestrictedTag, 0 if the silo has no restricted records
+	allowed    map[int]bool //Symbols of the viewer's principals
+}
+
+func (s *tagSilo) aclFilterFor(v *viewer) aclFilter {
+	f := aclFilter{allowed: map[int]bool{}}
+	if v == nil {
+		return f
+	}
+	f.restricted = s.lookupSymbol(aclRestrictedTag)
+	for _, p := range v.principals {
+		if sym := s.lookupSymbol(aclTag(p)); sym != 0 {
+			f.allowed[sym] = true
+		}
+	}
+	return f
+}
+
+func (f aclFilter) visible(r record) bool {
+	if f.restricted == 0 {
+		return true
+	}
+	restricted := false
+	for _, tag := range r.Fingerprint {
+		if f.allowed[tag] {
+			return true
+		}
+		if tag == f.restricted {
+			restricted = true
+		}
+	}
+	return !restricted
+}
+
+// Every record that has at least one of the tags
+func (s *tagSilo) candidateRecords(tags fingerPrint) []record {
+	out := []record{}
+	if s.memory_db {
+		seen := map[*record]bool{}
+		s.writeMutex.Lock()
+		defer s.writeMutex.Unlock()
+		for _, tag := range tags {
+			if tag <= 0 || tag >= len(s.tag2file) {
+				continue
+			}
+			for _, r := range s.tag2file[tag] {
+				if r != nil && !seen[r] {
+					seen[r] = true
+					out = append(out, *r)
+				}
+			}
+		}
+		return out
+	}
+
+	seen := map[int]bool{}
+	for _, tag := range tags {
+		if tag <= 0 {
+			continue
+		}
+		for _, id := range s.Store.GetRecordId(tag) {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			out = append(out, s.getRecord(id))
+		}
+	}
+	return out
+}
+
+// Fetch a record from a disk silo, using the cache if possible
+func (s *tagSilo) getRecord(id int) record {
+	if r, ok := s.record_cache.Load(id); ok {
+		return r
+	}
+	r := s.Store.GetRecord([]byte(strconv.Itoa(id)))
+	s.record_cache.Store(id, r)
+	return r
+}
+
+// Sort and de-duplicate a list of principals, e.g. from a config file
+func cleanPrincipals(principals []string) []string {
+	trimmed := []string{}
+	for _, p := range principals {
+		if p = strings.TrimSpace(p); p != "" {
+			trimmed = append(trimmed, p)
+		}
+	}
+	out := uniqStrings(trimmed)
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,83 @@
+// acl_test.go
+package tagbrowser
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSearchesOnlyFindRecordsTheCallerMaySee(t *testing.T) {
+	useTokens(t, map[string]tokenInfo{
+		"alice": {Token: "alice-secret", Scope: "write", Principals: []string{"user:alice"}},
+		"hr":    {Token: "hr-secret", Scope: "read", Principals: []string{"group:hr", "user:carol"}},
+		"bob":   {Token: "bob-secret", Scope: "read", Principals: []string{"user:bob"}},
+		"admin": {Token: "admin-secret", Scope: "admin"},
+	})
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 1)
+			tr := &TagResponder{Manor: m}
+			for _, args := range []InsertArgs{
+				{Name: "public.txt", Position: 1, Tags: []string{"report"}},
+				{Name: "alice.txt", Position: 1, Tags: []string{"report"}, Principals: []string{"user:alice"}},
+				{Name: "salaries.txt", Position: 1, Tags: []string{"report"}, Principals: []string{"group:hr", "user:alice"}},
+			} {
+				args.Token = "alice-secret"
+				if err := tr.InsertRecord(&args, &SuccessReply{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			search := func(args Args) []string {
+				args.A, args.Limit = "report", 10
+				reply := &Reply{}
+				if err := tr.SearchString(&args, reply); err != nil {
+					t.Fatal(err)
+				}
+				names := []string{}
+				for _, r := range reply.C {
+					for _, tag := range r.Fingerprint {
+						if isACLTag(tag) {
+							t.Errorf("%v was returned with its access list tag %q", r.Filename, tag)
+						}
+					}
+					names = append(names, r.Filename)
+				}
+				sort.Strings(names)
+				return names
+			}
+			waitUntil(t, "the records", func() bool { return len(search(Args{Token: "admin-secret"})) == 3 })
+
+			for _, c := range []struct {
+				who  string
+				args Args
+				want []string
+			}{
+				{"alice", Args{Token: "alice-secret"}, []string{"alice.txt", "public.txt", "salaries.txt"}},
+				{"hr", Args{Token: "hr-secret"}, []string{"public.txt", "salaries.txt"}},
+				{"bob", Args{Token: "bob-secret"}, []string{"public.txt"}},
+				{"no token", Args{}, []string{"public.txt"}},
+				{"admin as hr", Args{Token: "admin-secret", Restricted: true, ViewAs: []string{"group:hr"}}, []string{"public.txt", "salaries.txt"}},
+				{"bob as alice", Args{Token: "bob-secret", Restricted: true, ViewAs: []string{"user:alice"}}, []string{"public.txt"}},
+			} {
+				if got := search(c.args); !reflect.DeepEqual(got, c.want) {
+					t.Errorf("%v found %v, want %v", c.who, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithACLTagsReplacesTheAccessList(t *testing.T) {
+	tags := withACLTags([]string{"fox", aclTag("user:old")}, []string{"user:alice", ""})
+	want := []string{"fox", aclRestrictedTag, aclTag("user:alice")}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("withACLTags = %q, want %q", tags, want)
+	}
+	if got := withACLTags([]string{"fox"}, nil); !reflect.DeepEqual(got, []string{"fox"}) {
+		t.Errorf("a record without principals got tags %q", got)
+	}
+	if got := cleanPrincipals([]string{" user:b ", "user:a", "", "user:b"}); !reflect.DeepEqual(got, []string{"user:a", "user:b"}) {
+		t.Errorf("cleanPrincipals = %q", got)
+	}
+}
@@ -0,0 +1,361 @@
+// auth.go
+
+//API tokens.  Each token in the [Tokens] section of tagdb.conf has a scope, and every RPC method and HTTP path needs a scope.
+//If no tokens are configured, nothing is checked, so a private server works as before.
+//
+//JSON-RPC callers put the token in the Token field of the call's arguments.  HTTP callers (including /rpc) send
+//"Authorization: Bearer <token>", and gRPC callers send the same thing as "authorization" metadata.
+
+package tagbrowser
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/rpc"
+	"strings"
+
+	"github.com/donomii/tagdb/tagdbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type scope int
+
+// Each scope includes the scopes before it, so an admin token can also read and write
+const (
+	scopeNone scope = iota
+	scopeRead
+	scopeWrite
+	scopeAdmin
+)
+
+var ErrNoToken = errors.New("permission denied: missing or unknown token")
+
+// Wrapped by the error for a token whose scope is too small
+var errPermissionDenied = errors.New("permission denied")
+
+type accessToken struct {
+	name       string
+	secret     []byte
+	scope      scope
+	principals []string //Users and groups, for records with access lists
+}
+
+// Set by loadTokens before the servers start, and not changed afterwards
+var accessTokens []accessToken
+
+// The scope needed to call each TagResponder method.  Methods not listed here need admin
+var methodScopes = map[string]scope{
+	"TagResponder.SearchString":   scopeRead,
+	"TagResponder.PredictString":  scopeRead,
+	"TagResponder.Status":         scopeRead,
+	"TagResponder.HistoStatus":    scopeRead,
+	"TagResponder.TopTagsStatus":  scopeRead,
+	"TagResponder.InsertRecord":   scopeWrite,
+	"TagResponder.InsertRecords":  scopeWrite,
+	"TagResponder.DeleteRecord":   scopeWrite,
+	"TagResponder.RecordClick":    scopeRead,
+	"TagResponder.SimilarRecords": scopeRead,
+	"TagResponder.Shutdown":       scopeAdmin,
+	"TagResponder.CreateIndex":    scopeAdmin,
+	"TagResponder.AddSilos":       scopeAdmin,
+	"TagResponder.DrainSilo":      scopeAdmin,
+	"TagResponder.RouteRecords":   scopeAdmin,
+	"TagResponder.Compact":        scopeAdmin,
+	"TagResponder.Replicate":      scopeAdmin,
+	"TagResponder.Promote":        scopeAdmin,
+	"TagResponder.Backup":         scopeAdmin,
+	"TagResponder.Restore":        scopeAdmin,
+}
+
+// The scope needed for each gRPC method.  Methods not listed here need admin
+var grpcScopes = map[string]scope{
+	tagdbpb.TagDB_Search_FullMethodName:       scopeRead,
+	tagdbpb.TagDB_SearchStream_FullMethodName: scopeRead,
+	tagdbpb.TagDB_Predict_FullMethodName:      scopeRead,
+	tagdbpb.TagDB_Status_FullMethodName:       scopeRead,
+	tagdbpb.TagDB_Click_FullMethodName:        scopeRead,
+	tagdbpb.TagDB_Similar_FullMethodName:      scopeRead,
+	tagdbpb.TagDB_Insert_FullMethodName:       scopeWrite,
+	tagdbpb.TagDB_BulkInsert_FullMethodName:   scopeWrite,
+	tagdbpb.TagDB_Delete_FullMethodName:       scopeWrite,
+}
+
+func (s scope) String() string {
+	switch s {
+	case scopeRead:
+		return "read"
+	case scopeWrite:
+		return "write"
+	case scopeAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+func parseScope(s string) (scope, error) {
+	switch strings.ToLower(s) {
+	case "read":
+		return scopeRead, nil
+	case "write":
+		return scopeWrite, nil
+	case "admin":
+		return scopeAdmin, nil
+	}
+	return scopeNone, fmt.Errorf("unknown scope %q, use read, write or admin", s)
+}
+
+// A copy of the config with every token replaced, so it can be logged
+func redactedConfig(config tomlConfig) tomlConfig {
+	hide := func(token string) string {
+		if token == "" {
+			return ""
+		}
+		return "[redacted]"
+	}
+	tokens := map[string]tokenInfo{}
+	for name, t := range config.Tokens {
+		t.Token = hide(t.Token)
+		tokens[name] = t
+	}
+	config.Tokens = tokens
+	farms := map[string]serverInfo{}
+	for name, f := range config.Farms {
+		f.Token = hide(f.Token)
+		farms[name] = f
+	}
+	config.Farms = farms
+	config.Replication.Token = hide(config.Replication.Token)
+	return config
+}
+
+// Check and install the tokens from the config file
+func loadTokens(tokens map[string]tokenInfo) error {
+	loaded := []accessToken{}
+	for name, t := range tokens {
+		if t.Token == "" {
+			return fmt.Errorf("token %v: no Token set", name)
+		}
+		s, err := parseScope(t.Scope)
+		if err != nil {
+			return fmt.Errorf("token %v: %v", name, err)
+		}
+		loaded = append(loaded, accessToken{name, []byte(t.Token), s, cleanPrincipals(t.Principals)})
+	}
+	accessTokens = loaded
+	if len(accessTokens) == 0 {
+		log.Println("No tokens configured, authentication is disabled")
+	} else {
+		log.Printf("Loaded %v tokens, authentication is enabled", len(accessTokens))
+	}
+	return nil
+}
+
+func authEnabled() bool {
+	return len(accessTokens) > 0
+}
+
+// Find a token.  Every token is compared, so the time taken does not depend on which one matched
+func lookupToken(token string) *accessToken {
+	var found *accessToken
+	for i, t := range accessTokens {
+		if subtle.ConstantTimeCompare([]byte(token), t.secret) == 1 {
+			found = &accessTokens[i]
+		}
+	}
+	return found
+}
+
+func tokenScope(token string) scope {
+	if t := lookupToken(token); t != nil {
+		return t.scope
+	}
+	return scopeNone
+}
+
+// Returns an error if token may not do things that need scope want
+func authorize(token string, want scope) error {
+	if !authEnabled() || want == scopeNone {
+		return nil
+	}
+	have := tokenScope(token)
+	if have == scopeNone {
+		return ErrNoToken
+	}
+	if have < want {
+		return fmt.Errorf("%w: %v scope needed, token has %v", errPermissionDenied, want, have)
+	}
+	return nil
+}
+
+func methodScope(method string) scope {
+	if s, ok := methodScopes[method]; ok {
+		return s
+	}
+	return scopeAdmin
+}
+
+// Argument types that carry a token
+type tokenHolder interface {
+	authToken() string
+	setAuthToken(token string)
+}
+
+func (a *Args) authToken() string            { return a.Token }
+func (a *InsertArgs) authToken() string      { return a.Token }
+func (a *BatchInsertArgs) authToken() string { return a.Token }
+func (a *DeleteArgs) authToken() string      { return a.Token }
+func (a *CreateIndexArgs) authToken() string { return a.Token }
+func (a *ClickArgs) authToken() string       { return a.Token }
+func (a *SimilarArgs) authToken() string     { return a.Token }
+func (a *SiloArgs) authToken() string        { return a.Token }
+func (a *ReplicateArgs) authToken() string   { return a.Token }
+func (a *BackupArgs) authToken() string      { return a.Token }
+
+func (a *Args) setAuthToken(token string)            { a.Token = token }
+func (a *InsertArgs) setAuthToken(token string)      { a.Token = token }
+func (a *BatchInsertArgs) setAuthToken(token string) { a.Token = token }
+func (a *DeleteArgs) setAuthToken(token string)      { a.Token = token }
+func (a *CreateIndexArgs) setAuthToken(token string) { a.Token = token }
+func (a *ClickArgs) setAuthToken(token string)       { a.Token = token }
+func (a *SimilarArgs) setAuthToken(token string)     { a.Token = token }
+func (a *SiloArgs) setAuthToken(token string)        { a.Token = token }
+func (a *ReplicateArgs) setAuthToken(token string)   { a.Token = token }
+func (a *BackupArgs) setAuthToken(token string)      { a.Token = token }
+
+// Wraps a JSON-RPC codec, and refuses calls that the caller's token does not allow.
+// A token in the arguments is used first, then the one the connection was opened with
+type authCodec struct {
+	rpc.ServerCodec
+	token  string
+	method string
+}
+
+func newAuthCodec(codec rpc.ServerCodec, token string) rpc.ServerCodec {
+	return &authCodec{ServerCodec: codec, token: token}
+}
+
+func (a *authCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := a.ServerCodec.ReadRequestHeader(r)
+	a.method = r.ServiceMethod
+	return err
+}
+
+// An error returned here is sent back to the caller, and the method is not called
+func (a *authCodec) ReadRequestBody(x interface{}) error {
+	if err := a.ServerCodec.ReadRequestBody(x); err != nil {
+		return err
+	}
+	if x == nil {
+		//Unknown method, the rpc server will report it
+		return nil
+	}
+	token := a.token
+	if holder, ok := x.(tokenHolder); ok {
+		if holder.authToken() != "" {
+			token = holder.authToken()
+		} else {
+			//Methods use the token to decide which records the caller may see
+			holder.setAuthToken(token)
+		}
+	}
+	if err := authorize(token, methodScope(a.method)); err != nil {
+		log.Printf("Refused %v: %v", a.method, err)
+		return err
+	}
+	return nil
+}
+
+// The token from an "Authorization: Bearer" header
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// The scope needed for an HTTP request.  /rpc is checked per method by authCodec
+func httpScope(req *http.Request) scope {
+	path := req.URL.Path
+	switch {
+	case path == "/rpc" || path == "/api/openapi.json":
+		return scopeNone
+	case path == "/api/records" && req.Method != http.MethodGet:
+		return scopeWrite
+	case path == "/api/indexes" && req.Method != http.MethodGet:
+		return scopeAdmin
+	case strings.HasPrefix(path, "/api/silos") || path == "/api/promote" || path == "/api/backup" || path == "/api/restore":
+		return scopeAdmin
+	case strings.HasPrefix(path, "/api/"):
+		return scopeRead
+	case strings.HasPrefix(path, "/files/") || strings.HasPrefix(path, "/debug/"):
+		//The whole working directory, and the profiler
+		return scopeAdmin
+	}
+	return scopeNone
+}
+
+// Checks the bearer token on every HTTP request before passing it to next
+func authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		err := authorize(bearerToken(req.Header.Get("Authorization")), httpScope(req))
+		if err == ErrNoToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tagdb"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// The bearer token sent with a gRPC call
+func grpcToken(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("authorization"); len(vals) > 0 {
+			return bearerToken(vals[0])
+		}
+	}
+	return ""
+}
+
+func grpcAuthorize(ctx context.Context, method string) error {
+	want, ok := grpcScopes[method]
+	if !ok {
+		want = scopeAdmin
+	}
+	err := authorize(grpcToken(ctx), want)
+	if err == ErrNoToken {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+func grpcAuthOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := grpcAuthorize(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuthorize(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
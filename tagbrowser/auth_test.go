@@ -0,0 +1,161 @@
+// auth_test.go
+package tagbrowser
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc/jsonrpc"
+	"strings"
+	"testing"
+)
+
+// Install tokens until the test finishes
+func useTokens(t *testing.T, tokens map[string]tokenInfo) {
+	t.Helper()
+	if err := loadTokens(tokens); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accessTokens = nil })
+}
+
+var testTokens = map[string]tokenInfo{
+	"reader": {Token: "read-secret", Scope: "read", Principals: []string{"user:alice"}},
+	"writer": {Token: "write-secret", Scope: "write"},
+	"admin":  {Token: "admin-secret", Scope: "Admin"},
+}
+
+func TestLoadTokensRefusesBadTokens(t *testing.T) {
+	defer func() { accessTokens = nil }()
+	for name, tokens := range map[string]map[string]tokenInfo{
+		"no secret":     {"a": {Scope: "read"}},
+		"unknown scope": {"a": {Token: "x", Scope: "owner"}},
+	} {
+		if err := loadTokens(tokens); err == nil {
+			t.Errorf("%v: loaded", name)
+		}
+	}
+}
+
+func TestAuthorizeScopes(t *testing.T) {
+	if err := authorize("", scopeAdmin); err != nil {
+		t.Errorf("without tokens, an admin call was refused: %v", err)
+	}
+	useTokens(t, testTokens)
+	for _, c := range []struct {
+		token string
+		want  scope
+		ok    bool
+	}{
+		{"", scopeRead, false},
+		{"wrong", scopeRead, false},
+		{"", scopeNone, true},
+		{"read-secret", scopeRead, true},
+		{"read-secret", scopeWrite, false},
+		{"write-secret", scopeRead, true},
+		{"write-secret", scopeAdmin, false},
+		{"admin-secret", scopeAdmin, true},
+	} {
+		if err := authorize(c.token, c.want); (err == nil) != c.ok {
+			t.Errorf("authorize(%q, %v) = %v", c.token, c.want, err)
+		}
+	}
+	if methodScope("TagResponder.NotAMethod") != scopeAdmin {
+		t.Error("an unlisted method does not need admin")
+	}
+}
+
+func TestHTTPRequestsNeedTokens(t *testing.T) {
+	useTokens(t, testTokens)
+	handler := authHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	for _, c := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/api/search", "", http.StatusUnauthorized},
+		{"GET", "/api/search", "read-secret", http.StatusOK},
+		{"GET", "/api/records", "read-secret", http.StatusOK},
+		{"POST", "/api/records", "read-secret", http.StatusForbidden},
+		{"POST", "/api/records", "write-secret", http.StatusOK},
+		{"POST", "/api/silos/compact", "write-secret", http.StatusForbidden},
+		{"POST", "/api/silos/compact", "admin-secret", http.StatusOK},
+		{"GET", "/files/tagdb.conf", "read-secret", http.StatusForbidden},
+		{"GET", "/api/openapi.json", "", http.StatusOK},
+		{"POST", "/rpc", "", http.StatusOK},
+	} {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%v %v with %q: status %v, want %v", c.method, c.path, c.token, w.Code, c.want)
+		}
+	}
+}
+
+func TestRPCCallsUseTheBearerToken(t *testing.T) {
+	useTokens(t, testTokens)
+	m := testManor(t, "memory", 1)
+	storeRecords(t, m.Farms[0],
+		RecordTransmittable{"source.txt", 1, []string{"common", "rare"}},
+		RecordTransmittable{"public.txt", 1, []string{"common", "rare"}},
+		RecordTransmittable{"alice.txt", 1, withACLTags([]string{"common", "rare"}, []string{"user:alice"})},
+	)
+	similar := func(token string) []string {
+		t.Helper()
+		body := `{"method": "TagResponder.SimilarRecords", "params": [{"Name": "source.txt", "Position": 1}], "id": 1}`
+		reply := struct {
+			Result *Reply
+			Error  interface{}
+		}{}
+		if err := json.NewDecoder(NewRPCRequest(strings.NewReader(body)).Call(m, token)).Decode(&reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Error != nil {
+			t.Fatalf("SimilarRecords with %q: %v", token, reply.Error)
+		}
+		names := []string{}
+		for _, r := range reply.Result.C {
+			names = append(names, r.Filename)
+		}
+		return names
+	}
+	if got := similar("read-secret"); len(got) != 2 {
+		t.Errorf("alice's token found %v, want public.txt and alice.txt", got)
+	}
+	if got := similar("write-secret"); len(got) != 1 || got[0] != "public.txt" {
+		t.Errorf("a token without principals found %v, want public.txt", got)
+	}
+}
+
+func TestJSONRPCCallsNeedTokens(t *testing.T) {
+	useTokens(t, testTokens)
+	m := testManor(t, "memory", 1)
+	client, err := jsonrpc.Dial("tcp", serveTestManor(t, m, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	insert := func(token string) error {
+		return client.Call("TagResponder.InsertRecord", &InsertArgs{Name: "a.txt", Position: 1, Tags: []string{"fox"}, Token: token}, &SuccessReply{})
+	}
+	if err := insert(""); err == nil {
+		t.Error("an insert without a token was allowed")
+	}
+	if err := insert("read-secret"); err == nil {
+		t.Error("an insert with a read token was allowed")
+	}
+	if err := insert("write-secret"); err != nil {
+		t.Errorf("an insert with a write token was refused: %v", err)
+	}
+	if err := client.Call("TagResponder.Compact", &SiloArgs{Token: "write-secret"}, &SuccessReply{}); err == nil {
+		t.Error("Compact with a write token was allowed")
+	}
+	waitUntil(t, "a.txt", func() bool {
+		reply := &Reply{}
+		return client.Call("TagResponder.SearchString", &Args{A: "fox", Limit: 10, Token: "read-secret"}, reply) == nil && len(reply.C) == 1
+	})
+}
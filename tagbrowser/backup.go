@@ -0,0 +1,456 @@
+// backup.go
+
+//Backups.  The Backup RPC writes a snapshot of every farm to a directory on the server, and Restore puts one back.
+//
+//While the silos are copied, writes from clients and from the leader wait, records are not moved between silos, and the
+//records already queued are stored first, so every silo is copied at the same point.  Memory silos are written in the
+//same format as their checkpoints, and disk silos are copied by SQLite while they stay open.  Writes start again before
+//the copies are checksummed.  A manifest lists every file with its size and SHA-256, and the position in the replication
+//log, so a follower can be started from a leader's backup.
+//
+//Restore checks every file against the manifest, and copies the backup's files next to each farm, before it changes
+//anything.  Then it swaps each farm's silos for the ones in the backup.  The files it replaces are moved to a
+//before-restore directory in the farm's location.  If a farm cannot be swapped, the farms already swapped are put back
+//as they were.
+
+package tagbrowser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const manifestName = "manifest.toml"
+
+// The manifest format this server writes and reads
+const manifestVersion = 1
+
+// Held by backups and restores, so records are not moved between silos while the silos are copied.  Movers hold it for
+// reading while they move a batch
+var movesLock sync.RWMutex
+
+// Stop writes and record moves, and wait until the queued records are stored.  Call the returned function to start them
+// again.  Writes are stopped first, as a write to a full memory farm waits for the offload mover
+func (m *Manor) pauseWrites() (func(), error) {
+	if m.repl != nil {
+		m.repl.order.Lock()
+	}
+	movesLock.Lock()
+	resume := func() {
+		movesLock.Unlock()
+		if m.repl != nil {
+			m.repl.order.Unlock()
+		}
+	}
+	m.waitForQueues(reshardTimeout)
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	for _, f := range m.Farms {
+		if f.remote == nil && f.queued() > 0 {
+			resume()
+			return nil, fmt.Errorf("farm %v still has %v records waiting to be stored", f.location, f.queued())
+		}
+	}
+	return resume, nil
+}
+
+// Write a snapshot of every farm to dir, which must be new or empty
+func (m *Manor) Backup(dir string) (*BackupManifest, error) {
+	if dir == "" {
+		return nil, errors.New("no backup directory given")
+	}
+	if names, err := filepath.Glob(filepath.Join(dir, "*")); err != nil || len(names) > 0 {
+		return nil, fmt.Errorf("%v is not empty, backups need a new or empty directory", dir)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resume, err := m.pauseWrites()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if resume != nil {
+			resume()
+		}
+	}()
+
+	manifest := &BackupManifest{Version: manifestVersion, Created: time.Now().UTC()}
+	if m.repl != nil {
+		m.repl.lock.Lock()
+		manifest.ReplicationLog = m.repl.logID
+		manifest.ReplicationLast = m.repl.last
+		m.repl.lock.Unlock()
+	}
+	m.indexLock.RLock()
+	farms := append([]*Farm{}, m.Farms...)
+	m.indexLock.RUnlock()
+	for i, f := range farms {
+		if f.remote != nil {
+			manifest.Skipped = append(manifest.Skipped, f.location)
+			continue
+		}
+		entry, err := f.backup(dir, fmt.Sprintf("farm%v", i))
+		if err != nil {
+			return nil, fmt.Errorf("farm %v: %v", f.location, err)
+		}
+		manifest.Farms = append(manifest.Farms, entry)
+	}
+	resume()
+	resume = nil
+	log.Printf("Copied %v farms to %v in %v, writes have started again", len(manifest.Farms), dir, time.Since(start))
+
+	for i := range manifest.Farms {
+		for j := range manifest.Farms[i].Silos {
+			s := &manifest.Farms[i].Silos[j]
+			if s.Size, s.SHA256, err = fileChecksum(filepath.Join(dir, filepath.FromSlash(s.File))); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writeManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+	log.Printf("Backed up %v farms to %v in %v", len(manifest.Farms), dir, time.Since(start))
+	return manifest, nil
+}
+
+// Copy each of the farm's silos to dir/sub.  The silos' sizes and checksums are left for the caller
+func (f *Farm) backup(dir string, sub string) (BackupFarm, error) {
+	entry := BackupFarm{Index: f.index, Location: f.location, Memory: f.memory_only}
+	if err := os.MkdirAll(filepath.Join(dir, sub), 0777); err != nil {
+		return entry, err
+	}
+	f.checkpointMutex.Lock()
+	defer f.checkpointMutex.Unlock()
+	for _, s := range f.siloList() {
+		name := filepath.Join(sub, filepath.Base(s.filename))
+		if s.memory_db {
+			name = name + ".checkpoint"
+		}
+		var err error
+		if s.memory_db {
+			err = s.writeCheckpointFile(filepath.Join(dir, name))
+		} else {
+			s.Store.Flush(s)
+			err = s.Store.Backup(s, filepath.Join(dir, name))
+		}
+		if err != nil {
+			return entry, fmt.Errorf("silo %v: %v", s.id, err)
+		}
+		entry.Silos = append(entry.Silos, BackupSilo{Id: s.id, File: filepath.ToSlash(name)})
+	}
+	return entry, nil
+}
+
+func fileChecksum(filename string) (int64, string, error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, in)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeManifest(dir string, manifest *BackupManifest) error {
+	tmpName := filepath.Join(dir, manifestName+".tmp")
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# tagdb backup.  Restore it with tagquery -restore")
+	if err := toml.NewEncoder(f).Encode(manifest); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, manifestName))
+}
+
+// Read a backup's manifest, and check every file it lists
+func verifyBackup(dir string) (*BackupManifest, error) {
+	manifest := &BackupManifest{}
+	if _, err := toml.DecodeFile(filepath.Join(dir, manifestName), manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest: %v", err)
+	}
+	if manifest.Version != manifestVersion {
+		return nil, fmt.Errorf("the manifest has version %v, this server reads version %v", manifest.Version, manifestVersion)
+	}
+	for _, farm := range manifest.Farms {
+		for _, s := range farm.Silos {
+			if strings.Contains(s.File, "..") || filepath.IsAbs(s.File) {
+				return nil, fmt.Errorf("the manifest names a file outside the backup: %v", s.File)
+			}
+			size, sum, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(s.File)))
+			if err != nil {
+				return nil, err
+			}
+			if size != s.Size || sum != s.SHA256 {
+				return nil, fmt.Errorf("%v does not match the manifest, the backup is damaged", s.File)
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// Check a backup, then replace the silos of every farm in it with the backup's silos
+func (m *Manor) Restore(dir string) (*BackupManifest, error) {
+	manifest, err := verifyBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	targets := []*Farm{}
+	for _, entry := range manifest.Farms {
+		f, err := m.farmAt(entry.Index, entry.Location)
+		if err != nil {
+			return nil, err
+		}
+		if f.remote != nil || f.memory_only != entry.Memory {
+			return nil, fmt.Errorf("farm %v is a different kind of farm to the one in the backup", entry.Location)
+		}
+		targets = append(targets, f)
+	}
+
+	swaps := []*siloSwap{}
+	defer func() {
+		for _, sw := range swaps {
+			os.RemoveAll(sw.staged)
+		}
+	}()
+	for i, f := range targets {
+		sw, err := f.stageRestore(dir, manifest.Farms[i].Silos)
+		if sw != nil {
+			swaps = append(swaps, sw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("farm %v: %v", f.location, err)
+		}
+	}
+
+	resume, err := m.pauseWrites()
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	for _, sw := range swaps {
+		sw.detach()
+	}
+	for i, sw := range swaps {
+		if err := sw.swap(); err != nil {
+			for j := i; j >= 0; j-- {
+				swaps[j].undo()
+			}
+			return nil, fmt.Errorf("farm %v: %v.  Every farm was put back as it was", sw.f.location, err)
+		}
+	}
+	for _, sw := range swaps {
+		sw.finish()
+	}
+	if m.repl != nil && manifest.ReplicationLog != "" {
+		//Carry on the log from the point of the backup, so a follower made from a leader's backup can follow it
+		m.repl.lock.Lock()
+		m.repl.logID = manifest.ReplicationLog
+		m.repl.last = manifest.ReplicationLast
+		m.repl.entries = nil
+		m.repl.replayTo = 0
+		m.repl.lock.Unlock()
+		if err := m.repl.saveLast(); err != nil {
+			return nil, fmt.Errorf("could not save the place in the replication log: %v", err)
+		}
+	}
+	log.Printf("Restored %v farms from %v, taken %v", len(targets), dir, manifest.Created)
+	return manifest, nil
+}
+
+// A restore of one farm, with what it has changed so far, so it can be undone
+type siloSwap struct {
+	f        *Farm
+	staged   string   //Directory in the farm's location holding copies of the backup's silo files
+	ids      []string //The backup's silos
+	old      []*tagSilo
+	readOnly []bool   //The old silos' ReadOnly, to put back
+	closed   bool     //True once the old disk silos are closed
+	aside    string   //Where the old files are moved
+	moved    []string //Old files moved to aside
+	opened   []*tagSilo
+}
+
+// Copy the backup's silo files to a directory in the farm's location, so a full disk stops the restore before the farm
+// changes, and the files can be moved into place quickly.  Returns the swap to make, even on error, so the caller can
+// remove the directory
+func (f *Farm) stageRestore(dir string, silos []BackupSilo) (*siloSwap, error) {
+	now := time.Now().Format("20060102-150405")
+	sw := &siloSwap{f: f, staged: filepath.Join(f.location, "restoring-"+now), aside: filepath.Join(f.location, "before-restore-"+now)}
+	if err := os.MkdirAll(sw.staged, 0777); err != nil {
+		return nil, err
+	}
+	for _, s := range silos {
+		src := filepath.Join(dir, filepath.FromSlash(s.File))
+		if err := copyFile(src, filepath.Join(sw.staged, filepath.Base(src))); err != nil {
+			return sw, err
+		}
+		sw.ids = append(sw.ids, s.Id)
+	}
+	sortSiloIDs(sw.ids)
+	return sw, nil
+}
+
+// Remove every silo from the farm, so no new searches or records reach them, and wait for the searches using them
+func (sw *siloSwap) detach() {
+	f := sw.f
+	f.siloLock.Lock()
+	sw.old = f.silos
+	f.silos = []*tagSilo{}
+	f.siloLock.Unlock()
+	for _, s := range sw.old {
+		sw.readOnly = append(sw.readOnly, s.ReadOnly)
+		s.ReadOnly = true
+		s.useLock.Lock()
+		s.useLock.Unlock()
+	}
+}
+
+// Close the farm's old disk silos, move their files aside, move the backup's files in, and open them as the farm's
+// silos.  Memory silos are left open until finish, so undo can put them back
+func (sw *siloSwap) swap() error {
+	f := sw.f
+	for _, s := range sw.old {
+		if !s.memory_db {
+			s.halt()
+			s.Store.Close(s)
+		}
+	}
+	sw.closed = true
+	if err := os.MkdirAll(sw.aside, 0777); err != nil {
+		return err
+	}
+	files, _ := filepath.Glob(filepath.Join(f.location, "tagSilo_*.tagdb*"))
+	for _, name := range files {
+		if strings.HasSuffix(name, retiredSuffix) {
+			continue
+		}
+		if err := os.Rename(name, filepath.Join(sw.aside, filepath.Base(name))); err != nil {
+			return err
+		}
+		sw.moved = append(sw.moved, filepath.Base(name))
+	}
+	staged, _ := filepath.Glob(filepath.Join(sw.staged, "*"))
+	for _, name := range staged {
+		if err := os.Rename(name, filepath.Join(f.location, filepath.Base(name))); err != nil {
+			return err
+		}
+	}
+	for _, id := range sw.ids {
+		aSilo, err := f.newSilo(id, 10)
+		if err != nil {
+			return fmt.Errorf("silo %v: %v", id, err)
+		}
+		sw.opened = append(sw.opened, aSilo)
+	}
+	f.siloLock.Lock()
+	f.silos = sw.opened
+	f.siloLock.Unlock()
+	log.Printf("Restored %v silos to %v, the old files are in %v", len(sw.ids), f.location, sw.aside)
+	return nil
+}
+
+// Put the farm back as it was before swap.  Errors are logged, as the other farms must still be put back
+func (sw *siloSwap) undo() {
+	f := sw.f
+	f.siloLock.Lock()
+	f.silos = []*tagSilo{}
+	f.siloLock.Unlock()
+	for _, s := range sw.opened {
+		retireRestored(s)
+	}
+	//The backup's files, and any the new silos made, such as SQLite journals
+	files, _ := filepath.Glob(filepath.Join(f.location, "tagSilo_*.tagdb*"))
+	for _, name := range files {
+		if strings.HasSuffix(name, retiredSuffix) {
+			continue
+		}
+		if err := os.Rename(name, filepath.Join(sw.staged, filepath.Base(name))); err != nil {
+			log.Printf("Undoing the restore of %v: %v", f.location, err)
+		}
+	}
+	for _, name := range sw.moved {
+		if err := os.Rename(filepath.Join(sw.aside, name), filepath.Join(f.location, name)); err != nil {
+			log.Printf("Undoing the restore of %v: %v", f.location, err)
+		}
+	}
+	os.Remove(sw.aside)
+	silos := []*tagSilo{}
+	for i, s := range sw.old {
+		if s.memory_db || !sw.closed {
+			s.ReadOnly = sw.readOnly[i]
+			silos = append(silos, s)
+			continue
+		}
+		aSilo, err := f.newSilo(s.id, 10)
+		if err != nil {
+			log.Printf("Undoing the restore of %v, could not reopen silo %v: %v", f.location, s.id, err)
+			continue
+		}
+		silos = append(silos, aSilo)
+	}
+	f.siloLock.Lock()
+	f.silos = silos
+	f.siloLock.Unlock()
+	log.Printf("Put %v back as it was before the restore", f.location)
+}
+
+// Stop the old silos, once every farm has been swapped.  The disk silos were closed by swap
+func (sw *siloSwap) finish() {
+	for _, s := range sw.old {
+		s.halt()
+	}
+}
+
+// Stop a silo that a restore has removed.  It is not closed, as that would write its checkpoint over the file of the
+// silo that replaced it
+func retireRestored(s *tagSilo) {
+	s.halt()
+	if !s.memory_db {
+		s.Store.Close(s)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,96 @@
+// backup_test.go
+package tagbrowser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The names of the files a search of m finds
+func foundFiles(m *Manor, query string) map[string]bool {
+	found := map[string]bool{}
+	for _, r := range m.Search(query, 100) {
+		found[r.Filename] = true
+	}
+	return found
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 2)
+			f := m.Farms[0]
+			storeRecords(t, f, RecordTransmittable{"a.txt", 1, []string{"fox"}}, RecordTransmittable{"b.txt", 1, []string{"fox"}})
+			dir := filepath.Join(t.TempDir(), "backup")
+			manifest, err := m.Backup(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Farms) != 1 || len(manifest.Farms[0].Silos) != 2 || manifest.Farms[0].Silos[0].SHA256 == "" {
+				t.Fatalf("manifest %+v, want one farm of two checksummed silos", manifest)
+			}
+
+			m.DeleteRecords("a.txt", 1, false)
+			storeRecords(t, f, RecordTransmittable{"c.txt", 1, []string{"fox"}})
+			if _, err := m.Restore(dir); err != nil {
+				t.Fatal(err)
+			}
+			if found := foundFiles(m, "fox"); !found["a.txt"] || !found["b.txt"] || found["c.txt"] {
+				t.Errorf("after restoring, found %v, want a.txt and b.txt", found)
+			}
+			if staged, _ := filepath.Glob(filepath.Join(f.location, "restoring-*")); len(staged) > 0 {
+				t.Errorf("the restore left %v", staged)
+			}
+		})
+	}
+}
+
+func TestFailedRestorePutsFarmsBack(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestManor(t, map[string]FarmConfig{
+		"a": {Location: filepath.Join(dir, "a"), Silos: 1, Mode: "disk"},
+		"b": {Location: filepath.Join(dir, "b"), Silos: 1, Mode: "disk"},
+	})
+	for _, f := range m.Farms {
+		storeRecords(t, f, RecordTransmittable{f.location + "/old.txt", 1, []string{"fox"}})
+	}
+	backup := filepath.Join(dir, "backup")
+	manifest, err := m.Backup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range m.Farms {
+		storeRecords(t, f, RecordTransmittable{f.location + "/new.txt", 1, []string{"fox"}})
+	}
+
+	//Damage the last farm's silo in a way the checksums cannot see, so it only fails once the first farm is swapped
+	last := &manifest.Farms[len(manifest.Farms)-1].Silos[0]
+	name := filepath.Join(backup, filepath.FromSlash(last.File))
+	if err := os.WriteFile(name, []byte("not a database, but long enough to look like the start of one......................................................"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if last.Size, last.SHA256, err = fileChecksum(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManifest(backup, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Restore(backup); err == nil {
+		t.Fatal("restored a backup with a damaged silo")
+	}
+	found := foundFiles(m, "fox")
+	for _, f := range m.Farms {
+		if !found[f.location+"/old.txt"] || !found[f.location+"/new.txt"] {
+			t.Errorf("after the failed restore, found %v, want the old and new records of %v", found, f.location)
+		}
+		if left, _ := filepath.Glob(filepath.Join(f.location, "*-restore-*")); len(left) > 0 {
+			t.Errorf("the failed restore left %v", left)
+		}
+		if left, _ := filepath.Glob(filepath.Join(f.location, "restoring-*")); len(left) > 0 {
+			t.Errorf("the failed restore left %v", left)
+		}
+	}
+	storeRecords(t, m.Farms[0], RecordTransmittable{"later.txt", 1, []string{"fox"}})
+}
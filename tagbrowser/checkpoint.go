@@ -0,0 +1,302 @@
+// checkpoint.go
+
+//Checkpoints of memory silos.  A checkpoint starts with a magic string and a format version, followed by sections that
+//each hold one part of the silo, gob encoded, with its length and CRC-32C.  An empty section marks the end, so a torn
+//write is caught as well as a damaged one.
+//
+//Checkpoints are written to a temporary file, synced, and renamed into place, and the previous checkpoint is kept as
+//.checkpoint.bak.  Older checkpoints, a plain gob of SerialiseMe, are still read, and are rewritten in the new format at
+//the next checkpoint.  If a silo's checkpoint exists but neither it nor the .bak can be read, the silo starts empty and
+//refuses to write a checkpoint, so the damaged files are kept until someone looks at them.
+
+package tagbrowser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const checkpointMagic = "TAGDBCKP"
+
+// The checkpoint format this server writes.  Version 0 is the unversioned gob of SerialiseMe, and version 2 added the
+// vector store
+const checkpointVersion = 2
+
+// How often memory silos with changes are checkpointed
+const checkpointInterval = time.Second * 300
+
+// The sections of a checkpoint, in the order they are written
+const (
+	sectionEnd = iota
+	sectionMeta
+	sectionCounters
+	sectionStrings
+	sectionDatabase
+	sectionTag2file
+	sectionTag2record
+	sectionVectors
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// The fields of SerialiseMe that are not in sections of their own
+type checkpointMeta struct {
+	Id                   string
+	Last_database_record int
+	Next_string_index    int
+	Last_tag_record      int
+	Temporary            bool
+	Offload_index        int
+	Offloading           bool
+	MaxRecords           int
+}
+
+// The value each section holds
+func checkpointSections(d *SerialiseMe, meta *checkpointMeta) map[int]interface{} {
+	return map[int]interface{}{
+		sectionMeta:       meta,
+		sectionCounters:   &d.Counters,
+		sectionStrings:    &d.Reverse_string_table,
+		sectionDatabase:   &d.Database,
+		sectionTag2file:   &d.Tag2file,
+		sectionTag2record: &d.Tag2record,
+		sectionVectors:    &d.Vectors,
+	}
+}
+
+// Write d in the current checkpoint format
+func encodeCheckpoint(w io.Writer, d *SerialiseMe) error {
+	meta := &checkpointMeta{d.Id, d.Last_database_record, d.Next_string_index, d.Last_tag_record, d.Temporary, d.Offload_index, d.Offloading, d.MaxRecords}
+	sections := checkpointSections(d, meta)
+	header := make([]byte, len(checkpointMagic)+2)
+	copy(header, checkpointMagic)
+	binary.LittleEndian.PutUint16(header[len(checkpointMagic):], checkpointVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	for id := sectionMeta; id <= sectionVectors; id++ {
+		var payload bytes.Buffer
+		if err := gob.NewEncoder(&payload).Encode(sections[id]); err != nil {
+			return fmt.Errorf("section %v: %v", id, err)
+		}
+		if err := writeSection(w, id, payload.Bytes()); err != nil {
+			return err
+		}
+	}
+	return writeSection(w, sectionEnd, nil)
+}
+
+func writeSection(w io.Writer, id int, payload []byte) error {
+	head := make([]byte, 14)
+	binary.LittleEndian.PutUint16(head[0:], uint16(id))
+	binary.LittleEndian.PutUint64(head[2:], uint64(len(payload)))
+	binary.LittleEndian.PutUint32(head[10:], crc32.Checksum(payload, crcTable))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Read a checkpoint in any format, from r, which holds size bytes.  Returns the format's version.  A section longer than
+// the bytes left is reported as truncated before it is read, so a damaged length cannot make it allocate more than that
+func decodeCheckpoint(r io.Reader, size int64) (*SerialiseMe, int, error) {
+	in := bufio.NewReader(r)
+	magic, err := in.Peek(len(checkpointMagic))
+	if err != nil || string(magic) != checkpointMagic {
+		//Written before checkpoints had a header
+		d := &SerialiseMe{}
+		if err := gob.NewDecoder(in).Decode(d); err != nil {
+			return nil, 0, err
+		}
+		return d, 0, nil
+	}
+	header := make([]byte, len(checkpointMagic)+2)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, 0, err
+	}
+	version := int(binary.LittleEndian.Uint16(header[len(checkpointMagic):]))
+	if version > checkpointVersion {
+		return nil, version, fmt.Errorf("checkpoint version %v is newer than this server reads (%v)", version, checkpointVersion)
+	}
+	d := &SerialiseMe{}
+	meta := &checkpointMeta{}
+	sections := checkpointSections(d, meta)
+	seen := map[int]bool{}
+	left := size - int64(len(header))
+	for {
+		head := make([]byte, 14)
+		if _, err := io.ReadFull(in, head); err != nil {
+			return nil, version, fmt.Errorf("checkpoint is truncated: %v", err)
+		}
+		left = left - int64(len(head))
+		id := int(binary.LittleEndian.Uint16(head[0:]))
+		length := binary.LittleEndian.Uint64(head[2:])
+		sum := binary.LittleEndian.Uint32(head[10:])
+		if id == sectionEnd {
+			break
+		}
+		if left < 0 || length > uint64(left) {
+			return nil, version, fmt.Errorf("checkpoint is truncated in section %v: it should have %v bytes, but only %v are left", id, length, left)
+		}
+		left = left - int64(length)
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(in, payload); err != nil {
+			return nil, version, fmt.Errorf("checkpoint is truncated in section %v: %v", id, err)
+		}
+		if crc32.Checksum(payload, crcTable) != sum {
+			return nil, version, fmt.Errorf("checkpoint section %v is damaged, its checksum does not match", id)
+		}
+		val, ok := sections[id]
+		if !ok {
+			return nil, version, fmt.Errorf("checkpoint has an unknown section %v", id)
+		}
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(val); err != nil {
+			return nil, version, fmt.Errorf("checkpoint section %v: %v", id, err)
+		}
+		seen[id] = true
+	}
+	if !seen[sectionMeta] {
+		return nil, version, errors.New("checkpoint has no metadata section")
+	}
+	d.Id = meta.Id
+	d.Last_database_record = meta.Last_database_record
+	d.Next_string_index = meta.Next_string_index
+	d.Last_tag_record = meta.Last_tag_record
+	d.Temporary = meta.Temporary
+	d.Offload_index = meta.Offload_index
+	d.Offloading = meta.Offloading
+	d.MaxRecords = meta.MaxRecords
+	return d, version, nil
+}
+
+func readCheckpointFile(filename string) (*SerialiseMe, int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	return decodeCheckpoint(f, info.Size())
+}
+
+// Read the silo's checkpoint, or its .bak if the checkpoint cannot be read.  Returns nil if the silo has no checkpoint.
+// If there is one but it cannot be read, the silo is marked so it does not overwrite it
+func (s *tagSilo) loadCheckpoint() *SerialiseMe {
+	var failures []string
+	for _, name := range []string{s.filename + ".checkpoint", s.filename + ".checkpoint.bak"} {
+		d, version, err := readCheckpointFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", name, err))
+			continue
+		}
+		if version < checkpointVersion {
+			log.Printf("%v is in checkpoint format %v, it will be rewritten in format %v", name, version, checkpointVersion)
+		}
+		for _, failure := range failures {
+			log.Printf("Could not read %v, used %v instead", failure, name)
+		}
+		s.LogChan["file"] <- fmt.Sprintln(name, " exists, reading stored data")
+		return d
+	}
+	if len(failures) > 0 {
+		s.checkpointError = fmt.Sprintf("%v", failures)
+		log.Printf("SILO %v STARTED EMPTY: its checkpoint could not be read, and will not be overwritten until it is moved away: %v", s.id, failures)
+		s.LogChan["error"] <- fmt.Sprintln("Error decoding checkpoint: ", failures)
+	}
+	return nil
+}
+
+// Write the silo's checkpoint, keeping the previous one as .checkpoint.bak
+func (s *tagSilo) saveCheckpoint() error {
+	if s.checkpointError != "" {
+		return fmt.Errorf("not overwriting the checkpoint of silo %v, which could not be read: %v", s.id, s.checkpointError)
+	}
+	s.checkpointMutex.Lock()
+	defer s.checkpointMutex.Unlock()
+	name := s.filename + ".checkpoint"
+	if err := s.writeCheckpointFile(name + ".tmp"); err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	if err := os.Rename(name, name+".bak"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	//Make the renames durable
+	if dir, err := os.Open(filepath.Dir(name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	s.setDirty(false)
+	return nil
+}
+
+// Write the silo to filename in the current checkpoint format, and sync it
+func (s *tagSilo) writeCheckpointFile(filename string) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	s.writeMutex.Lock()
+	counters := map[string]int{}
+	s.counters.Range(func(k string, v int) bool {
+		counters[k] = v
+		return true
+	})
+	s.vectorLock.RLock()
+	vectors := make(map[vectorKey][]float32, len(s.vectors))
+	for k, v := range s.vectors {
+		vectors[k] = v
+	}
+	s.vectorLock.RUnlock()
+	d := SerialiseMe{s.id, s.last_database_record, s.database, counters, s.next_string_index, s.last_tag_record, s.reverse_string_table, s.tag2file, s.tag2record, s.temporary, s.offload_index, s.offloading, s.maxRecords, vectors}
+	err = encodeCheckpoint(w, &d)
+	s.writeMutex.Unlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Checkpoint the silo when it has changed, until it stops
+func (s *tagSilo) checkpointSaveWorker() {
+	defer s.threadsWait.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(checkpointInterval):
+		}
+		if s.isDirty() {
+			if err := s.saveCheckpoint(); err != nil {
+				log.Printf("Checkpointing silo %v: %v", s.id, err)
+			}
+		}
+	}
+}
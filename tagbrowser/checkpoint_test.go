@@ -0,0 +1,105 @@
+// checkpoint_test.go
+package tagbrowser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A checkpoint of a small silo
+func testCheckpoint(t *testing.T) (*SerialiseMe, []byte) {
+	t.Helper()
+	d := &SerialiseMe{
+		Id:                   "3",
+		Last_database_record: 2,
+		Database:             []record{{Filename: 3, Line: 1, Fingerprint: fingerPrint{1, 2}}, {Filename: 4, Line: 4, Fingerprint: fingerPrint{2}}},
+		Counters:             map[string]int{"inserts": 2},
+		Next_string_index:    5,
+		Reverse_string_table: []string{"", "quick", "fox", "a.txt", "b.txt"},
+		Tag2record:           [][]int{nil, {0}, {0, 1}},
+		Vectors:              map[vectorKey][]float32{{"a.txt", 1}: {0.6, 0.8}},
+	}
+	var buf bytes.Buffer
+	if err := encodeCheckpoint(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	return d, buf.Bytes()
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	d, data := testCheckpoint(t)
+	got, version, err := decodeCheckpoint(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != checkpointVersion {
+		t.Errorf("version %v, want %v", version, checkpointVersion)
+	}
+	if got.Id != d.Id || !reflect.DeepEqual(got.Reverse_string_table, d.Reverse_string_table) || !reflect.DeepEqual(got.Vectors, d.Vectors) || len(got.Database) != 2 {
+		t.Errorf("decoded %+v, want %+v", got, d)
+	}
+}
+
+func TestTruncatedCheckpointIsRefused(t *testing.T) {
+	_, data := testCheckpoint(t)
+	for _, n := range []int{len(checkpointMagic) + 2, len(checkpointMagic) + 10, len(data) / 2, len(data) - 15, len(data) - 1} {
+		if _, _, err := decodeCheckpoint(bytes.NewReader(data[:n]), int64(n)); err == nil || !strings.Contains(err.Error(), "truncated") {
+			t.Errorf("checkpoint cut to %v of %v bytes: got %v, want a truncated checkpoint", n, len(data), err)
+		}
+	}
+}
+
+func TestCheckpointWithAHugeSectionLengthIsRefused(t *testing.T) {
+	_, data := testCheckpoint(t)
+	damaged := append([]byte{}, data...)
+	binary.LittleEndian.PutUint64(damaged[len(checkpointMagic)+2+2:], 1<<62)
+	if _, _, err := decodeCheckpoint(bytes.NewReader(damaged), int64(len(damaged))); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("got %v, want a truncated checkpoint", err)
+	}
+}
+
+func TestCheckpointWithABadChecksumIsRefused(t *testing.T) {
+	_, data := testCheckpoint(t)
+	damaged := append([]byte{}, data...)
+	damaged[len(checkpointMagic)+2+14] ^= 0xff
+	if _, _, err := decodeCheckpoint(bytes.NewReader(damaged), int64(len(damaged))); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("got %v, want a checksum error", err)
+	}
+}
+
+func TestDamagedCheckpointFallsBackToTheBackup(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	f := openTestFarm(t, location, "memory", 1)
+	storeRecords(t, f, RecordTransmittable{"a.txt", 1, []string{"fox"}})
+	s := f.siloList()[0]
+	name := s.filename + ".checkpoint"
+	if err := s.saveCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveCheckpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, data[:len(data)/2], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFarm(t, location, "memory", 1).siloList()[0]
+	if len(s.findRecords("a.txt", 1)) != 1 {
+		t.Error("a.txt was not read from the .bak")
+	}
+	if s.checkpointError != "" {
+		t.Errorf("the silo will not checkpoint: %v", s.checkpointError)
+	}
+}
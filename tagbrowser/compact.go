@@ -0,0 +1,193 @@
+// compact.go
+
+//Compaction of disk silos.  Deletes and re-indexing leave records that can no longer be read, copies of the same record,
+//TagToRecord rows for records that are gone, and strings that no record uses.  Compaction removes them, rebuilds each
+//record's TagToRecord rows from the record itself, and then runs VACUUM if enough of the file is free pages.
+//
+//Compaction runs in the background, one silo at a time, on demand through the Compact RPC, or every few hours if the
+//[Compaction] section of the config sets an Interval.  It works through each table in small batches, holding the silo's
+//write lock only for a batch, and pauses between batches so searches and inserts carry on.  VACUUM rewrites the whole
+//file, so inserts to the silo wait while it runs.  Progress is in the farm's status.
+
+package tagbrowser
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Rows handled in each batch, if the config has no Batch
+const defaultCompactBatch = 1000
+
+// The pause between batches, if the config has no Pause
+const defaultCompactPause = 50 * time.Millisecond
+
+// VACUUM is run when at least this fraction of the file is free pages
+const compactVacuumRatio = 0.1
+
+// Totals for a compaction
+type compactStats struct {
+	RecordsRemoved int   //Records that could not be read, or were copies of a newer record
+	TagRowsRemoved int   //TagToRecord rows for records that are gone, or tags the record does not have
+	TagRowsAdded   int   //TagToRecord rows that were missing for a record's tags
+	StringsRemoved int   //Strings that no record used
+	BytesFreed     int64 //Bytes the file shrank by when it was vacuumed
+}
+
+// A compaction of some silos of a farm
+type compactJob struct {
+	farm  *Farm
+	batch int
+	pause time.Duration
+}
+
+func newCompactJob(f *Farm, settings compactionInfo) *compactJob {
+	job := &compactJob{farm: f, batch: settings.Batch, pause: time.Duration(settings.Pause) * time.Millisecond}
+	if job.batch < 1 {
+		job.batch = defaultCompactBatch
+	}
+	if job.pause <= 0 {
+		job.pause = defaultCompactPause
+	}
+	return job
+}
+
+// Note the step the compaction has reached
+func (j *compactJob) phase(silo *tagSilo, phase string) {
+	j.farm.siloLock.Lock()
+	defer j.farm.siloLock.Unlock()
+	j.farm.compactSilo = silo.id
+	j.farm.compactPhase = phase
+}
+
+// Add to the totals of the compaction
+func (j *compactJob) add(update func(*compactStats)) {
+	j.farm.siloLock.Lock()
+	defer j.farm.siloLock.Unlock()
+	update(&j.farm.compactStats)
+}
+
+// Wait between batches.  Returns an error if the silo is closing, so the compaction stops
+func (j *compactJob) rest(silo *tagSilo) error {
+	time.Sleep(j.pause)
+	if j.farm.ShutdownStatus || !silo.isOperational() || shuttingDown {
+		return errors.New("the silo was closed")
+	}
+	return nil
+}
+
+// Check that a farm can be compacted, and mark it as compacting
+func (f *Farm) startCompaction() error {
+	if !f.permanent() {
+		return errors.New("only disk farms are compacted")
+	}
+	f.siloLock.Lock()
+	defer f.siloLock.Unlock()
+	if f.ShutdownStatus {
+		return errors.New("the farm is shut down")
+	}
+	if f.compacting {
+		return errors.New("the farm is already being compacted")
+	}
+	f.compacting = true
+	f.compactStats = compactStats{}
+	f.compactError = ""
+	return nil
+}
+
+func (f *Farm) finishCompaction(err error) {
+	f.siloLock.Lock()
+	defer f.siloLock.Unlock()
+	f.compacting = false
+	f.compactSilo = ""
+	f.compactPhase = ""
+	f.compactLast = time.Now()
+	if err != nil {
+		f.compactError = err.Error()
+		log.Printf("Compacting %v: %v", f.location, err)
+	}
+}
+
+// Compact silos, one at a time
+func (f *Farm) compact(silos []*tagSilo, job *compactJob) error {
+	for _, s := range silos {
+		start := time.Now()
+		if err := s.Store.Compact(s, job); err != nil {
+			return fmt.Errorf("silo %v: %v", s.id, err)
+		}
+		log.Printf("Compacted silo %v of %v in %v", s.id, f.location, time.Since(start))
+	}
+	return nil
+}
+
+// Compact the silos of a disk farm in the background.  Every silo, or only args.Silo if it is set
+func (m *Manor) Compact(args SiloArgs) error {
+	f, err := m.farmAt(args.Index, args.Farm)
+	if err != nil {
+		return err
+	}
+	silos := []*tagSilo{}
+	for _, s := range f.siloList() {
+		if args.Silo == "" || s.id == args.Silo {
+			silos = append(silos, s)
+		}
+	}
+	if len(silos) == 0 {
+		return fmt.Errorf("no silo %q in %v", args.Silo, f.location)
+	}
+	if err := f.startCompaction(); err != nil {
+		return err
+	}
+	f.workers.Add(1)
+	go func() {
+		defer f.workers.Done()
+		f.finishCompaction(f.compact(silos, newCompactJob(f, m.compaction)))
+	}()
+	return nil
+}
+
+// Compact every disk farm, every Interval hours, until the manor shuts down
+func (m *Manor) compactionWorker() {
+	defer m.workers.Done()
+	interval := time.Duration(m.compaction.Interval) * time.Hour
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(interval):
+		}
+		m.indexLock.RLock()
+		farms := append([]*Farm{}, m.Farms...)
+		m.indexLock.RUnlock()
+		for _, f := range farms {
+			if !f.permanent() || f.startCompaction() != nil {
+				continue
+			}
+			f.finishCompaction(f.compact(f.siloList(), newCompactJob(f, m.compaction)))
+		}
+	}
+}
+
+// Compaction progress, for the farm's status
+func (f *Farm) compactStatus(stats map[string]string) {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	stats["compact.running"] = fmt.Sprintf("%v", f.compacting)
+	if f.compacting {
+		stats["compact.silo"] = f.compactSilo
+		stats["compact.phase"] = f.compactPhase
+	}
+	stats["compact.records_removed"] = fmt.Sprintf("%v", f.compactStats.RecordsRemoved)
+	stats["compact.tag_rows_removed"] = fmt.Sprintf("%v", f.compactStats.TagRowsRemoved)
+	stats["compact.tag_rows_added"] = fmt.Sprintf("%v", f.compactStats.TagRowsAdded)
+	stats["compact.strings_removed"] = fmt.Sprintf("%v", f.compactStats.StringsRemoved)
+	stats["compact.bytes_freed"] = fmt.Sprintf("%v", f.compactStats.BytesFreed)
+	if !f.compactLast.IsZero() {
+		stats["compact.last_finished"] = f.compactLast.Format(time.RFC3339)
+	}
+	if f.compactError != "" {
+		stats["compact.error"] = f.compactError
+	}
+}
@@ -0,0 +1,138 @@
+// compact_test.go
+package tagbrowser
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactionRemovesWhatDeletesLeaveBehind(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	farms := map[string]FarmConfig{"test": {Location: location, Silos: 1, Mode: "disk"}}
+	m := openTestManor(t, farms)
+	storeRecords(t, m.Farms[0], RecordTransmittable{"a.txt", 1, []string{"quick", "fox"}}, RecordTransmittable{"b.txt", 2, []string{"dog"}})
+	if err := m.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(location, "tagSilo_0.tagdb")
+	damageSilo(t, filename,
+		//A second copy of a.txt's record, with its tag rows
+		"insert into RecordTable(id, filename, line, value) select 1000, filename, line, value from RecordTable where id = (select min(id) from RecordTable)",
+		"insert into TagToRecord(tagid, recordid) select tagid, 1000 from TagToRecord where recordid = (select min(id) from RecordTable)",
+		//A record that cannot be read, with a tag row
+		"insert into RecordTable(id, filename, line, value) values(1001, 1, 1, x'ff')",
+		"insert into TagToRecord(tagid, recordid) values(1, 1001)",
+		//A tag row for a record that is gone, and one missing for b.txt
+		"insert into TagToRecord(tagid, recordid) values(1, 2000)",
+		"delete from TagToRecord where recordid = (select max(id) from RecordTable where id < 1000)",
+		//A string no record uses
+		"insert into StringTable(id, value) select max(id) + 1, cast('unused' as blob) from StringTable",
+		"insert into SymbolTable(id, value) select cast('unused' as blob), max(id) from StringTable",
+	)
+
+	m, err := openManor(tomlConfig{Farms: farms, Compaction: compactionInfo{Batch: 1, Pause: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	f := m.Farms[0]
+	if err := m.Compact(SiloArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the compaction to finish", func() bool { return f.status()["compact.last_finished"] != "" })
+	stats := f.status()
+	if e := stats["compact.error"]; e != "" {
+		t.Fatal(e)
+	}
+	for k, want := range map[string]string{
+		"compact.records_removed":  "2",
+		"compact.tag_rows_removed": "4",
+		"compact.tag_rows_added":   "1",
+		"compact.strings_removed":  "1",
+	} {
+		if stats[k] != want {
+			t.Errorf("%v is %v, want %v", k, stats[k], want)
+		}
+	}
+	for _, c := range []struct {
+		query string
+		want  int
+	}{{"fox", 1}, {"dog", 1}} {
+		if n := len(m.Search(c.query, 10)); n != c.want {
+			t.Errorf("%v found %v records, want %v", c.query, n, c.want)
+		}
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, filename, map[string]int{})
+}
+
+func TestCompactRefusesMemoryFarmsAndASecondRun(t *testing.T) {
+	if err := testManor(t, "memory", 1).Compact(SiloArgs{}); err == nil {
+		t.Error("a memory farm was compacted")
+	}
+	m := testManor(t, "disk", 2)
+	if err := m.Compact(SiloArgs{Silo: "5"}); err == nil {
+		t.Error("a silo that does not exist was compacted")
+	}
+	f := m.Farms[0]
+	if err := f.startCompaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(SiloArgs{}); err == nil {
+		t.Error("a farm was compacted twice at once")
+	}
+	f.finishCompaction(nil)
+	if err := m.Compact(SiloArgs{}); err != nil {
+		t.Errorf("compacting after the first run finished: %v", err)
+	}
+	waitUntil(t, "the compaction to finish", func() bool { return f.status()["compact.running"] == "false" })
+}
+
+func TestRecordsStoredDuringCompactionKeepTheirStrings(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	farms := map[string]FarmConfig{"test": {Location: location, Silos: 1, Mode: "disk"}}
+	m, err := openManor(tomlConfig{Farms: farms, Compaction: compactionInfo{Batch: 1, Pause: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Shutdown()
+	f := m.Farms[0]
+	//Records whose strings are unused once they are deleted, so compaction removes them unless new records take them again
+	old := []RecordTransmittable{}
+	for i := 0; i < 50; i++ {
+		old = append(old, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{fmt.Sprintf("word%v", i)}})
+	}
+	storeRecords(t, f, old...)
+	for _, r := range old {
+		m.DeleteRecords(r.Filename, 0, true)
+	}
+
+	if err := m.Compact(SiloArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range old {
+		r.Line = 2
+		if err := f.SubmitRecord(r); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitUntil(t, "the compaction to finish", func() bool { return f.status()["compact.last_finished"] != "" })
+	if e := f.status()["compact.error"]; e != "" {
+		t.Fatal(e)
+	}
+	for _, r := range old {
+		waitUntil(t, r.Filename, func() bool {
+			res := m.Search(r.Fingerprint[0], 10)
+			return len(res) == 1 && res[0].Filename == r.Filename && res[0].Line == "2"
+		})
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, filepath.Join(location, "tagSilo_0.tagdb"), map[string]int{})
+}
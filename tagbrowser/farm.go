@@ -2,6 +2,8 @@
 
 //A farm manages several silos, which are files on the disk.  A farm is a directory of silos.  The farm object manages these silos
 //It sends records to the silos to be stored, and queries the silos and combines the search results
+//
+//Each silo has its own record channel, and the farm picks the silo for each record by its filename (see routing.go)
 
 package tagbrowser
 
@@ -16,87 +18,122 @@ import (
 
 type Farm struct {
 	silos            []*tagSilo
-	recordCh         chan RecordTransmittable //Used to send records to the silos
-	permanentStoreCh chan RecordTransmittable
+	permanentStoreCh chan RecordTransmittable //Passed to the silos.  Records are offloaded by the mover in offload.go instead
+	siloLock         sync.RWMutex             //Held while the list of silos, or the resharding or offload progress, changes
+	resharding       bool                     //True while records are moved between silos
+	reshardMoved     int                      //Records moved by the last resharding
+	reshardError     string                   //Why the last resharding stopped, if it failed
+	reshardFrom      []*tagSilo               //The silos before AddSilos added more, while records move out of them
+	routed           bool                     //True if every record is in the silo its file routes to.  See routing.go
+	compacting       bool                     //True while silos are compacted, see compact.go
+	compactSilo      string                   //The silo being compacted
+	compactPhase     string                   //The step the compaction has reached
+	compactStats     compactStats             //Totals of the last compaction
+	compactLast      time.Time                //When the last compaction finished
+	compactError     string                   //Why the last compaction stopped, if it failed
+	offloadMoved     int                      //Records moved to the disk farms
+	offloadEmptied   int                      //Memory silos emptied and replaced
+	offloadError     string                   //Why the last offload stopped, if it failed
+	remote           *remoteFarm              //Set if the farm's records are kept by another server, see remote.go
 	temporary        bool
 	location         string
+	index            string //Name of the index the farm belongs to
 	memory_only      bool
 	maxSilos         int
 	maxRecords       int
 	checkpointMutex  sync.Mutex
 	ShutdownStatus   bool
+	stop             chan struct{}  //Closed when the farm shuts down, to stop its workers
+	stopOnce         sync.Once      //Closes stop
+	workers          sync.WaitGroup //The farm's offload, compaction and resharding workers
+	logStop          chan struct{}  //Closed once the silos are closed, to stop the log workers
+	logOnce          sync.Once      //Closes logStop
 	LockLog          chan string
 	LogChan          map[string]chan string
 }
 
-func (f *Farm) Shutdown() {
-	f.ShutdownStatus = true
-	for _, s := range f.silos {
-		s.ReadOnly = true
-
+// Stop the farm's workers, then close its silos, once they have stored the records queued for them.  Searches of the
+// farm must have finished.  Returns the first silo that could not be closed
+func (f *Farm) Shutdown() error {
+	f.stopWorkers()
+	if f.remote != nil {
+		return f.remote.shutdown()
 	}
-	time.Sleep(time.Second * 5.0)
-	for _, s := range f.silos {
-		s.Operational = false
-
+	var first error
+	for _, s := range f.siloList() {
+		if err := s.close(); err != nil {
+			log.Printf("Silo %v: %v", s.id, err)
+			if first == nil {
+				first = fmt.Errorf("silo %v of %v: %v", s.id, f.location, err)
+			}
+		}
+		log.Printf("Closed silo %v", s.id)
 	}
-	time.Sleep(time.Second * 10.0)
-	for _, s := range f.silos {
-		s.Checkpoint()
-		log.Printf("Silo %v", s.id)
+	f.closeLogs()
+	return first
+}
 
+// Stop the farm's offload, compaction and resharding workers, and wait for them to return
+func (f *Farm) stopWorkers() {
+	f.stopOnce.Do(func() {
+		f.ShutdownStatus = true
+		close(f.stop)
+	})
+	f.workers.Wait()
+}
+
+// Wait for d, or until the farm shuts down
+func (f *Farm) rest(d time.Duration) {
+	select {
+	case <-f.stop:
+	case <-time.After(d):
 	}
 }
 
-func monitorSilosWorker(f *Farm) {
-	var total_silos int
-	for i := 0; i < 1; i = 0 {
-		if f.ShutdownStatus {
-			return
-		}
-		time.Sleep(time.Millisecond * 500.0)
-		total_silos = 0
-		if f.temporary {
-			//log.Println("Checking memory silos: ", len(f.silos), " of ", f.maxSilos, " possible")
-			for _, s := range f.silos {
-				if s != nil && s.Operational {
-					//log.Printf("Silo %v, %v records, %v offloaded", s.id, s.last_database_record, s.offload_index)
-					total_silos = total_silos + 1
-				} else {
-					//f.silos[i] = nil
-				}
-			}
-			if total_silos < f.maxSilos {
-				aSilo := createSilo(f.memory_only, f.maxSilos, fmt.Sprintf("%v", len(f.silos)), 0, f.recordCh, f.location, f.permanentStoreCh, f.temporary, f.maxRecords, &f.checkpointMutex, f.LogChan)
-				aSilo.LockLog = f.LockLog
-				aSilo.LogChan = f.LogChan
-				if f.temporary {
-					aSilo.offloading = true
-				}
-				f.silos = append(f.silos, aSilo)
+// Stop the log workers, after printing what is left in the log channels
+func (f *Farm) closeLogs() {
+	f.logOnce.Do(func() {
+		close(f.logStop)
+	})
+}
 
-				log.Println("Added new silo:", aSilo.id)
+func printLogWorker(ch chan string, stop chan struct{}) {
+	select {
+	case <-time.After(time.Second * 2.0):
+	case <-stop:
+	}
+	for {
+		select {
+		case line := <-ch:
+			log.Println(line)
+		case <-stop:
+			for {
+				select {
+				case line := <-ch:
+					log.Println(line)
+				default:
+					return
+				}
 			}
 		}
 	}
 }
 
-func printLogWorker(ch chan string) {
-	time.Sleep(time.Second * 2.0)
-	for line := range ch {
-		log.Println(line)
-	}
-}
-
-func ignoreLogWorker(ch chan string) {
-	time.Sleep(time.Second * 2.0)
-	for _ = range ch {
-
+func ignoreLogWorker(ch chan string, stop chan struct{}) {
+	for {
+		select {
+		case <-ch:
+		case <-stop:
+			return
+		}
 	}
 }
 
-func createFarm(location string, number_of_silos int, inputchan chan RecordTransmittable, memory_only bool, permanentStoreCh chan RecordTransmittable, isTemporary bool, maxRecords int) *Farm {
+// Open the farm's silos.  If one cannot be opened, the ones already opened are closed again
+func createFarm(location string, number_of_silos int, memory_only bool, permanentStoreCh chan RecordTransmittable, isTemporary bool, maxRecords int) (*Farm, error) {
 	f := Farm{}
+	f.stop = make(chan struct{})
+	f.logStop = make(chan struct{})
 
 	f.LockLog = make(chan string, 100)
 	f.LogChan = map[string]chan string{}
@@ -107,15 +144,14 @@ func createFarm(location string, number_of_silos int, inputchan chan RecordTrans
 	f.LogChan["transport"] = make(chan string, 100)
 	f.LogChan["thread"] = make(chan string, 100)
 	f.LogChan["debug"] = make(chan string, 100)
-	go ignoreLogWorker(f.LockLog)
-	go printLogWorker(f.LogChan["file"])
-	go printLogWorker(f.LogChan["database"])
-	go printLogWorker(f.LogChan["error"])
-	go printLogWorker(f.LogChan["warning"])
-	go ignoreLogWorker(f.LogChan["transport"])
-	go ignoreLogWorker(f.LogChan["thread"])
-	go ignoreLogWorker(f.LogChan["debug"])
-	f.recordCh = inputchan
+	go ignoreLogWorker(f.LockLog, f.logStop)
+	go printLogWorker(f.LogChan["file"], f.logStop)
+	go printLogWorker(f.LogChan["database"], f.logStop)
+	go printLogWorker(f.LogChan["error"], f.logStop)
+	go printLogWorker(f.LogChan["warning"], f.logStop)
+	go ignoreLogWorker(f.LogChan["transport"], f.logStop)
+	go ignoreLogWorker(f.LogChan["thread"], f.logStop)
+	go ignoreLogWorker(f.LogChan["debug"], f.logStop)
 	f.permanentStoreCh = permanentStoreCh
 	f.location = location
 	os.MkdirAll(f.location, 0777)
@@ -125,23 +161,90 @@ func createFarm(location string, number_of_silos int, inputchan chan RecordTrans
 	f.temporary = isTemporary
 	f.maxRecords = maxRecords
 	f.checkpointMutex = sync.Mutex{}
+	f.routed = farmRouted(location, memory_only)
+
+	//Include silos added by AddSilos or by the offload mover, and leave out the ones retired by DrainSilo
+	for _, id := range siloIDs(location, number_of_silos, memory_only) {
+		aSilo, err := f.newSilo(id, 10)
+		if err != nil {
+			f.Shutdown()
+			return nil, err
+		}
+		//aSilo.test() FIXME
+		f.silos = append(f.silos, aSilo)
+	}
+
+	return &f, nil
+}
+
+// Create a silo for the farm, with its own record channel
+func (f *Farm) newSilo(id string, channel_buffer int) (*tagSilo, error) {
+	aSilo, err := createSilo(f.memory_only, f.maxSilos, id, channel_buffer, make(chan RecordTransmittable, 100), f.location, f.permanentStoreCh, f.temporary, f.maxRecords, &f.checkpointMutex, f.LogChan)
+	if err != nil {
+		return nil, err
+	}
+	aSilo.LockLog = f.LockLog
+	aSilo.LogChan = f.LogChan
+	if f.temporary {
+		aSilo.offloading = true
+	}
+	return aSilo, nil
+}
+
+// A copy of the farm's silos, which stays the same while silos are added and retired
+func (f *Farm) siloList() []*tagSilo {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	return append([]*tagSilo{}, f.silos...)
+}
 
-	if !f.temporary {
-		for i := 0; i < number_of_silos; i++ {
-			aSilo := createSilo(memory_only, f.maxSilos, fmt.Sprintf("%v", i), 10, f.recordCh, location, permanentStoreCh, isTemporary, f.maxRecords, &f.checkpointMutex, f.LogChan)
-			aSilo.LockLog = f.LockLog
-			aSilo.LogChan = f.LogChan
-			//aSilo.test() FIXME
-			f.silos = append(f.silos, aSilo)
+// The farm's silos, held open until done is called, so a silo that is retired or replaced meanwhile is not closed under a
+// search.  The silos are locked while the list is, so a silo removed from the list afterwards is never locked by it
+func (f *Farm) useSilos() (silos []*tagSilo, done func()) {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	silos = append([]*tagSilo{}, f.silos...)
+	for _, s := range silos {
+		s.useLock.RLock()
+	}
+	return silos, func() {
+		for _, s := range silos {
+			s.useLock.RUnlock()
 		}
 	}
-	go monitorSilosWorker(&f)
+}
+
+// True if the farm keeps records on disk, and so takes the records that memory silos offload
+func (f *Farm) permanent() bool {
+	return !f.temporary && !f.memory_only && f.remote == nil
+}
 
-	return &f
+// Send a record to the silo that stores its file, or to the remote server.  Fails once the farm has shut down
+func (f *Farm) SubmitRecord(r RecordTransmittable) error {
+	if f.remote != nil {
+		if f.remote.submit(r) {
+			return nil
+		}
+		return fmt.Errorf("farm %v is shut down", f.location)
+	}
+	if aSilo := f.waitForSilo(r.Filename); aSilo != nil && aSilo.submit(r) {
+		return nil
+	}
+	return fmt.Errorf("farm %v is shut down", f.location)
 }
 
-func (f *Farm) SubmitRecord(r RecordTransmittable) {
-	f.recordCh <- r
+// The number of records waiting to be stored by the farm's silos
+func (f *Farm) queued() int {
+	if f.remote != nil {
+		return len(f.remote.recordCh)
+	}
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	n := 0
+	for _, s := range f.silos {
+		n = n + len(s.InputRecordCh)
+	}
+	return n
 }
 
 func equalPrints(s1, s2 []string) bool {
@@ -158,12 +261,21 @@ func equalPrints(s1, s2 []string) bool {
 	return true
 }
 
-func (f *Farm) scanFileDatabase(searchString string, maxResults int, exactMatch bool) []ResultRecordTransmittable {
+func (f *Farm) scanFileDatabase(q searchQuery, maxResults int, v *viewer) []ResultRecordTransmittable {
+	if f.remote != nil {
+		res, err := f.remote.search(q, maxResults, v)
+		if err != nil {
+			q.missing.add(f.location, err)
+		}
+		return res
+	}
 	results := ResultRecordTransmittableCollection{}
 	resLock := sync.Mutex{}
 	var wg sync.WaitGroup
 
-	for i, aSilo := range f.silos {
+	silos, done := f.useSilos()
+	defer done()
+	for i, aSilo := range silos {
 		if debug {
 			log.Printf("Searching Silo: %v - %v", f.location, i)
 		}
@@ -173,17 +285,17 @@ func (f *Farm) scanFileDatabase(searchString string, maxResults int, exactMatch
 			if debug {
 				log.Printf("Starting search\n")
 			}
-			aFing := aSilo.makeFingerprintFromSearch(fmt.Sprintf("%v", searchString))
+			aFing := aSilo.makeFingerprintFromSearch(q.terms)
 			if debug {
 				log.Printf("Searching with fingerprint: %v", aFing)
 			}
-			res := aSilo.resultsToTransmittable(aSilo.scanFileDatabase(aFing, maxResults, exactMatch))
+			res := aSilo.resultsToTransmittable(aSilo.scanQuery(aFing, q, maxResults, v))
 			resLock.Lock()
 			defer resLock.Unlock()
 			for _, r := range res {
 				if !IsIn(r, results) {
 					results = append(results, r)
-					sort.Sort(results)
+					q.sortResults(results)
 					if results.Len() > maxResults {
 						results = results[0:maxResults]
 					}
@@ -195,3 +307,71 @@ func (f *Farm) scanFileDatabase(searchString string, maxResults int, exactMatch
 	wg.Wait()
 	return results
 }
+
+func (f *Farm) deleteRecords(filename string, line int, allLines bool) int {
+	if f.remote != nil {
+		return f.remote.deleteRecords(filename, line, allLines)
+	}
+	deleted := 0
+	for _, aSilo := range f.silosFor(filename) {
+		deleted = deleted + aSilo.deleteRecords(filename, line, allLines)
+	}
+	return deleted
+}
+
+// Complete prefix from the tags of the records that v may see.  A nil v sees everything
+func (f *Farm) predictString(prefix string, maxResults int, v *viewer) []string {
+	if f.remote != nil {
+		return f.remote.predictString(prefix, maxResults, v)
+	}
+	results := []string{}
+	silos, done := f.useSilos()
+	defer done()
+	for _, aSilo := range silos {
+		results = append(results, aSilo.predictFor(prefix, maxResults, v)...)
+	}
+	results = uniqStrings(results)
+	sort.Strings(results)
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results
+}
+
+// Summary statistics for the farm, keyed by statistic name
+func (f *Farm) status() map[string]string {
+	stats := map[string]string{}
+	stats["location"] = f.location
+	stats["index"] = f.index
+	if f.remote != nil {
+		f.remote.status(stats)
+		return stats
+	}
+	silos := f.siloList()
+	stats["silos"] = fmt.Sprintf("%v", len(silos))
+	stats["memory_only"] = fmt.Sprintf("%v", f.memory_only)
+	stats["routed"] = fmt.Sprintf("%v", f.isRouted())
+	if f.permanent() {
+		f.reshardStatus(stats)
+		f.compactStatus(stats)
+	}
+	if f.temporary {
+		f.offloadStatus(stats)
+	}
+	for _, s := range silos {
+		prefix := fmt.Sprintf("silo.%v.", s.id)
+		records, symbols := s.sizes()
+		stats[prefix+"records"] = fmt.Sprintf("%v", records)
+		stats[prefix+"strings"] = fmt.Sprintf("%v", symbols)
+		stats[prefix+"operational"] = fmt.Sprintf("%v", s.isOperational())
+		stats[prefix+"queued_records"] = fmt.Sprintf("%v", len(s.InputRecordCh))
+		if s.checkpointError != "" {
+			stats[prefix+"checkpoint_error"] = s.checkpointError
+		}
+		s.counters.Range(func(k string, v int) bool {
+			stats[prefix+k] = fmt.Sprintf("%v", v)
+			return true
+		})
+	}
+	return stats
+}
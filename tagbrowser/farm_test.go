@@ -0,0 +1,138 @@
+// farm_test.go
+package tagbrowser
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A farm in a temporary directory, shut down when the test finishes
+func testFarm(t *testing.T, mode string, silos int) *Farm {
+	t.Helper()
+	return openTestFarm(t, filepath.Join(t.TempDir(), "farm"), mode, silos)
+}
+
+// Open the farm at location, and shut it down when the test finishes
+func openTestFarm(t *testing.T, location string, mode string, silos int) *Farm {
+	t.Helper()
+	f, err := createFarm(location, silos, mode == "memory", make(chan RecordTransmittable, 100), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Shutdown() })
+	return f
+}
+
+// Wait until done returns true, failing the test after a few seconds
+func waitUntil(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Store records in the farm, and wait until they can be found
+func storeRecords(t *testing.T, f *Farm, records ...RecordTransmittable) {
+	t.Helper()
+	for _, r := range records {
+		if err := f.SubmitRecord(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range records {
+		waitUntil(t, r.Filename, func() bool {
+			s := f.siloFor(r.Filename)
+			return s != nil && len(s.findRecords(r.Filename, r.Line)) > 0
+		})
+	}
+}
+
+// A manor with one farm, in the default index
+func testManor(t *testing.T, mode string, silos int) *Manor {
+	t.Helper()
+	return openTestManor(t, map[string]FarmConfig{"test": {Location: filepath.Join(t.TempDir(), "farm"), Silos: silos, Mode: mode}})
+}
+
+// A manor with the farms in farms, shut down when the test finishes
+func openTestManor(t *testing.T, farms map[string]FarmConfig) *Manor {
+	t.Helper()
+	m, err := NewManor(farms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+	return m
+}
+
+func TestShutdownStoresQueuedRecords(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "farm")
+			f, err := createFarm(location, 2, mode == "memory", make(chan RecordTransmittable, 100), false, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 200; i++ {
+				if err := f.SubmitRecord(RecordTransmittable{fmt.Sprintf("file%v.txt", i%10), i, []string{"fox"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start := time.Now()
+			if err := f.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			if took := time.Since(start); took > 3*time.Second {
+				t.Errorf("Shutdown took %v", took)
+			}
+			if err := f.SubmitRecord(RecordTransmittable{"late.txt", 1, []string{"fox"}}); err == nil {
+				t.Error("a shut down farm took a record")
+			}
+			if err := f.Shutdown(); err != nil {
+				t.Errorf("second Shutdown: %v", err)
+			}
+
+			f = openTestFarm(t, location, mode, 2)
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("file%v.txt", i%10)
+				if got := f.siloFor(name).findRecords(name, i); len(got) != 1 {
+					t.Fatalf("after reopening, %v line %v has %v records, want 1", name, i, len(got))
+				}
+			}
+		})
+	}
+}
+
+func TestCreateFarmReportsNewerSchema(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	if err := os.MkdirAll(location, 0777); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(location, "tagSilo_1.tagdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`create table schema_version (version integer not null primary key, applied text not null, description text not null);
+		insert into schema_version values(99, '', 'from the future');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = createFarm(location, 2, false, make(chan RecordTransmittable, 100), false, 0)
+	if err == nil || !strings.Contains(err.Error(), "newer tagdb") {
+		t.Fatalf("createFarm returned %v, want the newer schema error", err)
+	}
+	_, err = NewManor(map[string]FarmConfig{"test": {Location: location, Silos: 2, Mode: "disk"}})
+	if err == nil {
+		t.Fatal("NewManor opened a farm with a newer schema")
+	}
+}
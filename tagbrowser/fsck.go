@@ -0,0 +1,493 @@
+// fsck.go
+
+//Offline checks of silo files, for cmd/tagfsck.  The server must not have the files open.
+//
+//A disk silo is checked with SQLite's integrity check, then every record is decoded, and the tables built from the
+//records are compared with them: TagToRecord must hold one row for each tag of each record and no others, every string a
+//record uses must be in StringTable, and SymbolTable must map each string back to its id.  A memory silo's checkpoint is
+//read, checksums and all, and its posting lists are compared with its records in the same way.  A disk silo on an older
+//schema is checked the same way, reading its records as that version stored them, and is reported as old_schema.
+//
+//Repair keeps a copy of the file first, then removes records that cannot be decoded and rebuilds TagToRecord and
+//SymbolTable (or a checkpoint's posting lists) from the records.  Strings that are missing from StringTable cannot be
+//rebuilt, as the records only hold their ids.
+
+package tagbrowser
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The result of checking one silo file
+type FsckReport struct {
+	File         string
+	Kind         string         //"disk" or "memory"
+	Version      int            //Schema version of a disk silo, or checkpoint format of a memory silo
+	Counts       map[string]int //Rows or entries, by table
+	Problems     map[string]int //Inconsistencies found, by kind.  Empty if the silo is sound
+	Unrepairable []string       //Problems that repair cannot fix
+	Backup       string         //The copy kept before repairing
+	corruptIDs   []int
+	columnsWrong []int
+}
+
+// True if no problems were found
+func (r *FsckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *FsckReport) problem(kind string, n int) {
+	if n > 0 {
+		r.Problems[kind] = r.Problems[kind] + n
+	}
+}
+
+// The problems, one "kind: count" per line, sorted
+func (r *FsckReport) String() string {
+	lines := []string{}
+	for k, v := range r.Problems {
+		lines = append(lines, fmt.Sprintf("%v: %v", k, v))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// The silo files in dir: disk silos, and memory silo checkpoints and their .bak copies
+func FindSiloFiles(dir string) []string {
+	out := []string{}
+	for _, pattern := range []string{"tagSilo_*.tagdb", "tagSilo_*.tagdb.checkpoint", "tagSilo_*.tagdb.checkpoint.bak"} {
+		names, _ := filepath.Glob(filepath.Join(dir, pattern))
+		out = append(out, names...)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Check a silo file, a disk silo's .tagdb or a memory silo's checkpoint, without changing it
+func CheckSilo(filename string) (*FsckReport, error) {
+	if isCheckpointFile(filename) {
+		return checkCheckpoint(filename)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	db, err := openFsckDB("file:" + filename + "?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return checkDiskSilo(db, filename)
+}
+
+// Repair a silo file, keeping a copy of it first.  Returns a check of the repaired file
+func RepairSilo(filename string) (*FsckReport, error) {
+	if isCheckpointFile(filename) {
+		return repairCheckpoint(filename)
+	}
+	return repairDiskSilo(filename)
+}
+
+func isCheckpointFile(filename string) bool {
+	return strings.HasSuffix(filename, ".checkpoint") || strings.HasSuffix(filename, ".checkpoint.bak")
+}
+
+func fsckBackupName(filename string) string {
+	return fmt.Sprintf("%v.fsck-%v.bak", filename, time.Now().Format("20060102-150405"))
+}
+
+func newFsckReport(filename, kind string) *FsckReport {
+	return &FsckReport{File: filename, Kind: kind, Counts: map[string]int{}, Problems: map[string]int{}}
+}
+
+// Open a database with one connection, so the temporary table made by the check is there for the repair
+func openFsckDB(name string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", name)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func countRows(db *sql.DB, query string, args ...interface{}) (int, error) {
+	var n int
+	err := db.QueryRow(query, args...).Scan(&n)
+	return n, err
+}
+
+func checkDiskSilo(db *sql.DB, filename string) (*FsckReport, error) {
+	report := newFsckReport(filename, "disk")
+	version, err := readSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	report.Version = version
+	if version > schemaVersion {
+		return nil, fmt.Errorf("schema version %v was written by a newer tagdb, this tagfsck reads up to version %v", version, schemaVersion)
+	}
+	if version < schemaVersion {
+		//Repair migrates the file first, as the server would
+		report.problem("old_schema", 1)
+	}
+	if version == 0 {
+		//No tables yet, so nothing to check
+		return report, nil
+	}
+
+	var integrity string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return nil, err
+	}
+	if integrity != "ok" {
+		report.problem("integrity_check", 1)
+		report.Unrepairable = append(report.Unrepairable, "SQLite integrity check: "+integrity)
+	}
+
+	tables := []string{"RecordTable", "TagToRecord", "StringTable", "SymbolTable"}
+	if version >= 3 {
+		tables = append(tables, "Vectors")
+	}
+	for _, table := range tables {
+		n, err := countRows(db, "select count(*) from "+table)
+		if err != nil {
+			return nil, err
+		}
+		report.Counts[table] = n
+	}
+
+	strs := map[int]bool{}
+	rows, err := db.Query("select id from StringTable")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			strs[id] = true
+		}
+	}
+	rows.Close()
+
+	//Every tag of every record, to compare with TagToRecord
+	db.Exec("drop table if exists temp.fsckExpected")
+	if _, err := db.Exec("create temp table fsckExpected (tagid integer not null, recordid integer not null, primary key (tagid, recordid)) without rowid"); err != nil {
+		return nil, err
+	}
+	missingStrings := map[int]bool{}
+	query := "select id, filename, line, value from RecordTable"
+	if version < 2 {
+		//Version 1 has no filename and line columns
+		query = "select id, 0, 0, value from RecordTable"
+	}
+	rows, err = db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	expected := [][2]int{}
+	for rows.Next() {
+		var id, filename, line int
+		var val []byte
+		if err := rows.Scan(&id, &filename, &line, &val); err != nil {
+			report.corruptIDs = append(report.corruptIDs, id)
+			continue
+		}
+		aRecord, err := decodeVersionedRecord(val, version)
+		if err != nil {
+			report.corruptIDs = append(report.corruptIDs, id)
+			continue
+		}
+		if version >= 2 && (aRecord.Filename != filename || aRecord.Line != line) {
+			report.columnsWrong = append(report.columnsWrong, id)
+		}
+		for _, sym := range append([]int{aRecord.Filename}, aRecord.Fingerprint...) {
+			if !strs[sym] {
+				missingStrings[sym] = true
+			}
+		}
+		for _, tag := range aRecord.Fingerprint {
+			expected = append(expected, [2]int{tag, id})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := insertExpected(db, expected); err != nil {
+		return nil, err
+	}
+	report.problem("corrupt_records", len(report.corruptIDs))
+	report.problem("record_columns_wrong", len(report.columnsWrong))
+	if len(missingStrings) > 0 {
+		report.problem("missing_strings", len(missingStrings))
+		report.Unrepairable = append(report.Unrepairable, fmt.Sprintf("%v strings used by records are not in StringTable", len(missingStrings)))
+	}
+
+	checks := []struct {
+		kind  string
+		query string
+	}{
+		{"dangling_tag_rows", "select count(*) from TagToRecord where recordid not in (select id from RecordTable)"},
+		{"stale_tag_rows", "select count(*) from (select tagid, recordid from TagToRecord where recordid in (select id from RecordTable) except select tagid, recordid from temp.fsckExpected)"},
+		{"missing_tag_rows", "select count(*) from (select tagid, recordid from temp.fsckExpected except select tagid, recordid from TagToRecord)"},
+		{"missing_symbols", "select count(*) from StringTable where value not in (select id from SymbolTable)"},
+		{"wrong_symbols", "select count(*) from SymbolTable y join StringTable s on y.id = s.value where y.value != s.id"},
+		{"dangling_symbols", "select count(*) from SymbolTable where value not in (select id from StringTable)"},
+		{"duplicate_strings", "select count(*) - count(distinct value) from StringTable"},
+	}
+	for _, c := range checks {
+		n, err := countRows(db, c.query)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", c.kind, err)
+		}
+		report.problem(c.kind, n)
+	}
+	if report.Problems["duplicate_strings"] > 0 {
+		report.Unrepairable = append(report.Unrepairable, "StringTable holds the same string under more than one id")
+	}
+	return report, nil
+}
+
+// Decode a record as schema version stored it: JSON in version 1, the binary encoding after
+func decodeVersionedRecord(val []byte, version int) (record, error) {
+	if version < 2 {
+		aRecord := record{}
+		err := json.Unmarshal(val, &aRecord)
+		return aRecord, err
+	}
+	return decodeRecord(val)
+}
+
+func insertExpected(db *sql.DB, pairs [][2]int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("insert or ignore into temp.fsckExpected(tagid, recordid) values(?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range pairs {
+		if _, err := stmt.Exec(p[0], p[1]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func repairDiskSilo(filename string) (*FsckReport, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	db, err := openFsckDB(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	//Migrating keeps its own copy of the old file
+	if _, err := migrateSchema(db, filename); err != nil {
+		return nil, err
+	}
+	before, err := checkDiskSilo(db, filename)
+	if err != nil {
+		return nil, err
+	}
+	if before.OK() {
+		return before, nil
+	}
+	keep := fsckBackupName(filename)
+	if _, err := db.Exec("VACUUM INTO ?", keep); err != nil {
+		return nil, fmt.Errorf("keeping a copy before repairing: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	err = func() error {
+		for _, id := range before.corruptIDs {
+			if _, err := tx.Exec("delete from RecordTable where id = ?", id); err != nil {
+				return err
+			}
+		}
+		for _, id := range before.columnsWrong {
+			var val []byte
+			if err := tx.QueryRow("select value from RecordTable where id = ?", id).Scan(&val); err != nil {
+				return err
+			}
+			aRecord, err := decodeRecord(val)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec("update RecordTable set filename = ?, line = ? where id = ?", aRecord.Filename, aRecord.Line, id); err != nil {
+				return err
+			}
+		}
+		for _, stmt := range []string{
+			"delete from TagToRecord",
+			"insert into TagToRecord(tagid, recordid) select tagid, recordid from temp.fsckExpected where recordid in (select id from RecordTable)",
+			"delete from SymbolTable",
+			"insert or ignore into SymbolTable(id, value) select value, id from StringTable order by id",
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("%v: %v", stmt, err)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	after, err := checkDiskSilo(db, filename)
+	if err != nil {
+		return nil, err
+	}
+	after.Backup = keep
+	return after, nil
+}
+
+// A key for a record, as gob gives each posting list entry its own copy of the record
+func recordKey(r record) string {
+	return fmt.Sprintf("%v:%v:%v", r.Filename, r.Line, r.Fingerprint)
+}
+
+func checkCheckpoint(filename string) (*FsckReport, error) {
+	report := newFsckReport(filename, "memory")
+	d, version, err := readCheckpointFile(filename)
+	report.Version = version
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		report.problem("unreadable_checkpoint", 1)
+		report.Unrepairable = append(report.Unrepairable, err.Error())
+		return report, nil
+	}
+	if version < checkpointVersion {
+		report.problem("old_checkpoint_format", 1)
+	}
+	checkCheckpointData(report, d)
+	return report, nil
+}
+
+func checkCheckpointData(report *FsckReport, d *SerialiseMe) {
+	report.Counts["records"] = len(d.Database)
+	report.Counts["strings"] = len(d.Reverse_string_table)
+	report.Counts["tags"] = len(d.Tag2file)
+
+	expected := map[string]int{}
+	live := map[string]bool{}
+	missingStrings := map[int]bool{}
+	for _, r := range d.Database {
+		if r.Filename == 0 {
+			//A deleted record, or the unused first entry
+			continue
+		}
+		live[recordKey(r)] = true
+		for _, sym := range append([]int{r.Filename}, r.Fingerprint...) {
+			if sym <= 0 || sym >= len(d.Reverse_string_table) {
+				missingStrings[sym] = true
+			}
+		}
+		for _, tag := range uniqInts(r.Fingerprint) {
+			expected[fmt.Sprintf("%v/%v", tag, recordKey(r))]++
+		}
+	}
+	postings := 0
+	for tag, list := range d.Tag2file {
+		for _, r := range list {
+			postings++
+			key := ""
+			if r != nil {
+				key = recordKey(*r)
+			}
+			if r == nil || !live[key] {
+				report.problem("dangling_postings", 1)
+				continue
+			}
+			pk := fmt.Sprintf("%v/%v", tag, key)
+			if expected[pk] > 0 {
+				expected[pk]--
+			} else {
+				report.problem("stale_postings", 1)
+			}
+		}
+	}
+	report.Counts["postings"] = postings
+	for _, n := range expected {
+		report.problem("missing_postings", n)
+	}
+	if len(missingStrings) > 0 {
+		report.problem("missing_strings", len(missingStrings))
+		report.Unrepairable = append(report.Unrepairable, fmt.Sprintf("%v strings used by records are not in the string table", len(missingStrings)))
+	}
+}
+
+// Rebuild a checkpoint's posting lists from its records, and write it in the current format
+func repairCheckpoint(filename string) (*FsckReport, error) {
+	before, err := checkCheckpoint(filename)
+	if err != nil {
+		return nil, err
+	}
+	if before.OK() || before.Problems["unreadable_checkpoint"] > 0 {
+		return before, nil
+	}
+	d, _, err := readCheckpointFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	d.Tag2file = make([][]*record, len(d.Reverse_string_table))
+	for i := range d.Database {
+		r := &d.Database[i]
+		if r.Filename == 0 {
+			continue
+		}
+		for _, tag := range uniqInts(r.Fingerprint) {
+			if tag > 0 && tag < len(d.Tag2file) {
+				d.Tag2file[tag] = append(d.Tag2file[tag], r)
+			}
+		}
+	}
+	keep := fsckBackupName(filename)
+	if err := copyFile(filename, keep); err != nil {
+		return nil, fmt.Errorf("keeping a copy before repairing: %v", err)
+	}
+	tmpName := filename + ".tmp"
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return nil, err
+	}
+	err = encodeCheckpoint(out, d)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return nil, err
+	}
+	after, err := checkCheckpoint(filename)
+	if err != nil {
+		return nil, err
+	}
+	after.Backup = keep
+	return after, nil
+}
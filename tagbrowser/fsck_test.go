@@ -0,0 +1,151 @@
+// fsck_test.go
+package tagbrowser
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run statements against the silo file filename
+func damageSilo(t *testing.T, filename string, stmts ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%v: %v", stmt, err)
+		}
+	}
+}
+
+// Check filename, failing unless it has exactly the problems in want
+func checkProblems(t *testing.T, filename string, want map[string]int) *FsckReport {
+	t.Helper()
+	report, err := CheckSilo(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != len(want) {
+		t.Errorf("found %v, want %v", report.Problems, want)
+	}
+	for k, v := range want {
+		if report.Problems[k] != v {
+			t.Errorf("found %v, want %v", report.Problems, want)
+			break
+		}
+	}
+	return report
+}
+
+func TestCheckAndRepairDiskSilo(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	f := openTestFarm(t, location, "disk", 1)
+	storeRecords(t, f, RecordTransmittable{"a.txt", 1, []string{"quick", "fox"}}, RecordTransmittable{"b.txt", 2, []string{"dog"}})
+	if err := f.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(location, "tagSilo_0.tagdb")
+	checkProblems(t, filename, map[string]int{})
+
+	damageSilo(t, filename,
+		"delete from TagToRecord where recordid = (select min(id) from RecordTable)",
+		"insert into RecordTable(id, filename, line, value) values(1000, 1, 1, x'ff')",
+		"insert into TagToRecord(tagid, recordid) values(1, 2000)",
+	)
+	checkProblems(t, filename, map[string]int{"missing_tag_rows": 2, "corrupt_records": 1, "dangling_tag_rows": 1})
+
+	report, err := RepairSilo(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Backup == "" {
+		t.Errorf("after repairing: %v, backup %q", report, report.Backup)
+	}
+	checkProblems(t, report.Backup, map[string]int{"missing_tag_rows": 2, "corrupt_records": 1, "dangling_tag_rows": 1})
+}
+
+func TestCheckVersion1Silo(t *testing.T) {
+	filename := writeVersion1Silo(t, filepath.Join(t.TempDir(), "farm"), 5)
+	report := checkProblems(t, filename, map[string]int{"old_schema": 1})
+	if report.Version != 1 || report.Counts["RecordTable"] != 5 {
+		t.Errorf("version %v with %v records, want version 1 with 5", report.Version, report.Counts["RecordTable"])
+	}
+
+	damageSilo(t, filename,
+		"update RecordTable set value = '{\"Filename\":' where id = 1",
+		"delete from TagToRecord where recordid = 2 and tagid = 2",
+		"delete from StringTable where id = 7",
+	)
+	//The unreadable record's two tag rows are stale
+	checkProblems(t, filename, map[string]int{"old_schema": 1, "corrupt_records": 1, "stale_tag_rows": 2, "missing_tag_rows": 1, "missing_strings": 1, "dangling_symbols": 1})
+	if version, err := checkedVersion(filename); err != nil || version != 1 {
+		t.Errorf("the check left the file at version %v: %v", version, err)
+	}
+}
+
+func TestCheckVersion2Silo(t *testing.T) {
+	filename := writeVersion1Silo(t, filepath.Join(t.TempDir(), "farm"), 5)
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err == nil {
+		err = migrateIndexedRecords(tx)
+	}
+	if err == nil {
+		_, err = tx.Exec("create table schema_version (version integer not null primary key, applied text not null, description text not null)")
+	}
+	if err == nil {
+		_, err = tx.Exec("insert into schema_version(version, applied, description) values(2, '', '')")
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, filename, map[string]int{"old_schema": 1})
+
+	damageSilo(t, filename, "update RecordTable set line = 9 where id = 3")
+	checkProblems(t, filename, map[string]int{"old_schema": 1, "record_columns_wrong": 1})
+}
+
+// The schema version of filename
+func checkedVersion(filename string) (int, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return readSchemaVersion(db)
+}
+
+func TestCheckAndRepairCheckpoint(t *testing.T) {
+	d, _ := testCheckpoint(t)
+	filename := filepath.Join(t.TempDir(), "tagSilo_0.tagdb.checkpoint")
+	d.Tag2file = [][]*record{nil, {&d.Database[0]}, {&d.Database[0]}}
+	var buf bytes.Buffer
+	if err := encodeCheckpoint(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	checkProblems(t, filename, map[string]int{"missing_postings": 1})
+
+	report, err := RepairSilo(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("after repairing: %v", report)
+	}
+}
@@ -0,0 +1,205 @@
+// group.go
+
+//Grouped searches.  With GroupBy "file", a search returns one group per file instead of one result per line, so a file
+//indexed with -everyLine cannot fill the whole result list.  Each group holds the file's best lines and the number of lines
+//that matched.
+//
+//Every silo counts the matching lines of every file, and returns its best groups.  Farms and the manor add up the counts
+//and merge the groups, so the hit counts cover every silo.  Searches that the silo's fast scan can answer (see fast) only
+//read the silo's best groupDepth lines for each line wanted, and count the hits among those.
+
+package tagbrowser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// The only GroupBy that is supported so far
+const GroupByFile = "file"
+
+// The number of lines kept in each group, if the search does not say
+const defaultGroupLines = 3
+
+// Grouped searches that use the fast scan read this many lines for each line they return
+const groupDepth = 10
+
+// Check a search's GroupBy
+func checkGroupBy(groupBy string) error {
+	if groupBy != "" && groupBy != GroupByFile {
+		return fmt.Errorf("unknown GroupBy %q, use \"file\"", groupBy)
+	}
+	return nil
+}
+
+// Group a silo's matches by file.  Returns the best maxGroups groups, holding up to lines lines each, and the number of
+// matching lines for every file, including the files that did not make the best groups
+func (s *tagSilo) scanGroups(aFing searchPrint, q searchQuery, maxGroups int, lines int, v *viewer) ([]ResultGroup, map[string]int) {
+	filter := s.aclFilterFor(v)
+	var matches []queryMatch
+	if q.fast(filter) {
+		for _, r := range s.fastScan(aFing, q, maxGroups*lines*groupDepth) {
+			matches = append(matches, queryMatch{r: r})
+		}
+	} else {
+		matches = s.matchRecords(aFing, q, filter)
+		q.sortMatches(matches)
+	}
+
+	hits := map[string]int{}
+	byFile := map[string][]queryMatch{}
+	order := []string{}
+	for _, m := range matches {
+		name := m.r.filename
+		hits[name]++
+		if len(byFile[name]) == 0 {
+			order = append(order, name)
+		}
+		if len(byFile[name]) < lines {
+			byFile[name] = append(byFile[name], m)
+		}
+	}
+	//The matches were sorted, so the files are in the order of their best lines
+	if len(order) > maxGroups {
+		order = order[0:maxGroups]
+	}
+	groups := []ResultGroup{}
+	for _, name := range order {
+		best := resultRecordCollection{}
+		for _, m := range byFile[name] {
+			best = append(best, m.r)
+		}
+		res := s.resultsToTransmittable(best)
+		groups = append(groups, ResultGroup{Filename: name, Hits: hits[name], Score: res[0].Score, Lines: res})
+	}
+	return groups, hits
+}
+
+// Merge groups for the same file, keeping the best lines.  Hits are filled in later, from the merged counts
+func mergeGroups(into map[string]*ResultGroup, groups []ResultGroup, q searchQuery, lines int) {
+	for _, g := range groups {
+		existing, ok := into[g.Filename]
+		if !ok {
+			g := g
+			into[g.Filename] = &g
+			continue
+		}
+		merged := ResultRecordTransmittableCollection(existing.Lines)
+		for _, r := range g.Lines {
+			if !IsIn(r, merged) {
+				merged = append(merged, r)
+			}
+		}
+		q.sortResults(merged)
+		if len(merged) > lines {
+			merged = merged[0:lines]
+		}
+		existing.Lines = merged
+		existing.Score = merged[0].Score
+	}
+}
+
+// The best maxGroups of the merged groups, with the merged hit counts
+func bestGroups(merged map[string]*ResultGroup, hits map[string]int, q searchQuery, maxGroups int) []ResultGroup {
+	groups := []ResultGroup{}
+	for _, g := range merged {
+		g.Hits = hits[g.Filename]
+		groups = append(groups, *g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].Lines[0], groups[j].Lines[0]
+		scoreA, _ := strconv.Atoi(a.Score)
+		scoreB, _ := strconv.Atoi(b.Score)
+		if q.sortKey == "" && scoreA == scoreB {
+			//Same score, so show the file with more hits first
+			return groups[i].Hits > groups[j].Hits
+		}
+		return q.less(a.Metadata, b.Metadata, scoreA, scoreB)
+	})
+	if len(groups) > maxGroups {
+		groups = groups[0:maxGroups]
+	}
+	return groups
+}
+
+func addHits(into map[string]int, hits map[string]int) {
+	for name, n := range hits {
+		into[name] += n
+	}
+}
+
+// Group the farm's matches by file, across all its silos
+func (f *Farm) scanGroups(q searchQuery, maxGroups int, lines int, v *viewer) ([]ResultGroup, map[string]int) {
+	if f.remote != nil {
+		groups, err := f.remote.groups(q, maxGroups, lines, v)
+		if err != nil {
+			q.missing.add(f.location, err)
+		}
+		//Only the remote server's best groups are known, so the hit counts of its other files are missing
+		hits := map[string]int{}
+		for _, g := range groups {
+			hits[g.Filename] = g.Hits
+		}
+		return groups, hits
+	}
+	merged := map[string]*ResultGroup{}
+	hits := map[string]int{}
+	lock := sync.Mutex{}
+	var wg sync.WaitGroup
+	silos, done := f.useSilos()
+	defer done()
+	for _, aSilo := range silos {
+		wg.Add(1)
+		go func(aSilo *tagSilo) {
+			defer wg.Done()
+			aFing := aSilo.makeFingerprintFromSearch(q.terms)
+			groups, siloHits := aSilo.scanGroups(aFing, q, maxGroups, lines, v)
+			lock.Lock()
+			defer lock.Unlock()
+			mergeGroups(merged, groups, q, lines)
+			addHits(hits, siloHits)
+		}(aSilo)
+	}
+	wg.Wait()
+	return bestGroups(merged, hits, q, maxGroups), hits
+}
+
+// Search the farms in the named indexes, returning the best maxGroups files with up to lines lines each
+func (m *Manor) groupFileDatabase(indexes string, q searchQuery, maxGroups int, lines int, v *viewer) ([]ResultGroup, error) {
+	farms, err := m.farmsFor(indexes)
+	if err != nil {
+		return nil, err
+	}
+	if lines < 1 {
+		lines = defaultGroupLines
+	}
+	q.rank = m.rank
+	merged := map[string]*ResultGroup{}
+	hits := map[string]int{}
+	lock := sync.Mutex{}
+	var wg sync.WaitGroup
+	for _, aFarm := range farms {
+		wg.Add(1)
+		go func(threadFarm *Farm) {
+			defer wg.Done()
+			groups, farmHits := threadFarm.scanGroups(q, maxGroups, lines, v)
+			lock.Lock()
+			defer lock.Unlock()
+			mergeGroups(merged, groups, q, lines)
+			addHits(hits, farmHits)
+		}(aFarm)
+	}
+	wg.Wait()
+	return bestGroups(merged, hits, q, maxGroups), nil
+}
+
+// The lines of every group, in group order
+func flattenGroups(groups []ResultGroup) []ResultRecordTransmittable {
+	out := []ResultRecordTransmittable{}
+	for _, g := range groups {
+		out = append(out, g.Lines...)
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+// group_test.go
+package tagbrowser
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGroupFileDatabase(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 2)
+			records := []RecordTransmittable{}
+			for i := 0; i < 5; i++ {
+				records = append(records, RecordTransmittable{"long.txt", i, []string{"fox"}})
+			}
+			records = append(records,
+				RecordTransmittable{"short.txt", 1, []string{"fox", "quick"}},
+				RecordTransmittable{"other.txt", 1, []string{"dog"}},
+			)
+			storeRecords(t, m.Farms[0], records...)
+
+			for _, query := range []string{"fox quick", "fox quick sort:-line"} {
+				groups, err := m.groupFileDatabase(DefaultIndex, parseQuery(query, "", nil), 10, 2, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []string{}
+				for _, g := range groups {
+					got = append(got, fmt.Sprintf("%v:%v:%v", g.Filename, g.Hits, len(g.Lines)))
+				}
+				if fmt.Sprint(got) != "[short.txt:1:1 long.txt:5:2]" {
+					t.Errorf("%q: groups %v, want [short.txt:1:1 long.txt:5:2]", query, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFastGroupsReadALimitedDepth(t *testing.T) {
+	f := testFarm(t, "memory", 1)
+	records := []RecordTransmittable{}
+	for i := 0; i < 50; i++ {
+		records = append(records, RecordTransmittable{"long.txt", i, []string{"fox"}})
+	}
+	storeRecords(t, f, records...)
+	s := f.siloList()[0]
+	q := parseQuery("fox", "", nil)
+	_, hits := s.scanGroups(s.makeFingerprintFromSearch(q.terms), q, 1, 2, nil)
+	if hits["long.txt"] != 1*2*groupDepth {
+		t.Errorf("the fast scan counted %v hits, want %v", hits["long.txt"], 1*2*groupDepth)
+	}
+}
@@ -0,0 +1,218 @@
+// grpc_server.go
+
+//Serves the tagdb.proto service.  Every call is passed on to the TagResponder, so gRPC and JSON-RPC behave the same
+
+package tagbrowser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/donomii/tagdb/tagdbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+var GrpcAddress = "127.0.0.1:6782"
+
+type grpcResponder struct {
+	tagdbpb.UnimplementedTagDBServer
+	t *TagResponder
+}
+
+func transmittableToResult(r ResultRecordTransmittable) *tagdbpb.Result {
+	line, _ := strconv.ParseInt(r.Line, 10, 64)
+	score, _ := strconv.ParseInt(r.Score, 10, 64)
+	return &tagdbpb.Result{Filename: r.Filename, Line: line, Fingerprint: r.Fingerprint, Sample: r.Sample, Score: score, Metadata: r.Metadata}
+}
+
+// The status for an error from a search, prediction or similar-records call, in the same classes as restStatus: NotFound for
+// an index, farm or record that does not exist, Unauthenticated or PermissionDenied for a token that may not make the call,
+// and InvalidArgument for anything else
+func grpcStatus(err error) error {
+	var missing notFoundError
+	code := codes.InvalidArgument
+	switch {
+	case errors.As(err, &missing):
+		code = codes.NotFound
+	case errors.Is(err, ErrNoToken):
+		code = codes.Unauthenticated
+	case errors.Is(err, errPermissionDenied), errors.Is(err, errFollower):
+		code = codes.PermissionDenied
+	}
+	return status.Error(code, err.Error())
+}
+
+// Searches need a query, unless they send a vector
+func checkSearchQuery(in *tagdbpb.SearchRequest) error {
+	if in.Query == "" && len(in.Vector) == 0 {
+		return status.Error(codes.InvalidArgument, "Query is required, unless Vector is given")
+	}
+	return nil
+}
+
+func (g *grpcResponder) Search(ctx context.Context, in *tagdbpb.SearchRequest) (*tagdbpb.SearchReply, error) {
+	if err := checkSearchQuery(in); err != nil {
+		return nil, err
+	}
+	if err := checkGroupBy(in.GroupBy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkSearchMode(in.Mode); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := parseMatchMode(in.Match); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reply := &Reply{}
+	if err := g.t.SearchString(&Args{A: in.Query, Limit: int(in.Limit), Index: in.Index, Sort: in.Sort, GroupBy: in.GroupBy, GroupLines: int(in.GroupLines), Match: in.Match, Mode: in.Mode, Vector: in.Vector, Token: grpcToken(ctx)}, reply); err != nil {
+		return nil, grpcStatus(err)
+	}
+	out := &tagdbpb.SearchReply{}
+	for _, r := range reply.C {
+		out.Results = append(out.Results, transmittableToResult(r))
+	}
+	for _, grp := range reply.Groups {
+		score, _ := strconv.ParseInt(grp.Score, 10, 64)
+		pg := &tagdbpb.ResultGroup{Filename: grp.Filename, Hits: int64(grp.Hits), Score: score}
+		for _, r := range grp.Lines {
+			pg.Lines = append(pg.Lines, transmittableToResult(r))
+		}
+		out.Groups = append(out.Groups, pg)
+	}
+	out.Partial = reply.Partial
+	return out, nil
+}
+
+func (g *grpcResponder) SearchStream(in *tagdbpb.SearchRequest, stream tagdbpb.TagDB_SearchStreamServer) error {
+	if err := checkSearchQuery(in); err != nil {
+		return err
+	}
+	var sendErr error
+	summary, err := g.t.searchStream(&Args{A: in.Query, Limit: int(in.Limit), Index: in.Index, Sort: in.Sort, Match: in.Match, Mode: in.Mode, Vector: in.Vector, Token: grpcToken(stream.Context())}, func(ev SearchEvent) {
+		if sendErr != nil {
+			return
+		}
+		out := &tagdbpb.SearchEvent{Farm: ev.Farm}
+		for _, r := range ev.C {
+			out.Results = append(out.Results, transmittableToResult(r))
+		}
+		sendErr = stream.Send(out)
+	})
+	if err != nil {
+		return grpcStatus(err)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	out := &tagdbpb.SearchEvent{Done: true, Total: int64(summary.Total), Farms: int64(summary.Farms), Milliseconds: summary.Milliseconds, Partial: summary.Partial}
+	for _, r := range summary.C {
+		out.Results = append(out.Results, transmittableToResult(r))
+	}
+	return stream.Send(out)
+}
+
+func (g *grpcResponder) Predict(ctx context.Context, in *tagdbpb.PredictRequest) (*tagdbpb.PredictReply, error) {
+	if in.Prefix == "" {
+		return nil, status.Error(codes.InvalidArgument, "Prefix is required")
+	}
+	reply := &StringListReply{}
+	if err := g.t.PredictString(&Args{A: in.Prefix, Limit: int(in.Limit), Index: in.Index, Token: grpcToken(ctx)}, reply); err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &tagdbpb.PredictReply{Completions: reply.C}, nil
+}
+
+func (g *grpcResponder) insert(in *tagdbpb.InsertRequest) *SuccessReply {
+	reply := &SuccessReply{}
+	g.t.InsertRecord(&InsertArgs{Name: in.Name, Position: int(in.Position), Tags: in.Tags, Principals: in.Principals, Index: in.Index, Metadata: in.Metadata, Vector: in.Vector}, reply)
+	return reply
+}
+
+func (g *grpcResponder) Insert(ctx context.Context, in *tagdbpb.InsertRequest) (*tagdbpb.InsertReply, error) {
+	reply := g.insert(in)
+	out := &tagdbpb.InsertReply{Success: reply.Success, Reason: reply.Reason}
+	if reply.Success {
+		out.Inserted = 1
+	}
+	return out, nil
+}
+
+func (g *grpcResponder) BulkInsert(stream tagdbpb.TagDB_BulkInsertServer) error {
+	out := &tagdbpb.InsertReply{Success: true}
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(out)
+		}
+		if err != nil {
+			return err
+		}
+		reply := g.insert(in)
+		if !reply.Success {
+			out.Success = false
+			out.Reason = reply.Reason
+			return stream.SendAndClose(out)
+		}
+		out.Inserted++
+	}
+}
+
+func (g *grpcResponder) Delete(ctx context.Context, in *tagdbpb.DeleteRequest) (*tagdbpb.DeleteReply, error) {
+	reply := &DeleteReply{}
+	if err := g.t.DeleteRecord(&DeleteArgs{Name: in.Name, Position: int(in.Position), AllLines: in.AllLines, Index: in.Index}, reply); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &tagdbpb.DeleteReply{Deleted: int64(reply.Deleted)}, nil
+}
+
+func (g *grpcResponder) Similar(ctx context.Context, in *tagdbpb.SimilarRequest) (*tagdbpb.SearchReply, error) {
+	reply := &Reply{}
+	if err := g.t.SimilarRecords(&SimilarArgs{Name: in.Name, Position: int(in.Position), Limit: int(in.Limit), Index: in.Index, Token: grpcToken(ctx)}, reply); err != nil {
+		return nil, grpcStatus(err)
+	}
+	out := &tagdbpb.SearchReply{}
+	for _, r := range reply.C {
+		out.Results = append(out.Results, transmittableToResult(r))
+	}
+	return out, nil
+}
+
+func (g *grpcResponder) Click(ctx context.Context, in *tagdbpb.ClickRequest) (*tagdbpb.ClickReply, error) {
+	reply := &SuccessReply{}
+	if err := g.t.RecordClick(&ClickArgs{Name: in.Name, Position: int(in.Position)}, reply); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &tagdbpb.ClickReply{Success: reply.Success}, nil
+}
+
+func (g *grpcResponder) Status(ctx context.Context, in *tagdbpb.StatusRequest) (*tagdbpb.StatusReply, error) {
+	reply := &StatusReply{}
+	if err := g.t.Status(&Args{}, reply); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &tagdbpb.StatusReply{Answer: reply.Answer}, nil
+}
+
+func grpc_server(serverAddress string, m *Manor) {
+	l, err := net.Listen("tcp", serverAddress)
+	if err != nil {
+		log.Fatal("grpc listen error:", err)
+	}
+	opts := grpcAuthOptions()
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	server := grpc.NewServer(opts...)
+	tagdbpb.RegisterTagDBServer(server, &grpcResponder{t: &TagResponder{Manor: m}})
+	log.Println("Starting grpc server on ", serverAddress)
+	if err := server.Serve(l); err != nil {
+		log.Println("grpc server stopped: ", err)
+	}
+}
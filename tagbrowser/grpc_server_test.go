@@ -0,0 +1,201 @@
+// grpc_server_test.go
+package tagbrowser
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/donomii/tagdb/tagdbpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Serve the manor's gRPC service on a local port, and connect to it with opts
+func testGrpcClient(t *testing.T, m *Manor, opts ...grpc.DialOption) tagdbpb.TagDBClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpcAuthOptions()...)
+	tagdbpb.RegisterTagDBServer(server, &grpcResponder{t: &TagResponder{Manor: m}})
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	client, conn, err := tagdbpb.Dial(l.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return client
+}
+
+func TestGrpcInsertAndSearch(t *testing.T) {
+	m := testManor(t, "memory", 1)
+	client := testGrpcClient(t, m)
+	ctx := context.Background()
+
+	stream, err := client.BulkInsert(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tags := range [][]string{{"quick", "brown", "fox"}, {"lazy", "dog"}} {
+		if err := stream.Send(&tagdbpb.InsertRequest{Name: "story.txt", Position: int64(i + 1), Tags: tags}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inserted, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inserted.Success || inserted.Inserted != 2 {
+		t.Fatalf("BulkInsert replied %v", inserted)
+	}
+
+	var reply *tagdbpb.SearchReply
+	waitUntil(t, "the records to be stored", func() bool {
+		reply, err = client.Search(ctx, &tagdbpb.SearchRequest{Query: "fox", Limit: 10})
+		return err == nil && len(reply.Results) > 0
+	})
+	got := reply.Results[0]
+	if got.Filename != "story.txt" || got.Line != 1 || got.Score < 1 {
+		t.Errorf("Search found %v", got)
+	}
+
+	predicted, err := client.Predict(ctx, &tagdbpb.PredictRequest{Prefix: "qu", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(predicted.Completions) != 1 || predicted.Completions[0] != "quick" {
+		t.Errorf("Predict gave %v", predicted.Completions)
+	}
+}
+
+func TestGrpcSearchStream(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestManor(t, map[string]FarmConfig{
+		"a": {Location: filepath.Join(dir, "a"), Silos: 1, Mode: "memory"},
+		"b": {Location: filepath.Join(dir, "b"), Silos: 1, Mode: "memory"},
+	})
+	for _, f := range m.Farms {
+		storeRecords(t, f, RecordTransmittable{f.location + "/story.txt", 1, []string{"fox"}})
+	}
+	client := testGrpcClient(t, m)
+
+	stream, err := client.SearchStream(context.Background(), &tagdbpb.SearchRequest{Query: "fox", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*tagdbpb.SearchEvent{}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %v events, want one for each farm and a summary", len(events))
+	}
+	for _, ev := range events[:2] {
+		if ev.Done || ev.Farm == "" || len(ev.Results) != 1 {
+			t.Errorf("farm event %v", ev)
+		}
+	}
+	if last := events[2]; !last.Done || last.Total != 2 || last.Farms != 2 || len(last.Results) != 2 {
+		t.Errorf("summary %v", last)
+	}
+
+	stream, err = client.SearchStream(context.Background(), &tagdbpb.SearchRequest{Query: "fox", Index: "missing"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("searching a missing index: %v, want NotFound", err)
+	}
+}
+
+func TestGrpcErrorCodes(t *testing.T) {
+	client := testGrpcClient(t, testManor(t, "memory", 1))
+	ctx := context.Background()
+	stream := func(in *tagdbpb.SearchRequest) error {
+		s, err := client.SearchStream(ctx, in)
+		if err == nil {
+			_, err = s.Recv()
+		}
+		return err
+	}
+	for _, c := range []struct {
+		what string
+		call func() error
+		want codes.Code
+	}{
+		{"a search without a query", func() error { _, err := client.Search(ctx, &tagdbpb.SearchRequest{}); return err }, codes.InvalidArgument},
+		{"a grouped hybrid search", func() error {
+			_, err := client.Search(ctx, &tagdbpb.SearchRequest{Query: "fox", GroupBy: "file", Mode: SearchModeHybrid})
+			return err
+		}, codes.InvalidArgument},
+		{"a search of a missing index", func() error {
+			_, err := client.Search(ctx, &tagdbpb.SearchRequest{Query: "fox", Index: "missing"})
+			return err
+		}, codes.NotFound},
+		{"a streamed hybrid search", func() error { return stream(&tagdbpb.SearchRequest{Query: "fox", Mode: SearchModeHybrid}) }, codes.InvalidArgument},
+		{"a streamed search without a query", func() error { return stream(&tagdbpb.SearchRequest{}) }, codes.InvalidArgument},
+		{"a prediction without a prefix", func() error { _, err := client.Predict(ctx, &tagdbpb.PredictRequest{}); return err }, codes.InvalidArgument},
+		{"a prediction from a missing index", func() error {
+			_, err := client.Predict(ctx, &tagdbpb.PredictRequest{Prefix: "fo", Index: "missing"})
+			return err
+		}, codes.NotFound},
+		{"records like a missing record", func() error {
+			_, err := client.Similar(ctx, &tagdbpb.SimilarRequest{Name: "missing.txt"})
+			return err
+		}, codes.NotFound},
+	} {
+		if err := c.call(); status.Code(err) != c.want {
+			t.Errorf("%v: %v, want %v", c.what, err, c.want)
+		}
+	}
+
+	useTokens(t, testTokens)
+	if code := status.Code(grpcStatus(authorize("", scopeRead))); code != codes.Unauthenticated {
+		t.Errorf("a missing token is %v, want Unauthenticated", code)
+	}
+	if code := status.Code(grpcStatus(authorize("read-secret", scopeAdmin))); code != codes.PermissionDenied {
+		t.Errorf("a read token for an admin call is %v, want PermissionDenied", code)
+	}
+}
+
+func TestGrpcCallsNeedTokens(t *testing.T) {
+	useTokens(t, testTokens)
+	m := testManor(t, "memory", 1)
+	ctx := context.Background()
+	insert := &tagdbpb.InsertRequest{Name: "a.txt", Position: 1, Tags: []string{"fox"}}
+
+	if _, err := testGrpcClient(t, m).Search(ctx, &tagdbpb.SearchRequest{Query: "fox"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("a search without a token: %v, want Unauthenticated", err)
+	}
+	reader := testGrpcClient(t, m, tagdbpb.WithToken("read-secret"))
+	if _, err := reader.Insert(ctx, insert); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("an insert with a read token: %v, want PermissionDenied", err)
+	}
+	if _, err := testGrpcClient(t, m, tagdbpb.WithToken("write-secret")).Insert(ctx, insert); err != nil {
+		t.Errorf("an insert with a write token: %v", err)
+	}
+	waitUntil(t, "a.txt", func() bool {
+		reply, err := reader.Search(ctx, &tagdbpb.SearchRequest{Query: "fox", Limit: 10})
+		return err == nil && len(reply.Results) == 1
+	})
+	stream, err := reader.SearchStream(ctx, &tagdbpb.SearchRequest{Query: "fox", Limit: 10})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Errorf("a streaming search with a read token: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// indexes.go
+
+//Indexes can be created while the server runs, with the CreateIndex RPC.  They are saved to a separate file next to
+//tagdb.conf, so they are recreated when the server restarts
+
+package tagbrowser
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/BurntSushi/toml"
+)
+
+var indexNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// The format of the created indexes file.  It uses the same [Farms.name] sections as tagdb.conf
+type indexFileContents struct {
+	Farms map[string]serverInfo
+}
+
+// Save indexes made by CreateIndex in filename, and recreate the ones already saved there.
+// Indexes that are also in tagdb.conf are left alone
+func (m *Manor) loadIndexes(filename string) error {
+	m.indexFile = filename
+	var saved indexFileContents
+	if _, err := toml.DecodeFile(filename, &saved); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for name, farm := range saved.Farms {
+		farm.Index = name
+		if err := m.addIndex(farm); err != nil {
+			log.Printf("Index %v from %v: %v, ignoring the saved copy", name, filename, err)
+		}
+	}
+	return nil
+}
+
+// Make a new index with one farm, and log it for the followers, so they have the index before its records reach them.
+// Followers refuse it
+func (m *Manor) CreateIndex(args CreateIndexArgs) error {
+	args.Token = ""
+	e := ReplicationEntry{Index: args.Name, Create: &args}
+	return m.repl.write(e, func() error {
+		return m.createIndex(args)
+	})
+}
+
+// Make a new index with one farm.  The index is saved, if the manor has an index file
+func (m *Manor) createIndex(args CreateIndexArgs) error {
+	if !indexNameRegex.MatchString(args.Name) {
+		return errors.New("index names may only use letters, numbers, - and _")
+	}
+	farm := serverInfo{Location: args.Location, Silos: args.Silos, Mode: args.Mode, Index: args.Name}
+	if farm.Location == "" {
+		farm.Location = filepath.Join("database", args.Name)
+	}
+	if farm.Mode == "" {
+		farm.Mode = "disk"
+	}
+	if farm.Mode != "disk" && farm.Mode != "memory" {
+		return fmt.Errorf("mode must be \"memory\" or \"disk\", not %q", farm.Mode)
+	}
+	if farm.Silos < 1 {
+		farm.Silos = 1
+	}
+
+	if err := m.addIndex(farm); err != nil {
+		return err
+	}
+	log.Printf("Created index %v at %v", args.Name, farm.Location)
+	return m.saveIndexes()
+}
+
+// True if the manor has an index called name
+func (m *Manor) hasIndex(name string) bool {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	_, ok := m.indexes[name]
+	return ok
+}
+
+// Add an index with one farm, and remember it for saveIndexes.  Fails if the index, or a farm at the same location,
+// already exists.  The check and the add are made under one lock, so two calls cannot both add the index
+func (m *Manor) addIndex(farm serverInfo) error {
+	m.indexLock.Lock()
+	defer m.indexLock.Unlock()
+	_, exists := m.indexes[farm.Index]
+	for _, f := range m.Farms {
+		if filepath.Clean(f.location) == filepath.Clean(farm.Location) {
+			exists = true
+		}
+	}
+	if exists {
+		return fmt.Errorf("index %v, or a farm at %v, already exists", farm.Index, farm.Location)
+	}
+	if _, err := m.addFarmLocked(farm); err != nil {
+		return err
+	}
+	m.created[farm.Index] = farm
+	return nil
+}
+
+// Write every index made by CreateIndex to the index file
+func (m *Manor) saveIndexes() error {
+	if m.indexFile == "" {
+		return nil
+	}
+	m.indexLock.RLock()
+	contents := indexFileContents{Farms: map[string]serverInfo{}}
+	for name, farm := range m.created {
+		contents.Farms[name] = farm
+	}
+	m.indexLock.RUnlock()
+
+	tmpName := m.indexFile + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# Indexes created with CreateIndex.  Move them to tagdb.conf to manage them by hand")
+	if err := toml.NewEncoder(f).Encode(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, m.indexFile)
+}
@@ -0,0 +1,44 @@
+// indexes_test.go
+package tagbrowser
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCreateIndexOnlyOnce(t *testing.T) {
+	m := testManor(t, "memory", 1)
+	location := filepath.Join(t.TempDir(), "logs")
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- m.CreateIndex(CreateIndexArgs{Name: "logs", Location: location, Mode: "memory"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("%v calls created the index, want 1", created)
+	}
+	farms, err := m.farmsFor("logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(farms) != 1 || len(m.Farms) != 2 {
+		t.Errorf("the index has %v farms and the manor %v, want 1 and 2", len(farms), len(m.Farms))
+	}
+
+	if err := m.CreateIndex(CreateIndexArgs{Name: "other", Location: location, Mode: "memory"}); err == nil {
+		t.Error("made a second index with the same farm location")
+	}
+}
@@ -4,6 +4,9 @@ package tagbrowser
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -36,6 +39,10 @@ func (t *TagResponder) Shutdown(args *Args, reply *SuccessReply) error {
 
 func (t *TagResponder) Status(args *Args, reply *StatusReply) error {
 	stats := map[string]string{}
+	if t.Manor != nil {
+		stats = t.Manor.Status()
+	}
+	stats["ShuttingDown"] = fmt.Sprintf("%v", shuttingDown)
 	//stats["NumberOfRecords"] = fmt.Sprintf("%v", len(silo.database))
 	//stats["InternedStrings"] = fmt.Sprintf("%v", silo.next_string_index+1)
 	//stats["NumberOfAcceleratedTags"] = fmt.Sprintf("%v", len(silo.tag2file))
@@ -61,10 +68,37 @@ func (t *TagResponder) TopTagsStatus(args *Args, reply *TopTagsReply) error {
 func (t *TagResponder) SearchString(args *Args, reply *Reply) error {
 
 	log.Printf("Query: '%v'", args.A)
+	if err := checkGroupBy(args.GroupBy); err != nil {
+		return err
+	}
+	if args.GroupBy != "" && args.Mode == SearchModeHybrid {
+		return errors.New("GroupBy does not work with hybrid searches")
+	}
 	if t.Manor != nil {
-		res := t.Manor.scanFileDatabase(args.A, args.Limit, false)
+		q, err := t.Manor.prepareQuery(args)
+		if err != nil {
+			return err
+		}
+		q.missing = &missingFarms{}
+		v := searchViewer(args.Token, args.Restricted, args.ViewAs)
+		var res []ResultRecordTransmittable
+		if args.GroupBy != "" {
+			limit := args.Limit
+			if limit < 1 {
+				limit = 10
+			}
+			reply.Groups, err = t.Manor.groupFileDatabase(args.Index, q, limit, args.GroupLines, v)
+			res = flattenGroups(reply.Groups)
+		} else if args.Mode == SearchModeHybrid {
+			res, err = t.Manor.hybridSearch(args.Index, q, args.Limit, v)
+		} else {
+			res, err = t.Manor.streamFileDatabase(args.Index, q, args.Limit, v, nil)
+		}
+		if err != nil {
+			return err
+		}
 		reply.C = res
-	} else {
+		reply.Partial = q.missing.list()
 	}
 
 	//searchF := silo.makeFingerprintFromSearch(args.A)
@@ -85,7 +119,17 @@ func (t *TagResponder) SearchString(args *Args, reply *Reply) error {
 func (t *TagResponder) PredictString(args *Args, reply *StringListReply) error {
 
 	log.Printf("PredictString: '%v'", args.A)
-	//res := defaultFarm.predictString(args.A, args.Limit)
+	if t.Manor != nil {
+		limit := args.Limit
+		if limit < 1 {
+			limit = 10
+		}
+		res, err := t.Manor.predictString(args.Index, args.A, limit, searchViewer(args.Token, args.Restricted, args.ViewAs))
+		if err != nil {
+			return err
+		}
+		reply.C = res
+	}
 
 	//log.Printf("Results: %v", res)
 	//reply.C = []resultRecord(res)
@@ -108,10 +152,22 @@ func (t *TagResponder) InsertRecord(args *InsertArgs, reply *SuccessReply) error
 
 	//f := makeFingerprint(args.Tags)
 	if t.Manor != nil && !shuttingDown {
-		rec := RecordTransmittable{args.Name, args.Position, args.Tags}
-		t.Manor.SubmitRecord(rec)
-		reply.Success = true
-		reply.Reason = ""
+		meta, err := cleanMetadata(args.Metadata)
+		var vector []float32
+		if err == nil {
+			vector, err = t.Manor.recordVector(args.Tags, args.Vector)
+		}
+		if err == nil {
+			rec := RecordTransmittable{args.Name, args.Position, withVectorTag(withMetaTags(withACLTags(args.Tags, args.Principals), meta), vector)}
+			err = t.Manor.SubmitRecordTo(args.Index, rec)
+		}
+		if err != nil {
+			reply.Success = false
+			reply.Reason = err.Error()
+		} else {
+			reply.Success = true
+			reply.Reason = ""
+		}
 	} else {
 		if shuttingDown {
 			reply.Success = false
@@ -129,6 +185,199 @@ func (t *TagResponder) InsertRecord(args *InsertArgs, reply *SuccessReply) error
 	return nil
 }
 
+// Insert several records in one call.  Stops at the first record that is refused, the records before it are stored and
+// counted in reply.Inserted, so a client only sends the rest again.  A batch sent again with the same BatchID is not
+// stored again, and gets the reply to the first one
+func (t *TagResponder) InsertRecords(args *BatchInsertArgs, reply *SuccessReply) error {
+	var batches *batchLog
+	if t.Manor != nil {
+		batches = t.Manor.batches
+	}
+	batches.once(args.BatchID, reply, func() {
+		reply.Inserted = 0
+		for i := range args.Records {
+			t.InsertRecord(&args.Records[i], reply)
+			if !reply.Success {
+				return
+			}
+			reply.Inserted++
+		}
+		reply.Success = true
+	})
+	return nil
+}
+
+func (t *TagResponder) DeleteRecord(args *DeleteArgs, reply *DeleteReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if shuttingDown {
+		return errors.New("Server in shutdown mode")
+	}
+	deleted, err := t.Manor.deleteRecords(args.Index, args.Name, args.Position, args.AllLines)
+	if err != nil {
+		return err
+	}
+	reply.Deleted = deleted
+	log.Printf("Deleted %v records for '%v'", reply.Deleted, args.Name)
+	return nil
+}
+
+// Create a new index while the server runs.  Needs an admin token
+func (t *TagResponder) CreateIndex(args *CreateIndexArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.CreateIndex(*args); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Add silos to a disk farm while the server runs, and move records into them.  Needs an admin token
+func (t *TagResponder) AddSilos(args *SiloArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.AddSilos(*args); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Move every record out of a silo of a disk farm, then retire it.  Needs an admin token
+func (t *TagResponder) DrainSilo(args *SiloArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.DrainSilo(*args); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Move the records of a farm that held records before routing to the silos their files route to.  Needs an admin token
+func (t *TagResponder) RouteRecords(args *SiloArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.RouteRecords(*args); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Compact the silos of a disk farm in the background.  Needs an admin token
+func (t *TagResponder) Compact(args *SiloArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.Compact(*args); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Apply a batch of the leader's replication log.  Called by the leader, needs an admin token
+func (t *TagResponder) Replicate(args *ReplicateArgs, reply *ReplicateReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	applied, err := t.Manor.applyReplication(args)
+	reply.Applied = applied
+	if err != nil {
+		reply.Reason = err.Error()
+	}
+	return nil
+}
+
+// Make a follower the leader.  Needs an admin token
+func (t *TagResponder) Promote(args *Args, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	if err := t.Manor.Promote(); err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return nil
+	}
+	reply.Success = true
+	return nil
+}
+
+// Write a snapshot of every farm to a directory on the server.  Writes wait while it is taken.  Needs an admin token
+func (t *TagResponder) Backup(args *BackupArgs, reply *BackupReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	manifest, err := t.Manor.Backup(args.Dir)
+	backupReply(reply, manifest, err)
+	return nil
+}
+
+// Check a backup in a directory on the server, and replace the farms' silos with it.  Needs an admin token
+func (t *TagResponder) Restore(args *BackupArgs, reply *BackupReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	manifest, err := t.Manor.Restore(args.Dir)
+	backupReply(reply, manifest, err)
+	return nil
+}
+
+func backupReply(reply *BackupReply, manifest *BackupManifest, err error) {
+	if err != nil {
+		reply.Success = false
+		reply.Reason = err.Error()
+		return
+	}
+	reply.Success = true
+	reply.Manifest = manifest
+}
+
+// Count an open of a search result, for the popularity boost
+func (t *TagResponder) RecordClick(args *ClickArgs, reply *SuccessReply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	t.Manor.RecordClick(args.Name, args.Position)
+	reply.Success = true
+	return nil
+}
+
+// Find the records most like the record for Name at Position, leaving that record out
+func (t *TagResponder) SimilarRecords(args *SimilarArgs, reply *Reply) error {
+	if t.Manor == nil {
+		return errors.New("Server not ready")
+	}
+	limit := args.Limit
+	if limit < 1 {
+		limit = 10
+	}
+	res, err := t.Manor.similarRecords(args.Index, args.Name, args.Position, limit, searchViewer(args.Token, args.Restricted, args.ViewAs))
+	if err != nil {
+		return err
+	}
+	reply.C = res
+	log.Printf("Results: %d records like '%v' line %v", len(reply.C), args.Name, args.Position)
+	return nil
+}
+
 func (t *TagResponder) Error(args *Args, reply *Reply) error {
 	log.Println("ERROR")
 	panic("ERROR")
@@ -155,9 +404,11 @@ func (r *rpcRequest) Read(p []byte) (n int, err error) {
 }
 
 // Write implements the io.ReadWriteCloser Write method.
+// Call is only signalled once the response is in the buffer, so it never reads a half written response
 func (r *rpcRequest) Write(p []byte) (n int, err error) {
+	n, err = r.rw.Write(p)
 	r.done <- true
-	return r.rw.Write(p)
+	return n, err
 }
 
 // Close implements the io.ReadWriteCloser Close method.
@@ -166,7 +417,8 @@ func (r *rpcRequest) Close() error {
 }
 
 // Call starts the RPC request, waits for it to complete, and returns the results.
-func (r *rpcRequest) Call(m *Manor) io.Reader {
+// token is used for calls that do not carry their own
+func (r *rpcRequest) Call(m *Manor, token string) io.Reader {
 	if debug {
 		log.Printf("Processing json rpc request\n")
 	}
@@ -177,7 +429,7 @@ func (r *rpcRequest) Call(m *Manor) io.Reader {
 
 	//server.HandleHTTP(rpc.DefaultRPCPath, rpc.DefaultDebugPath)
 
-	go server.ServeCodec(jsonrpc.NewServerCodec(r))
+	go server.ServeCodec(newAuthCodec(jsonrpc.NewServerCodec(r), token))
 	//go jsonrpc.ServeConn(r)
 	<-r.done
 	//b := []byte{}
@@ -198,6 +450,9 @@ func rpc_server(serverAddress string, m *Manor) {
 	if e != nil {
 		log.Fatal("listen error:", e)
 	}
+	if serverTLS != nil {
+		l = tls.NewListener(l, serverTLS)
+	}
 
 	http.HandleFunc("/rpc", func(w http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
@@ -207,9 +462,10 @@ func rpc_server(serverAddress string, m *Manor) {
 		// Let's Fix Call() to take an argument? Or just copy logic here.
 		// `NewRPCRequest(req.Body).Call()`
 		// I will update Call signature in a separate edit.
-		res := NewRPCRequest(req.Body).Call(m)
+		res := NewRPCRequest(req.Body).Call(m, bearerToken(req.Header.Get("Authorization")))
 		io.Copy(w, res)
 	})
+	registerRestHandlers(http.DefaultServeMux, m)
 
 	cwd, _ := os.Getwd()
 	log.Printf("Serving /files/ from:%s on port 8181\n", cwd)
@@ -217,6 +473,9 @@ func rpc_server(serverAddress string, m *Manor) {
 	http.Handle("/", http.FileServer(http.Dir("webfiles")))
 	//FIXME
 	http.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(cwd))))
+	if !authEnabled() {
+		log.Println("WARNING: /files/ and /debug/pprof/ can be read by anyone who can reach port 8181.  Configure tokens to protect them")
+	}
 
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
@@ -227,21 +486,31 @@ func rpc_server(serverAddress string, m *Manor) {
 		defer pprof.StopCPUProfile()
 	}
 
-	go http.ListenAndServe(":8181", nil)
-	open.Start("http://localhost:8181/index.html")
+	httpServer := &http.Server{Addr: ":8181", Handler: authHandler(http.DefaultServeMux), TLSConfig: serverTLS}
+	if serverTLS != nil {
+		go httpServer.ListenAndServeTLS("", "")
+		open.Start("https://localhost:8181/index.html")
+	} else {
+		go httpServer.ListenAndServe()
+		open.Start("http://localhost:8181/index.html")
+	}
+
+	log.Fatal(serveJSONRPC(l, server))
+}
 
+// Serve JSON-RPC calls on each connection that l accepts.  Returns when l fails or is closed
+func serveJSONRPC(l net.Listener, server *rpc.Server) error {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		if debug {
 			log.Println("Got connection")
 		}
-		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		go server.ServeCodec(newAuthCodec(jsonrpc.NewServerCodec(conn), ""))
 		if debug {
 			log.Println("Sent response, probably")
 		}
 	}
-
 }
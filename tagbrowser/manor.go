@@ -2,88 +2,459 @@
 
 //A manor holds several farms. The manor accepts records to be stored on one of the farms,
 //also it searches all the farms during a query, then combines the results and returns them
+//
+//The farms are grouped into named indexes.  Records sent to one index are only stored on that index's farms, on the one
+//farm and silo that their filename is routed to (see routing.go), and searches only look at the farms of the indexes they name
 
 package tagbrowser
 
 import (
+	"fmt"
 	"log"
 	"sort"
+	"strings"
 	"sync"
 )
 
+// The index used by farms that do not name one, and by requests that do not name one
+const DefaultIndex = "default"
+
+// An index, farm or record that a call names does not exist.  The REST and gRPC servers report these as not found
+type notFoundError string
+
+func (e notFoundError) Error() string { return string(e) }
+
+func notFound(format string, a ...interface{}) error {
+	return notFoundError(fmt.Sprintf(format, a...))
+}
+
+type index struct {
+	name             string
+	farms            []*Farm
+	permanentStoreCh chan RecordTransmittable //Passed to the farms' silos
+}
+
 type Manor struct {
-	Farms            []*Farm
-	recordCh         chan RecordTransmittable //Used to send records to all the farms
-	permanentStoreCh chan RecordTransmittable //Used to send records to disk databases only
+	Farms      []*Farm //Every farm, in every index
+	indexes    map[string]*index
+	indexLock  sync.RWMutex
+	indexFile  string                //Where indexes made with CreateIndex are saved.  Empty to not save them
+	created    map[string]serverInfo //Farms made with CreateIndex
+	rank       *ranker               //Recency and popularity boosts, and the click counts
+	embedder   Embedder              //Makes vectors for records and searches without one.  Nil for none
+	repl       *replicator           //Logs writes for followers, or applies the leader's log.  See replication.go
+	batches    *batchLog             //Replies to recent InsertRecords batches, so a coordinator's resent batches are not stored twice.  See remote.go
+	compaction compactionInfo        //How disk silos are compacted.  See compact.go
+	stop       chan struct{}         //Closed when the manor shuts down, to stop compactionWorker and the click saver
+	stopOnce   sync.Once             //Closes stop
+	workers    sync.WaitGroup        //compactionWorker and the click saver, while they run
 }
 
+// Create the manor for the server.  Exits if a farm cannot be opened
 func CreateManor(config tomlConfig) *Manor {
+	m, err := openManor(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return m
+}
+
+// Create the manor and open its farms.  If a farm cannot be opened, the farms already opened are shut down again
+func openManor(config tomlConfig) (*Manor, error) {
 	m := Manor{}
+	m.stop = make(chan struct{})
 	m.Farms = []*Farm{}
-	m.recordCh = make(chan RecordTransmittable, 100)
-	m.permanentStoreCh = make(chan RecordTransmittable, 100)
+	m.indexes = map[string]*index{}
+	m.created = map[string]serverInfo{}
+	m.batches = &batchLog{batches: map[string]*sentBatch{}}
+	m.rank = newRanker(config.Ranking)
+	embedder, err := newEmbedder(config.Vectors)
+	if err != nil {
+		log.Println("Vectors: ", err)
+	}
+	m.embedder = embedder
+	m.repl = newReplicator(config.Replication, m.stop)
+	m.compaction = config.Compaction
+	for name, v := range config.Farms {
+		if _, err := m.addFarm(v); err != nil {
+			m.Shutdown()
+			return nil, fmt.Errorf("farm %v: %v", name, err)
+		}
+	}
+	if _, ok := m.indexes[DefaultIndex]; !ok {
+		log.Printf("No farms in the %v index, records sent without an index name will be refused", DefaultIndex)
+	}
+	if m.compaction.Interval > 0 {
+		m.workers.Add(1)
+		go m.compactionWorker()
+	}
+	return &m, nil
+}
 
-	for _, v := range config.Farms {
-		var mem bool
-		if v.Mode == "memory" {
-			mem = true
+// Find or create the index called name.  The caller must hold indexLock
+func (m *Manor) getIndex(name string) *index {
+	idx, ok := m.indexes[name]
+	if !ok {
+		idx = &index{name: name}
+		idx.permanentStoreCh = make(chan RecordTransmittable, 100)
+		m.indexes[name] = idx
+	}
+	return idx
+}
+
+// Create a farm, and add it to the index named in its settings
+func (m *Manor) addFarm(v serverInfo) (*Farm, error) {
+	m.indexLock.Lock()
+	defer m.indexLock.Unlock()
+	return m.addFarmLocked(v)
+}
+
+// As addFarm.  The caller must hold indexLock
+func (m *Manor) addFarmLocked(v serverInfo) (*Farm, error) {
+	var mem bool
+	if v.Mode == "memory" {
+		mem = true
+	}
+	name := indexName(v.Index)
+	idx := m.getIndex(name)
+	var f *Farm
+	if v.Remote != "" {
+		log.Printf("Adding remote farm at %v for index %v", v.Remote, name)
+		var err error
+		f, err = createRemoteFarm(v)
+		if err != nil {
+			if len(idx.farms) == 0 {
+				delete(m.indexes, name)
+			}
+			return nil, err
 		}
-		log.Printf("Creating farm at %v, %v silos, memory only: %v, offloading: %v", v.Location, v.Silos, mem, v.Offload)
-		f := createFarm(v.Location, v.Silos, m.recordCh, mem, m.permanentStoreCh, v.Offload, v.Size)
-		m.Farms = append(m.Farms, f)
+	} else {
+		log.Printf("Creating farm at %v for index %v, %v silos, memory only: %v, offloading: %v", v.Location, name, v.Silos, mem, v.Offload)
+		var err error
+		f, err = createFarm(v.Location, v.Silos, mem, idx.permanentStoreCh, v.Offload, v.Size)
+		if err != nil {
+			if len(idx.farms) == 0 {
+				delete(m.indexes, name)
+			}
+			return nil, err
+		}
+	}
+	f.index = name
+	idx.farms = append(idx.farms, f)
+	if f.temporary && f.memory_only {
+		f.workers.Add(1)
+		go m.offloadMoverWorker(idx, f)
 	}
-	return &m
+	m.Farms = append(m.Farms, f)
+	return f, nil
 }
 
-func (m *Manor) Shutdown() {
-	for _, f := range m.Farms {
-		f.Shutdown()
+func indexName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultIndex
+	}
+	return name
+}
+
+// The farms to search for a list of index names.  "" is the default index, "a,b" names several indexes, and "*" is every index
+func (m *Manor) farmsFor(names string) ([]*Farm, error) {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	if strings.TrimSpace(names) == "*" {
+		return append([]*Farm{}, m.Farms...), nil
+	}
+	farms := []*Farm{}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = indexName(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		idx, ok := m.indexes[name]
+		if !ok {
+			return nil, notFound("unknown index %q", name)
+		}
+		farms = append(farms, idx.farms...)
+	}
+	return farms, nil
+}
+
+// The indexes for a list of index names, as farmsFor takes them
+func (m *Manor) indexesFor(names string) ([]*index, error) {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	out := []*index{}
+	if strings.TrimSpace(names) == "*" {
+		for _, idx := range m.indexes {
+			out = append(out, idx)
+		}
+		return out, nil
+	}
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = indexName(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		idx, ok := m.indexes[name]
+		if !ok {
+			return nil, notFound("unknown index %q", name)
+		}
+		out = append(out, idx)
+	}
+	return out, nil
+}
+
+// Names of every index, sorted
+func (m *Manor) IndexNames() []string {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	names := []string{}
+	for name := range m.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create a manor from farm settings, without reading a config file
+func NewManor(farms map[string]FarmConfig) (*Manor, error) {
+	return openManor(tomlConfig{Farms: farms})
+}
+
+// Stop the workers of every farm, so no records move between farms, then close the farms.  Returns the first error
+func (m *Manor) Shutdown() error {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+	m.indexLock.RLock()
+	farms := append([]*Farm{}, m.Farms...)
+	m.indexLock.RUnlock()
+	for _, f := range farms {
+		f.stopWorkers()
+	}
+	m.workers.Wait()
+	var first error
+	for _, f := range farms {
+		if err := f.Shutdown(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if err := m.rank.save(); err != nil {
+		log.Println("Could not save click counts: ", err)
+		if first == nil {
+			first = err
+		}
 	}
+	if err := m.repl.saveLast(); err != nil {
+		log.Println("Could not save the place in the replication log: ", err)
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Count an open of a search result, for the popularity boost
+func (m *Manor) RecordClick(name string, line int) {
+	m.rank.click(name, line)
 }
 
+// Store a record in the default index
 func (m *Manor) SubmitRecord(r RecordTransmittable) {
+	if err := m.SubmitRecordTo(DefaultIndex, r); err != nil {
+		log.Println("Dropped record: ", err)
+	}
+}
+
+// Store a record in the named index, on the farm its filename is routed to.  Followers refuse it
+func (m *Manor) SubmitRecordTo(name string, r RecordTransmittable) error {
+	e := ReplicationEntry{Index: name, Name: r.Filename, Position: r.Line, Tags: r.Fingerprint}
+	return m.repl.write(e, func() error {
+		return m.storeRecord(name, r)
+	})
+}
+
+func (m *Manor) storeRecord(name string, r RecordTransmittable) error {
+	name = indexName(name)
+	m.indexLock.RLock()
+	idx, ok := m.indexes[name]
+	var aFarm *Farm
+	if ok {
+		aFarm = routeFarm(intakeFarms(idx.farms), r.Filename)
+	}
+	m.indexLock.RUnlock()
+	if !ok {
+		return notFound("unknown index %q", name)
+	}
+	if aFarm == nil {
+		return fmt.Errorf("index %q has no farms", name)
+	}
 	if debug {
 		log.Println("Submitting record")
 	}
-	m.recordCh <- r
+	if err := aFarm.SubmitRecord(r); err != nil {
+		return err
+	}
 	if debug {
 		log.Println("Record submitted")
 	}
+	return nil
+}
+
+// Search the default index, returning the best maxResults results
+func (m *Manor) Search(searchString string, maxResults int) []ResultRecordTransmittable {
+	return m.scanFileDatabase(searchString, maxResults, false)
 }
 
 func (m *Manor) scanFileDatabase(searchString string, maxResults int, exactMatch bool) []ResultRecordTransmittable {
+	q := parseQuery(searchString, "", m.fieldsIn(DefaultIndex))
+	q.match.all = exactMatch
+	res, _ := m.streamFileDatabase(DefaultIndex, q, maxResults, nil, nil)
+	return res
+}
+
+// One farm's search results
+type farmResults struct {
+	farm string
+	res  []ResultRecordTransmittable
+}
+
+// Search the farms in the named indexes (see farmsFor), passing each farm's results to emit as soon as that farm is finished, then return the merged results.
+// Records that v may not see are left out, a nil v sees everything.  emit may be nil.  Calls to emit never overlap
+func (m *Manor) streamFileDatabase(indexes string, q searchQuery, maxResults int, v *viewer, emit func(farm string, res []ResultRecordTransmittable)) ([]ResultRecordTransmittable, error) {
+	farms, err := m.farmsFor(indexes)
+	if err != nil {
+		return nil, err
+	}
+	q.rank = m.rank
 	log.Printf("Requesting %v results\n", maxResults)
 	results := ResultRecordTransmittableCollection{}
-	resLock := sync.Mutex{}
-	resLock.Lock()
-	var wg sync.WaitGroup
-	log.Printf("Searching %v farms: %v", len(m.Farms), m.Farms)
-	for _, aFarm := range m.Farms {
+	//Farms are searched in parallel, and their results merged here, one farm at a time, so a slow emit only holds up the merging
+	finished := make(chan farmResults, len(farms))
+	log.Printf("Searching %v farms: %v", len(farms), farms)
+	for _, aFarm := range farms {
 		if debug {
 			log.Printf("Searching Farm: %v", aFarm.location)
 		}
-		wg.Add(1)
 		go func(threadFarm *Farm) {
-			defer wg.Done()
-			res := threadFarm.scanFileDatabase(searchString, maxResults, exactMatch)
-			resLock.Lock()
-			defer resLock.Unlock()
-			if debug {
-				log.Printf("Merging in resultset %v for farm %v", res, threadFarm.location)
-			}
-			for _, r := range res {
-				if !IsIn(r, results) {
-					results = append(results, r)
-					sort.Sort(results)
-					if results.Len() > maxResults {
-						results = results[0:maxResults]
-					}
+			finished <- farmResults{threadFarm.location, threadFarm.scanFileDatabase(q, maxResults, v)}
+		}(aFarm)
+	}
+	for range farms {
+		done := <-finished
+		if debug {
+			log.Printf("Merging in resultset %v for farm %v", done.res, done.farm)
+		}
+		if emit != nil {
+			emit(done.farm, done.res)
+		}
+		for _, r := range done.res {
+			if !IsIn(r, results) {
+				results = append(results, r)
+				q.sortResults(results)
+				if results.Len() > maxResults {
+					results = results[0:maxResults]
 				}
 			}
-		}(aFarm)
+		}
 	}
-	wg.Wait()
-	sort.Sort(results)
+	q.sortResults(results)
+
+	return results, nil
+}
+
+// Delete records from every farm in every index.  If allLines is true, every record for filename is removed, otherwise only the record at line
+func (m *Manor) DeleteRecords(filename string, line int, allLines bool) int {
+	deleted, _ := m.deleteRecords("*", filename, line, allLines)
+	return deleted
+}
+
+// Delete records from the farms in the named indexes.  Followers refuse it
+func (m *Manor) deleteRecords(indexes string, filename string, line int, allLines bool) (int, error) {
+	deleted := 0
+	e := ReplicationEntry{Index: indexes, Delete: true, Name: filename, Position: line, AllLines: allLines}
+	err := m.repl.write(e, func() error {
+		var err error
+		deleted, err = m.removeRecords(indexes, filename, line, allLines)
+		return err
+	})
+	return deleted, err
+}
+
+// Delete records from the farms of the named indexes that may hold them.  See routing.go
+func (m *Manor) removeRecords(indexes string, filename string, line int, allLines bool) (int, error) {
+	idxs, err := m.indexesFor(indexes)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, idx := range idxs {
+		for _, aFarm := range m.farmsHolding(idx, filename) {
+			deleted = deleted + aFarm.deleteRecords(filename, line, allLines)
+		}
+	}
+	return deleted, nil
+}
 
-	return results
+// Complete a partial word from the tags stored in the default index
+func (m *Manor) Predict(prefix string, maxResults int) []string {
+	res, _ := m.predictString(DefaultIndex, prefix, maxResults, nil)
+	return res
+}
+
+// Complete prefix from the farms in the named indexes, leaving out the tags of records that v may not see
+func (m *Manor) predictString(indexes string, prefix string, maxResults int, v *viewer) ([]string, error) {
+	farms, err := m.farmsFor(indexes)
+	if err != nil {
+		return nil, err
+	}
+	results := []string{}
+	for _, aFarm := range farms {
+		results = append(results, aFarm.predictString(prefix, maxResults, v)...)
+	}
+	results = uniqStrings(withoutReservedTags(results))
+	sort.Strings(results)
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results, nil
+}
+
+// Statistics for every farm, prefixed with the farm number
+func (m *Manor) Status() map[string]string {
+	stats := map[string]string{}
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	stats["farms"] = fmt.Sprintf("%v", len(m.Farms))
+	queued := 0
+	names := []string{}
+	for name, idx := range m.indexes {
+		indexQueued := 0
+		for _, aFarm := range idx.farms {
+			indexQueued = indexQueued + aFarm.queued()
+		}
+		queued = queued + indexQueued
+		names = append(names, name)
+		stats[fmt.Sprintf("index.%v.farms", name)] = fmt.Sprintf("%v", len(idx.farms))
+		stats[fmt.Sprintf("index.%v.queued_records", name)] = fmt.Sprintf("%v", indexQueued)
+	}
+	sort.Strings(names)
+	stats["indexes"] = strings.Join(names, ",")
+	stats["queued_records"] = fmt.Sprintf("%v", queued)
+	m.rank.status(stats)
+	m.repl.status(stats)
+	if m.embedder != nil {
+		stats["vectors.embedder"] = fmt.Sprintf("%T", m.embedder)
+		stats["vectors.dimensions"] = fmt.Sprintf("%v", m.embedder.Dimensions())
+	}
+	for i, aFarm := range m.Farms {
+		for k, v := range aFarm.status() {
+			stats[fmt.Sprintf("farm.%v.%v", i, k)] = v
+		}
+	}
+	return stats
 }
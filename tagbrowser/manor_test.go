@@ -0,0 +1,57 @@
+// manor_test.go
+package tagbrowser
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamFileDatabaseEmitsEachFarmInTurn(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestManor(t, map[string]FarmConfig{
+		"a": {Location: filepath.Join(dir, "a"), Silos: 1, Mode: "memory"},
+		"b": {Location: filepath.Join(dir, "b"), Silos: 1, Mode: "memory"},
+	})
+	for _, f := range m.Farms {
+		storeRecords(t, f, RecordTransmittable{f.location + "/story.txt", 1, []string{"fox"}})
+	}
+
+	var running, overlapped int32
+	emitted := map[string]int{}
+	res, err := m.streamFileDatabase(DefaultIndex, parseQuery("fox", "", nil), 10, nil, func(farm string, res []ResultRecordTransmittable) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(50 * time.Millisecond)
+		emitted[farm] = len(res)
+		atomic.AddInt32(&running, -1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overlapped != 0 {
+		t.Error("calls to emit overlapped")
+	}
+	if len(emitted) != 2 || emitted[m.Farms[0].location] != 1 || emitted[m.Farms[1].location] != 1 {
+		t.Errorf("emitted %v, want one result from each farm", emitted)
+	}
+	if len(res) != 2 {
+		t.Errorf("merged %v results, want 2", len(res))
+	}
+}
+
+func TestInsertRecordsCountsStoredRecords(t *testing.T) {
+	m := testManor(t, "memory", 1)
+	tr := &TagResponder{Manor: m}
+	reply := &SuccessReply{}
+	tr.InsertRecords(&BatchInsertArgs{Records: []InsertArgs{
+		{Name: "a.txt", Tags: []string{"x"}},
+		{Name: "b.txt", Tags: []string{"x"}, Index: "missing"},
+		{Name: "c.txt", Tags: []string{"x"}},
+	}}, reply)
+	if reply.Success || reply.Inserted != 1 {
+		t.Errorf("InsertRecords replied %+v, want a refusal after 1 record", reply)
+	}
+}
@@ -0,0 +1,143 @@
+// match.go
+
+//Match modes.  By default ("any") a search finds every record that has at least one of its words, and records with more
+//of the words score higher.  "all" only finds records that have every word, and "minimum_should_match=N" finds records
+//that have at least N of them.  Excluded words ("word-") count against a record, as they do for the score.
+//
+//"all" is answered by intersecting the words' posting lists, starting with the shortest, so it looks at fewer records
+//than a partial match does.
+
+package tagbrowser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MatchAny = "any"
+	MatchAll = "all"
+)
+
+const minimumShouldMatchPrefix = "minimum_should_match="
+
+// How many of a search's words a record needs
+type matchMode struct {
+	all      bool
+	minWords int //For minimum_should_match.  0 for any
+}
+
+// Read a search's Match.  Empty is "any"
+func parseMatchMode(s string) (matchMode, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == MatchAny:
+		return matchMode{}, nil
+	case s == MatchAll:
+		return matchMode{all: true}, nil
+	case strings.HasPrefix(s, minimumShouldMatchPrefix):
+		n, err := strconv.Atoi(s[len(minimumShouldMatchPrefix):])
+		if err == nil && n > 0 {
+			return matchMode{minWords: n}, nil
+		}
+	}
+	return matchMode{}, fmt.Errorf("unknown Match %q, use \"any\", \"all\" or \"minimum_should_match=N\" with N above 0", s)
+}
+
+// The mode as a Match, the opposite of parseMatchMode
+func (m matchMode) String() string {
+	switch {
+	case m.all:
+		return MatchAll
+	case m.minWords > 0:
+		return fmt.Sprintf("%v%v", minimumShouldMatchPrefix, m.minWords)
+	}
+	return MatchAny
+}
+
+// True if every record with a word passes, so the fast scan can be used
+func (m matchMode) any() bool {
+	return !m.all && m.minWords <= 1
+}
+
+// Check a record's score, the number of words it matched less the excluded words it has, against the mode
+func (m matchMode) passes(score int, words int) bool {
+	if score <= 0 {
+		return false
+	}
+	if m.all {
+		return score >= words
+	}
+	return score >= m.minWords
+}
+
+// The items that are in every list, in the order of the shortest list
+func intersect[T comparable](lists [][]T) []T {
+	out := []T{}
+	if len(lists) == 0 {
+		return out
+	}
+	sort.SliceStable(lists, func(i, j int) bool {
+		return len(lists[i]) < len(lists[j])
+	})
+	kept := map[T]bool{}
+	for _, item := range lists[0] {
+		kept[item] = true
+	}
+	for _, list := range lists[1:] {
+		if len(kept) == 0 {
+			break
+		}
+		next := map[T]bool{}
+		for _, item := range list {
+			if kept[item] {
+				next[item] = true
+			}
+		}
+		kept = next
+	}
+	for _, item := range lists[0] {
+		if kept[item] {
+			out = append(out, item)
+			delete(kept, item)
+		}
+	}
+	return out
+}
+
+// Every record that has all of the tags
+func (s *tagSilo) intersectRecords(tags fingerPrint) []record {
+	out := []record{}
+	if len(tags) == 0 {
+		return out
+	}
+	if s.memory_db {
+		s.writeMutex.Lock()
+		defer s.writeMutex.Unlock()
+		lists := [][]*record{}
+		for _, tag := range tags {
+			if tag <= 0 || tag >= len(s.tag2file) {
+				return out
+			}
+			lists = append(lists, s.tag2file[tag])
+		}
+		for _, r := range intersect(lists) {
+			if r != nil {
+				out = append(out, *r)
+			}
+		}
+		return out
+	}
+
+	for _, tag := range tags {
+		if tag <= 0 {
+			return out
+		}
+	}
+	for _, id := range s.Store.IntersectRecordIds(tags) {
+		out = append(out, s.getRecord(id))
+	}
+	return out
+}
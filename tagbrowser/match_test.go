@@ -0,0 +1,58 @@
+// match_test.go
+package tagbrowser
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIntersect(t *testing.T) {
+	got := intersect([][]int{{1, 2, 3, 4}, {4, 3, 9}, {3, 4, 5, 6, 7}})
+	if !reflect.DeepEqual(got, []int{4, 3}) {
+		t.Errorf("intersect = %v, want [4 3], in the order of the shortest list", got)
+	}
+	if got := intersect([][]int{{1}, {}}); len(got) != 0 {
+		t.Errorf("intersect with an empty list = %v", got)
+	}
+}
+
+func TestIntersectRecords(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFarm(t, mode, 1)
+			storeRecords(t, f,
+				RecordTransmittable{"a.txt", 1, []string{"quick", "brown", "fox"}},
+				RecordTransmittable{"b.txt", 1, []string{"quick", "fox"}},
+				RecordTransmittable{"c.txt", 1, []string{"quick", "dog"}},
+				RecordTransmittable{"d.txt", 1, []string{"brown", "fox"}},
+			)
+			s := f.siloList()[0]
+			for _, c := range []struct {
+				tags []string
+				want []string
+			}{
+				{[]string{"quick", "fox"}, []string{"a.txt", "b.txt"}},
+				{[]string{"quick", "brown", "fox"}, []string{"a.txt"}},
+				{[]string{"fox", "fox"}, []string{"a.txt", "b.txt", "d.txt"}},
+				{[]string{"dog", "fox"}, []string{}},
+			} {
+				tags := fingerPrint{}
+				for _, tag := range c.tags {
+					tags = append(tags, s.lookupSymbol(tag))
+				}
+				got := []string{}
+				for _, r := range s.intersectRecords(tags) {
+					got = append(got, s.getString(r.Filename))
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf("%v: found %v, want %v", c.tags, got, c.want)
+				}
+			}
+			if got := s.intersectRecords(fingerPrint{s.lookupSymbol("fox"), 0}); len(got) != 0 {
+				t.Errorf("a missing tag matched %v records", len(got))
+			}
+		})
+	}
+}
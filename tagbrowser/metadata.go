@@ -0,0 +1,451 @@
+// metadata.go
+
+//Record metadata.  As well as its tags, a record may carry named fields such as mtime, size, author or host.
+//Queries filter on them with terms like "mtime>2026-01-01" or "author:alice", and sort on them with "sort:mtime" or "sort:-size".
+//A term is only a filter if a record in the searched indexes has that field, so "a=b" in ordinary text is searched for as a word.
+//
+//Like access lists (see acl.go), the fields are stored as reserved tags, so every kind of silo saves them without changing
+//the storage formats.  Values are typed when they are compared: two values that look like numbers are compared as numbers,
+//two that look like dates are compared as dates, and anything else is compared as text.
+
+package tagbrowser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metaTagPrefix = "\x1fmeta:"
+
+var metaKeyRegex = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+
+// A filter term in a query: field, operator and value
+var filterRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.-]*)(>=|<=|!=|>|<|:|=)(.+)$`)
+
+// Layouts that metadata dates are read from.  Dates are stored as RFC3339
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func metaTag(key, value string) string {
+	return metaTagPrefix + key + "=" + value
+}
+
+func isMetaTag(tag string) bool {
+	return strings.HasPrefix(tag, metaTagPrefix)
+}
+
+// Split a metadata tag into its field and value
+func parseMetaTag(tag string) (string, string, bool) {
+	if !isMetaTag(tag) {
+		return "", "", false
+	}
+	kv := tag[len(metaTagPrefix):]
+	i := strings.Index(kv, "=")
+	if i < 0 {
+		return "", "", false
+	}
+	return kv[:i], kv[i+1:], true
+}
+
+// Tags that are stored with records, but never shown to users or offered as completions
+func isReservedTag(tag string) bool {
+	return isACLTag(tag) || isMetaTag(tag) || isVectorTag(tag)
+}
+
+func withoutReservedTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if !isReservedTag(t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Check a record's metadata before it is stored.  Field names are lower cased, and dates are stored as RFC3339 in UTC
+func cleanMetadata(meta map[string]string) (map[string]string, error) {
+	out := map[string]string{}
+	for k, v := range meta {
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if !metaKeyRegex.MatchString(k) {
+			return nil, fmt.Errorf("metadata field %q: names may only hold letters, digits, '_', '.' and '-'", k)
+		}
+		if k == "sort" {
+			return nil, fmt.Errorf("metadata field %q: the name is reserved for sorting", k)
+		}
+		if v == "" {
+			continue
+		}
+		if t, ok := parseTime(v); ok {
+			v = t.UTC().Format(time.RFC3339)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// Add the metadata tags to a record's tags
+func withMetaTags(tags []string, meta map[string]string) []string {
+	if len(meta) == 0 {
+		return tags
+	}
+	out := make([]string, 0, len(tags)+len(meta))
+	for _, t := range tags {
+		if !isMetaTag(t) {
+			out = append(out, t)
+		}
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		out = append(out, metaTag(k, meta[k]))
+	}
+	return out
+}
+
+// Compare two metadata values, as numbers, dates or text
+func compareValues(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := parseTime(a); ok {
+		if y, ok := parseTime(b); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// One filter term from a query, e.g. "mtime>2026-01-01"
+type metaFilter struct {
+	key   string
+	op    string
+	value string
+}
+
+// Equality also matches text regardless of case, and a date matches every time on that day
+func (f metaFilter) equal(v string) bool {
+	if compareValues(v, f.value) == 0 || strings.EqualFold(v, f.value) {
+		return true
+	}
+	if day, err := time.Parse("2006-01-02", f.value); err == nil {
+		if t, ok := parseTime(v); ok {
+			return t.UTC().Format("2006-01-02") == day.Format("2006-01-02")
+		}
+	}
+	return false
+}
+
+// Records without the field only pass "!=" filters
+func (f metaFilter) matches(meta map[string]string) bool {
+	v, ok := meta[f.key]
+	if !ok {
+		return f.op == "!="
+	}
+	switch f.op {
+	case ":", "=":
+		return f.equal(v)
+	case "!=":
+		return !f.equal(v)
+	case ">":
+		return compareValues(v, f.value) > 0
+	case ">=":
+		return compareValues(v, f.value) >= 0
+	case "<":
+		return compareValues(v, f.value) < 0
+	case "<=":
+		return compareValues(v, f.value) <= 0
+	}
+	return false
+}
+
+// A search string, split into the words to search for, the metadata filters and the sort order
+type searchQuery struct {
+	terms    string //The words, with the filters removed
+	filters  []metaFilter
+	sortKey  string //Metadata field to sort on.  Empty to sort by score
+	sortDesc bool
+	rank     *ranker       //Boosts to add to the text scores.  Nil for none
+	vector   []float32     //If set, records are scored by the similarity of their vectors to this, instead of by their tags
+	match    matchMode     //How many of the words a record needs
+	missing  *missingFarms //Collects the remote farms that do not answer.  Nil to only log them
+}
+
+// Split the filters, and any "sort:field", out of a search string.  sortBy, if set, overrides the sort in the query.
+// Words that look like paths or URLs ("c:/", "http://"), and words on fields that known reports are not stored, are left
+// as words.  A nil known treats every field as stored
+func parseQuery(query string, sortBy string, known func(field string) bool) searchQuery {
+	q := searchQuery{}
+	words := []string{}
+	for _, w := range strings.Fields(query) {
+		m := filterRegex.FindStringSubmatch(w)
+		if m == nil || strings.Contains(w, "://") || strings.ContainsAny(m[3][:1], `/\:`) {
+			words = append(words, w)
+			continue
+		}
+		key := strings.ToLower(m[1])
+		if key == "sort" && m[2] == ":" {
+			q.setSort(m[3])
+			continue
+		}
+		if known != nil && !known(key) {
+			words = append(words, w)
+			continue
+		}
+		q.filters = append(q.filters, metaFilter{key, m[2], m[3]})
+	}
+	q.terms = strings.Join(words, " ")
+	if sortBy != "" {
+		q.setSort(sortBy)
+	}
+	return q
+}
+
+// "field" sorts smallest first, "-field" largest first
+func (q *searchQuery) setSort(field string) {
+	q.sortDesc = strings.HasPrefix(field, "-")
+	q.sortKey = strings.ToLower(strings.TrimPrefix(field, "-"))
+}
+
+// True if the silos have to look at each record's metadata
+func (q searchQuery) usesMetadata() bool {
+	return len(q.filters) > 0 || q.sortKey != "" || q.rank.usesMetadata()
+}
+
+func (q searchQuery) matches(meta map[string]string) bool {
+	for _, f := range q.filters {
+		if !f.matches(meta) {
+			return false
+		}
+	}
+	return true
+}
+
+// The fields the query filters or sorts on
+func (q searchQuery) fields() []string {
+	keys := []string{}
+	for _, f := range q.filters {
+		keys = append(keys, f.key)
+	}
+	if q.sortKey != "" {
+		keys = append(keys, q.sortKey)
+	}
+	return uniqStrings(keys)
+}
+
+// Order two records by the sort field, then by score.  Records without the sort field go last
+func (q searchQuery) less(a, b map[string]string, scoreA, scoreB int) bool {
+	if q.sortKey != "" {
+		va, okA := a[q.sortKey]
+		vb, okB := b[q.sortKey]
+		if okA != okB {
+			return okA
+		}
+		if c := compareValues(va, vb); okA && c != 0 {
+			if q.sortDesc {
+				return c > 0
+			}
+			return c < 0
+		}
+	}
+	return scoreA > scoreB
+}
+
+// Sort merged results into the order the query asked for
+func (q searchQuery) sortResults(results ResultRecordTransmittableCollection) {
+	if q.sortKey == "" {
+		sort.Sort(results)
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		scoreI, _ := strconv.Atoi(results[i].Score)
+		scoreJ, _ := strconv.Atoi(results[j].Score)
+		return q.less(results[i].Metadata, results[j].Metadata, scoreI, scoreJ)
+	})
+}
+
+// A symbol's metadata field, remembered during one scan so each symbol is only looked up once
+type metaSymbol struct {
+	key   string
+	value string
+	ok    bool
+}
+
+func (s *tagSilo) recordMetadata(r record, seen map[int]metaSymbol) map[string]string {
+	meta := map[string]string{}
+	for _, sym := range r.Fingerprint {
+		m, ok := seen[sym]
+		if !ok {
+			m.key, m.value, m.ok = parseMetaTag(s.getString(sym))
+			seen[sym] = m
+		}
+		if m.ok {
+			meta[m.key] = m.value
+		}
+	}
+	return meta
+}
+
+// The symbols of every stored value of the fields
+func (s *tagSilo) metaSymbols(keys []string) fingerPrint {
+	syms := fingerPrint{}
+	for _, k := range keys {
+		syms = append(syms, s.prefixSymbols(metaTagPrefix+k+"=", 0)...)
+	}
+	return syms
+}
+
+// True if a record in the silo has, or had, the field
+func (s *tagSilo) hasField(key string) bool {
+	return len(s.prefixSymbols(metaTagPrefix+key+"=", 1)) > 0
+}
+
+// True if a silo of the farm has the field.  Remote farms answer false, and check the fields of the queries sent to them
+func (f *Farm) hasField(key string) bool {
+	silos, done := f.useSilos()
+	defer done()
+	for _, s := range silos {
+		if s.hasField(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// The fields stored by the farms of the named indexes, for parseQuery
+func (m *Manor) fieldsIn(indexes string) func(field string) bool {
+	farms, _ := m.farmsFor(indexes)
+	return func(field string) bool {
+		for _, f := range farms {
+			if f.hasField(field) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A record that passed a query, with its metadata if the query needed it
+type queryMatch struct {
+	r    resultRecord
+	meta map[string]string
+}
+
+// Every record in the silo that matches the query and that filter lets through, unsorted, with the ranking boosts added to the scores.
+// A query with filters but no words looks through every record that has one of the fields
+func (s *tagSilo) matchRecords(aFing searchPrint, q searchQuery, filter aclFilter) []queryMatch {
+	if q.vector != nil {
+		return s.matchVectors(q, filter, 0)
+	}
+	tags := aFing.wanted
+	noWords := len(tags) == 0
+	if noWords {
+		tags = s.metaSymbols(q.fields())
+	}
+	candidates := s.candidateRecords
+	if q.match.all && !noWords {
+		candidates = s.intersectRecords
+	}
+	matches := []queryMatch{}
+	seen := map[int]metaSymbol{}
+	now := time.Now()
+	for _, r := range candidates(tags) {
+		if r.Filename == 0 || !filter.visible(r) {
+			continue
+		}
+		score := 1
+		if !noWords {
+			score = s.score(aFing, r)
+			if !q.match.passes(score, len(aFing.wanted)) {
+				continue
+			}
+		}
+		var meta map[string]string
+		if q.usesMetadata() {
+			meta = s.recordMetadata(r, seen)
+			if !q.matches(meta) {
+				continue
+			}
+		}
+		name := s.getString(r.Filename)
+		score = q.rank.rankedScore(score, name, r.Line, meta, now)
+		matches = append(matches, queryMatch{resultRecord{name, r.Line, r.Fingerprint, "", score}, meta})
+	}
+	return matches
+}
+
+// Put matches in the query's order
+func (q searchQuery) sortMatches(matches []queryMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return q.less(matches[i].meta, matches[j].meta, matches[i].r.score, matches[j].r.score)
+	})
+}
+
+// True if nothing has to be left out of the results, so the silo's fast scan can answer the query
+func (q searchQuery) fast(filter aclFilter) bool {
+	return filter.restricted == 0 && len(q.filters) == 0 && q.sortKey == "" && q.vector == nil && q.match.any()
+}
+
+// The best maxResults results of the fast scan, with the boosts added.  See fast
+func (s *tagSilo) fastScan(aFing searchPrint, q searchQuery, maxResults int) resultRecordCollection {
+	if q.rank.enabled() {
+		return s.rerank(s.scanFileDatabase(aFing, maxResults*rankDepth, false), q, maxResults)
+	}
+	return s.rerank(s.scanFileDatabase(aFing, maxResults, false), q, maxResults)
+}
+
+// Search the silo, leaving out records that v may not see and records that fail the query's filters, in the query's order.
+// Records are removed while scanning, so up to maxResults records are returned, even if many better matches were left out
+func (s *tagSilo) scanQuery(aFing searchPrint, q searchQuery, maxResults int, v *viewer) resultRecordCollection {
+	filter := s.aclFilterFor(v)
+	if q.fast(filter) {
+		return s.fastScan(aFing, q, maxResults)
+	}
+
+	var matches []queryMatch
+	if q.vector != nil && q.sortKey == "" {
+		//Vectors are matched best first, so the search can stop at maxResults
+		matches = s.matchVectors(q, filter, maxResults)
+	} else {
+		matches = s.matchRecords(aFing, q, filter)
+	}
+	q.sortMatches(matches)
+	if len(matches) > maxResults {
+		matches = matches[0:maxResults]
+	}
+	results := make(resultRecordCollection, len(matches))
+	for i, m := range matches {
+		results[i] = m.r
+	}
+	return results
+}
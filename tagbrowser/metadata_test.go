@@ -0,0 +1,75 @@
+// metadata_test.go
+package tagbrowser
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseQueryOnlyFiltersKnownFields(t *testing.T) {
+	known := func(field string) bool { return field == "mtime" || field == "author" }
+	q := parseQuery("ratio=1:2 mtime>2026-01-01 Author:alice note:x c:/temp sort:-size", "", known)
+	if q.terms != "ratio=1:2 note:x c:/temp" {
+		t.Errorf("terms = %q", q.terms)
+	}
+	want := []metaFilter{{"mtime", ">", "2026-01-01"}, {"author", ":", "alice"}}
+	if !reflect.DeepEqual(q.filters, want) {
+		t.Errorf("filters = %v, want %v", q.filters, want)
+	}
+	if q.sortKey != "size" || !q.sortDesc {
+		t.Errorf("sort = %q, desc %v", q.sortKey, q.sortDesc)
+	}
+}
+
+func TestSearchFiltersOnStoredFields(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 2)
+			f := m.Farms[0]
+			storeRecords(t, f,
+				RecordTransmittable{"a.txt", 1, withMetaTags([]string{"fox"}, map[string]string{"author": "alice"})},
+				RecordTransmittable{"b.txt", 1, withMetaTags([]string{"fox"}, map[string]string{"author": "bob"})},
+				RecordTransmittable{"c.txt", 1, []string{"fox", "x=y"}},
+			)
+			for query, want := range map[string][]string{
+				"author:alice":     {"a.txt"},
+				"fox author!=bob":  {"a.txt", "c.txt"},
+				"x=y":              {"c.txt"},
+				"fox colour=green": {"a.txt", "b.txt", "c.txt"},
+			} {
+				got := []string{}
+				for _, r := range m.Search(query, 10) {
+					got = append(got, r.Filename)
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%q found %v, want %v", query, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefixSymbolsListsEveryValue(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFarm(t, mode, 1)
+			records := []RecordTransmittable{}
+			for i := 0; i < 300; i++ {
+				records = append(records, RecordTransmittable{"log.txt", i, withMetaTags([]string{"line"}, map[string]string{"seq": string(rune('a'+i%26)) + string(rune('a'+i/26))})})
+			}
+			storeRecords(t, f, records...)
+			s := f.siloFor("log.txt")
+			if got := len(s.metaSymbols([]string{"seq"})); got != 300 {
+				t.Errorf("metaSymbols found %v values, want 300", got)
+			}
+			if got := len(s.prefixSymbols(metaTagPrefix+"seq=", 5)); got != 5 {
+				t.Errorf("prefixSymbols with a limit of 5 found %v", got)
+			}
+			if !s.hasField("seq") || s.hasField("se") {
+				t.Errorf("hasField(seq) = %v, hasField(se) = %v", s.hasField("seq"), s.hasField("se"))
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// offload.go
+
+//Tiered storage.  A memory farm with Offload set holds the newest records of its index, so they can be searched as soon as
+//they arrive, and a mover copies them to the index's disk farms in the background.
+//
+//Each memory silo takes records until it holds Size of them.  It is then full: new records go to the farm's other silos,
+//and the mover sends each of its records to the disk silo that the record's file routes to, waits until the disk silo holds
+//it, and only then removes it from memory.  The emptied silo is closed, its checkpoint deleted, and a new empty silo takes
+//its place.  At most Silos silos take records at a time, plus one that is being emptied, so the farm's memory stays
+//bounded.  If every silo is full, inserts wait for the mover.
+//
+//While a record is in both farms, searches return it once, as the manor merges duplicate results.
+
+package tagbrowser
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// How often the mover looks for full silos
+const offloadInterval = time.Second
+
+// The records a memory silo takes before it is emptied, if the farm has no Size
+const defaultOffloadSize = 100000
+
+// The number of records that fills a silo of the farm
+func (f *Farm) siloSize() int {
+	if f.maxRecords > 0 {
+		return f.maxRecords
+	}
+	return defaultOffloadSize
+}
+
+// The number of silos that take records.  Older configs set Silos to 0 for offloading farms, which means 1
+func (f *Farm) memorySilos() int {
+	if f.maxSilos < 1 {
+		return 1
+	}
+	return f.maxSilos
+}
+
+// Stop routing new records to the silos that are full, and add empty silos to take their place, up to Silos taking records
+// and one more being emptied.  Returns the full silos, oldest first
+func (f *Farm) fullSilos() []*tagSilo {
+	f.siloLock.Lock()
+	full := []*tagSilo{}
+	taking := 0
+	for _, s := range f.silos {
+		if records, _ := s.sizes(); !s.draining && records > f.siloSize() {
+			s.draining = true
+		}
+		if s.draining {
+			full = append(full, s)
+		} else {
+			taking++
+		}
+	}
+	add := f.memorySilos() - taking
+	if spare := f.memorySilos() + 1 - len(f.silos); spare < add {
+		add = spare
+	}
+	f.siloLock.Unlock()
+
+	for i := 0; i < add; i++ {
+		aSilo, err := f.newSilo(f.nextSiloID(), 10)
+		if err != nil {
+			log.Printf("Could not add a memory silo to %v: %v", f.location, err)
+			break
+		}
+		f.siloLock.Lock()
+		f.silos = append(f.silos, aSilo)
+		f.siloLock.Unlock()
+		log.Printf("Added memory silo %v to %v", aSilo.id, f.location)
+	}
+	return full
+}
+
+// The disk farms of an index, which take the records that memory farms offload
+func (m *Manor) diskFarms(idx *index) []*Farm {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	farms := []*Farm{}
+	for _, f := range idx.farms {
+		if f.permanent() {
+			farms = append(farms, f)
+		}
+	}
+	return farms
+}
+
+// The disk silo that stores filename, or nil if the index has no disk farms
+func (m *Manor) diskSiloFor(idx *index, filename string) *tagSilo {
+	if aFarm := routeFarm(m.diskFarms(idx), filename); aFarm != nil {
+		return aFarm.siloFor(filename)
+	}
+	return nil
+}
+
+// Empty the full silos of an offloading farm into the index's disk farms, until the server shuts down
+func (m *Manor) offloadMoverWorker(idx *index, f *Farm) {
+	defer f.workers.Done()
+	for !f.ShutdownStatus {
+		full := f.fullSilos()
+		if len(full) == 0 {
+			f.rest(offloadInterval)
+			continue
+		}
+		err := f.offloadSilo(full[0], func(filename string) *tagSilo {
+			return m.diskSiloFor(idx, filename)
+		})
+		if err == nil {
+			err = f.replaceSilo(full[0])
+		}
+		f.siloLock.Lock()
+		if err != nil {
+			f.offloadError = err.Error()
+		} else {
+			f.offloadError = ""
+		}
+		f.siloLock.Unlock()
+		if err != nil {
+			log.Printf("Offloading %v: %v", f.location, err)
+			f.rest(offloadInterval)
+		}
+	}
+}
+
+// Move every record of a full silo to the silos that route gives.  Records still queued for the silo are waited for
+func (f *Farm) offloadSilo(s *tagSilo, route func(filename string) *tagSilo) error {
+	after := 0
+	for {
+		last, moved, err := moveBatch(s, after, route)
+		f.siloLock.Lock()
+		f.offloadMoved = f.offloadMoved + moved
+		f.siloLock.Unlock()
+		if err != nil {
+			return err
+		}
+		if last != after {
+			after = last
+			continue
+		}
+		if len(s.InputRecordCh) == 0 {
+			break
+		}
+		time.Sleep(routeRetry)
+	}
+	if !s.isEmpty() {
+		return fmt.Errorf("silo %v still has records, the index %v has no disk farm to take them", s.id, f.index)
+	}
+	return nil
+}
+
+// True if the silo holds no records
+func (s *tagSilo) isEmpty() bool {
+	after := 0
+	for {
+		ids, records := s.recordsAfter(after, reshardBatch)
+		if len(ids) == 0 {
+			return true
+		}
+		for _, r := range records {
+			if r.Filename != 0 {
+				return false
+			}
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// Remove an emptied memory silo from the farm, and delete its checkpoint.  fullSilos adds a new silo in its place
+func (f *Farm) replaceSilo(s *tagSilo) error {
+	if !s.memory_db {
+		return errors.New("only memory silos are replaced")
+	}
+	f.siloLock.Lock()
+	kept := []*tagSilo{}
+	for _, aSilo := range f.silos {
+		if aSilo != s {
+			kept = append(kept, aSilo)
+		}
+	}
+	f.silos = kept
+	f.siloLock.Unlock()
+
+	//Wait for the searches that started before the silo was removed, and for its workers to stop
+	s.useLock.Lock()
+	err := s.close()
+	s.useLock.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{s.filename + ".checkpoint", s.filename + ".checkpoint.bak"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	f.siloLock.Lock()
+	f.offloadEmptied++
+	f.siloLock.Unlock()
+	log.Printf("Emptied memory silo %v of %v", s.id, f.location)
+	return nil
+}
+
+// Offload progress, for the farm's status
+func (f *Farm) offloadStatus(stats map[string]string) {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	full := 0
+	for _, s := range f.silos {
+		if s.draining {
+			full++
+		}
+	}
+	stats["offload.size"] = fmt.Sprintf("%v", f.siloSize())
+	stats["offload.full_silos"] = fmt.Sprintf("%v", full)
+	stats["offload.moved"] = fmt.Sprintf("%v", f.offloadMoved)
+	stats["offload.silos_emptied"] = fmt.Sprintf("%v", f.offloadEmptied)
+	if f.offloadError != "" {
+		stats["offload.error"] = f.offloadError
+	}
+}
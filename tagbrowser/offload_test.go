@@ -0,0 +1,40 @@
+// offload_test.go
+package tagbrowser
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestOffloadClosesReplacedSilos(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestManor(t, map[string]FarmConfig{
+		"memory": {Location: filepath.Join(dir, "memory"), Silos: 1, Mode: "memory", Offload: true, Size: 5},
+		"disk":   {Location: filepath.Join(dir, "disk"), Silos: 2, Mode: "disk"},
+	})
+	var memory *Farm
+	for _, f := range m.Farms {
+		if f.temporary {
+			memory = f
+		}
+	}
+	waitUntil(t, "the memory farm's first silo", func() bool { return len(memory.siloList()) > 0 })
+	first := memory.siloList()[0]
+	for i := 0; i < 20; i++ {
+		if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{"fox"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitUntil(t, "a silo to be emptied", func() bool { return memory.status()["offload.silos_emptied"] != "0" })
+	first.inputLock.RLock()
+	closed := first.inputClosed
+	first.inputLock.RUnlock()
+	if !closed {
+		t.Error("the emptied silo was not closed")
+	}
+	if first.submit(RecordTransmittable{"late.txt", 1, []string{"fox"}}) {
+		t.Error("the emptied silo took a record")
+	}
+	waitUntil(t, "every record to be found", func() bool { return len(m.Search("fox", 100)) == 20 })
+}
@@ -0,0 +1,237 @@
+// ranking.go
+
+//Ranking boosts.  With weights set in the [Ranking] section of tagdb.conf, a record's score is its text score (the number of
+//search words it matched), plus a recency boost for records with a recent mtime, plus a popularity boost for records that
+//clients report opening.  The boosts are added inside each silo, before the results are cut down to the limit.  Text
+//search scores are always in hundredths, with or without boosts, so a boost worth less than one search word still changes
+//the order, and results from servers with different ranking settings can be merged.
+//
+//Searches that only need the boosts use the silo's fast scan, rankDepth times deeper than the results wanted, and the
+//boosts reorder what it finds.
+//
+//Clients report opens with the RecordClick RPC.  The counts are kept in memory, and saved in a file next to tagdb.conf.
+//Only the maxClickedRecords most opened records are kept, so the counts cannot grow without limit.
+
+package tagbrowser
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// How often changed click counts are written to disk
+var clickSaveInterval = time.Minute
+
+// Ranked scores are the text score plus the boosts, multiplied by this and rounded
+const rankScale = 100
+
+// How many times deeper than the results wanted the fast scan is read, for the boosts to reorder
+const rankDepth = 4
+
+// The most records that click counts are kept for.  Past this, the least opened records are forgotten
+var maxClickedRecords = 100000
+
+type clickKey struct {
+	name string
+	line int
+}
+
+// One line of the clicks file
+type clickCount struct {
+	Name  string
+	Line  int
+	Count int
+}
+
+type clicksFileContents struct {
+	Clicks []clickCount
+}
+
+// Adds the recency and popularity boosts to text scores, and keeps the click counts
+type ranker struct {
+	settings rankingInfo
+	lock     sync.RWMutex
+	clicks   map[clickKey]int
+	file     string //Where the click counts are saved.  Empty to not save them
+	dirty    bool
+}
+
+func newRanker(settings rankingInfo) *ranker {
+	if settings.RecencyHalfLife <= 0 {
+		settings.RecencyHalfLife = 30
+	}
+	if settings.RecencyField == "" {
+		settings.RecencyField = "mtime"
+	}
+	return &ranker{settings: settings, clicks: map[clickKey]int{}}
+}
+
+// True if either boost is turned on
+func (r *ranker) enabled() bool {
+	return r != nil && (r.settings.RecencyWeight > 0 || r.settings.PopularityWeight > 0)
+}
+
+// True if the recency boost needs the records' metadata
+func (r *ranker) usesMetadata() bool {
+	return r != nil && r.settings.RecencyWeight > 0
+}
+
+// Count one open of a search result
+func (r *ranker) click(name string, line int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.clicks[clickKey{name, line}]++
+	r.dirty = true
+	r.evictClicks()
+}
+
+// Forget the least opened records, down to nine tenths of maxClickedRecords, once there are more than maxClickedRecords.
+// Evicting a tenth at a time keeps clicks on new records from sorting the counts every time.  The caller holds the lock
+func (r *ranker) evictClicks() {
+	if len(r.clicks) <= maxClickedRecords {
+		return
+	}
+	keys := make([]clickKey, 0, len(r.clicks))
+	for k := range r.clicks {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return r.clicks[keys[i]] < r.clicks[keys[j]]
+	})
+	for _, k := range keys[0 : len(keys)-maxClickedRecords*9/10] {
+		delete(r.clicks, k)
+	}
+	r.dirty = true
+}
+
+func (r *ranker) clickCount(name string, line int) int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.clicks[clickKey{name, line}]
+}
+
+// The points to add to a record's text score
+func (r *ranker) boost(name string, line int, meta map[string]string, now time.Time) float64 {
+	b := 0.0
+	if r.settings.RecencyWeight > 0 {
+		if t, ok := parseTime(meta[r.settings.RecencyField]); ok {
+			days := math.Max(now.Sub(t).Hours()/24, 0)
+			b += r.settings.RecencyWeight * math.Pow(0.5, days/r.settings.RecencyHalfLife)
+		}
+	}
+	if r.settings.PopularityWeight > 0 {
+		if n := r.clickCount(name, line); n > 0 {
+			b += r.settings.PopularityWeight * math.Log2(1+float64(n))
+		}
+	}
+	return b
+}
+
+// The text score plus the boosts, in hundredths.  Without boosts, just the text score in hundredths
+func (r *ranker) rankedScore(score int, name string, line int, meta map[string]string, now time.Time) int {
+	b := 0.0
+	if r.enabled() {
+		b = r.boost(name, line, meta, now)
+	}
+	return int(math.Round((float64(score) + b) * rankScale))
+}
+
+// Add the boosts to the results of the fast scan, and keep the best maxResults.  Without boosts, this just scales the scores
+func (s *tagSilo) rerank(results resultRecordCollection, q searchQuery, maxResults int) resultRecordCollection {
+	seen := map[int]metaSymbol{}
+	now := time.Now()
+	for i, r := range results {
+		var meta map[string]string
+		if q.rank.usesMetadata() {
+			meta = s.recordMetadata(record{Fingerprint: r.fingerprint}, seen)
+		}
+		results[i].score = q.rank.rankedScore(r.score, r.filename, r.line, meta, now)
+	}
+	sort.Stable(results)
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results
+}
+
+// Read the click counts saved in filename, and save them there from now on
+func (r *ranker) load(filename string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.file = filename
+	var saved clicksFileContents
+	if _, err := toml.DecodeFile(filename, &saved); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, c := range saved.Clicks {
+		r.clicks[clickKey{c.Name, c.Line}] += c.Count
+	}
+	r.evictClicks()
+	log.Printf("Loaded click counts for %v records from %v", len(saved.Clicks), filename)
+	return nil
+}
+
+// Write the click counts to the clicks file, if they have changed
+func (r *ranker) save() error {
+	r.lock.Lock()
+	if r.file == "" || !r.dirty {
+		r.lock.Unlock()
+		return nil
+	}
+	contents := clicksFileContents{}
+	for k, n := range r.clicks {
+		contents.Clicks = append(contents.Clicks, clickCount{k.name, k.line, n})
+	}
+	filename := r.file
+	r.dirty = false
+	r.lock.Unlock()
+
+	tmpName := filename + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# How often each search result has been opened, reported by clients with RecordClick")
+	if err := toml.NewEncoder(f).Encode(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// Save the click counts every clickSaveInterval, until stop is closed.  Manor.Shutdown saves them a last time
+func (r *ranker) saveWorker(stop chan struct{}) {
+	ticker := time.NewTicker(clickSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.save(); err != nil {
+				log.Println("Could not save click counts: ", err)
+			}
+		}
+	}
+}
+
+func (r *ranker) status(stats map[string]string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	stats["ranking.clicked_records"] = fmt.Sprintf("%v", len(r.clicks))
+	stats["ranking.recency_weight"] = fmt.Sprintf("%v", r.settings.RecencyWeight)
+	stats["ranking.popularity_weight"] = fmt.Sprintf("%v", r.settings.PopularityWeight)
+}
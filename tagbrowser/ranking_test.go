@@ -0,0 +1,93 @@
+// ranking_test.go
+package tagbrowser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmallBoostsChangeTheOrder(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 1)
+			m.rank = newRanker(rankingInfo{PopularityWeight: 0.3, RecencyWeight: 0.2})
+			recent := time.Now().UTC().Format(time.RFC3339)
+			storeRecords(t, m.Farms[0],
+				RecordTransmittable{"a.txt", 1, []string{"fox"}},
+				RecordTransmittable{"b.txt", 1, []string{"fox"}},
+				RecordTransmittable{"c.txt", 1, withMetaTags([]string{"fox"}, map[string]string{"mtime": recent})},
+				RecordTransmittable{"d.txt", 1, []string{"fox", "quick"}},
+			)
+			m.RecordClick("b.txt", 1)
+
+			res := m.Search("fox quick", 10)
+			want := []struct {
+				name  string
+				score string
+			}{{"d.txt", "200"}, {"b.txt", "130"}, {"c.txt", "120"}, {"a.txt", "100"}}
+			if len(res) != len(want) {
+				t.Fatalf("found %v results, want %v", len(res), len(want))
+			}
+			for i, w := range want {
+				if res[i].Filename != w.name || res[i].Score != w.score {
+					t.Errorf("result %v is %v scoring %v, want %v scoring %v", i, res[i].Filename, res[i].Score, w.name, w.score)
+				}
+			}
+		})
+	}
+}
+
+func TestRankedScoreWithoutBoosts(t *testing.T) {
+	r := newRanker(rankingInfo{})
+	if got := r.rankedScore(3, "a.txt", 1, nil, time.Now()); got != 300 {
+		t.Errorf("rankedScore without boosts = %v, want the text score in hundredths", got)
+	}
+}
+
+func TestScoresAreInHundredthsWithoutBoosts(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 1)
+			storeRecords(t, m.Farms[0],
+				RecordTransmittable{"a.txt", 1, []string{"fox"}},
+				RecordTransmittable{"d.txt", 1, []string{"fox", "quick"}},
+			)
+			res := m.Search("fox quick", 10)
+			if len(res) != 2 || res[0].Score != "200" || res[1].Score != "100" {
+				t.Errorf("found %+v, want d.txt scoring 200 and a.txt scoring 100", res)
+			}
+		})
+	}
+}
+
+func TestLeastOpenedRecordsAreForgotten(t *testing.T) {
+	defer func(n int) { maxClickedRecords = n }(maxClickedRecords)
+	maxClickedRecords = 10
+	r := newRanker(rankingInfo{})
+	for i := 0; i < 10; i++ {
+		r.click("popular.txt", i)
+		r.click("popular.txt", i)
+	}
+	r.click("once.txt", 1)
+	if n := len(r.clicks); n != 9 {
+		t.Errorf("kept click counts for %v records, want 9", n)
+	}
+	if r.clickCount("once.txt", 1) != 0 {
+		t.Error("kept the least opened record")
+	}
+}
+
+func TestSaveWorkerStops(t *testing.T) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		newRanker(rankingInfo{}).saveWorker(stop)
+		close(done)
+	}()
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("saveWorker did not stop")
+	}
+}
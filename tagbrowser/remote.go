@@ -0,0 +1,433 @@
+// remote.go
+
+//Remote farms.  A farm with Remote set keeps its records on another tagserver, so one server can coordinate several.
+//The coordinator routes inserts to remote farms by filename like any other farm, sends searches, deletes and predictions
+//to every farm, local and remote, and merges the results.
+//
+//Each call to a remote server has a timeout.  If a remote farm does not answer a search in time, the search returns the
+//results of the other farms, and names the missing farm in Reply.Partial.  Inserts are queued, and sent in batches, retrying
+//until the remote server takes them.  Each batch has a BatchID, and the remote server remembers the replies to recent
+//batches, so a batch that is sent again after a timeout is not stored twice.  If the remote server refuses a batch, the
+//records it did not store are sent again.  Records still queued when the farm shuts down get one more try, and the
+//shutdown fails if they could not be sent.
+//
+//Remote servers are called over JSON-RPC, like the client package does, with TLS if the farm asks for it.  The client
+//package is not used, as it imports this one.
+
+package tagbrowser
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for a remote server, if the farm has no Timeout
+const defaultRemoteTimeout = 5 * time.Second
+
+// The most records sent to a remote server in one call
+const remoteBatch = 100
+
+// How long to wait before sending a batch of records again
+const remoteRetry = time.Second
+
+// How many InsertRecords batches a server remembers the replies to
+const rememberedBatches = 10000
+
+// A farm whose records are kept by another tagserver
+type remoteFarm struct {
+	address   string
+	index     string //The index on the remote server
+	token     string //Token for the remote server.  It needs admin scope, so searches can be run for the coordinator's callers
+	timeout   time.Duration
+	tlsConfig *tls.Config              //nil to connect without TLS
+	recordCh  chan RecordTransmittable //Records waiting to be sent
+	inputLock sync.RWMutex             //Held to send to recordCh, and to close it
+	closed    bool                     //True once recordCh is closed
+	stop      chan struct{}            //Closed when the farm shuts down, so insertWorker stops retrying
+	done      chan struct{}            //Closed when insertWorker returns
+	stopOnce  sync.Once                //Runs shutdown
+	stopErr   error                    //What shutdown returned
+	batchIDs  string                   //Prefix of this farm's BatchIDs
+	batches   int64                    //Batches sent so far.  Only used by insertWorker
+	lock      sync.Mutex
+	conn      *rpc.Client
+	errors    int
+	lastError string
+	unsent    int //Records given up on at shutdown
+}
+
+// Replies to recent InsertRecords batches, by BatchID, so a batch that is sent again is not stored twice
+type batchLog struct {
+	lock    sync.Mutex
+	batches map[string]*sentBatch
+	order   []string //BatchIDs, oldest first
+}
+
+type sentBatch struct {
+	done  chan struct{} //Closed once reply is set
+	reply SuccessReply
+}
+
+// The farms that did not answer a search.  Shared by every copy of the search's query
+type missingFarms struct {
+	lock  sync.Mutex
+	farms []string
+}
+
+// Note that a farm did not answer.  Does nothing if m is nil
+func (m *missingFarms) add(farm string, err error) {
+	log.Printf("Farm %v did not answer: %v", farm, err)
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.farms = append(m.farms, farm)
+}
+
+// The farms that did not answer, sorted, or nil if they all did
+func (m *missingFarms) list() []string {
+	if m == nil {
+		return nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.farms) == 0 {
+		return nil
+	}
+	out := uniqStrings(m.farms)
+	sort.Strings(out)
+	return out
+}
+
+// Create a farm that keeps its records on the tagserver at settings.Remote
+func createRemoteFarm(settings serverInfo) (*Farm, error) {
+	config, err := remoteTLS(settings)
+	if err != nil {
+		return nil, err
+	}
+	r := &remoteFarm{address: settings.Remote, index: indexName(settings.RemoteIndex), token: settings.Token, tlsConfig: config}
+	r.timeout = time.Duration(settings.Timeout) * time.Millisecond
+	if r.timeout <= 0 {
+		r.timeout = defaultRemoteTimeout
+	}
+	r.recordCh = make(chan RecordTransmittable, 100)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	r.batchIDs = newLogID()
+	f := &Farm{remote: r, silos: []*tagSilo{}, routed: true, stop: make(chan struct{}), logStop: make(chan struct{})}
+	f.location = fmt.Sprintf("tagdb://%v/%v", r.address, r.index)
+	go r.insertWorker()
+	return f, nil
+}
+
+// Queue a record to send to the remote server.  Returns false once the farm has shut down
+func (r *remoteFarm) submit(rec RecordTransmittable) bool {
+	r.inputLock.RLock()
+	defer r.inputLock.RUnlock()
+	if r.closed {
+		return false
+	}
+	r.recordCh <- rec
+	return true
+}
+
+// Connect to the remote server, if not already connected
+func (r *remoteFarm) connect() (*rpc.Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conn != nil {
+		return r.conn, nil
+	}
+	dialer := &net.Dialer{Timeout: r.timeout}
+	var netConn net.Conn
+	var err error
+	if r.tlsConfig != nil {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", r.address, r.tlsConfig)
+	} else {
+		netConn, err = dialer.Dial("tcp", r.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.conn = jsonrpc.NewClient(netConn)
+	return r.conn, nil
+}
+
+// Close a broken connection, so the next call makes a new one
+func (r *remoteFarm) drop(conn *rpc.Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conn == conn {
+		r.conn = nil
+	}
+	conn.Close()
+}
+
+func (r *remoteFarm) failed(err error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errors++
+	r.lastError = err.Error()
+	return err
+}
+
+// Call a TagResponder method on the remote server, giving up after the farm's timeout
+func (r *remoteFarm) call(method string, args interface{}, reply interface{}) error {
+	conn, err := r.connect()
+	if err != nil {
+		return r.failed(err)
+	}
+	call := conn.Go("TagResponder."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			return nil
+		}
+		if _, ok := call.Error.(rpc.ServerError); !ok {
+			r.drop(conn)
+		}
+		return r.failed(call.Error)
+	case <-time.After(r.timeout):
+		r.drop(conn)
+		return r.failed(fmt.Errorf("%v did not answer %v within %v", r.address, method, r.timeout))
+	}
+}
+
+// The search string for a query, with its filters and sort put back
+func (q searchQuery) text() string {
+	words := []string{q.terms}
+	for _, f := range q.filters {
+		words = append(words, f.key+f.op+f.value)
+	}
+	return strings.TrimSpace(strings.Join(words, " "))
+}
+
+func (q searchQuery) sortText() string {
+	if q.sortDesc {
+		return "-" + q.sortKey
+	}
+	return q.sortKey
+}
+
+// The arguments to run q on the remote server, for v.  The farm's token is admin, so v's principals are sent with ViewAs
+func (r *remoteFarm) searchArgs(q searchQuery, maxResults int, v *viewer) *Args {
+	args := &Args{A: q.text(), Limit: maxResults, Index: r.index, Sort: q.sortText(), Match: q.match.String(), Token: r.token}
+	if q.vector != nil {
+		args.Mode = SearchModeVector
+		args.Vector = q.vector
+	}
+	if v != nil {
+		args.Restricted = true
+		args.ViewAs = v.principals
+	}
+	return args
+}
+
+func (r *remoteFarm) search(q searchQuery, maxResults int, v *viewer) ([]ResultRecordTransmittable, error) {
+	reply := &Reply{}
+	err := r.call("SearchString", r.searchArgs(q, maxResults, v), reply)
+	return reply.C, err
+}
+
+func (r *remoteFarm) groups(q searchQuery, maxGroups int, lines int, v *viewer) ([]ResultGroup, error) {
+	args := r.searchArgs(q, maxGroups, v)
+	args.GroupBy = GroupByFile
+	args.GroupLines = lines
+	reply := &Reply{}
+	err := r.call("SearchString", args, reply)
+	return reply.Groups, err
+}
+
+func (r *remoteFarm) similar(filename string, line int, maxResults int, v *viewer) ([]ResultRecordTransmittable, error) {
+	args := &SimilarArgs{Name: filename, Position: line, Limit: maxResults, Index: r.index, Token: r.token}
+	if v != nil {
+		args.Restricted = true
+		args.ViewAs = v.principals
+	}
+	reply := &Reply{}
+	err := r.call("SimilarRecords", args, reply)
+	return reply.C, err
+}
+
+func (r *remoteFarm) deleteRecords(filename string, line int, allLines bool) int {
+	reply := &DeleteReply{}
+	if err := r.call("DeleteRecord", &DeleteArgs{Name: filename, Position: line, AllLines: allLines, Index: r.index, Token: r.token}, reply); err != nil {
+		log.Printf("Could not delete %v from %v: %v", filename, r.address, err)
+	}
+	return reply.Deleted
+}
+
+func (r *remoteFarm) predictString(prefix string, maxResults int, v *viewer) []string {
+	args := &Args{A: prefix, Limit: maxResults, Index: r.index, Token: r.token}
+	if v != nil {
+		args.Restricted = true
+		args.ViewAs = v.principals
+	}
+	reply := &StringListReply{}
+	if err := r.call("PredictString", args, reply); err != nil {
+		log.Printf("Could not predict %v from %v: %v", prefix, r.address, err)
+	}
+	return reply.C
+}
+
+func (r *remoteFarm) status(stats map[string]string) {
+	reply := &StatusReply{}
+	err := r.call("Status", &Args{Token: r.token}, reply)
+	stats["remote"] = r.address
+	stats["remote.index"] = r.index
+	stats["remote.reachable"] = fmt.Sprintf("%v", err == nil)
+	if err == nil {
+		stats["remote.queued_records"] = reply.Answer[fmt.Sprintf("index.%v.queued_records", r.index)]
+	}
+	stats["remote.tls"] = fmt.Sprintf("%v", r.tlsConfig != nil)
+	stats["queued_records"] = fmt.Sprintf("%v", len(r.recordCh))
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stats["remote.errors"] = fmt.Sprintf("%v", r.errors)
+	stats["remote.unsent_records"] = fmt.Sprintf("%v", r.unsent)
+	if r.lastError != "" {
+		stats["remote.last_error"] = r.lastError
+	}
+}
+
+// Send queued records to the remote server in batches, until recordCh is closed.  Once a batch cannot be sent after
+// shutdown, the batches behind it are not tried
+func (r *remoteFarm) insertWorker() {
+	defer close(r.done)
+	failed := false
+	for first := range r.recordCh {
+		batch := []InsertArgs{{Name: first.Filename, Position: first.Line, Tags: first.Fingerprint, Index: r.index}}
+	fill:
+		for len(batch) < remoteBatch {
+			select {
+			case rec, ok := <-r.recordCh:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, InsertArgs{Name: rec.Filename, Position: rec.Line, Tags: rec.Fingerprint, Index: r.index})
+			default:
+				break fill
+			}
+		}
+		if failed {
+			r.giveUp(len(batch))
+			continue
+		}
+		failed = !r.send(batch)
+	}
+}
+
+// A new BatchID
+func (r *remoteFarm) batchID() string {
+	r.batches++
+	return fmt.Sprintf("%v-%v", r.batchIDs, r.batches)
+}
+
+// Send a batch, again and again until the remote server stores it.  A batch that times out is sent again with the same
+// BatchID, so it is not stored twice.  If the server refuses the batch, the records it did not store are sent again in a
+// new batch.  After shutdown, the batch gets one more try.  Returns false if it was not sent
+func (r *remoteFarm) send(batch []InsertArgs) bool {
+	id := r.batchID()
+	for {
+		reply := &SuccessReply{}
+		err := r.call("InsertRecords", &BatchInsertArgs{Records: batch, BatchID: id, Token: r.token}, reply)
+		if err == nil && reply.Success {
+			return true
+		}
+		if err == nil {
+			batch = batch[reply.Inserted:]
+			id = r.batchID()
+			err = r.failed(fmt.Errorf("%v refused %v records: %v", r.address, len(batch), reply.Reason))
+		}
+		select {
+		case <-r.stop:
+			r.giveUp(len(batch))
+			return false
+		default:
+		}
+		log.Printf("Could not send %v records to %v, retrying: %v", len(batch), r.address, err)
+		select {
+		case <-r.stop:
+		case <-time.After(remoteRetry):
+		}
+	}
+}
+
+// Count records that were not sent before shutdown
+func (r *remoteFarm) giveUp(n int) {
+	log.Printf("Shutting down, %v records for %v were not sent", n, r.address)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.unsent += n
+}
+
+// Stop taking records, send the queued ones, then close the connection.  Fails if some records could not be sent.
+// Shutting down again returns the same result
+func (r *remoteFarm) shutdown() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		r.inputLock.Lock()
+		r.closed = true
+		close(r.recordCh)
+		r.inputLock.Unlock()
+		<-r.done
+
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.conn != nil {
+			r.conn.Close()
+			r.conn = nil
+		}
+		if r.unsent > 0 {
+			r.stopErr = fmt.Errorf("%v records for %v were not sent: %v", r.unsent, r.address, r.lastError)
+		}
+	})
+	return r.stopErr
+}
+
+// Run insert for the batch with BatchID id, unless it has been run already.  Then wait for the first run to finish, and
+// copy its reply.  Batches without a BatchID are always run
+func (b *batchLog) once(id string, reply *SuccessReply, insert func()) {
+	if b == nil || id == "" {
+		insert()
+		return
+	}
+	b.lock.Lock()
+	if sent, ok := b.batches[id]; ok {
+		b.lock.Unlock()
+		<-sent.done
+		*reply = sent.reply
+		return
+	}
+	sent := &sentBatch{done: make(chan struct{})}
+	b.batches[id] = sent
+	b.order = append(b.order, id)
+	if len(b.order) > rememberedBatches {
+		delete(b.batches, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.lock.Unlock()
+	insert()
+	sent.reply = *reply
+	close(sent.done)
+}
+
+// The remote farms in a list
+func remoteFarms(farms []*Farm) []*Farm {
+	out := []*Farm{}
+	for _, f := range farms {
+		if f.remote != nil {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,192 @@
+// remote_test.go
+package tagbrowser
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serve m's JSON-RPC calls on a localhost port until the test finishes, with TLS if config is not nil.  Returns the address
+func serveTestManor(t *testing.T, m *Manor, config *tls.Config) string {
+	t.Helper()
+	server := rpc.NewServer()
+	server.Register(&TagResponder{Manor: m})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if config != nil {
+		l = tls.NewListener(l, config)
+	}
+	go serveJSONRPC(l, server)
+	return l.Addr().String()
+}
+
+// A self-signed certificate for 127.0.0.1, for servers and clients.  Returns the server's TLS settings, and a CA file that trusts them
+func testCertificate(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tagdb test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, caFile
+}
+
+// Store records through the coordinator m, and wait until its searches find them all
+func storeRemote(t *testing.T, m *Manor, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{"fox"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitUntil(t, "the records to reach the remote servers", func() bool { return len(m.Search("fox", 100)) == n })
+}
+
+func TestRemoteFarmsSpreadRecordsOverServers(t *testing.T) {
+	east, west := testManor(t, "memory", 1), testManor(t, "disk", 2)
+	m := openTestManor(t, map[string]FarmConfig{
+		"east": {Remote: serveTestManor(t, east, nil), Timeout: 2000},
+		"west": {Remote: serveTestManor(t, west, nil), Timeout: 2000},
+	})
+	storeRemote(t, m, 40)
+	if e, w := len(east.Search("fox", 100)), len(west.Search("fox", 100)); e == 0 || w == 0 || e+w != 40 {
+		t.Errorf("east has %v records and west %v, want 40 between them", e, w)
+	}
+
+	if n := m.DeleteRecords("file3.txt", 1, false); n != 1 {
+		t.Errorf("deleted %v records of file3.txt, want 1", n)
+	}
+	if n := len(m.Search("fox", 100)); n != 39 {
+		t.Errorf("found %v records after the delete, want 39", n)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestRemoteFarmOverTLS(t *testing.T) {
+	config, caFile := testCertificate(t)
+	remote := testManor(t, "memory", 1)
+	address := serveTestManor(t, remote, config)
+
+	m := openTestManor(t, map[string]FarmConfig{"remote": {Remote: address, CA: caFile, Timeout: 2000}})
+	storeRemote(t, m, 5)
+	if got := m.Farms[0].status()["remote.tls"]; got != "true" {
+		t.Errorf("remote.tls is %v, want true", got)
+	}
+
+	plain := openTestManor(t, map[string]FarmConfig{"remote": {Remote: address, Timeout: 2000}})
+	if got := plain.Farms[0].status()["remote.reachable"]; got != "false" {
+		t.Errorf("a farm without TLS could reach a TLS server, reachable is %v", got)
+	}
+
+	if _, err := NewManor(map[string]FarmConfig{"remote": {Remote: address, CA: filepath.Join(t.TempDir(), "missing.pem")}}); err == nil {
+		t.Error("a farm with a missing CA file was created")
+	}
+}
+
+func TestResentBatchIsStoredOnce(t *testing.T) {
+	m := testManor(t, "memory", 1)
+	tr := &TagResponder{Manor: m}
+	args := &BatchInsertArgs{BatchID: "coordinator-1", Records: []InsertArgs{{Name: "a.txt", Position: 1, Tags: []string{"fox"}}}}
+	for i := 0; i < 3; i++ {
+		reply := &SuccessReply{}
+		tr.InsertRecords(args, reply)
+		if !reply.Success || reply.Inserted != 1 {
+			t.Fatalf("InsertRecords replied %+v", reply)
+		}
+	}
+	s := m.Farms[0].siloFor("a.txt")
+	waitUntil(t, "a.txt", func() bool { return len(s.findRecords("a.txt", 1)) > 0 })
+	tr.InsertRecords(&BatchInsertArgs{BatchID: "coordinator-2", Records: args.Records}, &SuccessReply{})
+	waitUntil(t, "the second batch", func() bool { return len(s.findRecords("a.txt", 1)) == 2 })
+}
+
+func TestRefusedBatchesAreSentAgain(t *testing.T) {
+	remote := testManor(t, "memory", 1)
+	m := openTestManor(t, map[string]FarmConfig{"remote": {Remote: serveTestManor(t, remote, nil), RemoteIndex: "later", Timeout: 2000}})
+	for i := 0; i < 5; i++ {
+		if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{"fox"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitUntil(t, "the remote server to refuse the records", func() bool { return m.Farms[0].status()["remote.last_error"] != "" })
+
+	if err := remote.CreateIndex(CreateIndexArgs{Name: "later", Mode: "memory", Location: filepath.Join(t.TempDir(), "later")}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the refused records to be stored", func() bool {
+		res, _ := remote.streamFileDatabase("later", parseQuery("fox", "", nil), 10, nil, nil)
+		return len(res) == 5
+	})
+}
+
+func TestRemoteShutdownReportsUnsentRecords(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	m := openTestManor(t, map[string]FarmConfig{"remote": {Remote: address, Timeout: 500}})
+	if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{"a.txt", 1, []string{"fox"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "a failed send", func() bool { return m.Farms[0].status()["remote.errors"] != "0" })
+	start := time.Now()
+	err = m.Shutdown()
+	if err == nil || !strings.Contains(err.Error(), "1 records") {
+		t.Errorf("Shutdown returned %v, want 1 record not sent", err)
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("Shutdown took %v", took)
+	}
+	if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{"b.txt", 1, []string{"fox"}}); err == nil {
+		t.Error("a shut down remote farm took a record")
+	}
+}
@@ -0,0 +1,475 @@
+// replication.go
+
+//Replication.  A leader tagserver keeps a log of the inserts and deletes it accepts, and sends it, in order, to the
+//follower tagservers named in the [Replication] section of its config.  Followers apply the log in the same order, serve
+//searches, and refuse writes from clients, so one can take over if the leader is lost.
+//
+//Each entry of the log has a sequence number.  Followers answer each batch with the last number they have applied, and
+//the leader sends on from there, so a follower that was down catches up when it returns, as long as the leader still
+//holds the entries it missed.  Followers keep a log of the entries they apply, with the leader's numbers, so a follower
+//that is promoted carries on the same log, and the other followers can follow it without starting again.
+//
+//The log is kept in memory.  A leader that restarts starts a new log, and its followers carry on from the start of it.
+//
+//A follower saves its place in the leader's log, with the log's id, in the replication file next to its config, so one
+//that restarts carries on from where it stopped.  Before it applies a batch, it saves the last entry of the batch too.
+//Entries up to that one may have been applied before an unclean stop, so when the leader sends them again, inserts of
+//records that are already stored are skipped, and the records lost with the follower's queues are stored again.
+
+package tagbrowser
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	RoleLeader   = "leader"   //Takes writes from clients.  The default
+	RoleFollower = "follower" //Applies the leader's log, and refuses writes from clients
+)
+
+// The most entries sent to a follower in one call
+const replicationBatch = 500
+
+// How often the leader sends a batch, even an empty one, so followers know how far behind they are
+const replicationInterval = time.Second
+
+// The entries kept for followers that fall behind, if the config has no LogSize
+const defaultReplicationLog = 100000
+
+var errFollower = errors.New("this server is a read-only follower, send writes to the leader")
+
+type replicator struct {
+	order       sync.Mutex //Held while a write is applied and logged, so the log is in the order the writes were applied
+	lock        sync.Mutex //Guards the fields below, and the links' fields
+	follower    bool
+	logID       string
+	entries     []ReplicationEntry //The newest entries, oldest first
+	last        int64              //Sequence number of the newest entry.  For followers, the last entry applied
+	size        int
+	links       []*replicaLink
+	leaderLast  int64     //Followers: the newest entry of the leader, from its last batch
+	lastContact time.Time //Followers: when the leader last sent a batch
+	file        string    //Where the follower's place in the log is saved.  Empty to not save it
+	replayTo    int64     //Followers: entries up to this one may have been applied before a restart, see applyReplication
+}
+
+// The replication file
+type replicationFileContents struct {
+	Log      string //The leader's log id
+	Last     int64  //The last entry applied
+	Applying int64  //The last entry of the batch being applied.  The entries after Last, up to this one, may have been applied
+}
+
+// A follower that the leader sends its log to
+type replicaLink struct {
+	server      *remoteFarm //The follower, called like a remote farm
+	wake        chan bool   //Signalled when there are new entries
+	known       bool        //True once the follower has said how far it has got
+	applied     int64
+	lastContact time.Time
+	lastError   string
+}
+
+// Check the [Replication] section of the config
+func checkReplication(settings replicationInfo) error {
+	switch settings.Role {
+	case "", RoleLeader, RoleFollower:
+		return nil
+	}
+	return fmt.Errorf("unknown replication Role %q, use \"leader\" or \"follower\"", settings.Role)
+}
+
+func newLogID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start sending the log to the followers in settings, until stop is closed.  Nothing is sent while this server is a
+// follower
+func newReplicator(settings replicationInfo, stop chan struct{}) *replicator {
+	r := &replicator{follower: settings.Role == RoleFollower, logID: newLogID(), size: settings.LogSize}
+	if r.size < 1 {
+		r.size = defaultReplicationLog
+	}
+	timeout := time.Duration(settings.Timeout) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+	for _, address := range settings.Followers {
+		l := &replicaLink{server: &remoteFarm{address: address, token: settings.Token, timeout: timeout}, wake: make(chan bool, 1)}
+		r.links = append(r.links, l)
+		go r.sendWorker(l, stop)
+	}
+	return r
+}
+
+func (r *replicator) isFollower() bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.follower
+}
+
+// Apply a write from a client, and log it if it succeeds.  Followers refuse writes from clients.  A leader without
+// followers keeps no log, so its writes are applied at once, without waiting for each other
+func (r *replicator) write(e ReplicationEntry, apply func() error) error {
+	if r == nil || (len(r.links) == 0 && !r.isFollower()) {
+		return apply()
+	}
+	r.order.Lock()
+	defer r.order.Unlock()
+	if r.isFollower() {
+		return errFollower
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.links) == 0 {
+		return nil
+	}
+	e.Seq = r.last + 1
+	r.appendEntry(e)
+	for _, l := range r.links {
+		select {
+		case l.wake <- true:
+		default:
+		}
+	}
+	return nil
+}
+
+// Add an entry to the log, dropping the oldest if it is full.  The caller must hold lock
+func (r *replicator) appendEntry(e ReplicationEntry) {
+	r.entries = append(r.entries, e)
+	r.last = e.Seq
+	if len(r.entries) > r.size {
+		r.entries = r.entries[1:]
+	}
+}
+
+// Up to limit entries after applied.  The caller must hold lock
+func (r *replicator) entriesAfter(applied int64, limit int) ([]ReplicationEntry, error) {
+	first := r.last - int64(len(r.entries)) + 1
+	if applied > r.last {
+		return nil, fmt.Errorf("the follower has applied %v entries, more than the %v of this server, it must be rebuilt", applied, r.last)
+	}
+	if applied < first-1 {
+		return nil, fmt.Errorf("the follower needs entry %v, which is no longer in the log, it must be rebuilt from a copy of this server's silos", applied+1)
+	}
+	start := int(applied - first + 1)
+	end := start + limit
+	if end > len(r.entries) {
+		end = len(r.entries)
+	}
+	return append([]ReplicationEntry{}, r.entries[start:end]...), nil
+}
+
+// Send the log to a follower, a batch at a time, until stop is closed
+func (r *replicator) sendWorker(l *replicaLink, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if r.isFollower() {
+			select {
+			case <-stop:
+				return
+			case <-time.After(replicationInterval):
+			}
+			continue
+		}
+		sent, err := r.sendBatch(l)
+		r.lock.Lock()
+		if err != nil {
+			l.lastError = err.Error()
+		} else {
+			l.lastError = ""
+		}
+		r.lock.Unlock()
+		if err != nil {
+			log.Printf("Replicating to %v: %v", l.server.address, err)
+		}
+		if err != nil || sent == 0 {
+			select {
+			case <-stop:
+				return
+			case <-l.wake:
+			case <-time.After(replicationInterval):
+			}
+		}
+	}
+}
+
+// Send the follower the next entries it needs.  The first batch is empty, to find out how far the follower has got
+func (r *replicator) sendBatch(l *replicaLink) (int, error) {
+	r.lock.Lock()
+	args := &ReplicateArgs{Log: r.logID, Last: r.last, Token: l.server.token}
+	var err error
+	if l.known {
+		args.Entries, err = r.entriesAfter(l.applied, replicationBatch)
+	}
+	r.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	reply := &ReplicateReply{}
+	if err := l.server.call("Replicate", args, reply); err != nil {
+		return 0, err
+	}
+	r.lock.Lock()
+	l.known = true
+	l.applied = reply.Applied
+	l.lastContact = time.Now()
+	r.lock.Unlock()
+	if reply.Reason != "" {
+		return 0, errors.New(reply.Reason)
+	}
+	return len(args.Entries), nil
+}
+
+// Apply a batch of the leader's log, in order.  Returns the last entry applied
+func (m *Manor) applyReplication(args *ReplicateArgs) (int64, error) {
+	r := m.repl
+	if r == nil {
+		return 0, errors.New("replication is not set up")
+	}
+	if !r.isFollower() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return r.last, errors.New("this server is not a follower")
+	}
+	r.order.Lock()
+	defer r.order.Unlock()
+	r.lock.Lock()
+	if args.Log != r.logID {
+		log.Printf("Following the leader's log %v from the start", args.Log)
+		r.logID = args.Log
+		r.entries = nil
+		r.last = 0
+		r.replayTo = 0
+	}
+	r.leaderLast = args.Last
+	r.lastContact = time.Now()
+	applied := r.last
+	replayTo := r.replayTo
+	r.lock.Unlock()
+
+	if n := len(args.Entries); n > 0 && args.Entries[n-1].Seq > applied {
+		if err := r.save(args.Entries[n-1].Seq); err != nil {
+			return applied, fmt.Errorf("could not save the replication file: %v", err)
+		}
+	}
+	for _, e := range args.Entries {
+		if e.Seq <= applied {
+			continue
+		}
+		if e.Seq != applied+1 {
+			//A gap, the leader will send again from applied
+			break
+		}
+		if e.Seq <= replayTo && !e.Delete && e.Create == nil && m.holdsEntry(e) {
+			log.Printf("Entry %v was applied before the restart, skipping it", e.Seq)
+		} else if err := m.applyEntry(e); err != nil {
+			return applied, fmt.Errorf("could not apply entry %v: %v", e.Seq, err)
+		}
+		r.lock.Lock()
+		r.appendEntry(e)
+		r.lock.Unlock()
+		applied = e.Seq
+	}
+	return applied, nil
+}
+
+func (m *Manor) applyEntry(e ReplicationEntry) error {
+	if e.Create != nil {
+		if m.hasIndex(e.Index) {
+			//Made before a restart, or by hand in the follower's config
+			log.Printf("Index %v already exists, skipping entry %v", e.Index, e.Seq)
+			return nil
+		}
+		return m.createIndex(*e.Create)
+	}
+	if !e.Delete {
+		return m.storeRecord(e.Index, RecordTransmittable{e.Name, e.Position, e.Tags})
+	}
+	//Inserts are stored by the silos in the background, so let them finish, or the delete could miss them
+	m.waitForQueues(reshardTimeout)
+	_, err := m.removeRecords(e.Index, e.Name, e.Position, e.AllLines)
+	return err
+}
+
+// True if the index already stores the record of an insert entry
+func (m *Manor) holdsEntry(e ReplicationEntry) bool {
+	indexes, err := m.indexesFor(e.Index)
+	if err != nil {
+		return false
+	}
+	tags, _ := splitVectorTag(e.Tags)
+	rec := RecordTransmittable{e.Name, e.Position, tags}
+	for _, idx := range indexes {
+		for _, f := range m.farmsHolding(idx, e.Name) {
+			for _, s := range f.silosFor(e.Name) {
+				if s.holdsRecord(rec) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Read the follower's place in the log from filename, and save it there from now on.  Leaders start a new log, so
+// they only save their place, for when they are made followers
+func (r *replicator) load(filename string) error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.file = filename
+	var saved replicationFileContents
+	if _, err := toml.DecodeFile(filename, &saved); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if r.follower && saved.Log != "" {
+		r.logID = saved.Log
+		r.last = saved.Last
+		r.replayTo = saved.Applying
+		log.Printf("Following log %v from entry %v", r.logID, r.last)
+	}
+	return nil
+}
+
+// Write the place in the log to the replication file, with the last entry of the batch about to be applied.  Pass
+// the last entry applied if no batch is being applied
+func (r *replicator) save(applying int64) error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	filename := r.file
+	contents := replicationFileContents{Log: r.logID, Last: r.last, Applying: applying}
+	r.lock.Unlock()
+	if filename == "" {
+		return nil
+	}
+
+	tmpName := filename + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(f, "# The place of this server in the replication log")
+	if err := toml.NewEncoder(f).Encode(contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// Write the place in the log to the replication file, when no batch is being applied
+func (r *replicator) saveLast() error {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	last := r.last
+	r.lock.Unlock()
+	return r.save(last)
+}
+
+// Wait until every record sent to the farms has been stored, or timeout has passed
+func (m *Manor) waitForQueues(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m.indexLock.RLock()
+		queued := 0
+		for _, aFarm := range m.Farms {
+			queued = queued + aFarm.queued()
+		}
+		m.indexLock.RUnlock()
+		if queued == 0 {
+			return
+		}
+		time.Sleep(routeRetry)
+	}
+}
+
+// Make a follower the leader.  It takes writes from clients, and sends its log on to its own followers
+func (m *Manor) Promote() error {
+	r := m.repl
+	if r == nil {
+		return errors.New("replication is not set up")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.follower {
+		return errors.New("this server is already the leader")
+	}
+	r.follower = false
+	log.Printf("Promoted to leader, carrying on log %v from entry %v", r.logID, r.last)
+	return nil
+}
+
+// Replication progress, for the server's status.  Followers report how far behind the leader they are, and leaders
+// how far behind each follower is
+func (r *replicator) status(stats map[string]string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.follower && len(r.links) == 0 && r.last == 0 {
+		return
+	}
+	stats["replication.role"] = RoleLeader
+	if r.follower {
+		stats["replication.role"] = RoleFollower
+	}
+	stats["replication.log"] = r.logID
+	stats["replication.last"] = fmt.Sprintf("%v", r.last)
+	if r.follower {
+		lag := r.leaderLast - r.last
+		if lag < 0 {
+			lag = 0
+		}
+		stats["replication.leader_last"] = fmt.Sprintf("%v", r.leaderLast)
+		stats["replication.lag"] = fmt.Sprintf("%v", lag)
+		if !r.lastContact.IsZero() {
+			stats["replication.last_contact"] = r.lastContact.Format(time.RFC3339)
+		}
+	}
+	for _, l := range r.links {
+		prefix := "replication.follower." + l.server.address + "."
+		if l.known {
+			stats[prefix+"applied"] = fmt.Sprintf("%v", l.applied)
+			stats[prefix+"lag"] = fmt.Sprintf("%v", r.last-l.applied)
+			stats[prefix+"last_contact"] = l.lastContact.Format(time.RFC3339)
+		}
+		if l.lastError != "" {
+			stats[prefix+"error"] = l.lastError
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// replication_test.go
+package tagbrowser
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// A free localhost address, so a follower can be restarted on the same one
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// Open a follower with one disk farm in dir, loading its place in the log from dir
+func openFollower(dir string) (*Manor, error) {
+	m, err := openManor(tomlConfig{
+		Farms:       map[string]FarmConfig{"test": {Location: filepath.Join(dir, "farm"), Silos: 2, Mode: "disk"}},
+		Replication: replicationInfo{Role: RoleFollower},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.repl.load(filepath.Join(dir, "tagdb.conf.replication")); err != nil {
+		m.Shutdown()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Serve a follower's JSON-RPC calls at address until the returned function is called, which shuts it down
+func serveFollower(t *testing.T, dir, address string) func() {
+	t.Helper()
+	m, err := openFollower(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.Register(&TagResponder{Manor: m})
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveJSONRPC(l, server)
+	return func() {
+		l.Close()
+		if err := m.Shutdown(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// A leader that sends its log to address
+func testLeader(t *testing.T, address string) *Manor {
+	t.Helper()
+	m, err := openManor(tomlConfig{
+		Farms:       map[string]FarmConfig{"test": {Location: filepath.Join(t.TempDir(), "farm"), Silos: 1, Mode: "memory"}},
+		Replication: replicationInfo{Followers: []string{address}, Timeout: 2000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+	return m
+}
+
+// Insert records first to first+n-1 through the leader, and wait until the follower at address has applied them
+func replicate(t *testing.T, leader *Manor, address string, first, n int) {
+	t.Helper()
+	for i := first; i < first+n; i++ {
+		if err := leader.SubmitRecordTo(DefaultIndex, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{"fox"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := fmt.Sprintf("%v", first+n-1)
+	waitUntil(t, "the follower to apply entry "+want, func() bool {
+		return leader.Status()["replication.follower."+address+".applied"] == want
+	})
+}
+
+// Check that the follower's farm in dir stores each of the first n records once
+func checkFollowerRecords(t *testing.T, dir string, n int) {
+	t.Helper()
+	f := openTestFarm(t, filepath.Join(dir, "farm"), "disk", 2)
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf("file%v.txt", i)
+		if got := len(f.siloFor(name).findRecords(name, 1)); got != 1 {
+			t.Errorf("the follower has %v copies of %v, want 1", got, name)
+		}
+	}
+}
+
+func TestReplayedEntriesAreNotStoredTwice(t *testing.T) {
+	dir := t.TempDir()
+	entry := func(seq int64) ReplicationEntry {
+		return ReplicationEntry{Seq: seq, Name: fmt.Sprintf("file%v.txt", seq), Position: 1, Tags: []string{"fox"}}
+	}
+	m, err := openFollower(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.applyReplication(&ReplicateArgs{Log: "leader", Last: 3, Entries: []ReplicationEntry{entry(1), entry(2), entry(3)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	//As if the follower stopped after storing the batch of entries 2 and 3, but before saving that it had
+	saved := &replicator{file: filepath.Join(dir, "tagdb.conf.replication"), logID: "leader", last: 1}
+	if err := saved.save(3); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = openFollower(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.applyReplication(&ReplicateArgs{Log: "leader", Last: 4, Entries: []ReplicationEntry{entry(2), entry(3), entry(4)}})
+	if err != nil || applied != 4 {
+		t.Fatalf("applied %v: %v, want 4", applied, err)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	checkFollowerRecords(t, dir, 4)
+}
+
+func TestCreatedIndexesAreReplicated(t *testing.T) {
+	leader := testLeader(t, freeAddress(t))
+	create := CreateIndexArgs{Name: "logs", Location: filepath.Join(t.TempDir(), "logs"), Mode: "memory", Token: "secret"}
+	if err := leader.CreateIndex(create); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.SubmitRecordTo("logs", RecordTransmittable{"app.log", 1, []string{"fox"}}); err != nil {
+		t.Fatal(err)
+	}
+	leader.repl.lock.Lock()
+	entries := append([]ReplicationEntry{}, leader.repl.entries...)
+	leader.repl.lock.Unlock()
+	if len(entries) != 2 || entries[0].Create == nil || entries[0].Create.Token != "" || entries[1].Index != "logs" {
+		t.Fatalf("the leader logged %+v, want the index creation without its token, then the insert", entries)
+	}
+
+	follower, err := openFollower(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Shutdown()
+	if err := follower.CreateIndex(CreateIndexArgs{Name: "other"}); err != errFollower {
+		t.Errorf("a follower's CreateIndex returned %v, want errFollower", err)
+	}
+	if applied, err := follower.applyReplication(&ReplicateArgs{Log: "leader", Last: 2, Entries: entries}); err != nil || applied != 2 {
+		t.Fatalf("applied %v: %v, want 2", applied, err)
+	}
+	//Sent again, as to a follower that lost its place
+	if applied, err := follower.applyReplication(&ReplicateArgs{Log: "restarted", Last: 1, Entries: entries[:1]}); err != nil || applied != 1 {
+		t.Errorf("applying the creation of an index the follower has: applied %v: %v, want 1", applied, err)
+	}
+	waitUntil(t, "app.log on the follower", func() bool {
+		reply := &Reply{}
+		err := (&TagResponder{Manor: follower}).SearchString(&Args{A: "fox", Limit: 10, Index: "logs"}, reply)
+		return err == nil && len(reply.C) == 1
+	})
+}
+
+func TestLeadersWithoutFollowersKeepNoLog(t *testing.T) {
+	m := testManor(t, "memory", 1)
+	if err := m.SubmitRecordTo(DefaultIndex, RecordTransmittable{"a.txt", 1, []string{"fox"}}); err != nil {
+		t.Fatal(err)
+	}
+	if m.repl.last != 0 || len(m.repl.entries) != 0 {
+		t.Errorf("a leader without followers logged %v entries", len(m.repl.entries))
+	}
+}
+
+// Not a test.  TestFollowerProcessRestart runs the test binary again with this test, as a follower process that stops
+// when its stdin is closed
+func TestFollowerProcess(t *testing.T) {
+	dir, address := os.Getenv("TAGDB_FOLLOWER_DIR"), os.Getenv("TAGDB_FOLLOWER_ADDRESS")
+	if dir == "" {
+		t.Skip("only run by TestFollowerProcessRestart")
+	}
+	stop := serveFollower(t, dir, address)
+	fmt.Println("ready")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	stop()
+}
+
+// Start a follower process, and return a function that stops it
+func startFollowerProcess(t *testing.T, dir, address string) func() {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFollowerProcess$")
+	cmd.Env = append(os.Environ(), "TAGDB_FOLLOWER_DIR="+dir, "TAGDB_FOLLOWER_ADDRESS="+address)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() && lines.Text() != "ready" {
+	}
+	go func() {
+		for lines.Scan() {
+		}
+	}()
+	return func() {
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("follower process: %v", err)
+		}
+	}
+}
+
+func TestFollowerProcessRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts other processes")
+	}
+	dir, address := t.TempDir(), freeAddress(t)
+	stop := startFollowerProcess(t, dir, address)
+	leader := testLeader(t, address)
+	replicate(t, leader, address, 1, 10)
+	stop()
+
+	stop = startFollowerProcess(t, dir, address)
+	replicate(t, leader, address, 11, 5)
+	stop()
+	checkFollowerRecords(t, dir, 15)
+}
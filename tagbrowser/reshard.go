@@ -0,0 +1,395 @@
+// reshard.go
+
+//Resharding.  Silos can be added to a running disk farm, or drained and retired, with the AddSilos and DrainSilo RPCs.
+//
+//New records are routed to the new set of silos at once (see routing.go).  The records already stored are then moved in the
+//background: each one is sent to the silo it now routes to, and only deleted from its old silo once the new silo holds it,
+//so searches find every record throughout.  While a record is in both silos, the merge removes the duplicate.
+//
+//The silos of a disk farm are the ones named in the config, plus any silo files found in the farm's directory.  A drained
+//silo's file is renamed to .retired, so it is not opened again when the server restarts.
+
+package tagbrowser
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Records are moved this many at a time
+const reshardBatch = 500
+
+// How long to wait for a silo to store a batch of moved records before giving up
+const reshardTimeout = time.Minute
+
+const retiredSuffix = ".retired"
+
+// The ids of the silos of a farm: 0 to n-1, less the retired ones, plus any others found in location.  Disk silos are found
+// by their database files, and memory silos by their checkpoints
+func siloIDs(location string, n int, memory bool) []string {
+	ids := map[string]bool{}
+	for i := 0; i < n; i++ {
+		ids[strconv.Itoa(i)] = true
+	}
+	files, _ := filepath.Glob(filepath.Join(location, "tagSilo_*.tagdb*"))
+	for _, name := range files {
+		base := filepath.Base(name)
+		id := strings.TrimSuffix(strings.TrimPrefix(base, "tagSilo_"), ".tagdb")
+		if memory {
+			for _, suffix := range []string{".tagdb.checkpoint", ".tagdb.checkpoint.bak"} {
+				if strings.HasSuffix(base, suffix) {
+					ids[strings.TrimSuffix(strings.TrimPrefix(base, "tagSilo_"), suffix)] = true
+				}
+			}
+			continue
+		}
+		if strings.HasSuffix(base, ".tagdb") {
+			ids[id] = true
+			continue
+		}
+		if strings.HasSuffix(base, ".tagdb"+retiredSuffix) {
+			id = strings.TrimSuffix(id, ".tagdb"+retiredSuffix)
+			if _, err := os.Stat(filepath.Join(location, "tagSilo_"+id+".tagdb")); os.IsNotExist(err) {
+				delete(ids, id)
+			}
+		}
+	}
+	out := []string{}
+	for id := range ids {
+		out = append(out, id)
+	}
+	sortSiloIDs(out)
+	return out
+}
+
+// An id for a new silo, one more than the highest numbered silo of the farm
+func (f *Farm) nextSiloID() string {
+	next := 0
+	for _, s := range f.siloList() {
+		if n, err := strconv.Atoi(s.id); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// Sort silo ids by number, then by name
+func sortSiloIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		a, errA := strconv.Atoi(ids[i])
+		b, errB := strconv.Atoi(ids[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return ids[i] < ids[j]
+	})
+}
+
+// The farm of the named index at location.  An empty location is the index's only farm
+func (m *Manor) farmAt(indexes string, location string) (*Farm, error) {
+	farms, err := m.farmsFor(indexName(indexes))
+	if err != nil {
+		return nil, err
+	}
+	if location == "" {
+		if len(farms) != 1 {
+			return nil, fmt.Errorf("index %q has %v farms, name one with Farm", indexName(indexes), len(farms))
+		}
+		return farms[0], nil
+	}
+	for _, f := range farms {
+		if filepath.Clean(f.location) == filepath.Clean(location) {
+			return f, nil
+		}
+	}
+	return nil, notFound("no farm at %q in index %q", location, indexName(indexes))
+}
+
+// Check that the records of a farm can be moved, and mark it as resharding
+func (f *Farm) startReshard() error {
+	f.siloLock.Lock()
+	defer f.siloLock.Unlock()
+	if f.ShutdownStatus {
+		return errors.New("the farm is shut down")
+	}
+	if f.resharding {
+		return errors.New("the farm is already being resharded")
+	}
+	f.resharding = true
+	f.reshardMoved = 0
+	f.reshardError = ""
+	return nil
+}
+
+func (f *Farm) finishReshard(err error) {
+	f.siloLock.Lock()
+	defer f.siloLock.Unlock()
+	f.resharding = false
+	f.reshardFrom = nil
+	if err != nil {
+		f.reshardError = err.Error()
+		log.Printf("Resharding %v: %v", f.location, err)
+	}
+}
+
+// Add silos to a disk farm, and move the records that now route to them
+func (m *Manor) AddSilos(args SiloArgs) error {
+	if args.Silos < 1 {
+		return errors.New("Silos must be at least 1")
+	}
+	f, err := m.farmAt(args.Index, args.Farm)
+	if err != nil {
+		return err
+	}
+	if !f.permanent() {
+		return errors.New("only disk farms can be resharded")
+	}
+	if err := f.startReshard(); err != nil {
+		return err
+	}
+	old := f.siloList()
+	f.siloLock.Lock()
+	f.reshardFrom = old
+	f.siloLock.Unlock()
+	for i := 0; i < args.Silos; i++ {
+		aSilo, err := f.newSilo(f.nextSiloID(), 10)
+		if err != nil {
+			//The silos already added stay, and take their records when the farm is next resharded
+			f.finishReshard(err)
+			return err
+		}
+		f.siloLock.Lock()
+		f.silos = append(f.silos, aSilo)
+		f.siloLock.Unlock()
+		log.Printf("Added silo %v to %v", aSilo.id, f.location)
+	}
+	f.workers.Add(1)
+	go func() {
+		defer f.workers.Done()
+		f.finishReshard(f.moveRecords(old))
+	}()
+	return nil
+}
+
+// Move every record out of a silo of a disk farm, then close the silo and retire its file
+func (m *Manor) DrainSilo(args SiloArgs) error {
+	f, err := m.farmAt(args.Index, args.Farm)
+	if err != nil {
+		return err
+	}
+	if !f.permanent() {
+		return errors.New("only disk farms can be resharded")
+	}
+	if err := f.startReshard(); err != nil {
+		return err
+	}
+	f.siloLock.Lock()
+	var drained *tagSilo
+	for _, s := range f.silos {
+		if s.id == args.Silo {
+			drained = s
+		}
+	}
+	switch {
+	case drained == nil:
+		err = fmt.Errorf("no silo %q in %v", args.Silo, f.location)
+	case len(f.silos) < 2:
+		err = errors.New("cannot drain the farm's last silo")
+	default:
+		//No new records go to the silo from now on
+		drained.draining = true
+	}
+	f.siloLock.Unlock()
+	if err != nil {
+		f.finishReshard(nil)
+		return err
+	}
+
+	f.workers.Add(1)
+	go func() {
+		defer f.workers.Done()
+		if err := f.moveRecords([]*tagSilo{drained}); err != nil {
+			f.finishReshard(err)
+			return
+		}
+		f.finishReshard(f.retireSilo(drained))
+	}()
+	return nil
+}
+
+// Move the records of the silos that route to other silos, a batch at a time
+func (f *Farm) moveRecords(silos []*tagSilo) error {
+	return f.moveRecordsTo(silos, f.siloFor)
+}
+
+// Move the records of the silos to the silos that route gives for their files, a batch at a time
+func (f *Farm) moveRecordsTo(silos []*tagSilo, route func(filename string) *tagSilo) error {
+	for _, source := range silos {
+		after := 0
+		for {
+			if f.ShutdownStatus {
+				return errors.New("the farm was shut down")
+			}
+			last, moved, err := moveBatch(source, after, route)
+			f.siloLock.Lock()
+			f.reshardMoved = f.reshardMoved + moved
+			f.siloLock.Unlock()
+			if err != nil {
+				return err
+			}
+			if last == after {
+				break
+			}
+			after = last
+		}
+		log.Printf("Moved the records of silo %v in %v", source.id, f.location)
+	}
+	return nil
+}
+
+// Move the next batch of a silo's records, the ones with ids after after, to the silos that route gives for their files.
+// Records that route gives the source, or nil, for are left alone.  Each record is deleted from the source once its new
+// silo holds it.  Returns the last id read, which is after if there were no more records, and the number moved
+func moveBatch(source *tagSilo, after int, route func(filename string) *tagSilo) (int, int, error) {
+	movesLock.RLock()
+	defer movesLock.RUnlock()
+	ids, records := source.recordsAfter(after, reshardBatch)
+	if len(ids) == 0 {
+		return after, 0, nil
+	}
+	moving := []RecordTransmittable{}
+	vectors := [][]float32{}
+	targets := []*tagSilo{}
+	for _, r := range records {
+		if r.Filename == 0 {
+			continue
+		}
+		name := source.getString(r.Filename)
+		target := route(name)
+		if target == nil || target == source {
+			continue
+		}
+		tags := []string{}
+		for _, sym := range r.Fingerprint {
+			tags = append(tags, source.getString(sym))
+		}
+		moving = append(moving, RecordTransmittable{name, r.Line, tags})
+		vectors = append(vectors, source.vectorOf(name, r.Line))
+		targets = append(targets, target)
+	}
+	for i, r := range moving {
+		if !targets[i].submit(RecordTransmittable{r.Filename, r.Line, withVectorTag(r.Fingerprint, vectors[i])}) {
+			return ids[len(ids)-1], 0, fmt.Errorf("silo %v is closed, the rest of silo %v was not moved", targets[i].id, source.id)
+		}
+	}
+	for i, r := range moving {
+		if !targets[i].waitForRecord(r, reshardTimeout) {
+			return ids[len(ids)-1], i, fmt.Errorf("silo %v did not store %v line %v, the rest of silo %v was not moved", targets[i].id, r.Filename, r.Line, source.id)
+		}
+		source.deleteRecords(r.Filename, r.Line, false)
+	}
+	return ids[len(ids)-1], len(moving), nil
+}
+
+// Remove an empty silo from the farm, close it, and rename its file so it is not opened again
+func (f *Farm) retireSilo(s *tagSilo) error {
+	f.siloLock.Lock()
+	kept := []*tagSilo{}
+	for _, aSilo := range f.silos {
+		if aSilo != s {
+			kept = append(kept, aSilo)
+		}
+	}
+	f.silos = kept
+	f.siloLock.Unlock()
+
+	//Wait for the searches that started before the silo was removed
+	s.useLock.Lock()
+	err := s.close()
+	s.useLock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(s.filename, s.filename+retiredSuffix); err != nil {
+		return err
+	}
+	log.Printf("Retired silo %v of %v", s.id, f.location)
+	return nil
+}
+
+// Up to limit records with ids after after, and their ids, in id order
+func (s *tagSilo) recordsAfter(after int, limit int) ([]int, []record) {
+	if !s.memory_db {
+		return s.Store.RecordsAfter(s, after, limit)
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	ids := []int{}
+	records := []record{}
+	for i := after + 1; i < len(s.database) && len(ids) < limit; i++ {
+		ids = append(ids, i)
+		records = append(records, s.database[i])
+	}
+	return ids, records
+}
+
+// True if the silo holds a record for r's file and line with each of r's tags
+func (s *tagSilo) holdsRecord(r RecordTransmittable) bool {
+	tags := fingerPrint{}
+	for _, tag := range r.Fingerprint {
+		sym := s.lookupSymbol(tag)
+		if sym == 0 {
+			return false
+		}
+		tags = append(tags, sym)
+	}
+	for _, stored := range s.findRecords(r.Filename, r.Line) {
+		has := map[int]bool{}
+		for _, sym := range stored.Fingerprint {
+			has[sym] = true
+		}
+		all := true
+		for _, sym := range tags {
+			all = all && has[sym]
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait until the silo has stored r, or timeout has passed
+func (s *tagSilo) waitForRecord(r RecordTransmittable, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !s.holdsRecord(r) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(routeRetry)
+	}
+	return true
+}
+
+// Resharding progress, for the farm's status
+func (f *Farm) reshardStatus(stats map[string]string) {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	stats["reshard.running"] = fmt.Sprintf("%v", f.resharding)
+	stats["reshard.moved"] = fmt.Sprintf("%v", f.reshardMoved)
+	if f.reshardError != "" {
+		stats["reshard.error"] = f.reshardError
+	}
+	for _, s := range f.silos {
+		if s.draining {
+			stats[fmt.Sprintf("silo.%v.draining", s.id)] = "true"
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// reshard_test.go
+package tagbrowser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHoldsRecord(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFarm(t, mode, 1)
+			storeRecords(t, f, RecordTransmittable{"a.txt", 1, []string{"quick", "fox"}}, RecordTransmittable{"b.txt", 2, []string{"dog"}})
+			s := f.siloList()[0]
+			for _, c := range []struct {
+				r    RecordTransmittable
+				want bool
+			}{
+				{RecordTransmittable{"a.txt", 1, []string{"quick", "fox"}}, true},
+				{RecordTransmittable{"a.txt", 1, []string{"fox"}}, true},
+				{RecordTransmittable{"a.txt", 1, nil}, true},
+				{RecordTransmittable{"a.txt", 1, []string{"dog"}}, false},
+				{RecordTransmittable{"a.txt", 2, []string{"fox"}}, false},
+				{RecordTransmittable{"a.txt", 1, []string{"unknown"}}, false},
+				{RecordTransmittable{"c.txt", 1, nil}, false},
+			} {
+				if got := s.holdsRecord(c.r); got != c.want {
+					t.Errorf("holdsRecord(%v) = %v, want %v", c.r, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDrainSiloMovesRecordsAndRetiresIt(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	m := openTestManor(t, map[string]FarmConfig{"test": {Location: location, Silos: 2, Mode: "disk"}})
+	f := m.Farms[0]
+	records := []RecordTransmittable{}
+	for i := 0; i < 40; i++ {
+		records = append(records, RecordTransmittable{fmt.Sprintf("file%v.txt", i), 1, []string{"fox"}})
+	}
+	storeRecords(t, f, records...)
+
+	start := time.Now()
+	if err := m.DrainSilo(SiloArgs{Silo: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the silo to be retired", func() bool { return len(f.siloList()) == 1 && f.status()["reshard.running"] != "true" })
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("draining took %v", took)
+	}
+	if e := f.status()["reshard.error"]; e != "" {
+		t.Fatal(e)
+	}
+	if _, err := os.Stat(filepath.Join(location, "tagSilo_0.tagdb"+retiredSuffix)); err != nil {
+		t.Errorf("silo 0 was not retired: %v", err)
+	}
+	if n := len(m.Search("fox", 100)); n != len(records) {
+		t.Errorf("found %v records after draining, want %v", n, len(records))
+	}
+}
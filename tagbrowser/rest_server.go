@@ -0,0 +1,707 @@
+// rest_server.go
+
+//A plain HTTP/JSON interface to the same TagResponder methods that the JSON-RPC server uses, so that scripts can use curl.
+//The API is described by the OpenAPI document served at /api/openapi.json
+
+package tagbrowser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(val); err != nil {
+		log.Println("While writing JSON response: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	writeJSON(w, status, restError{reason})
+}
+
+// The status for an error from a search, prediction or similar-records call: 404 for an index, farm or record that does
+// not exist, 401 or 403 for a token that may not make the call, and 400 for anything else, which is a bad request
+func restStatus(err error) int {
+	var missing notFoundError
+	switch {
+	case errors.As(err, &missing):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNoToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, errPermissionDenied), errors.Is(err, errFollower):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// Read an integer query parameter, returning def if it is missing
+func queryInt(req *http.Request, name string, def int) (int, error) {
+	val := req.URL.Query().Get(name)
+	if val == "" {
+		return def, nil
+	}
+	return strconv.Atoi(val)
+}
+
+// Read a comma separated list of numbers, e.g. a query vector, returning nil if it is missing
+func queryFloats(req *http.Request, name string) ([]float32, error) {
+	val := req.URL.Query().Get(name)
+	if val == "" {
+		return nil, nil
+	}
+	out := []float32{}
+	for _, s := range strings.Split(val, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, float32(f))
+	}
+	return out, nil
+}
+
+// Add the REST API to mux
+func registerRestHandlers(mux *http.ServeMux, m *Manor) {
+	t := &TagResponder{Manor: m}
+
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Use GET")
+			return
+		}
+		limit, err := queryInt(req, "limit", 10)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "limit must be a number")
+			return
+		}
+		groupLines, err := queryInt(req, "group_lines", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "group_lines must be a number")
+			return
+		}
+		groupBy := req.URL.Query().Get("group_by")
+		if err := checkGroupBy(groupBy); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		mode := req.URL.Query().Get("mode")
+		if err := checkSearchMode(mode); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		match := req.URL.Query().Get("match")
+		if _, err := parseMatchMode(match); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		vector, err := queryFloats(req, "vector")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "vector must be a list of numbers, separated by commas")
+			return
+		}
+		if req.URL.Query().Get("q") == "" && vector == nil {
+			writeError(w, http.StatusBadRequest, "q is required, unless vector is given")
+			return
+		}
+		reply := &Reply{}
+		if err := t.SearchString(&Args{A: req.URL.Query().Get("q"), Limit: limit, Index: req.URL.Query().Get("index"), Sort: req.URL.Query().Get("sort"), GroupBy: groupBy, GroupLines: groupLines, Match: match, Mode: mode, Vector: vector, Token: bearerToken(req.Header.Get("Authorization"))}, reply); err != nil {
+			writeError(w, restStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/search/stream", func(w http.ResponseWriter, req *http.Request) {
+		restSearchStream(t, w, req)
+	})
+
+	mux.HandleFunc("/api/predict", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Use GET")
+			return
+		}
+		limit, err := queryInt(req, "limit", 10)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "limit must be a number")
+			return
+		}
+		if req.URL.Query().Get("q") == "" {
+			writeError(w, http.StatusBadRequest, "q is required")
+			return
+		}
+		reply := &StringListReply{}
+		if err := t.PredictString(&Args{A: req.URL.Query().Get("q"), Limit: limit, Index: req.URL.Query().Get("index"), Token: bearerToken(req.Header.Get("Authorization"))}, reply); err != nil {
+			writeError(w, restStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/records", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			restInsert(t, w, req)
+		case http.MethodDelete:
+			restDelete(t, w, req)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Use POST or DELETE")
+		}
+	})
+
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Use GET")
+			return
+		}
+		reply := &StatusReply{}
+		if err := t.Status(&Args{}, reply); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/indexes", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			names := []string{}
+			if m != nil {
+				names = m.IndexNames()
+			}
+			writeJSON(w, http.StatusOK, StringListReply{C: names})
+		case http.MethodPost:
+			defer req.Body.Close()
+			args := &CreateIndexArgs{}
+			if err := json.NewDecoder(req.Body).Decode(args); err != nil {
+				writeError(w, http.StatusBadRequest, "Could not decode index: "+err.Error())
+				return
+			}
+			reply := &SuccessReply{}
+			if err := t.CreateIndex(args, reply); err != nil {
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			if !reply.Success {
+				writeJSON(w, http.StatusBadRequest, reply)
+				return
+			}
+			writeJSON(w, http.StatusOK, reply)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Use GET or POST")
+		}
+	})
+
+	siloHandler := func(call func(*SiloArgs, *SuccessReply) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "Use POST")
+				return
+			}
+			defer req.Body.Close()
+			args := &SiloArgs{}
+			if err := json.NewDecoder(req.Body).Decode(args); err != nil {
+				writeError(w, http.StatusBadRequest, "Could not decode silo request: "+err.Error())
+				return
+			}
+			reply := &SuccessReply{}
+			if err := call(args, reply); err != nil {
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			if !reply.Success {
+				writeJSON(w, http.StatusBadRequest, reply)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, reply)
+		}
+	}
+	mux.HandleFunc("/api/silos", siloHandler(t.AddSilos))
+	mux.HandleFunc("/api/silos/drain", siloHandler(t.DrainSilo))
+	mux.HandleFunc("/api/silos/compact", siloHandler(t.Compact))
+	mux.HandleFunc("/api/silos/route", siloHandler(t.RouteRecords))
+
+	backupHandler := func(call func(*BackupArgs, *BackupReply) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, "Use POST")
+				return
+			}
+			defer req.Body.Close()
+			args := &BackupArgs{}
+			if err := json.NewDecoder(req.Body).Decode(args); err != nil {
+				writeError(w, http.StatusBadRequest, "Could not decode backup request: "+err.Error())
+				return
+			}
+			reply := &BackupReply{}
+			if err := call(args, reply); err != nil {
+				writeError(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			if !reply.Success {
+				writeJSON(w, http.StatusBadRequest, reply)
+				return
+			}
+			writeJSON(w, http.StatusOK, reply)
+		}
+	}
+	mux.HandleFunc("/api/backup", backupHandler(t.Backup))
+	mux.HandleFunc("/api/restore", backupHandler(t.Restore))
+
+	mux.HandleFunc("/api/promote", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Use POST")
+			return
+		}
+		reply := &SuccessReply{}
+		if err := t.Promote(&Args{}, reply); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if !reply.Success {
+			writeJSON(w, http.StatusBadRequest, reply)
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/similar", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Use GET")
+			return
+		}
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			writeError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		line, err := queryInt(req, "line", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "line must be a number")
+			return
+		}
+		limit, err := queryInt(req, "limit", 10)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "limit must be a number")
+			return
+		}
+		reply := &Reply{}
+		if err := t.SimilarRecords(&SimilarArgs{Name: name, Position: line, Limit: limit, Index: req.URL.Query().Get("index"), Token: bearerToken(req.Header.Get("Authorization"))}, reply); err != nil {
+			writeError(w, restStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/clicks", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Use POST")
+			return
+		}
+		defer req.Body.Close()
+		args := &ClickArgs{}
+		if err := json.NewDecoder(req.Body).Decode(args); err != nil {
+			writeError(w, http.StatusBadRequest, "Could not decode click: "+err.Error())
+			return
+		}
+		reply := &SuccessReply{}
+		if err := t.RecordClick(args, reply); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	})
+
+	mux.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, openAPISpec)
+	})
+}
+
+// Accepts either a single InsertArgs object, or a JSON array of them
+func restInsert(t *TagResponder, w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	records := []InsertArgs{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &records)
+	} else {
+		var single InsertArgs
+		err = json.Unmarshal(body, &single)
+		records = append(records, single)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Could not decode record: "+err.Error())
+		return
+	}
+
+	for _, r := range records {
+		if r.Name == "" {
+			writeError(w, http.StatusBadRequest, "Every record needs a Name")
+			return
+		}
+	}
+	reply := &SuccessReply{}
+	t.InsertRecords(&BatchInsertArgs{Records: records}, reply)
+	if !reply.Success {
+		writeJSON(w, http.StatusServiceUnavailable, reply)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// Deletes the record at ?name=&line=, or every record for name if line is missing
+func restDelete(t *TagResponder, w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	args := &DeleteArgs{Name: name, AllLines: true, Index: req.URL.Query().Get("index")}
+	if req.URL.Query().Get("line") != "" {
+		line, err := queryInt(req, "line", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "line must be a number")
+			return
+		}
+		args.Position = line
+		args.AllLines = false
+	}
+	reply := &DeleteReply{}
+	if err := t.DeleteRecord(args, reply); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "tagdb",
+    "description": "Search, predict, insert and delete records in a tagdb server",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/api/search": {
+      "get": {
+        "summary": "Search for records matching the query",
+        "parameters": [
+          {"name": "q", "in": "query", "schema": {"type": "string"}, "description": "Search terms.  Add - to the end of a word to exclude it.  Terms like mtime>2026-01-01 or author:alice filter on metadata, and sort:field or sort:-field sorts on it.  Required unless vector is given"},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 10}},
+          {"$ref": "#/components/parameters/Index"},
+          {"$ref": "#/components/parameters/Sort"},
+          {"name": "group_by", "in": "query", "schema": {"type": "string", "enum": ["file"]}, "description": "Return one group per file in Groups, with limit groups"},
+          {"name": "group_lines", "in": "query", "schema": {"type": "integer", "default": 3}, "description": "Lines to keep in each group"},
+          {"$ref": "#/components/parameters/Match"},
+          {"$ref": "#/components/parameters/Mode"},
+          {"$ref": "#/components/parameters/Vector"}
+        ],
+        "responses": {
+          "200": {"description": "Matching records", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SearchReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/search/stream": {
+      "get": {
+        "summary": "Search, streaming each farm's results as server-sent events",
+        "description": "Sends a 'results' event (SearchEvent) as each farm finishes, then one 'summary' event (SearchSummary) holding the merged results",
+        "parameters": [
+          {"name": "q", "in": "query", "schema": {"type": "string"}, "description": "Search terms, as for /api/search.  Required unless vector is given"},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 10}},
+          {"$ref": "#/components/parameters/Index"},
+          {"$ref": "#/components/parameters/Sort"},
+          {"$ref": "#/components/parameters/Match"},
+          {"$ref": "#/components/parameters/Mode"},
+          {"$ref": "#/components/parameters/Vector"}
+        ],
+        "responses": {
+          "200": {"description": "An event stream", "content": {"text/event-stream": {"schema": {"type": "string"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/similar": {
+      "get": {
+        "summary": "Find the records that share the most informative tags with a stored record",
+        "description": "Tags are weighted by how rare they are.  Score is the share of the record's weight that each result matched, as a percentage.  The record itself is left out",
+        "parameters": [
+          {"name": "name", "in": "query", "required": true, "schema": {"type": "string"}, "description": "The record's Filename"},
+          {"name": "line", "in": "query", "schema": {"type": "integer", "default": 0}, "description": "The record's Line"},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 10}},
+          {"$ref": "#/components/parameters/Index"}
+        ],
+        "responses": {
+          "200": {"description": "Similar records", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SearchReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/predict": {
+      "get": {
+        "summary": "Complete a partial word from the stored tags",
+        "parameters": [
+          {"name": "q", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 10}},
+          {"$ref": "#/components/parameters/Index"}
+        ],
+        "responses": {
+          "200": {"description": "Completions", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StringListReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/records": {
+      "post": {
+        "summary": "Insert one record, or an array of records",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"oneOf": [
+            {"$ref": "#/components/schemas/InsertArgs"},
+            {"type": "array", "items": {"$ref": "#/components/schemas/InsertArgs"}}
+          ]}}}
+        },
+        "responses": {
+          "200": {"description": "Records queued for storage", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "503": {"description": "Server is not accepting records.  The first Inserted records were stored", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      },
+      "delete": {
+        "summary": "Delete the record at a line, or every record for a name",
+        "parameters": [
+          {"name": "name", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "line", "in": "query", "schema": {"type": "integer"}, "description": "Only delete this line.  If missing, every record for name is deleted"},
+          {"$ref": "#/components/parameters/Index"}
+        ],
+        "responses": {
+          "200": {"description": "Number of records deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeleteReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/indexes": {
+      "get": {
+        "summary": "List the indexes",
+        "responses": {
+          "200": {"description": "Index names", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StringListReply"}}}}
+        }
+      },
+      "post": {
+        "summary": "Create an index with one farm.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreateIndexArgs"}}}},
+        "responses": {
+          "200": {"description": "Index created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The index could not be created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/silos": {
+      "post": {
+        "summary": "Add silos to a disk farm, and move records into them in the background.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiloArgs"}}}},
+        "responses": {
+          "202": {"description": "Silos added, records are being moved.  Progress is in the farm's reshard status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The silos could not be added", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/silos/drain": {
+      "post": {
+        "summary": "Move every record out of a silo of a disk farm in the background, then retire it.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiloArgs"}}}},
+        "responses": {
+          "202": {"description": "The silo is being drained.  Progress is in the farm's reshard status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The silo could not be drained", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/silos/compact": {
+      "post": {
+        "summary": "Compact the silos of a disk farm in the background: remove unreadable and copied records and unused strings, rebuild the tag index, and vacuum.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiloArgs"}}}},
+        "responses": {
+          "202": {"description": "The silos are being compacted.  Progress is in the farm's compact status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The farm could not be compacted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/silos/route": {
+      "post": {
+        "summary": "Move each record of a farm that held records before routing to the silo its file routes to, in the background, so deletes only look in that silo.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SiloArgs"}}}},
+        "responses": {
+          "202": {"description": "The records are being moved.  Progress is in the farm's reshard status, and routed is true once they are all moved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The records could not be moved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/promote": {
+      "post": {
+        "summary": "Make a replication follower the leader, so it takes writes.  Needs an admin token",
+        "responses": {
+          "200": {"description": "Promoted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"description": "The server is not a follower", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}}
+        }
+      }
+    },
+    "/api/backup": {
+      "post": {
+        "summary": "Write a snapshot of every farm to a new or empty directory on the server.  Writes wait while it is taken.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupArgs"}}}},
+        "responses": {
+          "200": {"description": "Backup written", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupReply"}}}},
+          "400": {"description": "The backup failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupReply"}}}}
+        }
+      }
+    },
+    "/api/restore": {
+      "post": {
+        "summary": "Check a backup in a directory on the server against its manifest, and replace the farms' silos with it.  Needs an admin token",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupArgs"}}}},
+        "responses": {
+          "200": {"description": "Backup restored", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupReply"}}}},
+          "400": {"description": "The backup is damaged, does not match the server's farms, or could not be restored", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackupReply"}}}}
+        }
+      }
+    },
+    "/api/clicks": {
+      "post": {
+        "summary": "Report that a user opened a search result, for the popularity boost",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ClickArgs"}}}},
+        "responses": {
+          "200": {"description": "Click counted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SuccessReply"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/status": {
+      "get": {
+        "summary": "Server statistics",
+        "responses": {
+          "200": {"description": "Statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StatusReply"}}}}
+        }
+      }
+    }
+  },
+  "security": [{"bearerAuth": []}],
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer", "description": "A token from the [Tokens] section of tagdb.conf.  Only needed when tokens are configured.  Searching needs read scope, inserting and deleting need write"}
+    },
+    "parameters": {
+      "Index": {"name": "index", "in": "query", "schema": {"type": "string"}, "description": "Index name.  Empty for the default index, 'a,b' for several indexes, '*' for every index"},
+      "Sort": {"name": "sort", "in": "query", "schema": {"type": "string"}, "description": "Metadata field to sort by, '-field' for largest first.  Default: best score first"},
+      "Match": {"name": "match", "in": "query", "schema": {"type": "string", "default": "any"}, "description": "'any' finds records with any of the words, 'all' only records with every word, and 'minimum_should_match=N' records with at least N of them"},
+      "Mode": {"name": "mode", "in": "query", "schema": {"type": "string", "enum": ["tags", "vector", "hybrid"], "default": "tags"}, "description": "'vector' returns the records with the nearest vectors, scored by cosine similarity in thousandths.  'hybrid' fuses the tag and vector results by reciprocal rank.  Streams do not take 'hybrid', and group_by does not work with it"},
+      "Vector": {"name": "vector", "in": "query", "schema": {"type": "string"}, "description": "The query's vector, as numbers separated by commas.  Default: the server's embedder applied to q"}
+    },
+    "responses": {
+      "Error": {"description": "The request failed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+      "InsertArgs": {
+        "type": "object",
+        "required": ["Name"],
+        "properties": {
+          "Name": {"type": "string", "description": "File name or URL"},
+          "Position": {"type": "integer", "description": "Line number, or -1 for the whole file"},
+          "Tags": {"type": "array", "items": {"type": "string"}},
+          "Principals": {"type": "array", "items": {"type": "string"}, "description": "If set, only tokens holding one of these principals can find the record"},
+          "Index": {"type": "string", "description": "Index to store the record in.  Empty for the default index"},
+          "Metadata": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Fields that queries can filter and sort on, e.g. mtime, size, author.  Dates are stored as RFC3339"},
+          "Vector": {"type": "array", "items": {"type": "number"}, "description": "A dense vector for vector searches.  Default: the server's embedder applied to Tags, if it has one"}
+        }
+      },
+      "ClickArgs": {
+        "type": "object",
+        "required": ["Name"],
+        "properties": {
+          "Name": {"type": "string", "description": "The result's Filename"},
+          "Position": {"type": "integer", "description": "The result's Line"}
+        }
+      },
+      "CreateIndexArgs": {
+        "type": "object",
+        "required": ["Name"],
+        "properties": {
+          "Name": {"type": "string"},
+          "Location": {"type": "string", "description": "Directory for the silos.  Default: database/<Name>"},
+          "Mode": {"type": "string", "enum": ["disk", "memory"], "default": "disk"},
+          "Silos": {"type": "integer", "default": 1}
+        }
+      },
+      "SiloArgs": {
+        "type": "object",
+        "properties": {
+          "Index": {"type": "string", "description": "Index holding the farm.  Default: the default index"},
+          "Farm": {"type": "string", "description": "Location of the farm.  Only needed if the index has several farms"},
+          "Silos": {"type": "integer", "description": "Silos to add, for /api/silos"},
+          "Silo": {"type": "string", "description": "Id of the silo to drain, for /api/silos/drain, or to compact, for /api/silos/compact.  Default for compact: every silo"}
+        }
+      },
+      "ResultRecord": {
+        "type": "object",
+        "properties": {
+          "Filename": {"type": "string"},
+          "Line": {"type": "string"},
+          "Fingerprint": {"type": "array", "items": {"type": "string"}},
+          "Sample": {"type": "string"},
+          "Score": {"type": "string"},
+          "Metadata": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      },
+      "SearchReply": {"type": "object", "properties": {"C": {"type": "array", "items": {"$ref": "#/components/schemas/ResultRecord"}}, "Groups": {"type": "array", "items": {"$ref": "#/components/schemas/ResultGroup"}}, "Partial": {"type": "array", "items": {"type": "string"}, "description": "Remote farms that did not answer in time, so their results are missing"}}},
+      "ResultGroup": {"type": "object", "properties": {"Filename": {"type": "string"}, "Hits": {"type": "integer", "description": "Number of matching lines in the file"}, "Score": {"type": "string"}, "Lines": {"type": "array", "items": {"$ref": "#/components/schemas/ResultRecord"}}}},
+      "SearchEvent": {"type": "object", "properties": {"Farm": {"type": "string"}, "C": {"type": "array", "items": {"$ref": "#/components/schemas/ResultRecord"}}}},
+      "SearchSummary": {"type": "object", "properties": {"Farms": {"type": "integer"}, "Total": {"type": "integer"}, "Milliseconds": {"type": "integer"}, "C": {"type": "array", "items": {"$ref": "#/components/schemas/ResultRecord"}}, "Partial": {"type": "array", "items": {"type": "string"}}}},
+      "BackupArgs": {"type": "object", "required": ["Dir"], "properties": {"Dir": {"type": "string", "description": "Directory on the server"}}},
+      "BackupReply": {
+        "type": "object",
+        "properties": {
+          "Success": {"type": "boolean"},
+          "Reason": {"type": "string"},
+          "Manifest": {
+            "type": "object",
+            "properties": {
+              "Version": {"type": "integer"},
+              "Created": {"type": "string", "format": "date-time"},
+              "ReplicationLog": {"type": "string"},
+              "ReplicationLast": {"type": "integer"},
+              "Farms": {"type": "array", "items": {"type": "object", "properties": {"Index": {"type": "string"}, "Location": {"type": "string"}, "Memory": {"type": "boolean"}, "Silos": {"type": "array", "items": {"type": "object", "properties": {"Id": {"type": "string"}, "File": {"type": "string"}, "Size": {"type": "integer"}, "SHA256": {"type": "string"}}}}}}},
+              "Skipped": {"type": "array", "items": {"type": "string"}}
+            }
+          }
+        }
+      },
+      "StringListReply": {"type": "object", "properties": {"C": {"type": "array", "items": {"type": "string"}}}},
+      "SuccessReply": {"type": "object", "properties": {"Success": {"type": "boolean"}, "Reason": {"type": "string"}, "Inserted": {"type": "integer", "description": "Records stored, for inserts"}}},
+      "DeleteReply": {"type": "object", "properties": {"Deleted": {"type": "integer"}}},
+      "StatusReply": {"type": "object", "properties": {"Answer": {"type": "object", "additionalProperties": {"type": "string"}}}}
+    }
+  }
+}
+`
@@ -0,0 +1,143 @@
+// rest_server_test.go
+package tagbrowser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serve m's REST API until the test finishes.  Returns the server's URL
+func serveTestREST(t *testing.T, m *Manor) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	registerRestHandlers(mux, m)
+	server := httptest.NewServer(authHandler(mux))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// Make a request, and decode the JSON reply into reply unless it is nil.  Returns the status
+func restCall(t *testing.T, method, url, body string, reply interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != nil {
+		if err := json.Unmarshal(data, reply); err != nil {
+			t.Fatalf("%v %v: %v in %s", method, url, err, data)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestRESTInsertSearchAndDelete(t *testing.T) {
+	url := serveTestREST(t, testManor(t, "memory", 1))
+
+	inserted := &SuccessReply{}
+	body := `[{"Name": "a.txt", "Position": 1, "Tags": ["quick", "fox"]}, {"Name": "a.txt", "Position": 2, "Tags": ["fox"]}, {"Name": "b.txt", "Position": 1, "Tags": ["dog"]}]`
+	if code := restCall(t, "POST", url+"/api/records", body, inserted); code != http.StatusOK || inserted.Inserted != 3 {
+		t.Fatalf("POST /api/records: status %v, reply %+v", code, inserted)
+	}
+	search := func(q string) int {
+		reply := &Reply{}
+		if code := restCall(t, "GET", url+"/api/search?q="+q, "", reply); code != http.StatusOK {
+			t.Fatalf("GET /api/search?q=%v: status %v", q, code)
+		}
+		return len(reply.C)
+	}
+	waitUntil(t, "the records", func() bool { return search("fox") == 2 && search("dog") == 1 })
+
+	predicted := &StringListReply{}
+	if code := restCall(t, "GET", url+"/api/predict?q=qu", "", predicted); code != http.StatusOK || len(predicted.C) != 1 || predicted.C[0] != "quick" {
+		t.Errorf("GET /api/predict?q=qu: status %v, reply %+v", code, predicted)
+	}
+
+	deleted := &DeleteReply{}
+	if code := restCall(t, "DELETE", url+"/api/records?name=a.txt&line=2", "", deleted); code != http.StatusOK || deleted.Deleted != 1 {
+		t.Errorf("deleting a.txt line 2: status %v, deleted %v", code, deleted.Deleted)
+	}
+	if code := restCall(t, "DELETE", url+"/api/records?name=a.txt", "", deleted); code != http.StatusOK || deleted.Deleted != 1 {
+		t.Errorf("deleting the rest of a.txt: status %v, deleted %v", code, deleted.Deleted)
+	}
+	if n := search("fox"); n != 0 {
+		t.Errorf("found %v records of a.txt after deleting them", n)
+	}
+}
+
+func TestRESTRefusesBadRequests(t *testing.T) {
+	url := serveTestREST(t, testManor(t, "memory", 1))
+	for _, c := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/api/search?q=fox", "", http.StatusMethodNotAllowed},
+		{"GET", "/api/search?q=fox&limit=ten", "", http.StatusBadRequest},
+		{"GET", "/api/search?q=fox&group_by=author", "", http.StatusBadRequest},
+		{"GET", "/api/search?q=fox&index=missing", "", http.StatusNotFound},
+		{"GET", "/api/search", "", http.StatusBadRequest},
+		{"GET", "/api/search?q=fox&group_by=file&mode=hybrid", "", http.StatusBadRequest},
+		{"GET", "/api/search/stream", "", http.StatusBadRequest},
+		{"GET", "/api/predict", "", http.StatusBadRequest},
+		{"GET", "/api/predict?q=fo&index=missing", "", http.StatusNotFound},
+		{"GET", "/api/similar?name=missing.txt", "", http.StatusNotFound},
+		{"GET", "/api/similar?name=a.txt&index=missing", "", http.StatusNotFound},
+		{"POST", "/api/records", `{"Position": 1}`, http.StatusBadRequest},
+		{"POST", "/api/records", `not json`, http.StatusBadRequest},
+		{"DELETE", "/api/records", "", http.StatusBadRequest},
+		{"DELETE", "/api/records?name=a.txt&line=x", "", http.StatusBadRequest},
+		{"GET", "/api/records", "", http.StatusMethodNotAllowed},
+	} {
+		reply := &restError{}
+		if code := restCall(t, c.method, url+c.path, c.body, reply); code != c.want || reply.Error == "" {
+			t.Errorf("%v %v: status %v, error %q, want status %v and an error", c.method, c.path, code, reply.Error, c.want)
+		}
+	}
+}
+
+func TestRESTStatusOfErrors(t *testing.T) {
+	useTokens(t, testTokens)
+	for _, c := range []struct {
+		err  error
+		want int
+	}{
+		{notFound("unknown index %q", "missing"), http.StatusNotFound},
+		{fmt.Errorf("farm a: %w", notFound("no record for %v line %v", "a.txt", 1)), http.StatusNotFound},
+		{authorize("", scopeRead), http.StatusUnauthorized},
+		{authorize("read-secret", scopeAdmin), http.StatusForbidden},
+		{errFollower, http.StatusForbidden},
+		{checkGroupBy("author"), http.StatusBadRequest},
+	} {
+		if got := restStatus(c.err); got != c.want {
+			t.Errorf("restStatus(%q) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsJSON(t *testing.T) {
+	url := serveTestREST(t, testManor(t, "memory", 1))
+	doc := map[string]interface{}{}
+	if code := restCall(t, "GET", url+"/api/openapi.json", "", &doc); code != http.StatusOK {
+		t.Fatalf("status %v", code)
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, path := range []string{"/api/search", "/api/search/stream", "/api/records", "/api/silos/compact"} {
+		if paths[path] == nil {
+			t.Errorf("%v is not in the OpenAPI document", path)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// routing.go
+
+//Record routing.  Each record is sent to one farm of its index, and then to one silo of that farm, chosen by hashing its
+//filename.  Every line of a file, and every later update or delete of it, lands in the same silo.
+//
+//The choice uses rendezvous hashing: each farm (or silo) gets a weight from the hash of its name and the filename, and the
+//heaviest wins.  Adding a silo only moves the files that the new silo now wins, and the rest stay where they were.
+//
+//Deletes go to the same farm and silo, and to the places a file's older records may still be: the disk farm they are
+//offloaded to, silos being emptied, and while silos are added, the silo the file routed to before.  Farms that held
+//records before records were routed may have a file's records in any silo, so deletes look in every silo of them, until
+//the RouteRecords migration has moved each record to its silo.  A farm whose records are all routed has a "routed" file in
+//its directory.  New farms are marked when they are created.
+
+package tagbrowser
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The file that marks a farm whose records are all in the silos their files route to
+const routedMarker = "routed"
+
+// How long to wait before looking again for a farm or silo to store a record in
+const routeRetry = time.Millisecond * 100
+
+// The weight of node for key.  The highest weight wins
+func routeWeight(node, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(node))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	//fnv mixes the last bytes poorly, so finish with a mixer (from splitmix64)
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// The position in nodes of the node that key is routed to, or -1 if there are no nodes
+func pickNode(key string, nodes []string) int {
+	best := -1
+	var bestWeight uint64
+	for i, node := range nodes {
+		w := routeWeight(node, key)
+		if best < 0 || w > bestWeight || (w == bestWeight && node < nodes[best]) {
+			best = i
+			bestWeight = w
+		}
+	}
+	return best
+}
+
+// The farm of the list that filename is routed to, or nil if the list is empty
+func routeFarm(farms []*Farm, filename string) *Farm {
+	names := make([]string, len(farms))
+	for i, f := range farms {
+		names[i] = f.location
+	}
+	if i := pickNode(filename, names); i >= 0 {
+		return farms[i]
+	}
+	return nil
+}
+
+// The farms of an index that take new records: the offloading memory farms if there are any, so new records are searchable
+// at once, otherwise every farm
+func intakeFarms(farms []*Farm) []*Farm {
+	memory := []*Farm{}
+	for _, f := range farms {
+		if f.temporary && f.memory_only {
+			memory = append(memory, f)
+		}
+	}
+	if len(memory) > 0 {
+		return memory
+	}
+	return farms
+}
+
+// The silo of the list that filename is routed to, or nil if there are none.  Silos being drained are skipped
+func routeSilo(silos []*tagSilo, filename string) *tagSilo {
+	taking := []*tagSilo{}
+	names := []string{}
+	for _, s := range silos {
+		if !s.draining {
+			taking = append(taking, s)
+			names = append(names, s.id)
+		}
+	}
+	if i := pickNode(filename, names); i >= 0 {
+		return taking[i]
+	}
+	return nil
+}
+
+// The silo of the farm that stores filename, or nil if the farm has no silos yet.  Silos being drained are skipped
+func (f *Farm) siloFor(filename string) *tagSilo {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	return routeSilo(f.silos, filename)
+}
+
+// The silos of the farm that may hold records of filename: the silo it routes to, the silos being emptied, and while
+// silos are added, the silo it routed to before.  Every silo, if the farm has records stored before routing
+func (f *Farm) silosFor(filename string) []*tagSilo {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	if !f.routed {
+		return append([]*tagSilo{}, f.silos...)
+	}
+	out := []*tagSilo{}
+	add := func(s *tagSilo) {
+		for _, have := range out {
+			if have == s {
+				return
+			}
+		}
+		if s != nil {
+			out = append(out, s)
+		}
+	}
+	add(routeSilo(f.silos, filename))
+	add(routeSilo(f.reshardFrom, filename))
+	for _, s := range f.silos {
+		if s.draining {
+			add(s)
+		}
+	}
+	return out
+}
+
+// True if every record of the farm is in the silo its file routes to
+func (f *Farm) isRouted() bool {
+	f.siloLock.RLock()
+	defer f.siloLock.RUnlock()
+	return f.routed
+}
+
+// True if the farm at location is marked as routed, or is new.  New farms are marked
+func farmRouted(location string, memory bool) bool {
+	marker := filepath.Join(location, routedMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return true
+	}
+	if len(siloIDs(location, 0, memory)) > 0 {
+		return false
+	}
+	if err := os.WriteFile(marker, nil, 0666); err != nil {
+		log.Printf("Could not mark %v as routed, deletes will look in every silo: %v", location, err)
+		return false
+	}
+	return true
+}
+
+// The farms of an index that may hold records of filename: the farm that takes them, the disk farm they are offloaded
+// to, and every farm with records stored before routing
+func (m *Manor) farmsHolding(idx *index, filename string) []*Farm {
+	m.indexLock.RLock()
+	defer m.indexLock.RUnlock()
+	out := []*Farm{}
+	add := func(f *Farm) {
+		for _, have := range out {
+			if have == f {
+				return
+			}
+		}
+		if f != nil {
+			out = append(out, f)
+		}
+	}
+	intake := routeFarm(intakeFarms(idx.farms), filename)
+	add(intake)
+	if intake != nil && intake.temporary {
+		disk := []*Farm{}
+		for _, f := range idx.farms {
+			if f.permanent() {
+				disk = append(disk, f)
+			}
+		}
+		add(routeFarm(disk, filename))
+	}
+	for _, f := range idx.farms {
+		if !f.isRouted() {
+			add(f)
+		}
+	}
+	return out
+}
+
+// The silo that records of filename in the farm from belong in: the silo that new records of the file go to, or for disk
+// farms of an index that offloads, the disk silo they are offloaded to
+func (m *Manor) homeSilo(idx *index, from *Farm, filename string) *tagSilo {
+	m.indexLock.RLock()
+	target := routeFarm(intakeFarms(idx.farms), filename)
+	m.indexLock.RUnlock()
+	if from.permanent() && target != nil && target.temporary {
+		return m.diskSiloFor(idx, filename)
+	}
+	if target == nil || target.remote != nil {
+		return nil
+	}
+	return target.siloFor(filename)
+}
+
+// Migrate a farm that holds records stored before routing: move each record to the silo its file routes to, in the
+// background, then mark the farm as routed, so deletes only look in that silo
+func (m *Manor) RouteRecords(args SiloArgs) error {
+	f, err := m.farmAt(args.Index, args.Farm)
+	if err != nil {
+		return err
+	}
+	if f.remote != nil {
+		return errors.New("remote farms route their own records")
+	}
+	if f.isRouted() {
+		return nil
+	}
+	m.indexLock.RLock()
+	idx := m.indexes[indexName(args.Index)]
+	for _, other := range idx.farms {
+		if other.remote != nil {
+			m.indexLock.RUnlock()
+			return fmt.Errorf("index %q has a remote farm, and records cannot be moved to it", idx.name)
+		}
+	}
+	m.indexLock.RUnlock()
+	if err := f.startReshard(); err != nil {
+		return err
+	}
+	route := func(filename string) *tagSilo {
+		return m.homeSilo(idx, f, filename)
+	}
+	f.workers.Add(1)
+	go func() {
+		defer f.workers.Done()
+		err := f.moveRecordsTo(f.siloList(), route)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(f.location, routedMarker), nil, 0666)
+		}
+		if err == nil {
+			f.siloLock.Lock()
+			f.routed = true
+			f.siloLock.Unlock()
+			log.Printf("Every record of %v is in its silo", f.location)
+		}
+		f.finishReshard(err)
+	}()
+	return nil
+}
+
+// Wait until the farm has a silo for filename.  Farms that offload start without silos, and add them shortly after
+func (f *Farm) waitForSilo(filename string) *tagSilo {
+	for {
+		if aSilo := f.siloFor(filename); aSilo != nil || f.ShutdownStatus {
+			return aSilo
+		}
+		time.Sleep(routeRetry)
+	}
+}
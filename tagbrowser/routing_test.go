@@ -0,0 +1,91 @@
+// routing_test.go
+package tagbrowser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPickNodeOnlyMovesKeysToANewNode(t *testing.T) {
+	nodes := []string{"0", "1", "2"}
+	more := append(append([]string{}, nodes...), "3")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("file%v.txt", i)
+		before, after := nodes[pickNode(key, nodes)], more[pickNode(key, more)]
+		if before != after && after != "3" {
+			t.Fatalf("%v moved from silo %v to %v, not to the new silo", key, before, after)
+		}
+	}
+	if pickNode("a.txt", nil) != -1 {
+		t.Error("picked a node from an empty list")
+	}
+}
+
+// A silo of the farm that filename does not route to
+func otherSilo(f *Farm, filename string) *tagSilo {
+	for _, s := range f.siloList() {
+		if s != f.siloFor(filename) {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestDeletesFollowRouting(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "farm")
+			farms := map[string]FarmConfig{"test": {Location: location, Silos: 3, Mode: mode}}
+			m := openTestManor(t, farms)
+			f := m.Farms[0]
+			if !f.isRouted() {
+				t.Fatal("a new farm is not routed")
+			}
+			//A record stored before routing, in a silo its file does not route to
+			stray := otherSilo(f, "old.txt")
+			stray.submit(RecordTransmittable{"old.txt", 1, []string{"fox"}})
+			waitUntil(t, "old.txt", func() bool { return len(stray.findRecords("old.txt", 1)) > 0 })
+			if n := m.DeleteRecords("old.txt", 1, true); n != 0 {
+				t.Errorf("a routed farm deleted %v records outside the file's silo", n)
+			}
+
+			if err := m.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(filepath.Join(location, routedMarker)); err != nil {
+				t.Fatal(err)
+			}
+			m = openTestManor(t, farms)
+			f = m.Farms[0]
+			if f.isRouted() {
+				t.Fatal("a farm with silos but no routed file is routed")
+			}
+			storeRecords(t, f, RecordTransmittable{"new.txt", 1, []string{"dog"}})
+			if n := m.DeleteRecords("new.txt", 1, false); n != 1 {
+				t.Errorf("deleted %v records of new.txt, want 1", n)
+			}
+
+			if err := m.RouteRecords(SiloArgs{}); err != nil {
+				t.Fatal(err)
+			}
+			waitUntil(t, "the records to be routed", f.isRouted)
+			if _, err := os.Stat(filepath.Join(location, routedMarker)); err != nil {
+				t.Errorf("the farm was not marked: %v", err)
+			}
+			home := f.siloFor("old.txt")
+			if len(home.findRecords("old.txt", 1)) != 1 {
+				t.Error("old.txt was not moved to its silo")
+			}
+			for _, s := range f.siloList() {
+				if s != home && len(s.findRecords("old.txt", 1)) > 0 {
+					t.Errorf("old.txt is still in silo %v", s.id)
+				}
+			}
+			if n := m.DeleteRecords("old.txt", 1, true); n != 1 {
+				t.Errorf("deleted %v records of old.txt after routing, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// search_stream.go
+
+//Streaming searches send each farm's results as soon as they are ready, followed by a summary with the merged results.
+//They are served as server-sent events on /api/search/stream, and as the SearchStream gRPC call
+
+package tagbrowser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var errHybridStream = errors.New("hybrid searches cannot be streamed, as they are fused after every farm has finished")
+
+// Run a search, passing each farm's results to emit as they arrive
+func (t *TagResponder) searchStream(args *Args, emit func(SearchEvent)) (SearchSummary, error) {
+	start := time.Now()
+	summary := SearchSummary{}
+	if t.Manor == nil {
+		return summary, nil
+	}
+	log.Printf("Streaming query: '%v'", args.A)
+	if args.Mode == SearchModeHybrid {
+		return summary, errHybridStream
+	}
+	q, err := t.Manor.prepareQuery(args)
+	if err != nil {
+		return summary, err
+	}
+	q.missing = &missingFarms{}
+	summary.C, err = t.Manor.streamFileDatabase(args.Index, q, args.Limit, searchViewer(args.Token, args.Restricted, args.ViewAs), func(farm string, res []ResultRecordTransmittable) {
+		summary.Farms++
+		emit(SearchEvent{Farm: farm, C: res})
+	})
+	if err != nil {
+		return summary, err
+	}
+	summary.Total = len(summary.C)
+	summary.Partial = q.missing.list()
+	summary.Milliseconds = time.Since(start).Milliseconds()
+	log.Printf("Results: %d results for streaming query '%v'", summary.Total, args.A)
+	return summary, nil
+}
+
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, val interface{}) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		log.Println("While encoding event: ", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flusher.Flush()
+}
+
+// GET /api/search/stream?q=&limit= sends a "results" event per farm, then a "summary" event
+func restSearchStream(t *TagResponder, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Use GET")
+		return
+	}
+	limit, err := queryInt(req, "limit", 10)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "limit must be a number")
+		return
+	}
+	vector, err := queryFloats(req, "vector")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "vector must be a list of numbers, separated by commas")
+		return
+	}
+	if req.URL.Query().Get("q") == "" && vector == nil {
+		writeError(w, http.StatusBadRequest, "q is required, unless vector is given")
+		return
+	}
+	args := &Args{A: req.URL.Query().Get("q"), Limit: limit, Index: req.URL.Query().Get("index"), Sort: req.URL.Query().Get("sort"), Match: req.URL.Query().Get("match"), Mode: req.URL.Query().Get("mode"), Vector: vector, Token: bearerToken(req.Header.Get("Authorization"))}
+	if t.Manor != nil {
+		//Check the index and the query before the stream starts, while an error status can still be sent
+		if _, err := t.Manor.farmsFor(args.Index); err != nil {
+			writeError(w, restStatus(err), err.Error())
+			return
+		}
+		if args.Mode == SearchModeHybrid {
+			writeError(w, http.StatusBadRequest, errHybridStream.Error())
+			return
+		}
+		if _, err := t.Manor.prepareQuery(args); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported on this connection")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := req.Context()
+	summary, err := t.searchStream(args, func(ev SearchEvent) {
+		if ctx.Err() == nil {
+			writeEvent(w, flusher, "results", ev)
+		}
+	})
+	if err != nil {
+		writeEvent(w, flusher, "error", restError{err.Error()})
+		return
+	}
+	if ctx.Err() == nil {
+		writeEvent(w, flusher, "summary", summary)
+	}
+}
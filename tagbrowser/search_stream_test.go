@@ -0,0 +1,83 @@
+// search_stream_test.go
+package tagbrowser
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSearchStreamSendsEachFarmThenASummary(t *testing.T) {
+	dir := t.TempDir()
+	m := openTestManor(t, map[string]FarmConfig{
+		"a": {Location: filepath.Join(dir, "a"), Silos: 1, Mode: "memory"},
+		"b": {Location: filepath.Join(dir, "b"), Silos: 2, Mode: "disk"},
+	})
+	for _, f := range m.Farms {
+		storeRecords(t, f, RecordTransmittable{f.location + "/story.txt", 1, []string{"fox"}})
+	}
+	url := serveTestREST(t, m)
+
+	resp, err := http.Get(url + "/api/search/stream?q=fox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("status %v, content type %q", resp.StatusCode, ct)
+	}
+	events := []string{}
+	farms := map[string]int{}
+	summary := SearchSummary{}
+	event := ""
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+			events = append(events, event)
+		case strings.HasPrefix(line, "data: ") && event == "results":
+			ev := SearchEvent{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatal(err)
+			}
+			farms[ev.Farm] = len(ev.C)
+		case strings.HasPrefix(line, "data: ") && event == "summary":
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &summary); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if strings.Join(events, ",") != "results,results,summary" {
+		t.Errorf("events %v, want a results event for each farm and then a summary", events)
+	}
+	for _, f := range m.Farms {
+		if farms[f.location] != 1 {
+			t.Errorf("farm %v sent %v results, want 1", f.location, farms[f.location])
+		}
+	}
+	if summary.Farms != 2 || summary.Total != 2 || len(summary.C) != 2 {
+		t.Errorf("summary %+v, want 2 farms and 2 results", summary)
+	}
+}
+
+func TestSearchStreamRefusesBadSearchesBeforeStreaming(t *testing.T) {
+	url := serveTestREST(t, testManor(t, "memory", 1))
+	for _, c := range []struct {
+		path string
+		want int
+	}{
+		{"/api/search/stream?q=fox&mode=hybrid", http.StatusBadRequest},
+		{"/api/search/stream?q=fox&index=missing", http.StatusNotFound},
+		{"/api/search/stream?q=fox&limit=ten", http.StatusBadRequest},
+	} {
+		reply := &restError{}
+		if code := restCall(t, "GET", url+c.path, "", reply); code != c.want || reply.Error == "" {
+			t.Errorf("%v: status %v, error %q, want status %v and an error", c.path, code, reply.Error, c.want)
+		}
+	}
+}
@@ -5,8 +5,6 @@ import (
 
 	//"runtime/pprof"
 	//debugModule "runtime/debug"
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"log"
 	"os"
@@ -94,9 +92,17 @@ type SerialiseMe struct {
 	Offload_index int
 	Offloading    bool
 	MaxRecords    int
+
+	Vectors map[vectorKey][]float32 //The vector store.  See vector.go
+}
+
+// A record's file and line, which its vector is kept by
+type vectorKey struct {
+	Filename string
+	Line     int
 }
 
-func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, inputChan chan RecordTransmittable, dataDir string, permanentStoreCh chan RecordTransmittable, isTemporary bool, maxRecords int, checkpointMutex *sync.Mutex, logChans map[string]chan string) *tagSilo {
+func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, inputChan chan RecordTransmittable, dataDir string, permanentStoreCh chan RecordTransmittable, isTemporary bool, maxRecords int, checkpointMutex *sync.Mutex, logChans map[string]chan string) (*tagSilo, error) {
 
 	silo := &tagSilo{}
 	silo.LogChan = logChans
@@ -110,6 +116,7 @@ func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, in
 	silo.checkpointMutex = checkpointMutex
 	silo.recordCh = make(chan record, channel_buffer)
 	silo.InputRecordCh = inputChan
+	silo.stop = make(chan struct{})
 	silo.permanentStoreCh = permanentStoreCh
 	silo.temporary = isTemporary
 
@@ -135,61 +142,49 @@ func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, in
 		silo.reverse_string_table[0] = "An Error occurred, you should never have seen this"
 		silo.LogChan["file"] <- fmt.Sprintf("Creating memory silo")
 
-		var aBuff bytes.Buffer
-		silo.anotherbuffer = &aBuff
-		checkpointname := fmt.Sprintf("%v.checkpoint", silo.filename)
-		f, ferr := os.Open(checkpointname)
-		if ferr != nil {
-			checkpointname := fmt.Sprintf("%v.checkpoint.bak", silo.filename)
-			f, ferr = os.Open(checkpointname)
-		}
-		if ferr == nil {
-			silo.LogChan["file"] <- fmt.Sprintln(checkpointname, " exists, reading stored data")
-			defer f.Close()
-			silo.dec = gob.NewDecoder(f)
-			var d SerialiseMe
-			dec_err := silo.dec.Decode(&d)
-			if dec_err == nil {
-				silo.LogChan["file"] <- fmt.Sprintln("Successfully decoded checkpoint data")
-				silo.last_database_record = d.Last_database_record
-
-				silo.database = d.Database
-				//silo.counters = d.Counters
-				for k, v := range d.Counters {
-					silo.counters.Store(k, v)
-				}
-				silo.next_string_index = d.Next_string_index
-				silo.last_tag_record = d.Last_tag_record
-
-				//silo.string_table = d.String_table
-
-				silo.reverse_string_table = d.Reverse_string_table
-				silo.tag2file = d.Tag2file
-				silo.tag2record = d.Tag2record
-
-				//s.temporary     = d.Temporary
-				silo.offload_index = d.Offload_index
-				//s.offloading    = d.Offloading
-				//silo.maxRecords = d.MaxRecords
-
-				silo.dec = nil
-				silo.LogChan["file"] <- fmt.Sprintln("Recreating string table")
-
-				for i, v := range silo.reverse_string_table {
-					silo.string_table.Insert(patricia.Prefix(v), i)
-				}
-				silo.LogChan["file"] <- fmt.Sprintln("String table complete, ", len(silo.reverse_string_table), " entries,  ", silo.next_string_index, " strings, ", silo.last_tag_record, " tags")
-			} else {
-				silo.LogChan["error"] <- fmt.Sprintln("Error decoding: ", dec_err)
+		d := silo.loadCheckpoint()
+		if d != nil {
+			silo.LogChan["file"] <- fmt.Sprintln("Successfully decoded checkpoint data")
+			silo.last_database_record = d.Last_database_record
+
+			silo.database = d.Database
+			for k, v := range d.Counters {
+				silo.counters.Store(k, v)
+			}
+			silo.next_string_index = d.Next_string_index
+			silo.last_tag_record = d.Last_tag_record
+
+			silo.reverse_string_table = d.Reverse_string_table
+			silo.tag2file = d.Tag2file
+			silo.tag2record = d.Tag2record
+
+			//s.temporary     = d.Temporary
+			silo.offload_index = d.Offload_index
+			//s.offloading    = d.Offloading
+			//silo.maxRecords = d.MaxRecords
+
+			silo.LogChan["file"] <- fmt.Sprintln("Recreating string table")
+
+			for i, v := range silo.reverse_string_table {
+				silo.string_table.Insert(patricia.Prefix(v), i)
 			}
+			silo.LogChan["file"] <- fmt.Sprintln("String table complete, ", len(silo.reverse_string_table), " entries,  ", silo.next_string_index, " strings, ", silo.last_tag_record, " tags")
 		}
+		silo.loadVectors(d)
 	} else {
 
 		silo.LogChan["file"] <- fmt.Sprintf("Opening silo %v", silo.filename)
 
-		silo.Store = NewSQLStore(silo.filename)
+		store, err := NewSQLStore(silo.filename)
+		if err != nil {
+			return nil, err
+		}
 		//silo.Store = NewWeaviateStore(silo.filename)
-		silo.Store.Init(silo)
+		silo.Store = store
+		if err := silo.Store.Init(silo); err != nil {
+			silo.Store.Close(silo)
+			return nil, err
+		}
 		/*go func() {
 		      for {
 		          //pprof.Lookup("goroutine").WriteTo(os.Stdout, 1)
@@ -200,6 +195,7 @@ func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, in
 		*/
 
 		silo.LogChan["file"] <- fmt.Sprintf("Opened file %v", silo.filename)
+		silo.loadVectors(nil)
 
 	}
 	silo.Operational = true
@@ -207,15 +203,12 @@ func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, in
 	silo.threadsWait.Add(1)
 	go silo.storeRecordWorker()
 
-	if silo.temporary {
-		silo.threadsWait.Add(1)
-		go silo.offloadWorker()
-	}
+	//Silos of offloading farms are emptied by the farm's offload mover, see offload.go
 	if silo.memory_db {
 		silo.threadsWait.Add(1)
 		go silo.storeMemRecordWorker()
 		silo.threadsWait.Add(1)
-		go silo.checkpointWorker()
+		go silo.checkpointSaveWorker()
 
 	} else {
 		silo.threadsWait.Add(1)
@@ -233,7 +226,63 @@ func createSilo(memory bool, preAllocSize int, id string, channel_buffer int, in
 		log.Println("Create silo finished")
 	}
 
-	return silo
+	return silo, nil
+}
+
+// Queue a record for the silo to store.  Returns false if the silo has been closed
+func (s *tagSilo) submit(r RecordTransmittable) bool {
+	s.inputLock.RLock()
+	defer s.inputLock.RUnlock()
+	if s.inputClosed {
+		return false
+	}
+	var v []float32
+	if r.Fingerprint, v = splitVectorTag(r.Fingerprint); v != nil {
+		s.storeVector(r.Filename, r.Line, v)
+	}
+	s.InputRecordCh <- r
+	return true
+}
+
+// Stop taking records, wait for the queued ones to be stored and for the silo's workers to return, then write the silo
+// out and close its file.  Searches of the silo must have finished.  Closing a closed silo does nothing
+func (s *tagSilo) close() error {
+	if !s.halt() {
+		return nil
+	}
+	if s.memory_db {
+		return s.saveCheckpoint()
+	}
+	s.Checkpoint()
+	return s.Store.Close(s)
+}
+
+// Stop taking records, and wait for the queued ones to be stored and for the silo's workers to return, without writing
+// the silo out or closing its file.  Returns false if the silo was already stopped
+func (s *tagSilo) halt() bool {
+	s.inputLock.Lock()
+	if s.inputClosed {
+		s.inputLock.Unlock()
+		return false
+	}
+	s.inputClosed = true
+	s.ReadOnly = true
+	close(s.InputRecordCh)
+	s.inputLock.Unlock()
+
+	s.writeMutex.Lock()
+	s.Operational = false
+	s.writeMutex.Unlock()
+	close(s.stop)
+	s.threadsWait.Wait()
+	return true
+}
+
+// False once the silo has stopped
+func (s *tagSilo) isOperational() bool {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.Operational
 }
 
 func (tSilo *tagSilo) test() {
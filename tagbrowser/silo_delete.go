@@ -0,0 +1,80 @@
+// silo_delete.go
+package tagbrowser
+
+import (
+	"fmt"
+
+	"github.com/tchap/go-patricia/patricia"
+)
+
+// Find the symbol for a string, without creating a new one if it is missing.  Returns 0 (no symbol) if the string has never been seen
+func (s *tagSilo) lookupSymbol(aStr string) int {
+	if val, ok := s.symbol_cache.Load(aStr); ok {
+		return val
+	}
+	if s.memory_db {
+		s.trieMutex.Lock()
+		defer s.trieMutex.Unlock()
+		val := s.string_table.Get(patricia.Prefix(aStr))
+		if val == nil {
+			return 0
+		}
+		return val.(int)
+	}
+	return s.Store.GetSymbol(s, aStr)
+}
+
+// Remove the records for filename from the silo.  If allLines is false, only the record at line is removed.  Returns the number of records deleted
+func (s *tagSilo) deleteRecords(filename string, line int, allLines bool) int {
+	if s.ReadOnly {
+		return 0
+	}
+	s.deleteVectors(filename, line, allLines)
+	fileSym := s.lookupSymbol(filename)
+	if fileSym == 0 {
+		return 0
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if !s.memory_db {
+		deleted := s.Store.DeleteRecords(s, fileSym, line, allLines)
+		if deleted > 0 {
+			s.dirty = true
+		}
+		return deleted
+	}
+
+	matches := func(r *record) bool {
+		return r != nil && r.Filename == fileSym && (allLines || r.Line == line)
+	}
+
+	deleted := 0
+	for i := range s.database {
+		if !matches(&s.database[i]) {
+			continue
+		}
+		for _, tag := range s.database[i].Fingerprint {
+			if tag >= len(s.tag2file) {
+				continue
+			}
+			kept := s.tag2file[tag][:0]
+			for _, r := range s.tag2file[tag] {
+				if !matches(r) {
+					kept = append(kept, r)
+				}
+			}
+			s.tag2file[tag] = kept
+			s.tag_cache.Delete(tag)
+		}
+		//Leave a tombstone, record ids are positions in the database
+		s.database[i] = record{}
+		deleted++
+	}
+	if deleted > 0 {
+		s.dirty = true
+		s.LogChan["database"] <- fmt.Sprintf("Deleted %v records for %v from silo %v", deleted, filename, s.id)
+	}
+	return deleted
+}
@@ -0,0 +1,95 @@
+// silo_predict.go
+package tagbrowser
+
+import (
+	"github.com/tchap/go-patricia/patricia"
+)
+
+// How many more strings than asked for are read, when some of them may be hidden from the viewer
+const predictScan = 10
+
+// Up to limit strings from the silo's string table that start with prefix, and that v may see.  Viewers only see the
+// strings that are tags of records they can find.  A nil v sees every string
+func (s *tagSilo) predictFor(prefix string, limit int, v *viewer) []string {
+	filter := s.aclFilterFor(v)
+	if filter.restricted == 0 {
+		return s.predictString(prefix, limit)
+	}
+	out := []string{}
+	for _, str := range s.predictString(prefix, limit*predictScan) {
+		if len(out) >= limit {
+			break
+		}
+		if s.tagVisible(s.lookupSymbol(str), filter) {
+			out = append(out, str)
+		}
+	}
+	return out
+}
+
+// True if filter lets at least one record with the tag sym through
+func (s *tagSilo) tagVisible(sym int, filter aclFilter) bool {
+	if sym <= 0 {
+		return false
+	}
+	if s.memory_db {
+		s.writeMutex.Lock()
+		defer s.writeMutex.Unlock()
+		if sym >= len(s.tag2file) {
+			return false
+		}
+		for _, r := range s.tag2file[sym] {
+			if r != nil && r.Filename != 0 && filter.visible(*r) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range s.Store.GetRecordId(sym) {
+		if r := s.getRecord(id); r.Filename != 0 && filter.visible(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// The symbols of the strings that start with prefix, or the first limit of them if limit is above 0
+func (s *tagSilo) prefixSymbols(prefix string, limit int) []int {
+	if !s.memory_db {
+		return s.Store.SymbolsWithPrefix(s, prefix, limit)
+	}
+	out := []int{}
+	s.trieMutex.Lock()
+	defer s.trieMutex.Unlock()
+	s.string_table.VisitSubtree(patricia.Prefix(prefix), func(p patricia.Prefix, item patricia.Item) error {
+		if limit > 0 && len(out) >= limit {
+			return patricia.SkipSubtree
+		}
+		if sym, ok := item.(int); ok && sym > 0 {
+			out = append(out, sym)
+		}
+		return nil
+	})
+	return out
+}
+
+// Return up to limit strings from the silo's string table that start with prefix
+func (s *tagSilo) predictString(prefix string, limit int) []string {
+	if !s.memory_db {
+		return s.Store.PredictStrings(s, prefix, limit)
+	}
+
+	out := []string{}
+	s.trieMutex.Lock()
+	defer s.trieMutex.Unlock()
+	s.string_table.VisitSubtree(patricia.Prefix(prefix), func(p patricia.Prefix, item patricia.Item) error {
+		if len(out) >= limit {
+			return patricia.SkipSubtree
+		}
+		if sym, ok := item.(int); ok && sym > 0 {
+			out = append(out, string(p))
+		}
+		return nil
+	})
+	return out
+}
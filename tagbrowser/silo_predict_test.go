@@ -0,0 +1,58 @@
+// silo_predict_test.go
+package tagbrowser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPredictStringMatchesPrefixExactly(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFarm(t, mode, 1)
+			storeRecords(t, f, RecordTransmittable{"notes.txt", 1, []string{"50%off", "5000", "Apple", "apple", "a_b", "axb"}})
+			for prefix, want := range map[string][]string{
+				"50%": {"50%off"},
+				"5_":  {},
+				"app": {"apple"},
+				"App": {"Apple"},
+				"a_":  {"a_b"},
+			} {
+				got := f.predictString(prefix, 10, nil)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("predictString(%q) = %v, want %v", prefix, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPredictStringHidesRestrictedTags(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 1)
+			storeRecords(t, m.Farms[0],
+				RecordTransmittable{"public.txt", 1, []string{"salad", "shared"}},
+				RecordTransmittable{"secret.txt", 1, withACLTags([]string{"salary", "shared"}, []string{"user:alice"})},
+			)
+			for _, c := range []struct {
+				who  string
+				v    *viewer
+				want []string
+			}{
+				{"admin", nil, []string{"salad", "salary", "secret.txt", "shared"}},
+				{"alice", &viewer{principals: []string{"user:alice"}}, []string{"salad", "salary", "shared"}},
+				{"bob", &viewer{principals: []string{"user:bob"}}, []string{"salad", "shared"}},
+				{"anonymous", &viewer{}, []string{"salad", "shared"}},
+			} {
+				got, err := m.predictString(DefaultIndex, "s", 10, c.v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Errorf("%v: predictString = %v, want %v", c.who, got, c.want)
+				}
+			}
+		})
+	}
+}
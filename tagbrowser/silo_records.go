@@ -0,0 +1,72 @@
+// silo_records.go
+package tagbrowser
+
+//Adding records to a silo.
+//
+//A disk silo's records are turned into symbols and written while the silo's write lock is held, so compaction, which
+//holds the same lock, never removes a string between a record taking its id and the record being written
+
+import (
+	"strconv"
+)
+
+// Append a record to a memory silo's database, and add it to the posting lists of its tags
+func (s *tagSilo) storeMemRecord(line_elem record) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.database = append(s.database, line_elem)
+	s.last_database_record = len(s.database) - 1
+	stored := &s.database[len(s.database)-1]
+	for _, v := range line_elem.Fingerprint {
+		for len(s.tag2file) <= v {
+			s.tag2file = append(s.tag2file, []*record{})
+		}
+		s.tag2file[v] = append(s.tag2file[v], stored)
+		s.tag_cache.Delete(v)
+	}
+	s.dirty = true
+	s.count("memory_insert")
+}
+
+// Write a record to a disk silo
+func (s *tagSilo) storeFileRecord(aRecord RecordTransmittable) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	r := record{s.get_or_create_symbol(aRecord.Filename), aRecord.Line, s.makeFingerprint(aRecord.Fingerprint)}
+	s.last_database_record = s.last_database_record + 1
+	s.Store.InsertRecord(s, []byte(strconv.Itoa(s.last_database_record)), r)
+	s.Store.StoreTagToRecord(s.last_database_record, r.Fingerprint)
+	for _, v := range r.Fingerprint {
+		s.tag_cache.Delete(v)
+	}
+	s.dirty = true
+}
+
+// True if the silo has changed since it was last written
+func (s *tagSilo) isDirty() bool {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.dirty
+}
+
+func (s *tagSilo) setDirty(dirty bool) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.dirty = dirty
+}
+
+// The number of records and strings the silo has stored, for its status
+func (s *tagSilo) sizes() (int, int) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.last_database_record, s.next_string_index
+}
+
+// Write a disk silo's log into its file
+func (s *tagSilo) Checkpoint() {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.Store.Flush(s)
+	s.dirty = false
+	s.count("sql_commit")
+}
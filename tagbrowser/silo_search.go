@@ -0,0 +1,86 @@
+// silo_search.go
+package tagbrowser
+
+//Scoring records against a search, and the scan that answers plain searches
+
+import (
+	"sort"
+	"strings"
+)
+
+func (s *tagSilo) makeFingerprintFromSearch(aStr string) searchPrint {
+	return s.makeSearchPrint(strings.Fields(strings.ToLower(aStr)))
+}
+
+// The wanted and unwanted ("word-") symbols of a search.  Words the silo has never seen are left out
+func (s *tagSilo) makeSearchPrint(fragments []string) searchPrint {
+	frags := map[int]int{}
+	order := []int{}
+	for _, f := range fragments {
+		if len(f) > 1 && len(f) < maxTagLength {
+			key, rawScore := calcRawScore(f)
+			table_index := s.lookupSymbol(key)
+			if table_index == 0 {
+				continue
+			}
+			if _, ok := frags[table_index]; !ok {
+				order = append(order, table_index)
+			}
+			frags[table_index] = rawScore
+		} else {
+			Debugln("Rejected tag as too short or too long:", f)
+		}
+	}
+	searchP := searchPrint{}
+	for _, k := range order {
+		if frags[k] > 0 {
+			searchP.wanted = append(searchP.wanted, k)
+		} else {
+			searchP.unwanted = append(searchP.unwanted, k)
+		}
+	}
+	return searchP
+}
+
+// One point for each wanted tag the record has, minus one for each unwanted tag
+func (s *tagSilo) score(a searchPrint, b record) int {
+	score := 0
+	for _, vv := range b.Fingerprint {
+		for _, v := range a.wanted {
+			if v == vv {
+				score += 1
+			}
+		}
+		for _, v := range a.unwanted {
+			if v == vv {
+				score -= 1
+			}
+		}
+	}
+	return score
+}
+
+// The best maxResults records with a positive score, best first.  With exactMatch, only records with every wanted tag
+func (s *tagSilo) scanFileDatabase(aFing searchPrint, maxResults int, exactMatch bool) resultRecordCollection {
+	s.count("database_searches")
+	candidates := s.candidateRecords
+	if exactMatch {
+		candidates = s.intersectRecords
+	}
+	results := resultRecordCollection{}
+	for _, elem := range candidates(aFing.wanted) {
+		if elem.Filename == 0 {
+			continue
+		}
+		thisScore := s.score(aFing, elem)
+		if thisScore <= 0 || (exactMatch && thisScore != len(aFing.wanted)) {
+			continue
+		}
+		results = append(results, resultRecord{s.getString(elem.Filename), elem.Line, elem.Fingerprint, "", thisScore})
+	}
+	sort.Sort(results)
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results
+}
@@ -0,0 +1,86 @@
+// silo_symbols.go
+package tagbrowser
+
+//The silo's string table, which turns strings into the ids (symbols) that records hold
+
+import (
+	"log"
+	"strings"
+
+	"github.com/tchap/go-patricia/patricia"
+)
+
+// The string for a symbol, or "" if there is none
+func (s *tagSilo) getString(index int) string {
+	if s.memory_db {
+		s.trieMutex.Lock()
+		defer s.trieMutex.Unlock()
+		if index <= 0 || index >= len(s.reverse_string_table) {
+			return ""
+		}
+		return s.reverse_string_table[index]
+	}
+	if val, ok := s.string_cache.Load(index); ok {
+		s.count("string_cache_hit")
+		return val
+	}
+	return s.Store.GetString(s, index)
+}
+
+// The symbol for a string, creating it if the silo has not seen the string before.  The empty string is 0, "no symbol".
+// The caller must hold the write lock
+func (s *tagSilo) get_or_create_symbol(aStr string) int {
+	if aStr == "" {
+		log.Printf("Invalid insert!  Cannot insert empty string into symbol table of silo %v", s.id)
+		return 0
+	}
+	if val := s.lookupSymbol(aStr); val != 0 {
+		return val
+	}
+	if !s.memory_db {
+		s.next_string_index = s.next_string_index + 1
+		s.Store.InsertStringAndSymbol(s, aStr)
+		s.symbol_cache.Store(aStr, s.next_string_index)
+		s.string_cache.Store(s.next_string_index, aStr)
+		return s.next_string_index
+	}
+
+	s.trieMutex.Lock()
+	defer s.trieMutex.Unlock()
+	//Checked again under the lock
+	if val := s.string_table.Get(patricia.Prefix(aStr)); val != nil {
+		return val.(int)
+	}
+	s.reverse_string_table = append(s.reverse_string_table, aStr)
+	s.next_string_index = len(s.reverse_string_table) - 1
+	s.last_tag_record = s.last_tag_record + 1
+	s.string_table.Insert(patricia.Prefix(aStr), s.next_string_index)
+	Debugf("Storing mem tag %v and disk tag %v\n", s.next_string_index, s.last_tag_record)
+	return s.next_string_index
+}
+
+func (s *tagSilo) makeFingerprintFromData(aStr string) fingerPrint {
+	return s.makeFingerprint(RegSplit(strings.ToLower(aStr), FragsRegex))
+}
+
+// The symbols of a record's tags, in order, without repeats.  Tags of one character, or of maxTagLength or more, are
+// left out, except for the reserved tags (metadata, access lists), which are kept whole
+func (s *tagSilo) makeFingerprint(fragments []string) fingerPrint {
+	fingerprint := fingerPrint{}
+	seen := map[int]bool{}
+	for _, f := range fragments {
+		key := f
+		if !isReservedTag(f) {
+			if len(f) <= 1 || len(f) >= maxTagLength {
+				continue
+			}
+			key, _ = calcRawScore(f)
+		}
+		table_index := s.get_or_create_symbol(key)
+		if table_index != 0 && !seen[table_index] {
+			seen[table_index] = true
+			fingerprint = append(fingerprint, table_index)
+		}
+	}
+	return fingerprint
+}
@@ -0,0 +1,120 @@
+// silo_workers.go
+package tagbrowser
+
+//Background workers of a silo: storing queued records, committing disk silos, and trimming the caches.
+//
+//Records queued with submit are read by storeRecordWorker.  A memory silo's records are turned into symbols there and
+//passed on recordCh to storeMemRecordWorker.  A disk silo's records are stored by storeFileRecord, see silo_records.go.
+//When InputRecordCh is closed, storeRecordWorker stores what is left and closes recordCh, so the workers return once
+//every queued record is stored.  The other workers return when the silo's stop channel is closed
+
+import (
+	"time"
+)
+
+// How often a disk silo's write ahead log is written into the file, if the silo has changed
+const commitInterval = time.Second * 10
+
+// Caches with more entries than this are emptied by monitorSiloWorker
+const maxCacheEntries = 10000
+
+// Store the records queued for the silo, until InputRecordCh is closed
+func (s *tagSilo) storeRecordWorker() {
+	defer s.threadsWait.Done()
+	defer close(s.recordCh)
+	for aRecord := range s.InputRecordCh {
+		if !s.memory_db {
+			s.storeFileRecord(aRecord)
+			continue
+		}
+		s.writeMutex.Lock()
+		r := record{s.get_or_create_symbol(aRecord.Filename), aRecord.Line, s.makeFingerprint(aRecord.Fingerprint)}
+		s.writeMutex.Unlock()
+		Debugln("storeRecord writing to recordCh")
+		s.recordCh <- r
+	}
+	Debugln("StoreRecordWorker exiting in silo ", s.id)
+}
+
+// Add the records from recordCh to a memory silo, until it is closed
+func (s *tagSilo) storeMemRecordWorker() {
+	defer s.threadsWait.Done()
+	for line_elem := range s.recordCh {
+		s.storeMemRecord(line_elem)
+	}
+	Debugln("StoreMemRecordWorker exiting in silo ", s.id)
+}
+
+// Store records sent to the index's permanent store channel in a disk silo, until the silo stops
+func (s *tagSilo) storePermanentRecordWorker() {
+	defer s.threadsWait.Done()
+	for {
+		select {
+		case <-s.stop:
+			Debugln("StorePermanentRecordWorker exiting in silo ", s.id)
+			return
+		case aRecord := <-s.permanentStoreCh:
+			s.storeFileRecord(aRecord)
+		}
+	}
+}
+
+// Write a disk silo's log into its file when the silo has changed, until it stops
+func (s *tagSilo) SQLCommitWorker() {
+	defer s.threadsWait.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(commitInterval):
+		}
+		if s.isDirty() {
+			s.Checkpoint()
+		}
+	}
+}
+
+// Count the silo's minutes, and empty caches that have grown too large, until the silo stops
+func (s *tagSilo) monitorSiloWorker() {
+	defer s.threadsWait.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Minute):
+		}
+		s.count("minutes")
+		if clearCache(s.string_cache) {
+			s.count("string_cache_clear")
+		}
+		if clearCache(s.symbol_cache) {
+			s.count("symbol_cache_clear")
+		}
+		if clearCache(s.tag_cache) {
+			s.count("tag_cache_clear")
+		}
+		if clearCache(s.record_cache) {
+			s.count("record_cache_clear")
+		}
+	}
+}
+
+// Empty a cache if it has more than maxCacheEntries entries.  Returns true if it was emptied
+func clearCache[K comparable, V any](cache interface {
+	Range(func(K, V) bool)
+	Delete(K)
+}) bool {
+	n := 0
+	cache.Range(func(k K, v V) bool {
+		n++
+		return n <= maxCacheEntries
+	})
+	if n <= maxCacheEntries {
+		return false
+	}
+	cache.Range(func(k K, v V) bool {
+		cache.Delete(k)
+		return true
+	})
+	return true
+}
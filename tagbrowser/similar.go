@@ -0,0 +1,244 @@
+// similar.go
+
+//"More like this" searches.  Given a stored record, find the other records that share its most informative tags.
+//Each tag is weighted by its inverse document frequency, log(1 + records / records holding the tag), so a word that is in
+//nearly every record counts for little, while a rare key shared by two config snippets counts for a lot.
+//
+//The frequencies are counted across every silo of the searched indexes, so a record gets the same score whichever silo
+//holds it.  The score is the share of the source record's weight that the other record matched, as a percentage.
+
+package tagbrowser
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+)
+
+// The most tags of the source record that are compared.  The rest are the most common ones, which add little
+const maxSimilarTags = 25
+
+// A tag of the source record, and how much it counts
+type weightedTag struct {
+	tag    string
+	weight float64
+}
+
+// Every record for filename at line, in this silo
+func (s *tagSilo) findRecords(filename string, line int) []record {
+	fileSym := s.lookupSymbol(filename)
+	if fileSym == 0 {
+		return nil
+	}
+	if !s.memory_db {
+		return s.Store.FindRecords(s, fileSym, line)
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	out := []record{}
+	for _, r := range s.database {
+		if r.Filename == fileSym && r.Line == line {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// The number of records in the silo that hold tag
+func (s *tagSilo) tagFrequency(tag string) int {
+	sym := s.lookupSymbol(tag)
+	if sym == 0 {
+		return 0
+	}
+	if !s.memory_db {
+		return s.Store.CountRecordId(sym)
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if sym >= len(s.tag2file) {
+		return 0
+	}
+	n := 0
+	for _, r := range s.tag2file[sym] {
+		if r != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// The tags of the records for filename at line that v may see, without the reserved tags
+func (f *Farm) sourceTags(filename string, line int, v *viewer) []string {
+	tags := []string{}
+	silos, done := f.useSilos()
+	defer done()
+	for _, aSilo := range silos {
+		filter := aSilo.aclFilterFor(v)
+		for _, r := range aSilo.findRecords(filename, line) {
+			if !filter.visible(r) {
+				continue
+			}
+			for _, sym := range r.Fingerprint {
+				tags = append(tags, aSilo.getString(sym))
+			}
+		}
+	}
+	return withoutReservedTags(tags)
+}
+
+// Add the farm's record count, and the number of its records holding each tag
+func (f *Farm) countTags(tags []string, freq map[string]int) int {
+	records := 0
+	silos, done := f.useSilos()
+	defer done()
+	for _, aSilo := range silos {
+		records = records + aSilo.last_database_record
+		for _, tag := range tags {
+			freq[tag] = freq[tag] + aSilo.tagFrequency(tag)
+		}
+	}
+	return records
+}
+
+// Weight the tags by inverse document frequency, and keep the maxSimilarTags rarest
+func weightTags(tags []string, freq map[string]int, records int) []weightedTag {
+	weighted := []weightedTag{}
+	for _, tag := range tags {
+		n := freq[tag]
+		if n < 1 {
+			n = 1
+		}
+		if records < n {
+			records = n
+		}
+		weighted = append(weighted, weightedTag{tag, math.Log(1 + float64(records)/float64(n))})
+	}
+	sort.SliceStable(weighted, func(i, j int) bool {
+		return weighted[i].weight > weighted[j].weight
+	})
+	if len(weighted) > maxSimilarTags {
+		weighted = weighted[0:maxSimilarTags]
+	}
+	return weighted
+}
+
+// The silo's best maxResults records sharing the weighted tags, leaving out the source record and records that v may not see
+func (s *tagSilo) scanSimilar(weights []weightedTag, filename string, line int, maxResults int, v *viewer) resultRecordCollection {
+	total := 0.0
+	bySym := map[int]float64{}
+	syms := fingerPrint{}
+	for _, w := range weights {
+		total = total + w.weight
+		if sym := s.lookupSymbol(w.tag); sym != 0 {
+			bySym[sym] = w.weight
+			syms = append(syms, sym)
+		}
+	}
+	results := resultRecordCollection{}
+	if len(syms) == 0 || total <= 0 {
+		return results
+	}
+	filter := s.aclFilterFor(v)
+	for _, r := range s.candidateRecords(syms) {
+		if r.Filename == 0 || !filter.visible(r) {
+			continue
+		}
+		name := s.getString(r.Filename)
+		if name == filename && r.Line == line {
+			continue
+		}
+		shared := 0.0
+		seen := map[int]bool{}
+		for _, sym := range r.Fingerprint {
+			if !seen[sym] {
+				seen[sym] = true
+				shared = shared + bySym[sym]
+			}
+		}
+		score := int(math.Round(100 * shared / total))
+		if score < 1 {
+			continue
+		}
+		results = append(results, resultRecord{name, r.Line, r.Fingerprint, "", score})
+	}
+	sort.Stable(results)
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results
+}
+
+// Find the records in the named indexes that are most like the record for filename at line.  The source record must be
+// one that v may see
+func (m *Manor) similarRecords(indexes string, filename string, line int, maxResults int, v *viewer) ([]ResultRecordTransmittable, error) {
+	farms, err := m.farmsFor(indexes)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, aFarm := range farms {
+		tags = append(tags, aFarm.sourceTags(filename, line, v)...)
+	}
+	tags = uniqStrings(tags)
+	remote := remoteFarms(farms)
+	if len(tags) == 0 && len(remote) == 0 {
+		return nil, notFound("no record for %v line %v", filename, line)
+	}
+
+	freq := map[string]int{}
+	records := 0
+	for _, aFarm := range farms {
+		records = records + aFarm.countTags(tags, freq)
+	}
+	weights := weightTags(tags, freq, records)
+
+	results := ResultRecordTransmittableCollection{}
+	resLock := sync.Mutex{}
+	var wg sync.WaitGroup
+	//Remote servers weight the tags by their own records, so their scores are only roughly comparable
+	for _, aFarm := range remote {
+		wg.Add(1)
+		go func(aFarm *Farm) {
+			defer wg.Done()
+			res, err := aFarm.remote.similar(filename, line, maxResults, v)
+			if err != nil {
+				log.Printf("Farm %v did not answer: %v", aFarm.location, err)
+			}
+			resLock.Lock()
+			defer resLock.Unlock()
+			for _, r := range res {
+				if !IsIn(r, results) {
+					results = append(results, r)
+				}
+			}
+		}(aFarm)
+	}
+	for _, aFarm := range farms {
+		if len(tags) == 0 {
+			break
+		}
+		silos, done := aFarm.useSilos()
+		defer done()
+		for _, aSilo := range silos {
+			wg.Add(1)
+			go func(aSilo *tagSilo) {
+				defer wg.Done()
+				res := aSilo.resultsToTransmittable(aSilo.scanSimilar(weights, filename, line, maxResults, v))
+				resLock.Lock()
+				defer resLock.Unlock()
+				for _, r := range res {
+					if !IsIn(r, results) {
+						results = append(results, r)
+					}
+				}
+			}(aSilo)
+		}
+	}
+	wg.Wait()
+	sort.Stable(results)
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results, nil
+}
@@ -0,0 +1,69 @@
+// similar_test.go
+package tagbrowser
+
+import (
+	"testing"
+)
+
+func TestSimilarRecords(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			m := testManor(t, mode, 2)
+			storeRecords(t, m.Farms[0],
+				RecordTransmittable{"source.txt", 1, []string{"common", "rare", "fox"}},
+				RecordTransmittable{"close.txt", 1, []string{"common", "rare"}},
+				RecordTransmittable{"far.txt", 1, []string{"common"}},
+				RecordTransmittable{"other.txt", 1, []string{"common", "dog"}},
+				RecordTransmittable{"secret.txt", 1, withACLTags([]string{"rare", "fox"}, []string{"user:alice"})},
+			)
+			for _, s := range m.Farms[0].siloList() {
+				if s.lookupSymbol("common") != 0 && s.tagFrequency("common") == 0 {
+					t.Errorf("silo %v counts no records for a tag it holds", s.id)
+				}
+			}
+			if n := m.Farms[0].countTags([]string{"common"}, map[string]int{}); n == 0 {
+				t.Error("countTags found no records")
+			}
+
+			res, err := m.similarRecords(DefaultIndex, "source.txt", 1, 10, &viewer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range res {
+				got = append(got, r.Filename)
+			}
+			if len(got) < 2 || got[0] != "close.txt" {
+				t.Errorf("similar records are %v, want close.txt first", got)
+			}
+			for _, name := range got {
+				if name == "source.txt" || name == "secret.txt" {
+					t.Errorf("similar records include %v", name)
+				}
+			}
+			if _, err := m.similarRecords(DefaultIndex, "missing.txt", 1, 10, nil); err == nil {
+				t.Error("no error for a missing source record")
+			}
+		})
+	}
+}
+
+func TestTagFrequencyCountsEveryRecord(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFarm(t, mode, 1)
+			records := []RecordTransmittable{}
+			for i := 0; i < 40; i++ {
+				records = append(records, RecordTransmittable{"log.txt", i, []string{"line"}})
+			}
+			storeRecords(t, f, records...)
+			s := f.siloList()[0]
+			if n := s.tagFrequency("line"); n != 40 {
+				t.Errorf("tagFrequency = %v, want 40", n)
+			}
+			if n := s.tagFrequency("missing"); n != 0 {
+				t.Errorf("tagFrequency of a missing tag = %v", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+// sqlschema.go
+
+//The schema of disk silos.  Each .tagdb file records its schema version in the schema_version table, and Init brings
+//older files up to date by running the migrations after their version in turn, each in its own transaction.  Files made
+//before versions were kept have no schema_version table, and are version 1 if they have a RecordTable.  Before an
+//existing file is migrated, a copy is kept beside it, named for the version it had.
+//
+//Version 2 stores records in a compact binary encoding, with their filename and line in columns of their own, indexed,
+//so deletes and lookups by file do not read every record, and gives TagToRecord a primary key of (tagid, recordid) and an
+//index on recordid.
+//
+//Version 3 adds the Vectors table, the silo's vector store, and moves the vectors that records kept in tags into it.
+
+package tagbrowser
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The schema this server writes
+const schemaVersion = 3
+
+// The binary record encoding's first byte.  JSON records start with '{'
+const recordEncodingVersion = 1
+
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "create the original tables", migrateOriginalTables},
+	{2, "binary records indexed by file, and a primary key on TagToRecord", migrateIndexedRecords},
+	{3, "a table of record vectors, instead of vector tags", migrateVectorTable},
+}
+
+// The most records a migration reads at once
+const migrationBatch = 1000
+
+// The tables as they were before versions were kept
+func migrateOriginalTables(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		`create table IF NOT EXISTS TagToRecord (tagid int not null, recordid int not null);`,
+		`create table IF NOT EXISTS StringTable (id int not null primary key, value string not null);`,
+		`create table IF NOT EXISTS SymbolTable (id string not null primary key, value int not null);`,
+		`create table IF NOT EXISTS RecordTable (id int not null primary key, value blob not null);`,
+		`create table IF NOT EXISTS TagToRecordTable (id int not null primary key, value int not null);`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Re-encode every record, from JSON to the binary encoding, a batch at a time, and remove duplicate rows from TagToRecord
+func migrateIndexedRecords(tx *sql.Tx) error {
+	_, err := tx.Exec(`create table RecordTable2 (id integer primary key, filename integer not null, line integer not null, value blob not null);`)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("insert into RecordTable2(id, filename, line, value) values(?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	type row struct {
+		id  int
+		rec record
+	}
+	after := -1
+	for {
+		rows, err := tx.Query("select cast(id as integer), value from RecordTable where id > ? order by id limit ?", after, migrationBatch)
+		if err != nil {
+			return err
+		}
+		batch := []row{}
+		for rows.Next() {
+			var id int
+			var val []byte
+			if err := rows.Scan(&id, &val); err != nil {
+				rows.Close()
+				return err
+			}
+			aRecord := record{}
+			if err := json.Unmarshal(val, &aRecord); err != nil {
+				rows.Close()
+				return fmt.Errorf("record %v cannot be read: %v", id, err)
+			}
+			batch = append(batch, row{id, aRecord})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, r := range batch {
+			after = r.id
+			if _, err := stmt.Exec(r.id, r.rec.Filename, r.rec.Line, encodeRecord(r.rec)); err != nil {
+				return fmt.Errorf("record %v: %v", r.id, err)
+			}
+		}
+	}
+	for _, s := range []string{
+		`drop table RecordTable;`,
+		`alter table RecordTable2 rename to RecordTable;`,
+		`create index RecordByFile on RecordTable(filename, line);`,
+		`create table TagToRecord2 (tagid integer not null, recordid integer not null, primary key (tagid, recordid)) without rowid;`,
+		`insert or ignore into TagToRecord2(tagid, recordid) select cast(tagid as integer), cast(recordid as integer) from TagToRecord;`,
+		`drop table TagToRecord;`,
+		`alter table TagToRecord2 rename to TagToRecord;`,
+		`create index TagToRecordByRecord on TagToRecord(recordid);`,
+	} {
+		if _, err := tx.Exec(s); err != nil {
+			return fmt.Errorf("%v: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// Make the Vectors table, and move each vector tag into it, removing the tag and the marker tag from the record
+func migrateVectorTable(tx *sql.Tx) error {
+	if _, err := tx.Exec(`create table Vectors (filename text not null, line integer not null, value blob not null, primary key (filename, line)) without rowid;`); err != nil {
+		return err
+	}
+	var marker int
+	err := tx.QueryRow("select value from SymbolTable where id = ?", []byte(vectorMarkerTag)).Scan(&marker)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stringOf := func(id int) (string, error) {
+		var s string
+		err := tx.QueryRow("select value from StringTable where id = ?", id).Scan(&s)
+		return s, err
+	}
+	type row struct {
+		id  int
+		rec record
+	}
+	after := 0
+	for {
+		rows, err := tx.Query("select r.id, r.value from RecordTable r join TagToRecord t on t.recordid = r.id where t.tagid = ? and r.id > ? order by r.id limit ?", marker, after, migrationBatch)
+		if err != nil {
+			return err
+		}
+		batch := []row{}
+		for rows.Next() {
+			var id int
+			var val []byte
+			if err := rows.Scan(&id, &val); err != nil {
+				rows.Close()
+				return err
+			}
+			aRecord, err := decodeRecord(val)
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("record %v cannot be read: %v", id, err)
+			}
+			batch = append(batch, row{id, aRecord})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, r := range batch {
+			after = r.id
+			kept := fingerPrint{}
+			for _, tag := range r.rec.Fingerprint {
+				str, err := stringOf(tag)
+				if err != nil {
+					return fmt.Errorf("record %v, tag %v: %v", r.id, tag, err)
+				}
+				if !isVectorTag(str) {
+					kept = append(kept, tag)
+					continue
+				}
+				if _, err := tx.Exec("delete from TagToRecord where tagid = ? and recordid = ?", tag, r.id); err != nil {
+					return err
+				}
+				v, ok := parseVectorTag(str)
+				if !ok {
+					continue
+				}
+				filename, err := stringOf(r.rec.Filename)
+				if err != nil {
+					return fmt.Errorf("record %v, filename %v: %v", r.id, r.rec.Filename, err)
+				}
+				if _, err := tx.Exec("insert or replace into Vectors(filename, line, value) values(?, ?, ?)", filename, r.rec.Line, encodeVector(v)); err != nil {
+					return err
+				}
+			}
+			r.rec.Fingerprint = kept
+			if _, err := tx.Exec("update RecordTable set value = ? where id = ?", encodeRecord(r.rec), r.id); err != nil {
+				return fmt.Errorf("record %v: %v", r.id, err)
+			}
+		}
+	}
+}
+
+// The schema version of db.  0 for a new file.  An empty schema_version table is left by a migration that failed, and
+// the file is read as if it had none
+func readSchemaVersion(db *sql.DB) (int, error) {
+	var found int
+	err := db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = 'schema_version'").Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if found > 0 {
+		var version sql.NullInt64
+		err := db.QueryRow("select max(version) from schema_version").Scan(&version)
+		if err != nil || version.Valid {
+			return int(version.Int64), err
+		}
+	}
+	err = db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = 'RecordTable'").Scan(&found)
+	if err != nil {
+		return 0, err
+	}
+	if found > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Bring the schema of the database in filename up to date.  Returns the version it had
+func migrateSchema(db *sql.DB, filename string) (int, error) {
+	from, err := readSchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("reading the schema version: %v", err)
+	}
+	if from > schemaVersion {
+		return from, fmt.Errorf("schema version %v was written by a newer tagdb, this server reads up to version %v", from, schemaVersion)
+	}
+	if from == schemaVersion {
+		return from, nil
+	}
+	if from > 0 {
+		keep := fmt.Sprintf("%v.schema%v-%v.bak", filename, from, time.Now().Format("20060102-150405"))
+		if _, err := db.Exec("VACUUM INTO ?", keep); err != nil {
+			return from, fmt.Errorf("keeping a copy before migrating: %v", err)
+		}
+		log.Printf("Migrating %v from schema version %v to %v, a copy of the old file is in %v", filename, from, schemaVersion, keep)
+	}
+	if _, err := db.Exec(`create table IF NOT EXISTS schema_version (version integer not null primary key, applied text not null, description text not null);`); err != nil {
+		return from, err
+	}
+	for _, m := range migrations {
+		if m.version <= from {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return from, err
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return from, fmt.Errorf("migration to version %v (%v): %v", m.version, m.description, err)
+		}
+		_, err = tx.Exec("insert into schema_version(version, applied, description) values(?, ?, ?)", m.version, time.Now().UTC().Format(time.RFC3339), m.description)
+		if err != nil {
+			tx.Rollback()
+			return from, err
+		}
+		if err := tx.Commit(); err != nil {
+			return from, err
+		}
+	}
+	return from, nil
+}
+
+// A record in the binary encoding: the encoding version, then the filename, line, number of tags and each tag as varints
+func encodeRecord(r record) []byte {
+	buf := make([]byte, 1, 1+binary.MaxVarintLen64*(3+len(r.Fingerprint)))
+	buf[0] = recordEncodingVersion
+	buf = binary.AppendVarint(buf, int64(r.Filename))
+	buf = binary.AppendVarint(buf, int64(r.Line))
+	buf = binary.AppendUvarint(buf, uint64(len(r.Fingerprint)))
+	for _, tag := range r.Fingerprint {
+		buf = binary.AppendVarint(buf, int64(tag))
+	}
+	return buf
+}
+
+var errBadRecord = errors.New("record is damaged")
+
+func decodeRecord(buf []byte) (record, error) {
+	r := record{}
+	if len(buf) == 0 || buf[0] != recordEncodingVersion {
+		return r, fmt.Errorf("unknown record encoding")
+	}
+	pos := 1
+	next := func() (int64, bool) {
+		v, n := binary.Varint(buf[pos:])
+		if n <= 0 {
+			return 0, false
+		}
+		pos = pos + n
+		return v, true
+	}
+	filename, ok1 := next()
+	line, ok2 := next()
+	count, n := binary.Uvarint(buf[pos:])
+	if !ok1 || !ok2 || n <= 0 || count > uint64(len(buf)) {
+		return r, errBadRecord
+	}
+	pos = pos + n
+	r.Filename = int(filename)
+	r.Line = int(line)
+	r.Fingerprint = make(fingerPrint, 0, count)
+	for i := uint64(0); i < count; i++ {
+		tag, ok := next()
+		if !ok {
+			return r, errBadRecord
+		}
+		r.Fingerprint = append(r.Fingerprint, int(tag))
+	}
+	if pos != len(buf) {
+		return r, errBadRecord
+	}
+	return r, nil
+}
@@ -0,0 +1,119 @@
+// sqlschema_test.go
+package tagbrowser
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a silo file as tagdb wrote it before schema versions, with n records of the tags fox and dog, each tag row twice.
+// Returns the file's name
+func writeVersion1Silo(t *testing.T, location string, n int) string {
+	t.Helper()
+	if err := os.MkdirAll(location, 0777); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(location, "tagSilo_0.tagdb")
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOriginalTables(tx); err != nil {
+		t.Fatal(err)
+	}
+	exec := func(query string, args ...interface{}) {
+		if _, err := tx.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	strings := []string{"fox", "dog"}
+	for i := 0; i < n; i++ {
+		strings = append(strings, fmt.Sprintf("file%v.txt", i))
+	}
+	for i, s := range strings {
+		exec("insert into StringTable(id, value) values(?, ?)", i+1, []byte(s))
+		exec("insert into SymbolTable(id, value) values(?, ?)", []byte(s), i+1)
+	}
+	for i := 0; i < n; i++ {
+		exec("insert into RecordTable(id, value) values(?, ?)", i+1, []byte(fmt.Sprintf(`{"Filename":%v,"Line":1,"Fingerprint":[1,2]}`, i+3)))
+		for _, tag := range []int{1, 2, 1} {
+			exec("insert into TagToRecord(tagid, recordid) values(?, ?)", tag, i+1)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestVersion1SiloIsMigratedInBatches(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	n := 2*migrationBatch + 500
+	filename := writeVersion1Silo(t, location, n)
+
+	f := openTestFarm(t, location, "disk", 1)
+	s := f.siloList()[0]
+	for _, i := range []int{0, migrationBatch - 1, migrationBatch, n - 1} {
+		name := fmt.Sprintf("file%v.txt", i)
+		if !s.holdsRecord(RecordTransmittable{name, 1, []string{"fox", "dog"}}) {
+			t.Errorf("%v was not migrated", name)
+		}
+	}
+	if got := len(s.Store.IntersectRecordIds([]int{1, 2})); got != n {
+		t.Errorf("%v records have both tags, want %v", got, n)
+	}
+	if err := f.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if version, err := readSchemaVersion(db); err != nil || version != schemaVersion {
+		t.Errorf("schema version %v (%v), want %v", version, err, schemaVersion)
+	}
+	var rows int
+	if err := db.QueryRow("select count(*) from TagToRecord").Scan(&rows); err != nil || rows != 2*n {
+		t.Errorf("TagToRecord has %v rows (%v), want %v without the duplicates", rows, err, 2*n)
+	}
+	if kept, _ := filepath.Glob(filename + ".schema1-*.bak"); len(kept) != 1 {
+		t.Errorf("kept %v copies of the version 1 file, want 1", kept)
+	}
+}
+
+func TestDamagedRecordStopsTheMigration(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	filename := writeVersion1Silo(t, location, 10)
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("update RecordTable set value = ? where id = 5", []byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	_, err = createFarm(location, 1, false, make(chan RecordTransmittable, 100), false, 0)
+	if err == nil || !strings.Contains(err.Error(), "record 5") {
+		t.Fatalf("createFarm returned %v, want record 5 to be reported", err)
+	}
+	db, err = sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if version, err := readSchemaVersion(db); err != nil || version != 1 {
+		t.Errorf("after the failed migration the file has version %v (%v), want 1", version, err)
+	}
+}
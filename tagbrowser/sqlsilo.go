@@ -5,21 +5,22 @@ import (
 	//"runtime/pprof"
 	//debugModule "runtime/debug"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func NewSQLStore(filename string) *SqlStore {
+func NewSQLStore(filename string) (*SqlStore, error) {
 	s := SqlStore{}
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	s.Db = db
-	return &s
+	return &s, nil
 }
 
 //FIXME this needs to go, all access must be through member functions!
@@ -27,7 +28,7 @@ func (s *SqlStore) Dbh() *sql.DB {
 	return s.Db
 }
 
-func (s *SqlStore) Init(silo *tagSilo) {
+func (s *SqlStore) Init(silo *tagSilo) error {
 	if debug {
 		log.Println("Initialising silo ", silo.id)
 	}
@@ -39,86 +40,34 @@ func (s *SqlStore) Init(silo *tagSilo) {
 	if err != nil {
 		silo.LogChan["error"] <- fmt.Sprintf("Performing PRAGMA - %q: %s\n", err, sqlStmt)
 	}
-	//sqlStmt = `create table IF NOT EXISTS TagToRecord (tagid int not null, recordid int not null, UNIQUE(tagid, recordid));`
-	sqlStmt = `create table IF NOT EXISTS TagToRecord (tagid int not null, recordid int not null);`
-	_, err = s.Db.Exec(sqlStmt)
-	if err != nil {
-		silo.LogChan["error"] <- fmt.Sprintf("Creating TagToRecord - %q: %s\n", err, sqlStmt)
-	}
-
-	sqlStmt = `create table IF NOT EXISTS StringTable (id int not null primary key, value string not null);`
-	_, err = s.Db.Exec(sqlStmt)
-	if err != nil {
-		silo.LogChan["error"] <- fmt.Sprintf("Creating StringTable - %q: %s\n", err, sqlStmt)
+	if _, err := migrateSchema(s.Db, silo.filename); err != nil {
+		return fmt.Errorf("could not open %v, it has not been changed: %v", silo.filename, err)
 	}
 
-	sqlStmt = `create table IF NOT EXISTS SymbolTable (id string not null primary key, value int not null);`
-	_, err = s.Db.Exec(sqlStmt)
-	if err != nil {
-		silo.LogChan["error"] <- fmt.Sprintf("Creating SymbolTable - %q: %s\n", err, sqlStmt)
-	}
-
-	sqlStmt = `create table IF NOT EXISTS RecordTable (id int not null primary key, value blob not null);`
-	_, err = s.Db.Exec(sqlStmt)
-	if err != nil {
-		silo.LogChan["error"] <- fmt.Sprintf("Creating RecordTable - %q: %s\n", err, sqlStmt)
-	}
-
-	sqlStmt = `create table IF NOT EXISTS TagToRecordTable (id int not null primary key, value int not null);`
-	_, err = s.Db.Exec(sqlStmt)
-	if err != nil {
-		silo.LogChan["error"] <- fmt.Sprintf("Creating TagToRecordTable - %q: %s\n", err, sqlStmt)
-	}
-
-	rows, err := s.Db.Query("select id, value from RecordTable")
+	var last sql.NullInt64
+	err = s.Db.QueryRow("select max(id) from RecordTable").Scan(&last)
 	if err != nil {
 		silo.LogChan["database"] <- fmt.Sprintf("Reading from table RecordTable: %v", err)
 	}
-
-	for rows.Next() {
-		var k int
-		var name string
-		rows.Scan(&k, &name)
-		var val = k
-		//val, err := strconv.ParseInt(string(k), 10, 0)
-		if err != nil {
-			silo.LogChan["error"] <- fmt.Sprintln("Could not parse int, because ", err)
-		} else {
-			if int(val) > silo.last_database_record {
-				silo.last_database_record = int(val)
-			}
-		}
-
+	if int(last.Int64) > silo.last_database_record {
+		silo.last_database_record = int(last.Int64)
 	}
-	rows.Close()
 
-	rows, err = s.Db.Query("select id from StringTable")
+	last = sql.NullInt64{}
+	err = s.Db.QueryRow("select max(id) from StringTable").Scan(&last)
 	if err != nil {
 		silo.LogChan["database"] <- fmt.Sprintf("Reading from table StringTable: %v", err)
 	}
-
-	for rows.Next() {
-		var k int
-		rows.Scan(&k)
-		if err != nil {
-			silo.LogChan["error"] <- fmt.Sprintln("Could not parse int, because ", err)
-		} else {
-			if k > silo.next_string_index {
-				silo.next_string_index = k
-			}
-		}
-
+	if int(last.Int64) > silo.next_string_index {
+		silo.next_string_index = int(last.Int64)
 	}
-	rows.Close()
 
 	Debugln("Buckets created")
 	//We should store this properly
 	Debugln("Set next_sting_index to ", silo.next_string_index)
 
-	go silo.storeFileRecordWorker()
-
 	Debugln("Initialised silo ", silo.id)
-
+	return nil
 }
 
 func (store *SqlStore) GetString(s *tagSilo, index int) string {
@@ -158,17 +107,22 @@ func (s *SqlStore) GetSymbol(silo *tagSilo, aStr string) int {
 }
 
 func (s *SqlStore) InsertRecord(silo *tagSilo, key []byte, aRecord record) {
-	val, _ := json.Marshal(aRecord)
-	stmt, err := s.Db.Prepare("insert into RecordTable(id, value) values(?, ?)")
+	id, err := strconv.Atoi(string(key))
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("Record key is not a number: ", string(key))
+		return
+	}
+	val := encodeRecord(aRecord)
+	stmt, err := s.Db.Prepare("insert into RecordTable(id, filename, line, value) values(?, ?, ?, ?)")
 	if err != nil {
 		silo.LogChan["error"] <- fmt.Sprintln("While preparing to insert RecordTable: ", err)
 		return
 	}
 	defer stmt.Close()
 	if debug {
-		log.Printf("insert into RecordTable(id, value) values(%s, %s)\n", key, val)
+		log.Printf("insert into RecordTable(id, filename, line) values(%v, %v, %v)\n", id, aRecord.Filename, aRecord.Line)
 	}
-	_, err = stmt.Exec(key, val)
+	_, err = stmt.Exec(id, aRecord.Filename, aRecord.Line, val)
 
 	if err != nil {
 		silo.LogChan["warning"] <- fmt.Sprintf("While trying to insert RecordTable: %v", err)
@@ -179,19 +133,23 @@ func (s *SqlStore) InsertRecord(silo *tagSilo, key []byte, aRecord record) {
 	silo.count("sql_insert")
 	silo.record_cache.Store(silo.last_database_record, aRecord)
 
-	Debugf("Record %v inserted: %v", silo.last_database_record, string(val))
+	Debugf("Record %v inserted: %v", silo.last_database_record, aRecord)
 
 }
 
 func (s *SqlStore) GetRecord(key []byte) record {
 	var val []byte
 	retval := record{}
-	err := s.Dbh().QueryRow("select value from RecordTable where id like ?", key).Scan(&val)
+	id, err := strconv.Atoi(string(key))
+	if err != nil {
+		return retval
+	}
+	s.Dbh().QueryRow("select value from RecordTable where id = ?", id).Scan(&val)
 
 	if val != nil {
-		err = json.Unmarshal(val, &retval)
+		retval, err = decodeRecord(val)
 		if err != nil {
-			panic("Could not retrieve record")
+			panic(fmt.Sprintf("Could not retrieve record %v: %v", id, err))
 		}
 	}
 	if debug {
@@ -244,7 +202,7 @@ func (s *SqlStore) Flush(silo *tagSilo) {
 func (s *SqlStore) GetRecordId(tagID int) []int {
 	var retarr []int
 	//log.Printf("Fetching %v", tagID)
-	rows, err := s.Db.Query("select recordid from TagToRecord where tagid like ?", tagID)
+	rows, err := s.Db.Query("select recordid from TagToRecord where tagid = ?", tagID)
 	if err != nil {
 		if debug {
 			log.Printf("Failed to retrieve tag (%v) because %v", tagID, err)
@@ -265,6 +223,51 @@ func (s *SqlStore) GetRecordId(tagID int) []int {
 	return retarr
 }
 
+// The ids of the records that have every one of the tags, in order.  SQLite intersects the posting lists, so they are not read
+func (s *SqlStore) IntersectRecordIds(tagIDs []int) []int {
+	ids := []int{}
+	distinct := map[int]bool{}
+	args := []interface{}{}
+	for _, tag := range tagIDs {
+		if !distinct[tag] {
+			distinct[tag] = true
+			args = append(args, tag)
+		}
+	}
+	if len(args) == 0 {
+		return ids
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	args = append(args, len(args))
+	rows, err := s.Db.Query("select recordid from TagToRecord where tagid in ("+placeholders+") group by recordid having count(*) = ? order by recordid", args...)
+	if err != nil {
+		if debug {
+			log.Printf("Failed to intersect tags (%v) because %v", tagIDs, err)
+		}
+		return ids
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// The number of records with the tag, without reading their ids
+func (s *SqlStore) CountRecordId(tagID int) int {
+	var n int
+	if err := s.Db.QueryRow("select count(*) from TagToRecord where tagid = ?", tagID).Scan(&n); err != nil {
+		if debug {
+			log.Printf("Failed to count tag (%v) because %v", tagID, err)
+		}
+		return 0
+	}
+	return n
+}
+
 func (s *SqlStore) StoreTagToRecord(recordId int, fp fingerPrint) {
 	stmt, err := s.Dbh().Prepare("insert or ignore into TagToRecord(tagid, recordid) values(?, ?)")
 	defer stmt.Close()
@@ -280,6 +283,488 @@ func (s *SqlStore) StoreTagToRecord(recordId int, fp fingerPrint) {
 
 }
 
+func (s *SqlStore) DeleteRecords(silo *tagSilo, filename int, line int, allLines bool) int {
+	var rows *sql.Rows
+	var err error
+	if allLines {
+		rows, err = s.Db.Query("select id from RecordTable where filename = ?", filename)
+	} else {
+		rows, err = s.Db.Query("select id from RecordTable where filename = ? and line = ?", filename, line)
+	}
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While reading RecordTable for delete: ", err)
+		return 0
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if old, ok := silo.record_cache.Load(id); ok {
+			for _, tag := range old.Fingerprint {
+				silo.tag_cache.Delete(tag)
+			}
+		}
+		_, err = s.Db.Exec("delete from TagToRecord where recordid = ?", id)
+		if err != nil {
+			silo.LogChan["error"] <- fmt.Sprintln("While deleting from TagToRecord: ", err)
+		}
+		_, err = s.Db.Exec("delete from RecordTable where id = ?", id)
+		if err != nil {
+			silo.LogChan["error"] <- fmt.Sprintln("While deleting from RecordTable: ", err)
+		}
+		silo.record_cache.Delete(id)
+		silo.count("sql_delete")
+	}
+	return len(ids)
+}
+
+func (s *SqlStore) FindRecords(silo *tagSilo, filename int, line int) []record {
+	silo.count("sql_select")
+	out := []record{}
+	rows, err := s.Db.Query("select value from RecordTable where filename = ? and line = ?", filename, line)
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While reading RecordTable for find: ", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var val []byte
+		if err := rows.Scan(&val); err != nil {
+			continue
+		}
+		aRecord, err := decodeRecord(val)
+		if err != nil {
+			continue
+		}
+		out = append(out, aRecord)
+	}
+	return out
+}
+
+func (s *SqlStore) RecordsAfter(silo *tagSilo, after int, limit int) ([]int, []record) {
+	silo.count("sql_select")
+	ids := []int{}
+	records := []record{}
+	rows, err := s.Db.Query("select id, value from RecordTable where id > ? order by id limit ?", after, limit)
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While reading RecordTable: ", err)
+		return ids, records
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var val []byte
+		if err := rows.Scan(&id, &val); err != nil {
+			continue
+		}
+		aRecord, err := decodeRecord(val)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+		records = append(records, aRecord)
+	}
+	return ids, records
+}
+
+func (s *SqlStore) StoreVector(silo *tagSilo, filename string, line int, v []float32) {
+	_, err := s.Db.Exec("insert or replace into Vectors(filename, line, value) values(?, ?, ?)", filename, line, encodeVector(v))
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While storing a vector: ", err)
+	}
+}
+
+func (s *SqlStore) DeleteVectors(silo *tagSilo, filename string, line int, allLines bool) {
+	var err error
+	if allLines {
+		_, err = s.Db.Exec("delete from Vectors where filename = ?", filename)
+	} else {
+		_, err = s.Db.Exec("delete from Vectors where filename = ? and line = ?", filename, line)
+	}
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While deleting vectors: ", err)
+	}
+}
+
+func (s *SqlStore) Vectors(silo *tagSilo) map[vectorKey][]float32 {
+	out := map[vectorKey][]float32{}
+	rows, err := s.Db.Query("select filename, line, value from Vectors")
+	if err != nil {
+		silo.LogChan["error"] <- fmt.Sprintln("While reading Vectors: ", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key vectorKey
+		var val []byte
+		if err := rows.Scan(&key.Filename, &key.Line, &val); err != nil {
+			continue
+		}
+		if v, ok := decodeVector(val); ok {
+			out[key] = v
+		}
+	}
+	return out
+}
+
+// Write a consistent copy of the database to filename, while it stays open
+func (s *SqlStore) Backup(silo *tagSilo, filename string) error {
+	_, err := s.Db.Exec("VACUUM INTO ?", filename)
+	return err
+}
+
+// Remove unreadable and copied records, rebuild TagToRecord from the records, remove strings that no record uses, and
+// vacuum the file if enough of it is free.  The silo's write lock is held for one batch at a time
+func (s *SqlStore) Compact(silo *tagSilo, job *compactJob) error {
+	//Only the strings that existed when compaction started are checked.  Inserts take their strings and write their
+	//records under the write lock, and compactStrings checks and removes each batch under it too, so a string is never
+	//removed between an insert taking it and the record being written
+	silo.writeMutex.Lock()
+	lastString := silo.next_string_index
+	silo.writeMutex.Unlock()
+
+	steps := []struct {
+		phase string
+		batch func(after int) (int, bool, error)
+	}{
+		{"records", func(after int) (int, bool, error) { return s.compactRecords(silo, job, after) }},
+		{"tag_rows", func(after int) (int, bool, error) { return s.compactTagRows(silo, job, after) }},
+		{"strings", func(after int) (int, bool, error) { return s.compactStrings(silo, job, after, lastString) }},
+	}
+	for _, step := range steps {
+		job.phase(silo, step.phase)
+		after := 0
+		for {
+			last, done, err := step.batch(after)
+			if err != nil {
+				return fmt.Errorf("%v: %v", step.phase, err)
+			}
+			if done {
+				break
+			}
+			after = last
+			if err := job.rest(silo); err != nil {
+				return err
+			}
+		}
+	}
+	job.phase(silo, "vacuum")
+	return s.compactVacuum(silo, job)
+}
+
+// The tags of a record, from TagToRecord
+func tagRowsOf(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, recordId int) ([]int, error) {
+	rows, err := q.Query("select tagid from TagToRecord where recordid = ?", recordId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []int{}
+	for rows.Next() {
+		var tag int
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Check a batch of records after the id after.  Returns the last id checked, and true when there are no more
+func (s *SqlStore) compactRecords(silo *tagSilo, job *compactJob, after int) (int, bool, error) {
+	silo.writeMutex.Lock()
+	defer silo.writeMutex.Unlock()
+	type row struct {
+		id, filename, line int
+		val                []byte
+	}
+	batch := []row{}
+	rows, err := s.Db.Query("select id, filename, line, value from RecordTable where id > ? order by id limit ?", after, job.batch)
+	if err != nil {
+		return after, false, err
+	}
+	for rows.Next() {
+		r := row{}
+		if err := rows.Scan(&r.id, &r.filename, &r.line, &r.val); err != nil {
+			rows.Close()
+			return after, false, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if len(batch) == 0 {
+		return after, true, nil
+	}
+
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return after, false, err
+	}
+	stats := compactStats{}
+	for _, r := range batch {
+		existing, err := tagRowsOf(tx, r.id)
+		if err != nil {
+			tx.Rollback()
+			return after, false, err
+		}
+		aRecord, err := decodeRecord(r.val)
+		remove := err != nil
+		if !remove {
+			//Keep the newest copy of a record that was stored more than once
+			var newer int
+			err = tx.QueryRow("select count(*) from RecordTable where filename = ? and line = ? and value = ? and id > ?", r.filename, r.line, r.val, r.id).Scan(&newer)
+			if err != nil {
+				tx.Rollback()
+				return after, false, err
+			}
+			remove = newer > 0
+		}
+		want := map[int]bool{}
+		if !remove {
+			for _, tag := range aRecord.Fingerprint {
+				want[tag] = true
+			}
+		}
+		have := map[int]bool{}
+		for _, tag := range existing {
+			have[tag] = true
+			if !want[tag] {
+				if _, err := tx.Exec("delete from TagToRecord where tagid = ? and recordid = ?", tag, r.id); err != nil {
+					tx.Rollback()
+					return after, false, err
+				}
+				stats.TagRowsRemoved++
+				silo.tag_cache.Delete(tag)
+			}
+		}
+		for tag := range want {
+			if !have[tag] {
+				if _, err := tx.Exec("insert or ignore into TagToRecord(tagid, recordid) values(?, ?)", tag, r.id); err != nil {
+					tx.Rollback()
+					return after, false, err
+				}
+				stats.TagRowsAdded++
+				silo.tag_cache.Delete(tag)
+			}
+		}
+		if remove {
+			if _, err := tx.Exec("delete from RecordTable where id = ?", r.id); err != nil {
+				tx.Rollback()
+				return after, false, err
+			}
+			silo.record_cache.Delete(r.id)
+			stats.RecordsRemoved++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return after, false, err
+	}
+	job.add(func(c *compactStats) {
+		c.RecordsRemoved += stats.RecordsRemoved
+		c.TagRowsRemoved += stats.TagRowsRemoved
+		c.TagRowsAdded += stats.TagRowsAdded
+	})
+	return batch[len(batch)-1].id, len(batch) < job.batch, nil
+}
+
+// Remove the TagToRecord rows of a batch of record ids that are not in RecordTable
+func (s *SqlStore) compactTagRows(silo *tagSilo, job *compactJob, after int) (int, bool, error) {
+	silo.writeMutex.Lock()
+	defer silo.writeMutex.Unlock()
+	ids := []int{}
+	rows, err := s.Db.Query("select distinct recordid from TagToRecord where recordid > ? order by recordid limit ?", after, job.batch)
+	if err != nil {
+		return after, false, err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return after, false, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return after, true, nil
+	}
+	removed := 0
+	for _, id := range ids {
+		var found int
+		if err := s.Db.QueryRow("select count(*) from RecordTable where id = ?", id).Scan(&found); err != nil {
+			return after, false, err
+		}
+		if found > 0 {
+			continue
+		}
+		tags, err := tagRowsOf(s.Db, id)
+		if err != nil {
+			return after, false, err
+		}
+		if _, err := s.Db.Exec("delete from TagToRecord where recordid = ?", id); err != nil {
+			return after, false, err
+		}
+		for _, tag := range tags {
+			silo.tag_cache.Delete(tag)
+		}
+		removed = removed + len(tags)
+	}
+	job.add(func(c *compactStats) { c.TagRowsRemoved += removed })
+	return ids[len(ids)-1], len(ids) < job.batch, nil
+}
+
+// Remove the strings of a batch, up to the id last, that no record uses as a tag or a filename
+func (s *SqlStore) compactStrings(silo *tagSilo, job *compactJob, after int, last int) (int, bool, error) {
+	silo.writeMutex.Lock()
+	defer silo.writeMutex.Unlock()
+	type row struct {
+		id  int
+		val []byte
+	}
+	batch := []row{}
+	rows, err := s.Db.Query("select id, value from StringTable where id > ? and id <= ? order by id limit ?", after, last, job.batch)
+	if err != nil {
+		return after, false, err
+	}
+	for rows.Next() {
+		r := row{}
+		if err := rows.Scan(&r.id, &r.val); err != nil {
+			rows.Close()
+			return after, false, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if len(batch) == 0 {
+		return after, true, nil
+	}
+	removed := 0
+	for _, r := range batch {
+		var used int
+		err := s.Db.QueryRow("select exists(select 1 from TagToRecord where tagid = ?) or exists(select 1 from RecordTable where filename = ?)", r.id, r.id).Scan(&used)
+		if err != nil {
+			return after, false, err
+		}
+		if used != 0 {
+			continue
+		}
+		if _, err := s.Db.Exec("delete from SymbolTable where id = ? and value = ?", r.val, r.id); err != nil {
+			return after, false, err
+		}
+		if _, err := s.Db.Exec("delete from StringTable where id = ?", r.id); err != nil {
+			return after, false, err
+		}
+		silo.string_cache.Delete(r.id)
+		silo.symbol_cache.Delete(string(r.val))
+		removed++
+	}
+	job.add(func(c *compactStats) { c.StringsRemoved += removed })
+	return batch[len(batch)-1].id, len(batch) < job.batch, nil
+}
+
+// Run VACUUM if enough of the file is free pages.  Inserts to the silo wait until it finishes
+func (s *SqlStore) compactVacuum(silo *tagSilo, job *compactJob) error {
+	var pages, free, pageSize int64
+	if err := s.Db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return err
+	}
+	if err := s.Db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return err
+	}
+	if err := s.Db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return err
+	}
+	if pages == 0 || float64(free) < compactVacuumRatio*float64(pages) {
+		return nil
+	}
+	silo.writeMutex.Lock()
+	_, err := s.Db.Exec("VACUUM")
+	silo.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	s.Flush(silo)
+	var after int64
+	if err := s.Db.QueryRow("PRAGMA page_count").Scan(&after); err != nil {
+		return err
+	}
+	job.add(func(c *compactStats) { c.BytesFreed += (pages - after) * pageSize })
+	return nil
+}
+
+func (s *SqlStore) Close(silo *tagSilo) error {
+	if err := s.Db.Close(); err != nil {
+		return fmt.Errorf("while closing %v: %v", silo.filename, err)
+	}
+	return nil
+}
+
+func (s *SqlStore) PredictStrings(silo *tagSilo, prefix string, limit int) []string {
+	silo.count("sql_select")
+	out := []string{}
+	//Compared as bytes.  LIKE would treat % and _ in the prefix as wildcards, and ignore case
+	rows, err := s.Db.Query("select value from StringTable where substr(cast(value as blob), 1, length(?1)) = ?1 limit ?2", []byte(prefix), limit)
+	if err != nil {
+		silo.LogChan["warning"] <- fmt.Sprintln("While predicting from StringTable: ", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var val string
+		if err := rows.Scan(&val); err == nil {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+// Symbols of the strings that start with prefix, from the primary key of SymbolTable.  Every one of them if limit is 0
+func (s *SqlStore) SymbolsWithPrefix(silo *tagSilo, prefix string, limit int) []int {
+	silo.count("sql_select")
+	out := []int{}
+	if limit <= 0 {
+		limit = -1
+	}
+	query := "select value from SymbolTable where id >= ?1 order by id limit ?3"
+	end := prefixEnd([]byte(prefix))
+	if end != nil {
+		query = "select value from SymbolTable where id >= ?1 and id < ?2 order by id limit ?3"
+	}
+	rows, err := s.Db.Query(query, []byte(prefix), end, limit)
+	if err != nil {
+		silo.LogChan["warning"] <- fmt.Sprintln("While reading symbols from SymbolTable: ", err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sym int
+		if err := rows.Scan(&sym); err == nil {
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+// The smallest byte string that is after every string starting with prefix, or nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 func (s *SqlStore) StoreRecordId(key []byte, val []byte) {
 	panic("Don't use this")
 	stmt, err := s.Dbh().Prepare("insert or replace into TagToRecordTable(id, value) values(?, ?)")
@@ -7,6 +7,7 @@ import (
 	"database/sql/driver"
 	"encoding/gob"
 	"sync"
+	"time"
 
 	syncmap "github.com/donomii/genericsyncmap"
 	"github.com/tchap/go-patricia/patricia"
@@ -17,12 +18,47 @@ var RpcPath = "/rpc/json/"
 
 // RPC
 type Args struct {
-	A     string
-	Limit int
+	A          string
+	Limit      int
+	Index      string    //Index to search.  Empty for the default index, "a,b" for several indexes, "*" for every index
+	Sort       string    //Metadata field to sort by, "-field" for largest first.  Default: best score first
+	GroupBy    string    //"file" to return one group per file in Groups, with Limit groups.  Empty for one result per record
+	GroupLines int       //The number of lines to keep in each group.  Default: 3
+	Match      string    //"any" (the default) finds records with any of the words, "all" only those with every word, "minimum_should_match=N" those with at least N
+	Mode       string    //"tags" (the default), "vector" for the nearest vectors, or "hybrid" for both, fused by rank
+	Vector     []float32 //The query's vector, for vector and hybrid searches.  Default: the server's embedder applied to A
+	Restricted bool      //Search as a caller holding only the ViewAs principals.  Needs an admin token, and is used by servers searching their remote farms
+	ViewAs     []string  //The principals to search as, with Restricted
+	Token      string    //API token, needed when the server has tokens configured
 }
 
 type Reply struct {
-	C []ResultRecordTransmittable
+	C       []ResultRecordTransmittable
+	Groups  []ResultGroup `json:",omitempty"` //Set by searches with a GroupBy.  C then holds the lines of every group, in order
+	Partial []string      `json:",omitempty"` //Remote farms that did not answer in time, so their results are missing
+}
+
+// The best lines of one file, and the number of its lines that matched
+type ResultGroup struct {
+	Filename string
+	Hits     int
+	Score    string //The score of the best line
+	Lines    []ResultRecordTransmittable
+}
+
+// One farm's results, sent by streaming searches as soon as the farm is finished
+type SearchEvent struct {
+	Farm string
+	C    []ResultRecordTransmittable
+}
+
+// The last message of a streaming search.  C holds the merged results from every farm
+type SearchSummary struct {
+	Farms        int
+	Total        int
+	Milliseconds int64
+	C            []ResultRecordTransmittable
+	Partial      []string `json:",omitempty"` //Remote farms that did not answer in time
 }
 
 type StringListReply struct {
@@ -30,14 +66,135 @@ type StringListReply struct {
 }
 
 type InsertArgs struct {
+	Name       string
+	Position   int
+	Tags       []string
+	Principals []string          //If set, only tokens holding one of these principals can find the record
+	Index      string            //Index to store the record in.  Empty for the default index
+	Metadata   map[string]string //Fields that queries can filter and sort on, e.g. "mtime", "size", "author"
+	Vector     []float32         //For vector searches.  Default: the server's embedder applied to Tags, if it has one
+	Token      string
+}
+
+type BatchInsertArgs struct {
+	Records []InsertArgs
+	BatchID string //If set, a batch sent again with the same BatchID is not stored twice, and gets the first reply.  The server remembers the last 10000
+	Token   string
+}
+
+type DeleteArgs struct {
+	Name     string
+	Position int
+	AllLines bool   //Delete every record for Name, ignoring Position
+	Index    string //Empty for the default index, "a,b" for several indexes, "*" for every index
+	Token    string
+}
+
+// Create a new index, with one farm
+type CreateIndexArgs struct {
+	Name     string
+	Location string //Directory for the farm's silos.  Default: database/<Name>
+	Mode     string //"memory" or "disk".  Default: "disk"
+	Silos    int    //Default: 1
+	Token    string
+}
+
+// Add silos to a disk farm, or drain one
+type SiloArgs struct {
+	Index string //Empty for the default index
+	Farm  string //Location of the farm.  Empty if the index has one farm
+	Silos int    //The number of silos to add, for AddSilos
+	Silo  string //The id of the silo to drain, for DrainSilo, or to compact, for Compact.  Compact does every silo if it is empty
+	Token string
+}
+
+// One insert or delete in the replication log
+type ReplicationEntry struct {
+	Seq      int64
+	Index    string
+	Delete   bool
 	Name     string
 	Position int
-	Tags     []string
+	AllLines bool             //Deletes only
+	Tags     []string         //Inserts only.  The reserved tags for access lists, metadata and vectors are already added
+	Create   *CreateIndexArgs //Index creations only, without the token
+}
+
+// A batch of the leader's replication log, sent to a follower
+type ReplicateArgs struct {
+	Log     string //Id of the leader's log.  A follower sent a new log starts again from its first entry
+	Last    int64  //The leader's newest entry, so the follower knows how far behind it is
+	Entries []ReplicationEntry
+	Token   string
+}
+
+type ReplicateReply struct {
+	Applied int64  //The last entry the follower has applied.  The leader sends on from there
+	Reason  string //Why the follower stopped, if it did
+}
+
+// Write a backup to a directory on the server, or restore one
+type BackupArgs struct {
+	Dir   string //Directory on the server.  Backups need a new or empty directory
+	Token string
+}
+
+type BackupReply struct {
+	Success  bool
+	Reason   string
+	Manifest *BackupManifest `json:",omitempty"`
+}
+
+// The contents of a backup, saved in its manifest.toml
+type BackupManifest struct {
+	Version         int
+	Created         time.Time
+	ReplicationLog  string //The replication log at the time of the backup
+	ReplicationLast int64  //The last entry of ReplicationLog in the backup
+	Farms           []BackupFarm
+	Skipped         []string //Remote farms, which are backed up by their own servers
+}
+
+type BackupFarm struct {
+	Index    string
+	Location string
+	Memory   bool
+	Silos    []BackupSilo
+}
+
+type BackupSilo struct {
+	Id     string
+	File   string //Relative to the backup directory
+	Size   int64
+	SHA256 string
+}
+
+// Find records like the one for Name at Position
+type SimilarArgs struct {
+	Name       string
+	Position   int
+	Limit      int
+	Index      string   //Empty for the default index, "a,b" for several indexes, "*" for every index
+	Restricted bool     //As for Args
+	ViewAs     []string //As for Args
+	Token      string
+}
+
+// Report that a user opened a search result
+type ClickArgs struct {
+	Name     string
+	Position int
+	Token    string
 }
 
 type SuccessReply struct {
-	Success bool
-	Reason  string
+	Success  bool
+	Reason   string
+	Inserted int `json:",omitempty"` //Records stored by InsertRecords.  A refused batch had its first Inserted records stored
+}
+
+type DeleteReply struct {
+	Deleted int
 }
 
 type StatusReply struct {
@@ -89,21 +246,35 @@ type tagSilo struct {
 	maxRecords           int
 	Operational          bool
 	ReadOnly             bool
+	draining             bool          //True while the silo's records are moved out.  New records are not routed to it
+	inputLock            sync.RWMutex  //Held to send to InputRecordCh, and to close it
+	inputClosed          bool          //True once InputRecordCh is closed.  See close
+	stop                 chan struct{} //Closed when the silo closes, to wake its sleeping workers
+	useLock              sync.RWMutex  //Read locked by searches, so the silo is not closed under them.  See Farm.useSilos
 	string_cache         *syncmap.SyncMap[int, string]
 	//symbol_cache         map[string]int
-	symbol_cache *syncmap.SyncMap[string, int]
-	tag_cache    *syncmap.SyncMap[int, []int]
-	record_cache *syncmap.SyncMap[int, record]
-	threadsWait  sync.WaitGroup
-	dirty        bool
-	LockLog      chan string
-	LogChan      map[string]chan string
-	Store        SiloStore
+	symbol_cache    *syncmap.SyncMap[string, int]
+	tag_cache       *syncmap.SyncMap[int, []int]
+	record_cache    *syncmap.SyncMap[int, record]
+	vectorLock      sync.RWMutex
+	vectors         map[vectorKey][]float32 //The silo's vector store, in memory.  See vector.go
+	threadsWait     sync.WaitGroup
+	dirty           bool
+	checkpointError string //Why the checkpoint could not be read.  Set, the silo does not write a checkpoint
+	LockLog         chan string
+	LogChan         map[string]chan string
+	Store           SiloStore
 }
 
 type tomlConfig struct {
-	Server server `toml:"database"`
-	Farms  map[string]serverInfo
+	Server      server `toml:"database"`
+	Farms       map[string]serverInfo
+	Tokens      map[string]tokenInfo
+	TLS         tlsInfo
+	Ranking     rankingInfo
+	Vectors     vectorInfo
+	Replication replicationInfo
+	Compaction  compactionInfo
 }
 
 type server struct {
@@ -119,8 +290,59 @@ type serverInfo struct {
 	Mode     string //"memory" or "disk"
 	Offload  bool   //Should the farm manager automatically move data out of these silos?
 	Size     int    //Maximum number of records to store in a silo.  Ignored for disk DBs
+	Index    string //The index this farm belongs to.  Default: "default"
+
+	Remote      string //Address of another tagserver, "host:port", that keeps this farm's records.  Location, Silos, Mode, Offload and Size are then ignored
+	RemoteIndex string //The index on the remote server.  Default: "default"
+	Token       string //Token for the remote server.  It needs admin scope if the remote server has tokens configured
+	Timeout     int    //Milliseconds to wait for the remote server.  Default: 5000
+	TLS         bool   //Connect to the remote server with TLS.  Implied by CA and Cert
+	CA          string //CA certificate that signed the remote server's certificate.  Default: the system roots
+	Cert        string //Client certificate, for remote servers that set ClientCA
+	Key         string //Private key for Cert
 }
 
+type tokenInfo struct {
+	Token      string   //The secret that clients send
+	Scope      string   //"read", "write" or "admin".  Each scope includes the ones before it
+	Principals []string //Users and groups this token acts for, e.g. "user:alice", "group:hr".  Records with an access list are only found by tokens that share a principal with it
+}
+
+type rankingInfo struct {
+	RecencyWeight    float64 //Points added to a record modified just now.  The boost halves every RecencyHalfLife days.  0 turns it off
+	RecencyHalfLife  float64 //Days.  Default: 30
+	RecencyField     string  //Metadata field holding the record's time.  Default: "mtime"
+	PopularityWeight float64 //Points added each time the number of opens of a record doubles.  0 turns it off
+}
+
+type vectorInfo struct {
+	Embedder   string //"hash" for the hash embedder, a stand-in for a real model.  Empty for none, so only records and searches that carry a vector use vectors
+	Dimensions int    //Length of the embedder's vectors.  Default: 256
+}
+
+type replicationInfo struct {
+	Role      string   //"leader" or "follower".  Default: "leader"
+	Followers []string //Addresses of follower tagservers, "host:port", to send the log to.  A follower sends to them once it is promoted
+	Token     string   //Token for the followers.  It needs admin scope if they have tokens configured
+	Timeout   int      //Milliseconds to wait for a follower.  Default: 5000
+	LogSize   int      //Entries kept for followers that fall behind.  Default: 100000
+}
+
+type compactionInfo struct {
+	Interval int //Hours between compactions of every disk farm.  Default: 0, only when asked with the Compact RPC
+	Pause    int //Milliseconds to wait between batches, so searches and inserts are not held up.  Default: 50
+	Batch    int //Rows handled in each batch.  Default: 1000
+}
+
+type tlsInfo struct {
+	Cert     string //PEM certificate file for the listeners.  Leave Cert and Key empty to serve plain text
+	Key      string //PEM private key file for Cert
+	ClientCA string //If set, clients must present a certificate signed by this CA
+}
+
+// Settings for one farm, as read from a [Farms.name] section of tagdb.conf
+type FarmConfig = serverInfo
+
 type fingerPrint []int
 
 type searchPrint struct {
@@ -154,22 +376,36 @@ type ResultRecordTransmittable struct {
 	Fingerprint []string
 	Sample      string
 	Score       string
+	Metadata    map[string]string `json:",omitempty"`
 }
 
 type resultRecordCollection []resultRecord
 type ResultRecordTransmittableCollection []ResultRecordTransmittable
 
 type SiloStore interface {
-	Init(silo *tagSilo)
+	Init(silo *tagSilo) error
 	GetString(s *tagSilo, index int) string
 	GetSymbol(silo *tagSilo, aStr string) int
 	InsertRecord(silo *tagSilo, key []byte, aRecord record)
 	InsertStringAndSymbol(silo *tagSilo, aStr string)
 	Flush(silo *tagSilo)
 	GetRecordId(tagID int) []int
+	CountRecordId(tagID int) int
+	IntersectRecordIds(tagIDs []int) []int
 	StoreRecordId(key, val []byte)
 	GetRecord(key []byte) record
 	StoreTagToRecord(recordId int, fp fingerPrint)
+	DeleteRecords(silo *tagSilo, filename int, line int, allLines bool) int
+	FindRecords(silo *tagSilo, filename int, line int) []record
+	PredictStrings(silo *tagSilo, prefix string, limit int) []string
+	SymbolsWithPrefix(silo *tagSilo, prefix string, limit int) []int
+	RecordsAfter(silo *tagSilo, after int, limit int) ([]int, []record)
+	StoreVector(silo *tagSilo, filename string, line int, v []float32)
+	DeleteVectors(silo *tagSilo, filename string, line int, allLines bool)
+	Vectors(silo *tagSilo) map[vectorKey][]float32
+	Backup(silo *tagSilo, filename string) error
+	Compact(silo *tagSilo, job *compactJob) error
+	Close(silo *tagSilo) error
 }
 
 type SqlStore struct {
@@ -12,6 +12,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +32,26 @@ var FragsString = "\\b|\\p{Z}+|\\p{C}|\\s+|\\/+|\\.+|\\\\+|_+|\\\\(|\\\\)"
 var rpcClient *rpc.Client
 
 var debug = false
+
+// Turn on extra logging, for programs that do not use StartServer's flags
+func SetDebug(on bool) {
+	debug = on
+}
+
+// Log, if debugging is on
+func Debugf(format string, args ...interface{}) {
+	if debug {
+		log.Printf(format, args...)
+	}
+}
+
+// Log, if debugging is on
+func Debugln(args ...interface{}) {
+	if debug {
+		log.Println(args...)
+	}
+}
+
 var profile = true
 
 var wg = sync.WaitGroup{}
@@ -56,17 +77,28 @@ func (s *tagSilo) resultsToTransmittable(input resultRecordCollection) []ResultR
 	output := []ResultRecordTransmittable{}
 	for _, v := range input {
 		printStrings := []string{}
+		var meta map[string]string
 		for _, f := range v.fingerprint {
-			printStrings = append(printStrings, s.getString(f))
+			tag := s.getString(f)
+			if key, value, ok := parseMetaTag(tag); ok {
+				if meta == nil {
+					meta = map[string]string{}
+				}
+				meta[key] = value
+			}
+			if !isReservedTag(tag) {
+				printStrings = append(printStrings, tag)
+			}
 		}
-		output = append(output, ResultRecordTransmittable{v.filename, fmt.Sprintf("%v", v.line), printStrings, v.sample, fmt.Sprintf("%v", v.score)})
+		output = append(output, ResultRecordTransmittable{Filename: v.filename, Line: fmt.Sprintf("%v", v.line), Fingerprint: printStrings, Sample: v.sample, Score: fmt.Sprintf("%v", v.score), Metadata: meta})
 	}
 	return output
 }
 
+// Scores are compared as numbers, so that "10" beats "9"
 func (r ResultRecordTransmittableCollection) Less(i, j int) bool {
-	a := r[i].Score
-	b := r[j].Score
+	a, _ := strconv.Atoi(r[i].Score)
+	b, _ := strconv.Atoi(r[j].Score)
 	if a > b {
 		return true
 	} else {
@@ -108,6 +140,19 @@ func uniqStrings(strings []string) []string {
 	return out
 }
 
+// The distinct values of a list, in the order they first appear
+func uniqInts(in []int) []int {
+	seen := map[int]bool{}
+	out := []int{}
+	for _, v := range in {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func ReSplit(seps []string, in []string) []string {
 
 	for _, sep := range seps {
@@ -236,7 +281,7 @@ func testRPC(serverAddress string) {
 		log.Println("dialing:", err)
 		shutdown()
 	}
-	args := &Args{"the", 10}
+	args := &Args{A: "the", Limit: 10}
 	preply := &Reply{}
 	err = client.Call("TagResponder.SearchString", args, preply)
 	if err != nil {
@@ -286,6 +331,7 @@ func StartServer() *Manor {
 	flag.IntVar(&preAllocSize, "preAlloc", preAllocSize, fmt.Sprintf("Allocate this many entries at startup.  Default: %v", preAllocSize))
 	flag.BoolVar(&debug, "debug", debug, fmt.Sprintf("Print extra debugging information.  Default: %v", debug))
 	flag.StringVar(&config_location, "config", config_location, "Config file to load settings from")
+	flag.StringVar(&GrpcAddress, "grpc", GrpcAddress, "Address for the gRPC listener.  Empty to disable")
 
 	flag.Parse()
 
@@ -295,14 +341,49 @@ func StartServer() *Manor {
 		os.Exit(1)
 	}
 
+	if err := loadTokens(config.Tokens); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := checkReplication(config.Replication); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tlsConfig, err := loadServerTLS(config.TLS)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	serverTLS = tlsConfig
+
 	//Blank entry at 0
 
 	log.Println("Starting rpc server on ", ServerAddress)
-	log.Println("Loaded config: ", config)
+	log.Println("Loaded config: ", redactedConfig(config))
 
 	manor := CreateManor(config)
+	if err := manor.loadIndexes(config_location + ".indexes"); err != nil {
+		fmt.Println("Could not load created indexes: ", err)
+		os.Exit(1)
+	}
+	if err := manor.rank.load(config_location + ".clicks"); err != nil {
+		fmt.Println("Could not load click counts: ", err)
+		os.Exit(1)
+	}
+	manor.workers.Add(1)
+	go func() {
+		defer manor.workers.Done()
+		manor.rank.saveWorker(manor.stop)
+	}()
+	if err := manor.repl.load(config_location + ".replication"); err != nil {
+		fmt.Println("Could not load the place in the replication log: ", err)
+		os.Exit(1)
+	}
 
 	go rpc_server(ServerAddress, manor)
+	if GrpcAddress != "" {
+		go grpc_server(GrpcAddress, manor)
+	}
 	rpcClient, _ = jsonrpc.Dial("tcp", ServerAddress)
 	return manor
 }
@@ -0,0 +1,87 @@
+// tls.go
+
+//TLS for the JSON-RPC, HTTP and gRPC listeners, set up from the [TLS] section of tagdb.conf, and for the connections to
+//remote farms
+
+package tagbrowser
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Set by StartServer before the listeners start.  nil means serve plain text
+var serverTLS *tls.Config
+
+// Build the listeners' TLS settings.  Returns nil if no certificate is configured
+func loadServerTLS(info tlsInfo) (*tls.Config, error) {
+	if info.Cert == "" && info.Key == "" {
+		if info.ClientCA != "" {
+			return nil, errors.New("TLS: ClientCA is set, but there is no Cert and Key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(info.Cert, info.Key)
+	if err != nil {
+		return nil, fmt.Errorf("TLS: could not load certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if info.ClientCA != "" {
+		pem, err := os.ReadFile(info.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("TLS: could not read ClientCA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS: no certificates found in %v", info.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		log.Println("TLS enabled, clients must present a certificate signed by ", info.ClientCA)
+	} else {
+		log.Println("TLS enabled")
+	}
+	return config, nil
+}
+
+// Build the TLS settings for a connection to a tagserver.
+// caFile is the CA that signed the server's certificate, or empty to use the system roots.
+// certFile and keyFile are the client's own certificate, for servers that verify clients.  Leave them empty otherwise
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// The TLS settings for a remote farm, or nil if the farm does not ask for TLS
+func remoteTLS(settings serverInfo) (*tls.Config, error) {
+	if !settings.TLS && settings.CA == "" && settings.Cert == "" {
+		return nil, nil
+	}
+	config, err := ClientTLSConfig(settings.CA, settings.Cert, settings.Key)
+	if err != nil {
+		return nil, fmt.Errorf("remote farm %v: %v", settings.Remote, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,76 @@
+// tls_test.go
+package tagbrowser
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write the certificate and key of config to files.  Returns their names
+func writeKeyPair(t *testing.T, config *tls.Config) (string, string) {
+	t.Helper()
+	cert := config.Certificates[0]
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestLoadServerTLS(t *testing.T) {
+	generated, caFile := testCertificate(t)
+	certFile, keyFile := writeKeyPair(t, generated)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+
+	if config, err := loadServerTLS(tlsInfo{}); config != nil || err != nil {
+		t.Errorf("without a certificate: %v, %v, want plain text", config, err)
+	}
+	for name, info := range map[string]tlsInfo{
+		"ClientCA without a certificate": {ClientCA: caFile},
+		"a missing key":                  {Cert: certFile, Key: missing},
+		"a missing ClientCA":             {Cert: certFile, Key: keyFile, ClientCA: missing},
+		"a ClientCA with no certificate": {Cert: certFile, Key: keyFile, ClientCA: keyFile},
+	} {
+		if _, err := loadServerTLS(info); err == nil {
+			t.Errorf("%v was loaded", name)
+		}
+	}
+	config, err := loadServerTLS(tlsInfo{Cert: certFile, Key: keyFile, ClientCA: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("client auth %v and minimum version %x", config.ClientAuth, config.MinVersion)
+	}
+}
+
+func TestRemoteFarmPresentsItsCertificate(t *testing.T) {
+	generated, caFile := testCertificate(t)
+	certFile, keyFile := writeKeyPair(t, generated)
+	config, err := loadServerTLS(tlsInfo{Cert: certFile, Key: keyFile, ClientCA: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTestManor(t, testManor(t, "memory", 1), config)
+
+	m := openTestManor(t, map[string]FarmConfig{"remote": {Remote: address, CA: caFile, Cert: certFile, Key: keyFile, Timeout: 2000}})
+	storeRemote(t, m, 3)
+
+	anonymous := openTestManor(t, map[string]FarmConfig{"remote": {Remote: address, CA: caFile, Timeout: 2000}})
+	if got := anonymous.Farms[0].status()["remote.reachable"]; got != "false" {
+		t.Errorf("a farm without a client certificate reached a server that needs one, reachable is %v", got)
+	}
+}
@@ -0,0 +1,455 @@
+// vector.go
+
+//Vector search.  A record may carry a dense vector, sent by the client with the record, or made by the server's embedder
+//from the record's tags.  Searches with Mode "vector" return the records whose vectors are nearest the query's vector,
+//and Mode "hybrid" fuses those results with the normal tag results by reciprocal rank fusion.
+//
+//A record is sent to its silo with its vector in a reserved tag, so the vector travels with the record through routing,
+//remote farms and replication.  The silo takes the tag out before the record is stored, and keeps the vector in its
+//vector store, by file and line: the Vectors table in disk silos, and a section of the checkpoint in memory silos.  Each
+//silo keeps its vectors in memory as well, and compares the query with every one of them, so a search reads only the
+//records of the vectors it returns.  That is exact, and quick enough for a few hundred thousand vectors per silo.
+//
+//Records stored before the vector store kept their vectors as tags.  Disk silos move them to the Vectors table in
+//schema version 3, and memory silos read them from the records when their checkpoint is loaded.
+
+package tagbrowser
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const vectorTagPrefix = "\x1fvec:"
+
+// Every record with a vector also carries this tag, so a silo can find its vectors without reading every record
+const vectorMarkerTag = vectorTagPrefix
+
+const maxVectorDimensions = 4096
+
+// Vector scores are the cosine similarity in thousandths
+const vectorScale = 1000
+
+// Reciprocal rank fusion gives a record 1/(rrfK + rank) for each list it is in
+const rrfK = 60
+
+// Fused scores are multiplied by this, so they are still in order after they are rounded to whole scores
+const rrfScale = 10000
+
+const (
+	SearchModeTags   = "tags"   //Match the words against the tags.  The default
+	SearchModeVector = "vector" //Nearest vectors to the query's vector
+	SearchModeHybrid = "hybrid" //Tag and vector results, fused by rank
+)
+
+// Check a search's Mode
+func checkSearchMode(mode string) error {
+	switch mode {
+	case "", SearchModeTags, SearchModeVector, SearchModeHybrid:
+		return nil
+	}
+	return fmt.Errorf("unknown Mode %q, use \"tags\", \"vector\" or \"hybrid\"", mode)
+}
+
+// Makes vectors from text, for records and queries that do not carry one
+type Embedder interface {
+	Embed(text string) ([]float32, error)
+	Dimensions() int
+}
+
+// A deterministic stand-in for an embedding model, for tests and small installs.  Each word is hashed to a dimension and
+// a sign, so texts that share words get similar vectors.  It knows nothing about meaning
+type HashEmbedder struct {
+	Dims int
+}
+
+func NewHashEmbedder(dims int) *HashEmbedder {
+	if dims < 1 {
+		dims = 256
+	}
+	return &HashEmbedder{Dims: dims}
+}
+
+func (h *HashEmbedder) Dimensions() int {
+	return h.Dims
+}
+
+func (h *HashEmbedder) Embed(text string) ([]float32, error) {
+	v := make([]float32, h.Dims)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		sum := fnv.New64a()
+		sum.Write([]byte(w))
+		x := sum.Sum64()
+		if x>>63 == 0 {
+			v[x%uint64(h.Dims)]++
+		} else {
+			v[x%uint64(h.Dims)]--
+		}
+	}
+	return v, nil
+}
+
+// The embedder named in the [Vectors] section of tagdb.conf, or nil if there is none
+func newEmbedder(settings vectorInfo) (Embedder, error) {
+	switch settings.Embedder {
+	case "":
+		return nil, nil
+	case "hash":
+		return NewHashEmbedder(settings.Dimensions), nil
+	}
+	return nil, fmt.Errorf("unknown embedder %q, use \"hash\", or call SetEmbedder", settings.Embedder)
+}
+
+func isVectorTag(tag string) bool {
+	return strings.HasPrefix(tag, vectorTagPrefix)
+}
+
+// Scale v to length 1, so that the dot product of two vectors is their cosine similarity
+func normalizeVector(v []float32) ([]float32, error) {
+	if len(v) > maxVectorDimensions {
+		return nil, fmt.Errorf("vector has %v dimensions, the most is %v", len(v), maxVectorDimensions)
+	}
+	sum := 0.0
+	for _, x := range v {
+		sum = sum + float64(x)*float64(x)
+	}
+	if sum == 0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return nil, errors.New("vector must have a length, and no NaN or infinite values")
+	}
+	length := math.Sqrt(sum)
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / length)
+	}
+	return out, nil
+}
+
+// A vector as little endian float32s, as the Vectors table keeps them
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(x))
+	}
+	return b
+}
+
+func decodeVector(b []byte) ([]float32, bool) {
+	if len(b) == 0 || len(b)%4 != 0 {
+		return nil, false
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, true
+}
+
+func vectorTag(v []float32) string {
+	return vectorTagPrefix + base64.RawStdEncoding.EncodeToString(encodeVector(v))
+}
+
+func parseVectorTag(tag string) ([]float32, bool) {
+	if !isVectorTag(tag) || tag == vectorMarkerTag {
+		return nil, false
+	}
+	b, err := base64.RawStdEncoding.DecodeString(tag[len(vectorTagPrefix):])
+	if err != nil {
+		return nil, false
+	}
+	return decodeVector(b)
+}
+
+// Add the vector tags to a record's tags
+func withVectorTag(tags []string, v []float32) []string {
+	if len(v) == 0 {
+		return tags
+	}
+	out := make([]string, 0, len(tags)+2)
+	for _, t := range tags {
+		if !isVectorTag(t) {
+			out = append(out, t)
+		}
+	}
+	return append(out, vectorMarkerTag, vectorTag(v))
+}
+
+// Take the vector tags out of a record's tags.  Returns the other tags, and the vector, or nil if there is none.  tags is
+// not changed
+func splitVectorTag(tags []string) ([]string, []float32) {
+	var v []float32
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if !isVectorTag(t) {
+			out = append(out, t)
+		} else if parsed, ok := parseVectorTag(t); ok {
+			v = parsed
+		}
+	}
+	return out, v
+}
+
+func dot(a, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum = sum + float64(a[i])*float64(b[i])
+	}
+	return sum
+}
+
+// Use e to make vectors for records and queries that do not carry one.  Call it before the servers start.  nil turns it off
+func (m *Manor) SetEmbedder(e Embedder) {
+	m.embedder = e
+}
+
+// The vector to store with a record: the one it was sent with, or one made from its tags.  nil if there is neither
+func (m *Manor) recordVector(tags []string, v []float32) ([]float32, error) {
+	if len(v) == 0 {
+		if m.embedder == nil {
+			return nil, nil
+		}
+		var err error
+		v, err = m.embedder.Embed(strings.Join(withoutReservedTags(tags), " "))
+		if err != nil {
+			return nil, err
+		}
+		if out, err := normalizeVector(v); err == nil {
+			return out, nil
+		}
+		//No words the embedder could use, so the record has no vector
+		return nil, nil
+	}
+	if m.embedder != nil && len(v) != m.embedder.Dimensions() {
+		return nil, fmt.Errorf("vector has %v dimensions, the server's embedder makes %v", len(v), m.embedder.Dimensions())
+	}
+	return normalizeVector(v)
+}
+
+// The vector to search with: the one sent with the search, or one made from its words
+func (m *Manor) queryVector(text string, v []float32) ([]float32, error) {
+	if len(v) == 0 {
+		if m.embedder == nil {
+			return nil, errors.New("vector searches need a Vector, as the server has no embedder")
+		}
+		var err error
+		v, err = m.embedder.Embed(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return normalizeVector(v)
+}
+
+// Parse a search's query and match mode, and work out its vector if the Mode needs one
+func (m *Manor) prepareQuery(args *Args) (searchQuery, error) {
+	q := parseQuery(args.A, args.Sort, m.fieldsIn(args.Index))
+	if err := checkSearchMode(args.Mode); err != nil {
+		return q, err
+	}
+	var err error
+	if q.match, err = parseMatchMode(args.Match); err != nil {
+		return q, err
+	}
+	if args.Mode == SearchModeVector || args.Mode == SearchModeHybrid {
+		if q.vector, err = m.queryVector(q.terms, args.Vector); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// Keep a record's vector in the silo's vector store, replacing any it had
+func (s *tagSilo) storeVector(filename string, line int, v []float32) {
+	s.vectorLock.Lock()
+	s.vectors[vectorKey{filename, line}] = v
+	s.vectorLock.Unlock()
+	if s.memory_db {
+		s.setDirty(true)
+		return
+	}
+	s.Store.StoreVector(s, filename, line, v)
+}
+
+// The vector of the record for filename at line, or nil if it has none
+func (s *tagSilo) vectorOf(filename string, line int) []float32 {
+	s.vectorLock.RLock()
+	defer s.vectorLock.RUnlock()
+	return s.vectors[vectorKey{filename, line}]
+}
+
+// Remove the vectors of filename's records.  If allLines is false, only the vector for line is removed
+func (s *tagSilo) deleteVectors(filename string, line int, allLines bool) {
+	s.vectorLock.Lock()
+	for key := range s.vectors {
+		if key.Filename == filename && (allLines || key.Line == line) {
+			delete(s.vectors, key)
+		}
+	}
+	s.vectorLock.Unlock()
+	if !s.memory_db {
+		s.Store.DeleteVectors(s, filename, line, allLines)
+	}
+}
+
+// Read the silo's vectors into memory, from the Vectors table, or from the checkpoint d of a memory silo.  Memory silos
+// also read the vectors that older records keep in their tags
+func (s *tagSilo) loadVectors(d *SerialiseMe) {
+	s.vectors = map[vectorKey][]float32{}
+	if !s.memory_db {
+		s.vectors = s.Store.Vectors(s)
+		return
+	}
+	if d != nil && d.Vectors != nil {
+		s.vectors = d.Vectors
+	}
+	marker := s.lookupSymbol(vectorMarkerTag)
+	if marker == 0 {
+		return
+	}
+	for _, r := range s.database {
+		marked := false
+		for _, sym := range r.Fingerprint {
+			marked = marked || sym == marker
+		}
+		if !marked || r.Filename == 0 {
+			continue
+		}
+		key := vectorKey{s.getString(r.Filename), r.Line}
+		if _, ok := s.vectors[key]; ok {
+			continue
+		}
+		for _, sym := range r.Fingerprint {
+			if v, ok := parseVectorTag(s.getString(sym)); ok {
+				s.vectors[key] = v
+				break
+			}
+		}
+	}
+}
+
+// A vector of the query's length, with its similarity to the query
+type vectorMatch struct {
+	key   vectorKey
+	score int
+}
+
+// The silo's vectors that are like the query's, best first
+func (s *tagSilo) nearestVectors(query []float32) []vectorMatch {
+	nearest := []vectorMatch{}
+	s.vectorLock.RLock()
+	for key, v := range s.vectors {
+		if len(v) != len(query) {
+			continue
+		}
+		if score := int(math.Round(vectorScale * dot(query, v))); score > 0 {
+			nearest = append(nearest, vectorMatch{key, score})
+		}
+	}
+	s.vectorLock.RUnlock()
+	sort.Slice(nearest, func(i, j int) bool {
+		if nearest[i].score != nearest[j].score {
+			return nearest[i].score > nearest[j].score
+		}
+		if nearest[i].key.Filename != nearest[j].key.Filename {
+			return nearest[i].key.Filename < nearest[j].key.Filename
+		}
+		return nearest[i].key.Line < nearest[j].key.Line
+	})
+	return nearest
+}
+
+// The records in the silo with a vector of the query's length that filter lets through, scored by the similarity of their
+// vectors to the query, best first.  The query's filters are applied, but the ranking boosts are not, as they are not on the
+// same scale.  Only the records of the vectors looked at are read, so when limit is above 0 the search stops at limit matches
+func (s *tagSilo) matchVectors(q searchQuery, filter aclFilter, limit int) []queryMatch {
+	matches := []queryMatch{}
+	seen := map[int]metaSymbol{}
+	for _, near := range s.nearestVectors(q.vector) {
+		for _, r := range s.findRecords(near.key.Filename, near.key.Line) {
+			if r.Filename == 0 || !filter.visible(r) {
+				continue
+			}
+			var meta map[string]string
+			if q.usesMetadata() {
+				meta = s.recordMetadata(r, seen)
+				if !q.matches(meta) {
+					continue
+				}
+			}
+			matches = append(matches, queryMatch{resultRecord{near.key.Filename, r.Line, r.Fingerprint, "", near.score}, meta})
+			break
+		}
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+// Run a tag search and a vector search, and fuse their results by reciprocal rank.  Each search looks twice as deep as
+// the results wanted, so records that are near the top of both lists are found
+func (m *Manor) hybridSearch(indexes string, q searchQuery, maxResults int, v *viewer) ([]ResultRecordTransmittable, error) {
+	depth := 2 * maxResults
+	tagQuery := q
+	tagQuery.vector = nil
+	var tagResults, vectorResults []ResultRecordTransmittable
+	var tagErr, vectorErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		tagResults, tagErr = m.streamFileDatabase(indexes, tagQuery, depth, v, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		vectorResults, vectorErr = m.streamFileDatabase(indexes, q, depth, v, nil)
+	}()
+	wg.Wait()
+	if tagErr != nil {
+		return nil, tagErr
+	}
+	if vectorErr != nil {
+		return nil, vectorErr
+	}
+
+	fused := map[string]float64{}
+	records := map[string]ResultRecordTransmittable{}
+	for _, list := range [][]ResultRecordTransmittable{tagResults, vectorResults} {
+		for rank, r := range list {
+			key := r.Filename + "\x00" + r.Line
+			fused[key] = fused[key] + 1/float64(rrfK+rank+1)
+			if _, ok := records[key]; !ok {
+				records[key] = r
+			}
+		}
+	}
+	results := ResultRecordTransmittableCollection{}
+	for key, r := range records {
+		r.Score = fmt.Sprintf("%v", int(math.Round(rrfScale*fused[key])))
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].Filename+"\x00"+results[i].Line, results[j].Filename+"\x00"+results[j].Line
+		if fused[a] != fused[b] {
+			return fused[a] > fused[b]
+		}
+		return a < b
+	})
+	if q.sortKey != "" {
+		q.sortResults(results)
+	}
+	if len(results) > maxResults {
+		results = results[0:maxResults]
+	}
+	return results, nil
+}
@@ -0,0 +1,182 @@
+// vector_test.go
+package tagbrowser
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The cosine similarity of the hash embeddings of two texts
+func hashSimilarity(t *testing.T, h *HashEmbedder, a, b string) float64 {
+	t.Helper()
+	va, _ := h.Embed(a)
+	vb, _ := h.Embed(b)
+	na, err := normalizeVector(va)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb, err := normalizeVector(vb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dot(na, nb)
+}
+
+func TestHashEmbedder(t *testing.T) {
+	if d := NewHashEmbedder(0).Dimensions(); d != 256 {
+		t.Errorf("default dimensions = %v, want 256", d)
+	}
+	h := NewHashEmbedder(64)
+	a, _ := h.Embed("The quick brown fox")
+	b, _ := h.Embed("the QUICK, brown fox!")
+	if len(a) != 64 {
+		t.Fatalf("vector has %v dimensions, want 64", len(a))
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Error("case and punctuation changed the vector")
+	}
+	if near, far := hashSimilarity(t, h, "quick brown fox", "quick fox"), hashSimilarity(t, h, "quick brown fox", "lazy sleeping dog"); near <= far {
+		t.Errorf("texts sharing words are %v alike, texts sharing none %v", near, far)
+	}
+	empty, _ := h.Embed("  ...  ")
+	if _, err := normalizeVector(empty); err == nil {
+		t.Error("a text without words has a vector")
+	}
+}
+
+func TestVectorTagRoundTrip(t *testing.T) {
+	v := []float32{0.5, -0.25, 1}
+	tags, got := splitVectorTag(withVectorTag([]string{"fox"}, v))
+	if !reflect.DeepEqual(tags, []string{"fox"}) || !reflect.DeepEqual(got, v) {
+		t.Errorf("split %v and %v, want [fox] and %v", tags, got, v)
+	}
+}
+
+// Insert records through the JSON-RPC server, so the server's embedder makes their vectors
+func insertEmbedded(t *testing.T, m *Manor, names map[string]string) {
+	t.Helper()
+	tr := &TagResponder{Manor: m}
+	for name, text := range names {
+		reply := &SuccessReply{}
+		tr.InsertRecord(&InsertArgs{Name: name, Position: 1, Tags: []string{text}}, reply)
+		if !reply.Success {
+			t.Fatalf("inserting %v: %v", name, reply.Reason)
+		}
+	}
+	for name := range names {
+		waitUntil(t, name, func() bool {
+			s := m.Farms[0].siloFor(name)
+			return s != nil && len(s.findRecords(name, 1)) > 0
+		})
+	}
+}
+
+// The files a vector search finds, best first
+func vectorSearch(t *testing.T, m *Manor, query string) []string {
+	t.Helper()
+	reply := &Reply{}
+	if err := (&TagResponder{Manor: m}).SearchString(&Args{A: query, Limit: 10, Mode: SearchModeVector}, reply); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, r := range reply.C {
+		got = append(got, r.Filename)
+	}
+	return got
+}
+
+func TestVectorStore(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			farms := map[string]FarmConfig{"test": {Location: filepath.Join(t.TempDir(), "farm"), Silos: 2, Mode: mode}}
+			m := openTestManor(t, farms)
+			m.SetEmbedder(NewHashEmbedder(64))
+			insertEmbedded(t, m, map[string]string{"fox.txt": "quick brown fox", "dog.txt": "lazy sleeping dog", "cat.txt": "quick grey cat"})
+
+			if got := vectorSearch(t, m, "brown fox"); len(got) == 0 || got[0] != "fox.txt" {
+				t.Errorf("found %v, want fox.txt first", got)
+			}
+			for _, s := range m.Farms[0].siloList() {
+				for _, r := range s.findRecords("fox.txt", 1) {
+					for _, sym := range r.Fingerprint {
+						if isVectorTag(s.getString(sym)) {
+							t.Errorf("record keeps the vector tag %q", s.getString(sym))
+						}
+					}
+				}
+			}
+
+			s := m.Farms[0].siloFor("dog.txt")
+			if s.vectorOf("dog.txt", 1) == nil {
+				t.Fatal("dog.txt has no vector")
+			}
+			s.deleteRecords("dog.txt", 1, false)
+			if s.vectorOf("dog.txt", 1) != nil {
+				t.Error("deleting dog.txt kept its vector")
+			}
+
+			if err := m.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			m = openTestManor(t, farms)
+			m.SetEmbedder(NewHashEmbedder(64))
+			got := vectorSearch(t, m, "brown fox")
+			if len(got) == 0 || got[0] != "fox.txt" {
+				t.Errorf("after reopening, found %v, want fox.txt first", got)
+			}
+			for _, name := range got {
+				if name == "dog.txt" {
+					t.Error("found the deleted dog.txt")
+				}
+			}
+		})
+	}
+}
+
+func TestVectorTagsOfOlderRecordsAreRead(t *testing.T) {
+	v, _ := normalizeVector([]float32{1, 2, 3})
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "farm")
+			f := openTestFarm(t, location, mode, 1)
+			s := f.siloList()[0]
+			//Stored as older servers did, with the vector in the record's tags
+			s.InputRecordCh <- RecordTransmittable{"old.txt", 1, withVectorTag([]string{"fox"}, v)}
+			waitUntil(t, "old.txt", func() bool { return len(s.findRecords("old.txt", 1)) > 0 })
+			filename := s.filename
+			if err := f.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+			if mode == "disk" {
+				db, err := sql.Open("sqlite3", filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, stmt := range []string{"drop table Vectors", "delete from schema_version where version = 3"} {
+					if _, err := db.Exec(stmt); err != nil {
+						t.Fatal(err)
+					}
+				}
+				db.Close()
+			}
+
+			s = openTestFarm(t, location, mode, 1).siloList()[0]
+			if got := s.vectorOf("old.txt", 1); !reflect.DeepEqual(got, v) {
+				t.Errorf("vector of old.txt is %v, want %v", got, v)
+			}
+			got := s.matchVectors(searchQuery{vector: v}, aclFilter{}, 10)
+			if len(got) != 1 || got[0].r.filename != "old.txt" {
+				t.Errorf("vector search found %+v, want old.txt", got)
+			}
+			if mode == "disk" {
+				for _, r := range s.findRecords("old.txt", 1) {
+					if len(r.Fingerprint) != 1 {
+						t.Errorf("migrated record has %v tags, want only fox", len(r.Fingerprint))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// tagdb.go
+
+// Package tagdb opens a tagdb index inside your own program.
+//
+// There is no server, no listeners, and no flags or config file are read.  The index is the same Manor that
+// tagserver runs, so the files it writes can later be served by tagserver.
+//
+// To build the command line tools, see the cmd directory.
+package tagdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+var ErrClosed = errors.New("tagdb: database is closed")
+
+type Config struct {
+	// Farms to create, keyed by name.  These are the same settings as the [Farms.name] sections of tagdb.conf
+	Farms map[string]tagbrowser.FarmConfig
+}
+
+type DB struct {
+	manor  *tagbrowser.Manor
+	lock   sync.RWMutex
+	closed bool
+}
+
+func (c Config) validate() error {
+	if len(c.Farms) == 0 {
+		return errors.New("tagdb: no farms configured")
+	}
+	defaultIndex := false
+	for name, f := range c.Farms {
+		if index := strings.TrimSpace(f.Index); index == "" || index == tagbrowser.DefaultIndex {
+			defaultIndex = true
+		}
+		if f.Mode != "memory" && f.Mode != "disk" {
+			return fmt.Errorf("tagdb: farm %v: mode must be \"memory\" or \"disk\", not %q", name, f.Mode)
+		}
+		if f.Location == "" {
+			return fmt.Errorf("tagdb: farm %v has no location", name)
+		}
+		if f.Silos < 1 && !f.Offload {
+			return fmt.Errorf("tagdb: farm %v needs at least one silo", name)
+		}
+	}
+	if !defaultIndex {
+		return fmt.Errorf("tagdb: no farm is in the index %q, which Insert and Search use", tagbrowser.DefaultIndex)
+	}
+	return nil
+}
+
+// Create or reopen the farms in config.  Debugging output is turned on for the whole process, with tagbrowser.SetDebug
+func Open(config Config) (*DB, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	manor, err := tagbrowser.NewManor(config.Farms)
+	if err != nil {
+		return nil, fmt.Errorf("tagdb: %v", err)
+	}
+	return &DB{manor: manor}, nil
+}
+
+// The manor holding the farms, for callers that need more than the DB methods
+func (db *DB) Manor() *tagbrowser.Manor {
+	return db.manor
+}
+
+// Queue a record for storage.  Records are stored in the background, so a search straight after Insert may not find it
+// yet.  Returns an error if the record could not be queued
+func (db *DB) Insert(name string, position int, tags []string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	err := db.manor.SubmitRecordTo(tagbrowser.DefaultIndex, tagbrowser.RecordTransmittable{Filename: name, Line: position, Fingerprint: tags})
+	if err != nil {
+		return fmt.Errorf("tagdb: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) Search(query string, limit int) ([]tagbrowser.ResultRecordTransmittable, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return db.manor.Search(query, limit), nil
+}
+
+func (db *DB) Predict(prefix string, limit int) ([]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return db.manor.Predict(prefix, limit), nil
+}
+
+// Delete the record at position, or every record for name if allLines is set.  Returns the number of records deleted
+func (db *DB) Delete(name string, position int, allLines bool) (int, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		return 0, ErrClosed
+	}
+	return db.manor.DeleteRecords(name, position, allLines), nil
+}
+
+func (db *DB) Status() (map[string]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return db.manor.Status(), nil
+}
+
+// Stop accepting records, store the ones already queued, then write every silo to disk and close its file
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+	return db.manor.Shutdown()
+}
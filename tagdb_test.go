@@ -0,0 +1,65 @@
+// tagdb_test.go
+package tagdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/donomii/tagdb/tagbrowser"
+)
+
+func openTestDB(t *testing.T, location string) *DB {
+	t.Helper()
+	db, err := Open(Config{Farms: map[string]tagbrowser.FarmConfig{
+		"main": {Location: location, Silos: 1, Mode: "disk"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCloseWritesRecordsAndReopens(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "farm")
+	db := openTestDB(t, location)
+	for i := 0; i < 50; i++ {
+		if err := db.Insert("story.txt", i, []string{"quick", "fox"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("Close took %v", took)
+	}
+	if err := db.Close(); err != ErrClosed {
+		t.Errorf("second Close returned %v, want ErrClosed", err)
+	}
+	if err := db.Insert("story.txt", 99, []string{"fox"}); err != ErrClosed {
+		t.Errorf("Insert after Close returned %v, want ErrClosed", err)
+	}
+
+	db = openTestDB(t, location)
+	defer db.Close()
+	res, err := db.Search("fox", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 50 {
+		t.Fatalf("found %v records after reopening, want 50", len(res))
+	}
+}
+
+func TestOpenReportsBadConfig(t *testing.T) {
+	_, err := Open(Config{Farms: map[string]tagbrowser.FarmConfig{"main": {Location: t.TempDir(), Silos: 1, Mode: "tape"}}})
+	if err == nil {
+		t.Fatal("Open accepted mode tape")
+	}
+	_, err = Open(Config{Farms: map[string]tagbrowser.FarmConfig{"main": {Location: t.TempDir(), Silos: 1, Mode: "memory", Index: "logs"}}})
+	if err == nil {
+		t.Fatal("Open accepted farms that are all in another index")
+	}
+}
@@ -0,0 +1,49 @@
+// client.go
+
+// Package tagdbpb holds the gRPC messages, service description and client for tagserver.
+//
+// tagdb.pb.go and tagdb_grpc.pb.go are generated from tagdb.proto.  After changing it, run go generate in this directory,
+// with protoc, protoc-gen-go and protoc-gen-go-grpc on the PATH.
+package tagdbpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tagdb.proto
+
+import (
+	"context"
+	"crypto/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Connect to a tagserver gRPC port, and return a client for it.  The connection is plain text unless WithTLS is passed
+func Dial(address string, opts ...grpc.DialOption) (TagDBClient, *grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewTagDBClient(conn), conn, nil
+}
+
+// Connect with TLS instead of plain text
+func WithTLS(config *tls.Config) grpc.DialOption {
+	return grpc.WithTransportCredentials(credentials.NewTLS(config))
+}
+
+// Sends token with every call, for servers that have tokens configured
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(tokenCredentials(token))
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// The token is sent on plain connections too, so that it works with Dial
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
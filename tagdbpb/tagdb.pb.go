@@ -0,0 +1,1264 @@
+// tagdb.proto
+//
+// The gRPC interface to tagserver.  Generate stubs for other languages with protoc, e.g.
+//
+//     protoc --python_out=. --grpc_python_out=. tagdb.proto
+//
+// The Go code in this directory is generated from this file by go generate, see client.go.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: tagdb.proto
+
+package tagdbpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SearchRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Query string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Limit int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Index to search.  Empty for the default index, "a,b" for several, "*" for all of them
+	Index string `protobuf:"bytes,3,opt,name=index,proto3" json:"index,omitempty"`
+	// Metadata field to sort by, "-field" for descending.  Empty to sort by score
+	Sort string `protobuf:"bytes,4,opt,name=sort,proto3" json:"sort,omitempty"`
+	// "file" to return one group per file in SearchReply.groups, with limit groups.  Search only, SearchStream ignores it
+	GroupBy string `protobuf:"bytes,5,opt,name=group_by,json=groupBy,proto3" json:"group_by,omitempty"`
+	// Lines to keep in each group.  Default: 3
+	GroupLines int32 `protobuf:"varint,6,opt,name=group_lines,json=groupLines,proto3" json:"group_lines,omitempty"`
+	// "tags" (the default), "vector" for the nearest vectors, or "hybrid" to fuse both by rank.  SearchStream does not take "hybrid"
+	Mode string `protobuf:"bytes,7,opt,name=mode,proto3" json:"mode,omitempty"`
+	// The query's vector, for vector and hybrid searches.  Default: the server's embedder applied to query
+	Vector []float32 `protobuf:"fixed32,8,rep,packed,name=vector,proto3" json:"vector,omitempty"`
+	// "any" (the default), "all", or "minimum_should_match=N": how many of the query's words a record needs
+	Match         string `protobuf:"bytes,9,opt,name=match,proto3" json:"match,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_tagdb_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SearchRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetGroupBy() string {
+	if x != nil {
+		return x.GroupBy
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetGroupLines() int32 {
+	if x != nil {
+		return x.GroupLines
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetVector() []float32 {
+	if x != nil {
+		return x.Vector
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetMatch() string {
+	if x != nil {
+		return x.Match
+	}
+	return ""
+}
+
+type Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	Line          int64                  `protobuf:"varint,2,opt,name=line,proto3" json:"line,omitempty"`
+	Fingerprint   []string               `protobuf:"bytes,3,rep,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	Sample        string                 `protobuf:"bytes,4,opt,name=sample,proto3" json:"sample,omitempty"`
+	Score         int64                  `protobuf:"varint,5,opt,name=score,proto3" json:"score,omitempty"`
+	Metadata      map[string]string      `protobuf:"bytes,6,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Result) Reset() {
+	*x = Result{}
+	mi := &file_tagdb_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Result) ProtoMessage() {}
+
+func (x *Result) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Result.ProtoReflect.Descriptor instead.
+func (*Result) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Result) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *Result) GetLine() int64 {
+	if x != nil {
+		return x.Line
+	}
+	return 0
+}
+
+func (x *Result) GetFingerprint() []string {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return nil
+}
+
+func (x *Result) GetSample() string {
+	if x != nil {
+		return x.Sample
+	}
+	return ""
+}
+
+func (x *Result) GetScore() int64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *Result) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type SearchReply struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Results []*Result              `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	Groups  []*ResultGroup         `protobuf:"bytes,2,rep,name=groups,proto3" json:"groups,omitempty"`
+	// Remote farms that did not answer in time, so their results are missing
+	Partial       []string `protobuf:"bytes,3,rep,name=partial,proto3" json:"partial,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchReply) Reset() {
+	*x = SearchReply{}
+	mi := &file_tagdb_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchReply) ProtoMessage() {}
+
+func (x *SearchReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchReply.ProtoReflect.Descriptor instead.
+func (*SearchReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SearchReply) GetResults() []*Result {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SearchReply) GetGroups() []*ResultGroup {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *SearchReply) GetPartial() []string {
+	if x != nil {
+		return x.Partial
+	}
+	return nil
+}
+
+// The best lines of one file, and the number of its lines that matched
+type ResultGroup struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	Hits          int64                  `protobuf:"varint,2,opt,name=hits,proto3" json:"hits,omitempty"`
+	Score         int64                  `protobuf:"varint,3,opt,name=score,proto3" json:"score,omitempty"`
+	Lines         []*Result              `protobuf:"bytes,4,rep,name=lines,proto3" json:"lines,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultGroup) Reset() {
+	*x = ResultGroup{}
+	mi := &file_tagdb_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultGroup) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultGroup) ProtoMessage() {}
+
+func (x *ResultGroup) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultGroup.ProtoReflect.Descriptor instead.
+func (*ResultGroup) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ResultGroup) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *ResultGroup) GetHits() int64 {
+	if x != nil {
+		return x.Hits
+	}
+	return 0
+}
+
+func (x *ResultGroup) GetScore() int64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+func (x *ResultGroup) GetLines() []*Result {
+	if x != nil {
+		return x.Lines
+	}
+	return nil
+}
+
+type SearchEvent struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Results      []*Result              `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	Farm         string                 `protobuf:"bytes,2,opt,name=farm,proto3" json:"farm,omitempty"`
+	Done         bool                   `protobuf:"varint,3,opt,name=done,proto3" json:"done,omitempty"`
+	Total        int64                  `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"`
+	Farms        int64                  `protobuf:"varint,5,opt,name=farms,proto3" json:"farms,omitempty"`
+	Milliseconds int64                  `protobuf:"varint,6,opt,name=milliseconds,proto3" json:"milliseconds,omitempty"`
+	// Set on the done event, as in SearchReply
+	Partial       []string `protobuf:"bytes,7,rep,name=partial,proto3" json:"partial,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchEvent) Reset() {
+	*x = SearchEvent{}
+	mi := &file_tagdb_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchEvent) ProtoMessage() {}
+
+func (x *SearchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchEvent.ProtoReflect.Descriptor instead.
+func (*SearchEvent) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SearchEvent) GetResults() []*Result {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *SearchEvent) GetFarm() string {
+	if x != nil {
+		return x.Farm
+	}
+	return ""
+}
+
+func (x *SearchEvent) GetDone() bool {
+	if x != nil {
+		return x.Done
+	}
+	return false
+}
+
+func (x *SearchEvent) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *SearchEvent) GetFarms() int64 {
+	if x != nil {
+		return x.Farms
+	}
+	return 0
+}
+
+func (x *SearchEvent) GetMilliseconds() int64 {
+	if x != nil {
+		return x.Milliseconds
+	}
+	return 0
+}
+
+func (x *SearchEvent) GetPartial() []string {
+	if x != nil {
+		return x.Partial
+	}
+	return nil
+}
+
+type PredictRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Prefix string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Limit  int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Index to use.  Empty for the default index
+	Index         string `protobuf:"bytes,3,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PredictRequest) Reset() {
+	*x = PredictRequest{}
+	mi := &file_tagdb_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictRequest) ProtoMessage() {}
+
+func (x *PredictRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictRequest.ProtoReflect.Descriptor instead.
+func (*PredictRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PredictRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *PredictRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *PredictRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+type PredictReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Completions   []string               `protobuf:"bytes,1,rep,name=completions,proto3" json:"completions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PredictReply) Reset() {
+	*x = PredictReply{}
+	mi := &file_tagdb_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PredictReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PredictReply) ProtoMessage() {}
+
+func (x *PredictReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PredictReply.ProtoReflect.Descriptor instead.
+func (*PredictReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PredictReply) GetCompletions() []string {
+	if x != nil {
+		return x.Completions
+	}
+	return nil
+}
+
+type InsertRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Position int64                  `protobuf:"varint,2,opt,name=position,proto3" json:"position,omitempty"`
+	Tags     []string               `protobuf:"bytes,3,rep,name=tags,proto3" json:"tags,omitempty"`
+	// If set, only tokens holding one of these principals can find the record
+	Principals []string `protobuf:"bytes,4,rep,name=principals,proto3" json:"principals,omitempty"`
+	// Index to use.  Empty for the default index
+	Index string `protobuf:"bytes,5,opt,name=index,proto3" json:"index,omitempty"`
+	// Fields that queries can filter and sort on, e.g. "mtime", "size", "author"
+	Metadata map[string]string `protobuf:"bytes,6,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// For vector searches.  Default: the server's embedder applied to tags, if it has one
+	Vector        []float32 `protobuf:"fixed32,7,rep,packed,name=vector,proto3" json:"vector,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InsertRequest) Reset() {
+	*x = InsertRequest{}
+	mi := &file_tagdb_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertRequest) ProtoMessage() {}
+
+func (x *InsertRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertRequest.ProtoReflect.Descriptor instead.
+func (*InsertRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *InsertRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *InsertRequest) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+func (x *InsertRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *InsertRequest) GetPrincipals() []string {
+	if x != nil {
+		return x.Principals
+	}
+	return nil
+}
+
+func (x *InsertRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+func (x *InsertRequest) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *InsertRequest) GetVector() []float32 {
+	if x != nil {
+		return x.Vector
+	}
+	return nil
+}
+
+type InsertReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Inserted      int64                  `protobuf:"varint,3,opt,name=inserted,proto3" json:"inserted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InsertReply) Reset() {
+	*x = InsertReply{}
+	mi := &file_tagdb_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InsertReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InsertReply) ProtoMessage() {}
+
+func (x *InsertReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InsertReply.ProtoReflect.Descriptor instead.
+func (*InsertReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *InsertReply) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *InsertReply) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *InsertReply) GetInserted() int64 {
+	if x != nil {
+		return x.Inserted
+	}
+	return 0
+}
+
+type DeleteRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Position int64                  `protobuf:"varint,2,opt,name=position,proto3" json:"position,omitempty"`
+	AllLines bool                   `protobuf:"varint,3,opt,name=all_lines,json=allLines,proto3" json:"all_lines,omitempty"`
+	// Index to use.  Empty for the default index
+	Index         string `protobuf:"bytes,4,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_tagdb_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DeleteRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+func (x *DeleteRequest) GetAllLines() bool {
+	if x != nil {
+		return x.AllLines
+	}
+	return false
+}
+
+func (x *DeleteRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+type DeleteReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deleted       int64                  `protobuf:"varint,1,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteReply) Reset() {
+	*x = DeleteReply{}
+	mi := &file_tagdb_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteReply) ProtoMessage() {}
+
+func (x *DeleteReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteReply.ProtoReflect.Descriptor instead.
+func (*DeleteReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *DeleteReply) GetDeleted() int64 {
+	if x != nil {
+		return x.Deleted
+	}
+	return 0
+}
+
+type ClickRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Position      int64                  `protobuf:"varint,2,opt,name=position,proto3" json:"position,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClickRequest) Reset() {
+	*x = ClickRequest{}
+	mi := &file_tagdb_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClickRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClickRequest) ProtoMessage() {}
+
+func (x *ClickRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClickRequest.ProtoReflect.Descriptor instead.
+func (*ClickRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ClickRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ClickRequest) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+type ClickReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClickReply) Reset() {
+	*x = ClickReply{}
+	mi := &file_tagdb_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClickReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClickReply) ProtoMessage() {}
+
+func (x *ClickReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClickReply.ProtoReflect.Descriptor instead.
+func (*ClickReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ClickReply) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+type SimilarRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Position int64                  `protobuf:"varint,2,opt,name=position,proto3" json:"position,omitempty"`
+	Limit    int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Index to search.  Empty for the default index, "a,b" for several, "*" for all of them
+	Index         string `protobuf:"bytes,4,opt,name=index,proto3" json:"index,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SimilarRequest) Reset() {
+	*x = SimilarRequest{}
+	mi := &file_tagdb_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SimilarRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimilarRequest) ProtoMessage() {}
+
+func (x *SimilarRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimilarRequest.ProtoReflect.Descriptor instead.
+func (*SimilarRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SimilarRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SimilarRequest) GetPosition() int64 {
+	if x != nil {
+		return x.Position
+	}
+	return 0
+}
+
+func (x *SimilarRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SimilarRequest) GetIndex() string {
+	if x != nil {
+		return x.Index
+	}
+	return ""
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_tagdb_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{14}
+}
+
+type StatusReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Answer        map[string]string      `protobuf:"bytes,1,rep,name=answer,proto3" json:"answer,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusReply) Reset() {
+	*x = StatusReply{}
+	mi := &file_tagdb_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusReply) ProtoMessage() {}
+
+func (x *StatusReply) ProtoReflect() protoreflect.Message {
+	mi := &file_tagdb_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusReply.ProtoReflect.Descriptor instead.
+func (*StatusReply) Descriptor() ([]byte, []int) {
+	return file_tagdb_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *StatusReply) GetAnswer() map[string]string {
+	if x != nil {
+		return x.Answer
+	}
+	return nil
+}
+
+var File_tagdb_proto protoreflect.FileDescriptor
+
+const file_tagdb_proto_rawDesc = "" +
+	"\n" +
+	"\vtagdb.proto\x12\x05tagdb\"\xe3\x01\n" +
+	"\rSearchRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\tR\x05index\x12\x12\n" +
+	"\x04sort\x18\x04 \x01(\tR\x04sort\x12\x19\n" +
+	"\bgroup_by\x18\x05 \x01(\tR\agroupBy\x12\x1f\n" +
+	"\vgroup_lines\x18\x06 \x01(\x05R\n" +
+	"groupLines\x12\x12\n" +
+	"\x04mode\x18\a \x01(\tR\x04mode\x12\x16\n" +
+	"\x06vector\x18\b \x03(\x02R\x06vector\x12\x14\n" +
+	"\x05match\x18\t \x01(\tR\x05match\"\xfe\x01\n" +
+	"\x06Result\x12\x1a\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04line\x18\x02 \x01(\x03R\x04line\x12 \n" +
+	"\vfingerprint\x18\x03 \x03(\tR\vfingerprint\x12\x16\n" +
+	"\x06sample\x18\x04 \x01(\tR\x06sample\x12\x14\n" +
+	"\x05score\x18\x05 \x01(\x03R\x05score\x127\n" +
+	"\bmetadata\x18\x06 \x03(\v2\x1b.tagdb.Result.MetadataEntryR\bmetadata\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"|\n" +
+	"\vSearchReply\x12'\n" +
+	"\aresults\x18\x01 \x03(\v2\r.tagdb.ResultR\aresults\x12*\n" +
+	"\x06groups\x18\x02 \x03(\v2\x12.tagdb.ResultGroupR\x06groups\x12\x18\n" +
+	"\apartial\x18\x03 \x03(\tR\apartial\"x\n" +
+	"\vResultGroup\x12\x1a\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04hits\x18\x02 \x01(\x03R\x04hits\x12\x14\n" +
+	"\x05score\x18\x03 \x01(\x03R\x05score\x12#\n" +
+	"\x05lines\x18\x04 \x03(\v2\r.tagdb.ResultR\x05lines\"\xc8\x01\n" +
+	"\vSearchEvent\x12'\n" +
+	"\aresults\x18\x01 \x03(\v2\r.tagdb.ResultR\aresults\x12\x12\n" +
+	"\x04farm\x18\x02 \x01(\tR\x04farm\x12\x12\n" +
+	"\x04done\x18\x03 \x01(\bR\x04done\x12\x14\n" +
+	"\x05total\x18\x04 \x01(\x03R\x05total\x12\x14\n" +
+	"\x05farms\x18\x05 \x01(\x03R\x05farms\x12\"\n" +
+	"\fmilliseconds\x18\x06 \x01(\x03R\fmilliseconds\x12\x18\n" +
+	"\apartial\x18\a \x03(\tR\apartial\"T\n" +
+	"\x0ePredictRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\tR\x05index\"0\n" +
+	"\fPredictReply\x12 \n" +
+	"\vcompletions\x18\x01 \x03(\tR\vcompletions\"\x9e\x02\n" +
+	"\rInsertRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x03R\bposition\x12\x12\n" +
+	"\x04tags\x18\x03 \x03(\tR\x04tags\x12\x1e\n" +
+	"\n" +
+	"principals\x18\x04 \x03(\tR\n" +
+	"principals\x12\x14\n" +
+	"\x05index\x18\x05 \x01(\tR\x05index\x12>\n" +
+	"\bmetadata\x18\x06 \x03(\v2\".tagdb.InsertRequest.MetadataEntryR\bmetadata\x12\x16\n" +
+	"\x06vector\x18\a \x03(\x02R\x06vector\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"[\n" +
+	"\vInsertReply\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x1a\n" +
+	"\binserted\x18\x03 \x01(\x03R\binserted\"r\n" +
+	"\rDeleteRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x03R\bposition\x12\x1b\n" +
+	"\tall_lines\x18\x03 \x01(\bR\ballLines\x12\x14\n" +
+	"\x05index\x18\x04 \x01(\tR\x05index\"'\n" +
+	"\vDeleteReply\x12\x18\n" +
+	"\adeleted\x18\x01 \x01(\x03R\adeleted\">\n" +
+	"\fClickRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x03R\bposition\"&\n" +
+	"\n" +
+	"ClickReply\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\"l\n" +
+	"\x0eSimilarRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1a\n" +
+	"\bposition\x18\x02 \x01(\x03R\bposition\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x14\n" +
+	"\x05index\x18\x04 \x01(\tR\x05index\"\x0f\n" +
+	"\rStatusRequest\"\x80\x01\n" +
+	"\vStatusReply\x126\n" +
+	"\x06answer\x18\x01 \x03(\v2\x1e.tagdb.StatusReply.AnswerEntryR\x06answer\x1a9\n" +
+	"\vAnswerEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\xeb\x03\n" +
+	"\x05TagDB\x122\n" +
+	"\x06Search\x12\x14.tagdb.SearchRequest\x1a\x12.tagdb.SearchReply\x12:\n" +
+	"\fSearchStream\x12\x14.tagdb.SearchRequest\x1a\x12.tagdb.SearchEvent0\x01\x125\n" +
+	"\aPredict\x12\x15.tagdb.PredictRequest\x1a\x13.tagdb.PredictReply\x122\n" +
+	"\x06Insert\x12\x14.tagdb.InsertRequest\x1a\x12.tagdb.InsertReply\x128\n" +
+	"\n" +
+	"BulkInsert\x12\x14.tagdb.InsertRequest\x1a\x12.tagdb.InsertReply(\x01\x122\n" +
+	"\x06Delete\x12\x14.tagdb.DeleteRequest\x1a\x12.tagdb.DeleteReply\x122\n" +
+	"\x06Status\x12\x14.tagdb.StatusRequest\x1a\x12.tagdb.StatusReply\x12/\n" +
+	"\x05Click\x12\x13.tagdb.ClickRequest\x1a\x11.tagdb.ClickReply\x124\n" +
+	"\aSimilar\x12\x15.tagdb.SimilarRequest\x1a\x12.tagdb.SearchReplyB\"Z github.com/donomii/tagdb/tagdbpbb\x06proto3"
+
+var (
+	file_tagdb_proto_rawDescOnce sync.Once
+	file_tagdb_proto_rawDescData []byte
+)
+
+func file_tagdb_proto_rawDescGZIP() []byte {
+	file_tagdb_proto_rawDescOnce.Do(func() {
+		file_tagdb_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tagdb_proto_rawDesc), len(file_tagdb_proto_rawDesc)))
+	})
+	return file_tagdb_proto_rawDescData
+}
+
+var file_tagdb_proto_msgTypes = make([]protoimpl.MessageInfo, 19)
+var file_tagdb_proto_goTypes = []any{
+	(*SearchRequest)(nil),  // 0: tagdb.SearchRequest
+	(*Result)(nil),         // 1: tagdb.Result
+	(*SearchReply)(nil),    // 2: tagdb.SearchReply
+	(*ResultGroup)(nil),    // 3: tagdb.ResultGroup
+	(*SearchEvent)(nil),    // 4: tagdb.SearchEvent
+	(*PredictRequest)(nil), // 5: tagdb.PredictRequest
+	(*PredictReply)(nil),   // 6: tagdb.PredictReply
+	(*InsertRequest)(nil),  // 7: tagdb.InsertRequest
+	(*InsertReply)(nil),    // 8: tagdb.InsertReply
+	(*DeleteRequest)(nil),  // 9: tagdb.DeleteRequest
+	(*DeleteReply)(nil),    // 10: tagdb.DeleteReply
+	(*ClickRequest)(nil),   // 11: tagdb.ClickRequest
+	(*ClickReply)(nil),     // 12: tagdb.ClickReply
+	(*SimilarRequest)(nil), // 13: tagdb.SimilarRequest
+	(*StatusRequest)(nil),  // 14: tagdb.StatusRequest
+	(*StatusReply)(nil),    // 15: tagdb.StatusReply
+	nil,                    // 16: tagdb.Result.MetadataEntry
+	nil,                    // 17: tagdb.InsertRequest.MetadataEntry
+	nil,                    // 18: tagdb.StatusReply.AnswerEntry
+}
+var file_tagdb_proto_depIdxs = []int32{
+	16, // 0: tagdb.Result.metadata:type_name -> tagdb.Result.MetadataEntry
+	1,  // 1: tagdb.SearchReply.results:type_name -> tagdb.Result
+	3,  // 2: tagdb.SearchReply.groups:type_name -> tagdb.ResultGroup
+	1,  // 3: tagdb.ResultGroup.lines:type_name -> tagdb.Result
+	1,  // 4: tagdb.SearchEvent.results:type_name -> tagdb.Result
+	17, // 5: tagdb.InsertRequest.metadata:type_name -> tagdb.InsertRequest.MetadataEntry
+	18, // 6: tagdb.StatusReply.answer:type_name -> tagdb.StatusReply.AnswerEntry
+	0,  // 7: tagdb.TagDB.Search:input_type -> tagdb.SearchRequest
+	0,  // 8: tagdb.TagDB.SearchStream:input_type -> tagdb.SearchRequest
+	5,  // 9: tagdb.TagDB.Predict:input_type -> tagdb.PredictRequest
+	7,  // 10: tagdb.TagDB.Insert:input_type -> tagdb.InsertRequest
+	7,  // 11: tagdb.TagDB.BulkInsert:input_type -> tagdb.InsertRequest
+	9,  // 12: tagdb.TagDB.Delete:input_type -> tagdb.DeleteRequest
+	14, // 13: tagdb.TagDB.Status:input_type -> tagdb.StatusRequest
+	11, // 14: tagdb.TagDB.Click:input_type -> tagdb.ClickRequest
+	13, // 15: tagdb.TagDB.Similar:input_type -> tagdb.SimilarRequest
+	2,  // 16: tagdb.TagDB.Search:output_type -> tagdb.SearchReply
+	4,  // 17: tagdb.TagDB.SearchStream:output_type -> tagdb.SearchEvent
+	6,  // 18: tagdb.TagDB.Predict:output_type -> tagdb.PredictReply
+	8,  // 19: tagdb.TagDB.Insert:output_type -> tagdb.InsertReply
+	8,  // 20: tagdb.TagDB.BulkInsert:output_type -> tagdb.InsertReply
+	10, // 21: tagdb.TagDB.Delete:output_type -> tagdb.DeleteReply
+	15, // 22: tagdb.TagDB.Status:output_type -> tagdb.StatusReply
+	12, // 23: tagdb.TagDB.Click:output_type -> tagdb.ClickReply
+	2,  // 24: tagdb.TagDB.Similar:output_type -> tagdb.SearchReply
+	16, // [16:25] is the sub-list for method output_type
+	7,  // [7:16] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_tagdb_proto_init() }
+func file_tagdb_proto_init() {
+	if File_tagdb_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tagdb_proto_rawDesc), len(file_tagdb_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   19,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tagdb_proto_goTypes,
+		DependencyIndexes: file_tagdb_proto_depIdxs,
+		MessageInfos:      file_tagdb_proto_msgTypes,
+	}.Build()
+	File_tagdb_proto = out.File
+	file_tagdb_proto_goTypes = nil
+	file_tagdb_proto_depIdxs = nil
+}
@@ -0,0 +1,448 @@
+// tagdb.proto
+//
+// The gRPC interface to tagserver.  Generate stubs for other languages with protoc, e.g.
+//
+//     protoc --python_out=. --grpc_python_out=. tagdb.proto
+//
+// The Go code in this directory is generated from this file by go generate, see client.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: tagdb.proto
+
+package tagdbpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TagDB_Search_FullMethodName       = "/tagdb.TagDB/Search"
+	TagDB_SearchStream_FullMethodName = "/tagdb.TagDB/SearchStream"
+	TagDB_Predict_FullMethodName      = "/tagdb.TagDB/Predict"
+	TagDB_Insert_FullMethodName       = "/tagdb.TagDB/Insert"
+	TagDB_BulkInsert_FullMethodName   = "/tagdb.TagDB/BulkInsert"
+	TagDB_Delete_FullMethodName       = "/tagdb.TagDB/Delete"
+	TagDB_Status_FullMethodName       = "/tagdb.TagDB/Status"
+	TagDB_Click_FullMethodName        = "/tagdb.TagDB/Click"
+	TagDB_Similar_FullMethodName      = "/tagdb.TagDB/Similar"
+)
+
+// TagDBClient is the client API for TagDB service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TagDBClient interface {
+	// Search all farms and return the best results
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchReply, error)
+	// Search all farms, sending each farm's results as soon as they are ready.  The last event has done set, and holds the merged results
+	SearchStream(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SearchEvent], error)
+	// Complete a partial word from the stored tags
+	Predict(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*PredictReply, error)
+	// Add one record to the index
+	Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertReply, error)
+	// Stream records into the index, the reply is sent once the client closes the stream
+	BulkInsert(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[InsertRequest, InsertReply], error)
+	// Remove one record, or every record for a name
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteReply, error)
+	// Server statistics
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	// Report that a user opened a search result, for the popularity boost
+	Click(ctx context.Context, in *ClickRequest, opts ...grpc.CallOption) (*ClickReply, error)
+	// Find the records that share the most informative tags with a stored record, leaving that record out
+	Similar(ctx context.Context, in *SimilarRequest, opts ...grpc.CallOption) (*SearchReply, error)
+}
+
+type tagDBClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTagDBClient(cc grpc.ClientConnInterface) TagDBClient {
+	return &tagDBClient{cc}
+}
+
+func (c *tagDBClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchReply)
+	err := c.cc.Invoke(ctx, TagDB_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) SearchStream(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SearchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TagDB_ServiceDesc.Streams[0], TagDB_SearchStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SearchRequest, SearchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TagDB_SearchStreamClient = grpc.ServerStreamingClient[SearchEvent]
+
+func (c *tagDBClient) Predict(ctx context.Context, in *PredictRequest, opts ...grpc.CallOption) (*PredictReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PredictReply)
+	err := c.cc.Invoke(ctx, TagDB_Predict_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InsertReply)
+	err := c.cc.Invoke(ctx, TagDB_Insert_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) BulkInsert(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[InsertRequest, InsertReply], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TagDB_ServiceDesc.Streams[1], TagDB_BulkInsert_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[InsertRequest, InsertReply]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TagDB_BulkInsertClient = grpc.ClientStreamingClient[InsertRequest, InsertReply]
+
+func (c *tagDBClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteReply)
+	err := c.cc.Invoke(ctx, TagDB_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, TagDB_Status_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) Click(ctx context.Context, in *ClickRequest, opts ...grpc.CallOption) (*ClickReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClickReply)
+	err := c.cc.Invoke(ctx, TagDB_Click_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tagDBClient) Similar(ctx context.Context, in *SimilarRequest, opts ...grpc.CallOption) (*SearchReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchReply)
+	err := c.cc.Invoke(ctx, TagDB_Similar_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TagDBServer is the server API for TagDB service.
+// All implementations must embed UnimplementedTagDBServer
+// for forward compatibility.
+type TagDBServer interface {
+	// Search all farms and return the best results
+	Search(context.Context, *SearchRequest) (*SearchReply, error)
+	// Search all farms, sending each farm's results as soon as they are ready.  The last event has done set, and holds the merged results
+	SearchStream(*SearchRequest, grpc.ServerStreamingServer[SearchEvent]) error
+	// Complete a partial word from the stored tags
+	Predict(context.Context, *PredictRequest) (*PredictReply, error)
+	// Add one record to the index
+	Insert(context.Context, *InsertRequest) (*InsertReply, error)
+	// Stream records into the index, the reply is sent once the client closes the stream
+	BulkInsert(grpc.ClientStreamingServer[InsertRequest, InsertReply]) error
+	// Remove one record, or every record for a name
+	Delete(context.Context, *DeleteRequest) (*DeleteReply, error)
+	// Server statistics
+	Status(context.Context, *StatusRequest) (*StatusReply, error)
+	// Report that a user opened a search result, for the popularity boost
+	Click(context.Context, *ClickRequest) (*ClickReply, error)
+	// Find the records that share the most informative tags with a stored record, leaving that record out
+	Similar(context.Context, *SimilarRequest) (*SearchReply, error)
+	mustEmbedUnimplementedTagDBServer()
+}
+
+// UnimplementedTagDBServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTagDBServer struct{}
+
+func (UnimplementedTagDBServer) Search(context.Context, *SearchRequest) (*SearchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedTagDBServer) SearchStream(*SearchRequest, grpc.ServerStreamingServer[SearchEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method SearchStream not implemented")
+}
+func (UnimplementedTagDBServer) Predict(context.Context, *PredictRequest) (*PredictReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Predict not implemented")
+}
+func (UnimplementedTagDBServer) Insert(context.Context, *InsertRequest) (*InsertReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Insert not implemented")
+}
+func (UnimplementedTagDBServer) BulkInsert(grpc.ClientStreamingServer[InsertRequest, InsertReply]) error {
+	return status.Errorf(codes.Unimplemented, "method BulkInsert not implemented")
+}
+func (UnimplementedTagDBServer) Delete(context.Context, *DeleteRequest) (*DeleteReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedTagDBServer) Status(context.Context, *StatusRequest) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedTagDBServer) Click(context.Context, *ClickRequest) (*ClickReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Click not implemented")
+}
+func (UnimplementedTagDBServer) Similar(context.Context, *SimilarRequest) (*SearchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Similar not implemented")
+}
+func (UnimplementedTagDBServer) mustEmbedUnimplementedTagDBServer() {}
+func (UnimplementedTagDBServer) testEmbeddedByValue()               {}
+
+// UnsafeTagDBServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TagDBServer will
+// result in compilation errors.
+type UnsafeTagDBServer interface {
+	mustEmbedUnimplementedTagDBServer()
+}
+
+func RegisterTagDBServer(s grpc.ServiceRegistrar, srv TagDBServer) {
+	// If the following call pancis, it indicates UnimplementedTagDBServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TagDB_ServiceDesc, srv)
+}
+
+func _TagDB_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_SearchStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TagDBServer).SearchStream(m, &grpc.GenericServerStream[SearchRequest, SearchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TagDB_SearchStreamServer = grpc.ServerStreamingServer[SearchEvent]
+
+func _TagDB_Predict_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PredictRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Predict(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Predict_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Predict(ctx, req.(*PredictRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_Insert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Insert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Insert_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Insert(ctx, req.(*InsertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_BulkInsert_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TagDBServer).BulkInsert(&grpc.GenericServerStream[InsertRequest, InsertReply]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TagDB_BulkInsertServer = grpc.ClientStreamingServer[InsertRequest, InsertReply]
+
+func _TagDB_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_Click_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClickRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Click(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Click_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Click(ctx, req.(*ClickRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TagDB_Similar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SimilarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TagDBServer).Similar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TagDB_Similar_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TagDBServer).Similar(ctx, req.(*SimilarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TagDB_ServiceDesc is the grpc.ServiceDesc for TagDB service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TagDB_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tagdb.TagDB",
+	HandlerType: (*TagDBServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _TagDB_Search_Handler,
+		},
+		{
+			MethodName: "Predict",
+			Handler:    _TagDB_Predict_Handler,
+		},
+		{
+			MethodName: "Insert",
+			Handler:    _TagDB_Insert_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _TagDB_Delete_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _TagDB_Status_Handler,
+		},
+		{
+			MethodName: "Click",
+			Handler:    _TagDB_Click_Handler,
+		},
+		{
+			MethodName: "Similar",
+			Handler:    _TagDB_Similar_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SearchStream",
+			Handler:       _TagDB_SearchStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BulkInsert",
+			Handler:       _TagDB_BulkInsert_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "tagdb.proto",
+}
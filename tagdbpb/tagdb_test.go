@@ -0,0 +1,73 @@
+// tagdb_test.go
+package tagdbpb
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInsertRequestRoundTrip(t *testing.T) {
+	in := &InsertRequest{
+		Name:       "notes.txt",
+		Position:   12,
+		Tags:       []string{"quick", "brown", "fox"},
+		Principals: []string{"user:alice"},
+		Index:      "docs",
+		Metadata:   map[string]string{"mtime": "2024-01-02", "size": "100"},
+		Vector:     []float32{0.5, -1, 3.25},
+	}
+	b, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &InsertRequest{}
+	if err := proto.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(in, out) {
+		t.Errorf("round trip gave %v, want %v", out, in)
+	}
+}
+
+// Clients generated from tagdb.proto in other languages rely on these field numbers
+func TestSearchRequestFieldNumbers(t *testing.T) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(&SearchRequest{Query: "fox", Limit: 5, Index: "docs", Match: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := protowire.AppendTag(nil, 1, protowire.BytesType)
+	want = protowire.AppendString(want, "fox")
+	want = protowire.AppendTag(want, 2, protowire.VarintType)
+	want = protowire.AppendVarint(want, 5)
+	want = protowire.AppendTag(want, 3, protowire.BytesType)
+	want = protowire.AppendString(want, "docs")
+	want = protowire.AppendTag(want, 9, protowire.BytesType)
+	want = protowire.AppendString(want, "all")
+	if !bytes.Equal(b, want) {
+		t.Errorf("SearchRequest encoded as %x, want %x", b, want)
+	}
+}
+
+func TestSearchEventRoundTrip(t *testing.T) {
+	in := &SearchEvent{
+		Results: []*Result{{Filename: "a.txt", Line: 3, Fingerprint: []string{"x"}, Score: 2, Metadata: map[string]string{"author": "bob"}}},
+		Farm:    "database/disk",
+		Done:    true,
+		Total:   1,
+		Partial: []string{"remote:6781"},
+	}
+	b, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &SearchEvent{}
+	if err := proto.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(in, out) {
+		t.Errorf("round trip gave %v, want %v", out, in)
+	}
+}